@@ -3,19 +3,54 @@ package main
 import (
 	"calculator_app/internal/agent"
 	"calculator_app/internal/config"
+	"encoding/json"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	cfg, err := config.LoadConfig("config/config.txt")
+	cfg, err := config.LoadAgentConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	log.Printf("Agent started with %d workers", cfg.ComputingPower)
-	agentInstance := agent.NewAgent("localhost:50051", cfg.ComputingPower)
-	log.Println("gRPC агент запущен на порту 50051")
+	agentInstance, err := agent.NewAgentWithConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create agent: %v", err)
+	}
+	log.Printf("Agent %s started with %d workers, orchestrator %s (TLS: %t)",
+		agentInstance.ID, agentInstance.ComputingPower, cfg.OrchestratorAddr, cfg.TLS.Enabled)
+	if cfg.MaxWorkers > 0 {
+		log.Printf("Autoscaling between %d and %d workers", cfg.MinWorkers, cfg.MaxWorkers)
+	}
 	agentInstance.Start()
 
-	select {}
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(agentInstance.Metrics())
+		})
+		go func() {
+			log.Printf("Agent metrics on %s/metrics", cfg.MetricsAddr)
+			if err := http.ListenAndServe(cfg.MetricsAddr, mux); err != nil {
+				log.Printf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	s := <-sig
+	log.Printf("Received %s, stopping agent (grace period %s)", s, agentInstance.ShutdownGrace)
+	agentInstance.Stop()
+	log.Println("Agent stopped")
 }
@@ -6,25 +6,47 @@ package main
 import (
 	"bytes"
 	"calculator_app/db"
+	"calculator_app/internal/agent"
+	"calculator_app/internal/config"
 	orchestratorgrpc "calculator_app/internal/orchestrator/grpc"
 	"calculator_app/internal/orchestrator/handler"
 	"calculator_app/internal/orchestrator/repository"
 	"calculator_app/internal/orchestrator/service"
+	"calculator_app/internal/pkg/models"
 	pb "calculator_app/internal/proto"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	_ "modernc.org/sqlite"
 )
 
-func startServers(t *testing.T) (httpURL, grpcAddr string, cleanup func()) {
+// serverOptions строит опции gRPC-сервера; может опираться на оркестратор (например, проверка ключей агентов).
+type serverOptions func(orc *service.Orchestrator) []grpc.ServerOption
+
+func startServers(t *testing.T, opts ...serverOptions) (httpURL, grpcAddr string, cleanup func()) {
 	dbConn, err := sql.Open("sqlite", ":memory:")
 	if err != nil {
 		t.Fatal(err)
@@ -35,25 +57,45 @@ func startServers(t *testing.T) (httpURL, grpcAddr string, cleanup func()) {
 
 	repo := repository.NewRepository(dbConn)
 	orcSvc := service.NewOrchestrator(1, 1, 1, 1, repo)
-	h := handler.NewHandler(orcSvc)
+	if err := orcSvc.LoadReadyQueue(); err != nil {
+		t.Fatal(err)
+	}
+	h := handler.NewHandler(orcSvc).WithAdmins([]string{"alice"})
+	ctx, stop := context.WithCancel(context.Background())
+	go orcSvc.RunHealthMonitor(ctx, 50*time.Millisecond)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/v1/register", h.RegisterUser)
 	mux.HandleFunc("/api/v1/login", h.LoginUser)
 	mux.HandleFunc("/api/v1/calculate", h.AddExpression)
 	mux.HandleFunc("/api/v1/expressions", h.GetExpressions)
-	mux.HandleFunc("/api/v1/expressions/", h.GetExpressionByID)
+	mux.HandleFunc("/api/v1/expressions/{id}", h.GetExpressionByID)
+	mux.HandleFunc("/api/v1/expressions/{id}/tasks", h.GetExpressionTasks)
+	mux.HandleFunc("POST /api/v1/expressions/{id}/cancel", h.CancelExpression)
+	mux.HandleFunc("/api/v1/admin/agents", h.GetAgents)
+	mux.HandleFunc("GET /api/v1/admin/agents/health", h.GetAgentHealth)
+	mux.HandleFunc("DELETE /api/v1/admin/agents/{id}/quarantine", h.ReleaseQuarantine)
+	mux.HandleFunc("/api/v1/admin/queue", h.GetQueue)
+	mux.HandleFunc("/api/v1/admin/dead-tasks", h.GetDeadTasks)
+	mux.HandleFunc("POST /api/v1/admin/join-tokens", h.CreateJoinToken)
+	mux.HandleFunc("GET /api/v1/admin/enrolled-agents", h.GetEnrolledAgents)
+	mux.HandleFunc("DELETE /api/v1/admin/enrolled-agents/{id}", h.RevokeAgent)
 	httpSrv := httptest.NewServer(mux)
 
 	lis, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
-	grpcSrv := grpc.NewServer()
+	var grpcOpts []grpc.ServerOption
+	for _, opt := range opts {
+		grpcOpts = append(grpcOpts, opt(orcSvc)...)
+	}
+	grpcSrv := grpc.NewServer(grpcOpts...)
 	pb.RegisterOrchestratorServiceServer(grpcSrv, orchestratorgrpc.NewOrchestratorGRPCServer(orcSvc))
 	go grpcSrv.Serve(lis)
 
 	return httpSrv.URL, lis.Addr().String(), func() {
+		stop()
 		httpSrv.Close()
 		grpcSrv.Stop()
 		dbConn.Close()
@@ -105,13 +147,33 @@ func TestEndToEnd(t *testing.T) {
 		defer conn.Close()
 		cli := pb.NewOrchestratorServiceClient(conn)
 
-		getResp, err := cli.GetTask(context.Background(), &pb.GetTaskRequest{})
+		if _, err := cli.RegisterAgent(context.Background(), &pb.RegisterAgentRequest{AgentId: "agent-1", Hostname: "host", Version: "test", Labels: map[string]string{"zone": "eu"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		getResp, err := cli.GetTask(context.Background(), &pb.GetTaskRequest{AgentId: "agent-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if getResp.LeaseId == "" {
+			t.Fatal("task was issued without a lease")
+		}
+		stale, err := cli.SubmitResult(context.Background(), &pb.SubmitResultRequest{
+			TaskId:  getResp.TaskId,
+			LeaseId: "stale-lease",
+			Outcome: &pb.SubmitResultRequest_Result{Result: 100},
+		})
 		if err != nil {
 			t.Fatal(err)
 		}
+		if stale.Success {
+			t.Fatal("result with a foreign lease was accepted")
+		}
 
 		_, err = cli.SubmitResult(context.Background(), &pb.SubmitResultRequest{
 			TaskId:  getResp.TaskId,
+			LeaseId: getResp.LeaseId,
 			Outcome: &pb.SubmitResultRequest_Result{Result: getResp.Arg1 + getResp.Arg2},
 		})
 		if err != nil {
@@ -131,4 +193,1354 @@ func TestEndToEnd(t *testing.T) {
 			t.Fatalf("expected gRPC result=5, got %v", resResp.Result.GetValue())
 		}
 	}
+
+	req, _ = http.NewRequest("GET", httpURL+"/api/v1/admin/agents", nil)
+	req.Header.Set("Authorization", "Bearer "+creds["token"])
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("list agents failed: %v", resp.Status)
+	}
+	var ar struct {
+		Agents []struct {
+			ID        string            `json:"id"`
+			Completed int               `json:"completed"`
+			Alive     bool              `json:"alive"`
+			Labels    map[string]string `json:"labels"`
+		} `json:"agents"`
+	}
+	json.NewDecoder(resp.Body).Decode(&ar)
+	if len(ar.Agents) != 1 || ar.Agents[0].ID != "agent-1" || ar.Agents[0].Completed != 1 || !ar.Agents[0].Alive ||
+		ar.Agents[0].Labels["zone"] != "eu" {
+		t.Fatalf("unexpected agents: %+v", ar.Agents)
+	}
+}
+
+func registerAndLogin(t *testing.T, httpURL, login string) string {
+	b, _ := json.Marshal(map[string]string{"login": login, "password": "pass"})
+	if resp, err := http.Post(httpURL+"/api/v1/register", "application/json", bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusOK {
+		t.Fatalf("register failed: %v", resp.Status)
+	}
+	resp, err := http.Post(httpURL+"/api/v1/login", "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lr struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&lr)
+	if lr.Token == "" {
+		t.Fatal("empty token")
+	}
+	return lr.Token
+}
+
+func TestStreamTasks(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "bob")
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := cli.StreamTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.TaskStreamRequest{AgentId: "agent-1", FreeSlots: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// выражение отправлено уже после открытия потока: задача должна прийти без опроса
+	b, _ := json.Marshal(map[string]string{"expression": "2*3+1"})
+	req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("calculate failed: %v", resp.Status)
+	}
+
+	start := time.Now()
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("task delivery took %v", time.Since(start))
+	}
+
+	// вторая задача приходит только после сообщения о свободном слоте
+	if _, err := cli.SubmitResult(context.Background(), &pb.SubmitResultRequest{
+		TaskId:  first.TaskId,
+		LeaseId: first.LeaseId,
+		Outcome: &pb.SubmitResultRequest_Result{Result: first.Arg1 * first.Arg2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.TaskStreamRequest{FreeSlots: 1}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.TaskId == first.TaskId {
+		t.Fatalf("task %s delivered twice", first.TaskId)
+	}
+}
+
+func TestDispatchOnlyReadyTasks(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "carol")
+
+	// левый операнд умножения — ноль, раньше агент принимал его за «пустой» аргумент
+	b, _ := json.Marshal(map[string]string{"expression": "(2-2)*(4+3)"})
+	req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("calculate failed: %v", resp.Status)
+	}
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+
+	var leaves []*pb.GetTaskResponse
+	for i := 0; i < 2; i++ {
+		task, err := cli.GetTask(context.Background(), &pb.GetTaskRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Operation == "*" {
+			t.Fatal("task dispatched before its dependencies completed")
+		}
+		leaves = append(leaves, task)
+	}
+	if task, err := cli.GetTask(context.Background(), &pb.GetTaskRequest{}); err == nil && task.TaskId != "" {
+		t.Fatalf("task %s %s dispatched while its dependencies are running", task.TaskId, task.Operation)
+	}
+
+	for _, leaf := range leaves {
+		result := leaf.Arg1 + leaf.Arg2
+		if leaf.Operation == "-" {
+			result = leaf.Arg1 - leaf.Arg2
+		}
+		if _, err := cli.SubmitResult(context.Background(), &pb.SubmitResultRequest{
+			TaskId:  leaf.TaskId,
+			LeaseId: leaf.LeaseId,
+			Outcome: &pb.SubmitResultRequest_Result{Result: result},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := cli.GetTask(context.Background(), &pb.GetTaskRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Operation != "*" || root.Arg1 != 0 || root.Arg2 != 7 {
+		t.Fatalf("unexpected root task: %s %v %v", root.Operation, root.Arg1, root.Arg2)
+	}
+}
+
+func TestBatchGetAndSubmit(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "dave")
+	var ids []string
+	for _, expr := range []string{"1+2", "3*4", "10-4"} {
+		b, _ := json.Marshal(map[string]string{"expression": expr})
+		req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cr struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(resp.Body).Decode(&cr)
+		ids = append(ids, cr.ID)
+	}
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+
+	batch, err := cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "agent-1", MaxN: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Tasks) != 3 {
+		t.Fatalf("expected 3 tasks in one batch, got %d", len(batch.Tasks))
+	}
+	// очередь пуста: одиночный GetTask отвечает пустой задачей, а не ошибкой
+	if empty, err := cli.GetTask(context.Background(), &pb.GetTaskRequest{AgentId: "agent-1"}); err != nil || empty.TaskId != "" {
+		t.Fatalf("expected empty response, got %v (%v)", empty, err)
+	}
+
+	submit := &pb.SubmitResultsRequest{}
+	for _, task := range batch.Tasks {
+		var result float64
+		switch task.Operation {
+		case "+":
+			result = task.Arg1 + task.Arg2
+		case "*":
+			result = task.Arg1 * task.Arg2
+		case "-":
+			result = task.Arg1 - task.Arg2
+		}
+		submit.Results = append(submit.Results, &pb.SubmitResultRequest{
+			TaskId:  task.TaskId,
+			LeaseId: task.LeaseId,
+			Outcome: &pb.SubmitResultRequest_Result{Result: result},
+		})
+	}
+	resp, err := cli.SubmitResults(context.Background(), submit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range resp.Success {
+		if !ok {
+			t.Fatalf("result %d was rejected", i)
+		}
+	}
+
+	expected := []float64{3, 12, 6}
+	for i, id := range ids {
+		req, _ := http.NewRequest("GET", httpURL+"/api/v1/expressions/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var er struct {
+			Expression struct {
+				Status string   `json:"status"`
+				Result *float64 `json:"result"`
+			} `json:"expression"`
+		}
+		json.NewDecoder(r.Body).Decode(&er)
+		if er.Expression.Result == nil || *er.Expression.Result != expected[i] {
+			t.Fatalf("expression %s: unexpected result %+v", id, er.Expression)
+		}
+	}
+}
+
+// TestFairQueue проверяет порядок выдачи через административный API: приоритет
+// поднимает выражение внутри пользователя, а доли пользователей чередуются.
+func TestFairQueue(t *testing.T) {
+	httpURL, _, cleanup := startServers(t)
+	defer cleanup()
+
+	calculate := func(token, expr string, priority int) string {
+		b, _ := json.Marshal(map[string]interface{}{"expression": expr, "priority": priority})
+		req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var cr struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(resp.Body).Decode(&cr)
+		return cr.ID
+	}
+
+	bob := registerAndLogin(t, httpURL, "bob")
+	alice := registerAndLogin(t, httpURL, "alice")
+	calculate(bob, "1+1", 0)
+	calculate(bob, "2+2", 0)
+	urgent := calculate(bob, "7*8", 5)
+	calculate(alice, "3-1", 0)
+
+	req, _ := http.NewRequest("GET", httpURL+"/api/v1/admin/queue", nil)
+	req.Header.Set("Authorization", "Bearer "+alice)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats models.QueueStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for _, task := range stats.Next {
+		order = append(order, task.UserLogin+":"+task.Operation)
+	}
+	want := []string{"bob:*", "alice:-", "bob:+", "bob:+"}
+	if len(order) != len(want) {
+		t.Fatalf("unexpected dispatch order %v", order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("unexpected dispatch order %v, want %v", order, want)
+		}
+	}
+	if stats.Next[0].TaskID != urgent+"-1" || stats.Next[0].Priority != 5 {
+		t.Fatalf("expected the urgent task first, got %+v", stats.Next[0])
+	}
+}
+
+// TestReadyQueueRebuild проверяет, что после перезапуска оркестратор восстанавливает
+// очередь готовых задач из БД.
+func TestReadyQueueRebuild(t *testing.T) {
+	dbConn, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	dbConn.SetMaxOpenConns(1)
+	if err := db.RunMigrations(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewRepository(dbConn)
+
+	before := service.NewOrchestrator(1, 1, 1, 1, repo)
+	if _, err := before.AddExpression("1 + 2 * 3", "bob", "", 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := service.NewOrchestrator(1, 1, 1, 1, repo)
+	tasks, err := restarted.GetTasks("agent-1", models.Capabilities{}, 10)
+	if err != nil || len(tasks) != 0 {
+		t.Fatalf("expected empty queue before rebuild, got %d tasks (%v)", len(tasks), err)
+	}
+	if err := restarted.LoadReadyQueue(); err != nil {
+		t.Fatal(err)
+	}
+
+	// готова только 2 * 3: сложение ждёт её результата
+	tasks, err = restarted.GetTasks("agent-1", models.Capabilities{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Operation != "*" {
+		t.Fatalf("expected the multiplication task, got %+v", tasks)
+	}
+	if tasks[0].LeaseID == "" || tasks[0].LeaseExpiresAt.Before(time.Now()) {
+		t.Fatalf("task claimed without a valid lease: %+v", tasks[0])
+	}
+
+	ok, err := restarted.SubmitResult(tasks[0].ID, tasks[0].LeaseID, &models.TaskResult{Value: 6}, nil)
+	if err != nil || !ok {
+		t.Fatalf("submit failed: %v", err)
+	}
+	tasks, err = restarted.GetTasks("agent-1", models.Capabilities{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Operation != "+" || tasks[0].Arg2 != 6 {
+		t.Fatalf("expected the addition task with resolved argument, got %+v", tasks)
+	}
+}
+
+// TestRetryAndDeadLetter проверяет повтор задачи после временной ошибки и перевод
+// её в dead, когда попытки исчерпаны.
+func TestRetryAndDeadLetter(t *testing.T) {
+	dbConn, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	dbConn.SetMaxOpenConns(1)
+	if err := db.RunMigrations(dbConn); err != nil {
+		t.Fatal(err)
+	}
+
+	orc := service.NewOrchestrator(1, 1, 1, 1, repository.NewRepository(dbConn)).
+		WithRetryPolicy(repository.RetryPolicy{MaxAttempts: 2, Backoff: 50 * time.Millisecond})
+	exprID, err := orc.AddExpression("1 + 2", "bob", "", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash := models.NewTaskError(models.ErrInternalError, "agent crashed")
+
+	tasks, err := orc.GetTasks("agent-1", models.Capabilities{}, 10)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("expected one task, got %d (%v)", len(tasks), err)
+	}
+	if ok, err := orc.SubmitResult(tasks[0].ID, tasks[0].LeaseID, nil, crash); err != nil || !ok {
+		t.Fatalf("submit failed: %v", err)
+	}
+
+	// до истечения паузы задача не выдаётся
+	tasks, err = orc.GetTasks("agent-2", models.Capabilities{}, 10)
+	if err != nil || len(tasks) != 0 {
+		t.Fatalf("task dispatched before backoff elapsed: %d (%v)", len(tasks), err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	tasks, err = orc.GetTasks("agent-2", models.Capabilities{}, 10)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("expected retried task, got %d (%v)", len(tasks), err)
+	}
+	if tasks[0].Attempts != 2 {
+		t.Fatalf("expected second attempt, got %d", tasks[0].Attempts)
+	}
+	if ok, err := orc.SubmitResult(tasks[0].ID, tasks[0].LeaseID, nil, crash); err != nil || !ok {
+		t.Fatalf("submit failed: %v", err)
+	}
+
+	dead, err := orc.DeadTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || len(dead[0].Attempts) != 2 {
+		t.Fatalf("expected one dead task with two attempts, got %+v", dead)
+	}
+	for i, a := range dead[0].Attempts {
+		want := "agent-1"
+		if i == 1 {
+			want = "agent-2"
+		}
+		if a.AgentID != want || a.Outcome != string(models.ErrInternalError) || a.FinishedAt == nil {
+			t.Fatalf("unexpected attempt %d: %+v", i+1, a)
+		}
+	}
+
+	expr, found, err := orc.GetExpressionByID(exprID, "bob")
+	if err != nil || !found {
+		t.Fatalf("expression not found: %v", err)
+	}
+	if expr.Status != string(models.ErrInternalError) {
+		t.Fatalf("expected expression to fail with internal_error, got %s", expr.Status)
+	}
+}
+
+// TestReleaseTasks проверяет, что задача, возвращённая агентом при остановке,
+// сразу выдаётся другому агенту, не дожидаясь истечения аренды.
+func TestReleaseTasks(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "erin")
+	b, _ := json.Marshal(map[string]string{"expression": "2+3"})
+	req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+
+	first, err := cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "agent-1", MaxN: 1})
+	if err != nil || len(first.Tasks) != 1 {
+		t.Fatalf("expected one task, got %v (%v)", first, err)
+	}
+	task := first.Tasks[0]
+
+	// чужой агент не может вернуть задачу
+	resp, err := cli.ReleaseTasks(context.Background(), &pb.ReleaseTasksRequest{
+		AgentId: "agent-2",
+		Tasks:   []*pb.TaskLease{{TaskId: task.TaskId, LeaseId: task.LeaseId}},
+	})
+	if err != nil || len(resp.Success) != 1 || resp.Success[0] {
+		t.Fatalf("release by another agent must fail: %v (%v)", resp, err)
+	}
+
+	resp, err = cli.ReleaseTasks(context.Background(), &pb.ReleaseTasksRequest{
+		AgentId: "agent-1",
+		Tasks:   []*pb.TaskLease{{TaskId: task.TaskId, LeaseId: task.LeaseId}},
+	})
+	if err != nil || len(resp.Success) != 1 || !resp.Success[0] {
+		t.Fatalf("release failed: %v (%v)", resp, err)
+	}
+
+	second, err := cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "agent-2", MaxN: 1})
+	if err != nil || len(second.Tasks) != 1 || second.Tasks[0].TaskId != task.TaskId {
+		t.Fatalf("released task was not redispatched: %v (%v)", second, err)
+	}
+
+	// результат по старой аренде отклоняется
+	sub, err := cli.SubmitResult(context.Background(), &pb.SubmitResultRequest{
+		TaskId:  task.TaskId,
+		LeaseId: task.LeaseId,
+		Outcome: &pb.SubmitResultRequest_Result{Result: 5},
+	})
+	if err != nil || sub.Success {
+		t.Fatalf("result with released lease must be rejected: %v (%v)", sub, err)
+	}
+}
+
+// writeTestCerts выпускает самоподписанный CA, сертификат сервера для 127.0.0.1
+// и клиентский сертификат агента; возвращает пути к PEM-файлам.
+func writeTestCerts(t *testing.T) (ca, serverCert, serverKey, clientCert, clientKey string) {
+	dir := t.TempDir()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage, ips []net.IP) (string, string) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  ips,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		return writePEM(name+".pem", "CERTIFICATE", der), writePEM(name+"-key.pem", "EC PRIVATE KEY", keyDER)
+	}
+
+	ca = writePEM("ca.pem", "CERTIFICATE", caDER)
+	serverCert, serverKey = issue("server", 2, x509.ExtKeyUsageServerAuth, []net.IP{net.ParseIP("127.0.0.1")})
+	clientCert, clientKey = issue("agent-1", 3, x509.ExtKeyUsageClientAuth, nil)
+	return
+}
+
+func TestMutualAuth(t *testing.T) {
+	ca, serverCert, serverKey, clientCert, clientKey := writeTestCerts(t)
+	serverTLS, err := config.TLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: ca}.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := orchestratorgrpc.NewAuthenticator("", map[string]string{"agent-1": "s3cret"})
+	_, grpcAddr, cleanup := startServers(t, func(*service.Orchestrator) []grpc.ServerOption {
+		return append(auth.ServerOptions(), grpc.Creds(credentials.NewTLS(serverTLS)))
+	})
+	defer cleanup()
+
+	call := func(opts ...grpc.DialOption) error {
+		conn, err := grpc.NewClient(grpcAddr, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, err = pb.NewOrchestratorServiceClient(conn).Heartbeat(ctx, &pb.HeartbeatRequest{AgentId: "agent-1"})
+		return err
+	}
+
+	// без TLS и без клиентского сертификата соединение не устанавливается
+	if err := call(grpc.WithTransportCredentials(insecure.NewCredentials())); err == nil {
+		t.Fatal("plaintext connection must fail")
+	}
+	noCert, err := config.TLSConfig{CAFile: ca}.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := call(grpc.WithTransportCredentials(credentials.NewTLS(noCert))); err == nil {
+		t.Fatal("connection without client certificate must fail")
+	}
+
+	// сертификат есть, но нет токена
+	clientTLS, err := config.TLSConfig{CAFile: ca, CertFile: clientCert, KeyFile: clientKey}.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := call(grpc.WithTransportCredentials(credentials.NewTLS(clientTLS))); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without token, got %v", err)
+	}
+
+	cfg := config.DefaultAgentConfig()
+	cfg.OrchestratorAddr = grpcAddr
+	cfg.AgentID = "agent-1"
+	cfg.AuthToken = "wrong"
+	cfg.TLS = config.TLSConfig{Enabled: true, CAFile: ca, CertFile: clientCert, KeyFile: clientKey}
+	a, err := agent.NewAgentWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Register(); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated with wrong token, got %v", err)
+	}
+
+	cfg.AuthToken = "s3cret"
+	a, err = agent.NewAgentWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Register(); err != nil {
+		t.Fatalf("register with valid certificate and token: %v", err)
+	}
+
+	// токен agent-1 не позволяет брать задачи от имени другого агента
+	_, err = a.Client.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "agent-2", MaxN: 1})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for foreign agent_id, got %v", err)
+	}
+	// пустой agent_id тоже не позволяет обойти привязку токена к агенту
+	_, err = a.Client.GetTasks(context.Background(), &pb.GetTasksRequest{MaxN: 1})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for empty agent_id, got %v", err)
+	}
+	_, err = a.Client.Heartbeat(context.Background(), &pb.HeartbeatRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for empty agent_id in heartbeat, got %v", err)
+	}
+	stream, err := a.Client.StreamTasks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&pb.TaskStreamRequest{AgentId: "agent-2", FreeSlots: 1})
+	if _, err := stream.Recv(); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied on stream, got %v", err)
+	}
+}
+
+func TestAgentEnrollment(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t, func(orc *service.Orchestrator) []grpc.ServerOption {
+		return orchestratorgrpc.NewAuthenticator("", nil).WithEnrollment(orc).ServerOptions()
+	})
+	defer cleanup()
+
+	admin := registerAndLogin(t, httpURL, "alice")
+	adminRequest := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, httpURL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+admin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := adminRequest("POST", "/api/v1/admin/join-tokens")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create join token: %v", resp.Status)
+	}
+	var join models.JoinToken
+	json.NewDecoder(resp.Body).Decode(&join)
+	resp.Body.Close()
+
+	// без ключа агент не допускается
+	cfg := config.DefaultAgentConfig()
+	cfg.OrchestratorAddr = grpcAddr
+	cfg.CredentialsFile = filepath.Join(t.TempDir(), "credentials.json")
+	anonymous, err := agent.NewAgentWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.Register(); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without credential, got %v", err)
+	}
+
+	cfg.JoinToken = join.Token
+	cfg.AgentID = "worker-1"
+	a, err := agent.NewAgentWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Register(); err != nil {
+		t.Fatalf("register enrolled agent: %v", err)
+	}
+	saved, err := agent.LoadCredentials(cfg.CredentialsFile)
+	if err != nil || saved.AgentID != "worker-1" {
+		t.Fatalf("credentials not saved: %v (%v)", saved, err)
+	}
+
+	// токен одноразовый
+	if _, err := agent.Enroll(a.Client, join.Token, "worker-2", ""); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected used join token to be rejected, got %v", err)
+	}
+
+	resp = adminRequest("GET", "/api/v1/admin/enrolled-agents")
+	var list struct {
+		Agents []models.EnrolledAgent `json:"agents"`
+	}
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list.Agents) != 1 || list.Agents[0].ID != "worker-1" || list.Agents[0].EnrolledBy != "alice" {
+		t.Fatalf("unexpected enrolled agents: %+v", list.Agents)
+	}
+
+	if resp := adminRequest("DELETE", "/api/v1/admin/enrolled-agents/worker-1"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("revoke: %v", resp.Status)
+	}
+	_, err = a.Client.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "worker-1", MaxN: 1})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected revoked agent to be rejected, got %v", err)
+	}
+	_, err = a.Client.SubmitResult(context.Background(), &pb.SubmitResultRequest{TaskId: "t", LeaseId: "l"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected revoked agent to be rejected, got %v", err)
+	}
+}
+
+func TestTaskProvenance(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "frank")
+	b, _ := json.Marshal(map[string]string{"expression": "2+3"})
+	req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cr struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&cr)
+	resp.Body.Close()
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+
+	if _, err := cli.RegisterAgent(context.Background(), &pb.RegisterAgentRequest{AgentId: "agent-1", Version: "1.2.0"}); err != nil {
+		t.Fatal(err)
+	}
+	batch, err := cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "agent-1", MaxN: 1})
+	if err != nil || len(batch.Tasks) != 1 {
+		t.Fatalf("expected one task, got %v (%v)", batch, err)
+	}
+	task := batch.Tasks[0]
+	sub, err := cli.SubmitResults(context.Background(), &pb.SubmitResultsRequest{Results: []*pb.SubmitResultRequest{{
+		TaskId:  task.TaskId,
+		LeaseId: task.LeaseId,
+		Outcome: &pb.SubmitResultRequest_Result{Result: 5},
+		Worker:  3,
+	}}})
+	if err != nil || !sub.Success[0] {
+		t.Fatalf("submit failed: %v (%v)", sub, err)
+	}
+	// повтор из outbox агента принимается, но ничего не меняет
+	sub, err = cli.SubmitResults(context.Background(), &pb.SubmitResultsRequest{Results: []*pb.SubmitResultRequest{{
+		TaskId:  task.TaskId,
+		LeaseId: task.LeaseId,
+		Outcome: &pb.SubmitResultRequest_Result{Result: 7},
+		Worker:  1,
+	}}})
+	if err != nil || !sub.Success[0] {
+		t.Fatalf("replayed submit failed: %v (%v)", sub, err)
+	}
+
+	get := func(login string) *http.Response {
+		req, _ := http.NewRequest("GET", httpURL+"/api/v1/expressions/"+cr.ID+"/tasks", nil)
+		req.Header.Set("Authorization", "Bearer "+login)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := get(registerAndLogin(t, httpURL, "mallory")); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("foreign expression tasks must be hidden, got %v", resp.Status)
+	}
+
+	resp = get(token)
+	defer resp.Body.Close()
+	var tr struct {
+		Tasks []models.ExpressionTask `json:"tasks"`
+	}
+	json.NewDecoder(resp.Body).Decode(&tr)
+	if len(tr.Tasks) != 1 || len(tr.Tasks[0].Attempts) != 1 {
+		t.Fatalf("unexpected tasks: %+v", tr.Tasks)
+	}
+	got := tr.Tasks[0]
+	attempt := got.Attempts[0]
+	if got.Status != "completed" || got.Result == nil || *got.Result != 5 {
+		t.Fatalf("unexpected task: %+v", got)
+	}
+	if attempt.AgentID != "agent-1" || attempt.AgentVersion != "1.2.0" || attempt.Worker != 3 ||
+		attempt.FinishedAt == nil || attempt.FinishedAt.Before(attempt.StartedAt) || attempt.Outcome != "completed" {
+		t.Fatalf("unexpected provenance: %+v", attempt)
+	}
+}
+
+func TestVerification(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "grace")
+	b, _ := json.Marshal(map[string]any{"expression": "2+3", "verify": true})
+	req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cr struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&cr)
+	resp.Body.Close()
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+
+	// каждый агент выполняет задачу не больше одного раза
+	run := func(agentID string, result float64) {
+		t.Helper()
+		batch, err := cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: agentID, MaxN: 1})
+		if err != nil || len(batch.Tasks) != 1 {
+			t.Fatalf("%s: expected one task, got %v (%v)", agentID, batch, err)
+		}
+		task := batch.Tasks[0]
+		sub, err := cli.SubmitResults(context.Background(), &pb.SubmitResultsRequest{Results: []*pb.SubmitResultRequest{{
+			TaskId:  task.TaskId,
+			LeaseId: task.LeaseId,
+			Outcome: &pb.SubmitResultRequest_Result{Result: result},
+		}}})
+		if err != nil || !sub.Success[0] {
+			t.Fatalf("%s: submit failed: %v (%v)", agentID, sub, err)
+		}
+		again, err := cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: agentID, MaxN: 1})
+		if err != nil || len(again.Tasks) != 0 {
+			t.Fatalf("%s: task must go to another agent, got %v (%v)", agentID, again, err)
+		}
+	}
+	run("agent-1", 5)
+	run("agent-2", 6) // расходится с agent-1: нужен третий агент
+	run("agent-3", 5)
+
+	req, _ = http.NewRequest("GET", httpURL+"/api/v1/expressions/"+cr.ID+"/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var tr struct {
+		Tasks []models.ExpressionTask `json:"tasks"`
+	}
+	json.NewDecoder(resp.Body).Decode(&tr)
+	if len(tr.Tasks) != 1 || len(tr.Tasks[0].Attempts) != 3 {
+		t.Fatalf("unexpected tasks: %+v", tr.Tasks)
+	}
+	got := tr.Tasks[0]
+	if got.Status != "completed" || got.Result == nil || *got.Result != 5 {
+		t.Fatalf("unexpected task: %+v", got)
+	}
+	var outcomes []string
+	for _, a := range got.Attempts {
+		outcomes = append(outcomes, a.AgentID+":"+a.Outcome)
+	}
+	if strings.Join(outcomes, ",") != "agent-1:completed,agent-2:mismatch,agent-3:completed" {
+		t.Fatalf("unexpected attempts: %v", outcomes)
+	}
+
+	req, _ = http.NewRequest("GET", httpURL+"/api/v1/expressions/"+cr.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	var er struct {
+		Expression models.Expression `json:"expression"`
+	}
+	json.NewDecoder(resp2.Body).Decode(&er)
+	if !er.Expression.Verify || er.Expression.Status != "done" || er.Expression.Result == nil || *er.Expression.Result != 5 {
+		t.Fatalf("unexpected expression: %+v", er.Expression)
+	}
+}
+
+func TestAgentQuarantine(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "heidi")
+	for i := 0; i < 10; i++ {
+		b, _ := json.Marshal(map[string]string{"expression": fmt.Sprintf("%d+1", i)})
+		req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+	for _, id := range []string{"broken", "healthy"} {
+		if _, err := cli.RegisterAgent(context.Background(), &pb.RegisterAgentRequest{AgentId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// сломанный агент проваливает все 10 задач
+	batch, err := cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "broken", MaxN: 10})
+	if err != nil || len(batch.Tasks) != 10 {
+		t.Fatalf("expected 10 tasks, got %v (%v)", batch, err)
+	}
+	var results []*pb.SubmitResultRequest
+	for _, task := range batch.Tasks {
+		results = append(results, &pb.SubmitResultRequest{
+			TaskId:  task.TaskId,
+			LeaseId: task.LeaseId,
+			Outcome: &pb.SubmitResultRequest_Error{Error: string(models.ErrInternalError)},
+		})
+	}
+	if _, err := cli.SubmitResults(context.Background(), &pb.SubmitResultsRequest{Results: results}); err != nil {
+		t.Fatal(err)
+	}
+
+	token = registerAndLogin(t, httpURL, "alice")
+	admin := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, httpURL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	var health map[string]models.AgentHealth
+	deadline := time.Now().Add(2 * time.Second)
+	for !health["broken"].Quarantined {
+		if time.Now().After(deadline) {
+			t.Fatalf("agent was not quarantined: %+v", health)
+		}
+		time.Sleep(50 * time.Millisecond)
+		resp := admin("GET", "/api/v1/admin/agents/health")
+		var hr struct {
+			Agents []models.AgentHealth `json:"agents"`
+		}
+		json.NewDecoder(resp.Body).Decode(&hr)
+		resp.Body.Close()
+		health = make(map[string]models.AgentHealth)
+		for _, h := range hr.Agents {
+			health[h.AgentID] = h
+		}
+	}
+	if h := health["broken"]; h.Score != 0 || h.InternalErrors != 10 || h.QuarantineReason == "" {
+		t.Fatalf("unexpected health of broken agent: %+v", h)
+	}
+	if h := health["healthy"]; h.Quarantined || h.Score != 1 {
+		t.Fatalf("unexpected health of healthy agent: %+v", h)
+	}
+
+	// после паузы повтора задачи достаются только здоровому агенту
+	time.Sleep(1100 * time.Millisecond)
+	batch, err = cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "broken", MaxN: 10})
+	if err != nil || len(batch.Tasks) != 0 {
+		t.Fatalf("quarantined agent must not get tasks, got %v (%v)", batch, err)
+	}
+	batch, err = cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "healthy", MaxN: 1})
+	if err != nil || len(batch.Tasks) != 1 {
+		t.Fatalf("expected a task for healthy agent, got %v (%v)", batch, err)
+	}
+
+	if resp := admin("DELETE", "/api/v1/admin/agents/broken/quarantine"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("release failed: %v", resp.Status)
+	}
+	if resp := admin("DELETE", "/api/v1/admin/agents/broken/quarantine"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("second release must fail, got %v", resp.Status)
+	}
+	batch, err = cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "broken", MaxN: 1})
+	if err != nil || len(batch.Tasks) != 1 {
+		t.Fatalf("released agent must get tasks again, got %v (%v)", batch, err)
+	}
+}
+
+// TestQueueDepthAndWorkers проверяет данные для автомасштабирования агента:
+// queue_depth в выданных задачах и число воркеров из heartbeat.
+func TestQueueDepthAndWorkers(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "alice")
+	for _, expr := range []string{"1+2", "3+4", "5+6"} {
+		b, _ := json.Marshal(map[string]string{"expression": expr})
+		req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+
+	batch, err := cli.GetTasks(context.Background(), &pb.GetTasksRequest{AgentId: "agent-1", MaxN: 1})
+	if err != nil || len(batch.Tasks) != 1 {
+		t.Fatalf("expected one task, got %v (%v)", batch, err)
+	}
+	if batch.Tasks[0].QueueDepth != 2 {
+		t.Fatalf("expected queue depth 2, got %d", batch.Tasks[0].QueueDepth)
+	}
+
+	if _, err := cli.RegisterAgent(context.Background(), &pb.RegisterAgentRequest{AgentId: "agent-1", ComputingPower: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Heartbeat(context.Background(), &pb.HeartbeatRequest{AgentId: "agent-1", Workers: 6}); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", httpURL+"/api/v1/admin/agents", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var ar struct {
+		Agents []models.Agent `json:"agents"`
+	}
+	json.NewDecoder(resp.Body).Decode(&ar)
+	if len(ar.Agents) != 1 || ar.Agents[0].ComputingPower != 6 {
+		t.Fatalf("unexpected agents: %+v", ar.Agents)
+	}
+}
+
+func TestCancelExpression(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t)
+	defer cleanup()
+
+	token := registerAndLogin(t, httpURL, "alice")
+	b, _ := json.Marshal(map[string]string{"expression": "2*3+1"})
+	req, _ := http.NewRequest("POST", httpURL+"/api/v1/calculate", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cr struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&cr)
+	resp.Body.Close()
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := cli.StreamTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.TaskStreamRequest{AgentId: "agent-1", FreeSlots: 1}); err != nil {
+		t.Fatal(err)
+	}
+	running, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelExpr := func() int {
+		req, _ := http.NewRequest("POST", httpURL+"/api/v1/expressions/"+cr.ID+"/cancel", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := cancelExpr(); code != http.StatusNoContent {
+		t.Fatalf("cancel returned %d", code)
+	}
+
+	// агент, выполняющий задачу, узнаёт об отмене из потока
+	msg, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.TaskId != "" || len(msg.CancelTaskIds) != 1 || msg.CancelTaskIds[0] != running.TaskId {
+		t.Fatalf("expected cancellation of %s, got %+v", running.TaskId, msg)
+	}
+	res, err := cli.SubmitResult(context.Background(), &pb.SubmitResultRequest{
+		TaskId:  running.TaskId,
+		LeaseId: running.LeaseId,
+		Outcome: &pb.SubmitResultRequest_Error{Error: string(models.ErrCancelled)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success {
+		t.Fatal("result of cancelled task was accepted")
+	}
+
+	req, _ = http.NewRequest("GET", httpURL+"/api/v1/expressions/"+cr.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var er struct {
+		Expression models.Expression `json:"expression"`
+	}
+	json.NewDecoder(resp.Body).Decode(&er)
+	if er.Expression.Status != repository.TaskStatusCancelled {
+		t.Fatalf("expected cancelled expression, got %+v", er.Expression)
+	}
+	if code := cancelExpr(); code != http.StatusConflict {
+		t.Fatalf("second cancel returned %d", code)
+	}
+}
+
+func TestRevokedAgentIdleStream(t *testing.T) {
+	httpURL, grpcAddr, cleanup := startServers(t, func(orc *service.Orchestrator) []grpc.ServerOption {
+		return orchestratorgrpc.NewAuthenticator("", nil).WithEnrollment(orc).ServerOptions()
+	})
+	defer cleanup()
+
+	admin := registerAndLogin(t, httpURL, "alice")
+	request := func(method, path string, body []byte) *http.Response {
+		req, _ := http.NewRequest(method, httpURL+path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+admin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := request("POST", "/api/v1/admin/join-tokens", nil)
+	var join models.JoinToken
+	json.NewDecoder(resp.Body).Decode(&join)
+	resp.Body.Close()
+
+	cfg := config.DefaultAgentConfig()
+	cfg.OrchestratorAddr = grpcAddr
+	cfg.CredentialsFile = filepath.Join(t.TempDir(), "credentials.json")
+	cfg.JoinToken = join.Token
+	cfg.AgentID = "worker-1"
+	a, err := agent.NewAgentWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := a.Client.StreamTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.TaskStreamRequest{AgentId: "worker-1", FreeSlots: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// поток открыт и молчит, пока ключ отзывают
+	time.Sleep(50 * time.Millisecond)
+	if resp := request("DELETE", "/api/v1/admin/enrolled-agents/worker-1", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("revoke: %v", resp.Status)
+	}
+
+	b, _ := json.Marshal(map[string]string{"expression": "1+2"})
+	resp = request("POST", "/api/v1/calculate", b)
+	var cr struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&cr)
+	resp.Body.Close()
+
+	msg, err := stream.Recv()
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected revoked stream to be closed, got %+v (%v)", msg, err)
+	}
+
+	resp = request("GET", "/api/v1/expressions/"+cr.ID+"/tasks", nil)
+	defer resp.Body.Close()
+	var tr struct {
+		Tasks []models.ExpressionTask `json:"tasks"`
+	}
+	json.NewDecoder(resp.Body).Decode(&tr)
+	if len(tr.Tasks) != 1 || tr.Tasks[0].Status != repository.TaskStatusPending || len(tr.Tasks[0].Attempts) != 0 {
+		t.Fatalf("task was leased to revoked agent: %+v", tr.Tasks)
+	}
+}
+
+// TestSharedTokenRequiresAgentID проверяет, что с общим токеном вызов без agent_id
+// отклоняется: иначе агент мог бы обойти карантин, не назвавшись.
+func TestSharedTokenRequiresAgentID(t *testing.T) {
+	_, grpcAddr, cleanup := startServers(t, func(*service.Orchestrator) []grpc.ServerOption {
+		return orchestratorgrpc.NewAuthenticator("shared", nil).ServerOptions()
+	})
+	defer cleanup()
+
+	conn, err := grpc.NewClient(grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewOrchestratorServiceClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer shared")
+
+	if _, err := cli.GetTasks(ctx, &pb.GetTasksRequest{MaxN: 1}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for empty agent_id, got %v", err)
+	}
+	if _, err := cli.GetTask(ctx, &pb.GetTaskRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for empty agent_id in GetTask, got %v", err)
+	}
+	stream, err := cli.StreamTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&pb.TaskStreamRequest{FreeSlots: 1})
+	if _, err := stream.Recv(); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied on stream, got %v", err)
+	}
+
+	if _, err := cli.GetTasks(ctx, &pb.GetTasksRequest{AgentId: "agent-1", MaxN: 1}); err != nil {
+		t.Fatalf("named agent with shared token: %v", err)
+	}
+}
+
+// TestDistinctVoters проверяет, что БД не выдаёт задачу с верификацией агенту,
+// который за неё уже голосовал, даже если очередь в памяти его пропустила.
+func TestDistinctVoters(t *testing.T) {
+	dbConn, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := db.RunMigrations(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewRepository(dbConn)
+	if err := repo.AddExpression(&models.Expression{ID: "e", Status: repository.TaskStatusPending, Owner: "ivan", Mode: models.ModeReal, Verify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddTask(&models.Task{ID: "e-1", Operation: "+", Arg1: 2, Arg2: 3, UserLogin: "ivan", Mode: models.ModeReal, Verify: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := repo.ClaimTasks("agent-1", []string{"e-1"}, time.Second)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("first claim: %v (%v)", claimed, err)
+	}
+	statuses, err := repo.UpdateTaskResults([]repository.ResultUpdate{{
+		TaskID: "e-1", LeaseID: claimed[0].LeaseID, Result: &models.TaskResult{Value: 5},
+	}})
+	if err != nil || statuses[0].Status != repository.TaskStatusPending {
+		t.Fatalf("first vote must requeue the task, got %+v (%v)", statuses, err)
+	}
+
+	// второй голос того же агента или агента без ID не принимается
+	for _, agentID := range []string{"agent-1", ""} {
+		claimed, err := repo.ClaimTasks(agentID, []string{"e-1"}, time.Second)
+		if err != nil || len(claimed) != 0 {
+			t.Fatalf("agent %q must not vote again, got %v (%v)", agentID, claimed, err)
+		}
+	}
+	claimed, err = repo.ClaimTasks("agent-2", []string{"e-1"}, time.Second)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("another agent must get the task: %v (%v)", claimed, err)
+	}
+}
+
+// TestExpiredLeaseDeadLetter проверяет, что задача, аренда которой истекает при
+// каждой выдаче (она роняет агентов), после MaxAttempts выдач уходит в dead.
+func TestExpiredLeaseDeadLetter(t *testing.T) {
+	dbConn, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := db.RunMigrations(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewRepository(dbConn)
+	if err := repo.AddExpression(&models.Expression{ID: "e", Status: repository.TaskStatusPending, Owner: "judy", Mode: models.ModeReal}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddTask(&models.Task{ID: "e-1", Operation: "+", Arg1: 2, Arg2: 3, UserLogin: "judy", Mode: models.ModeReal}); err != nil {
+		t.Fatal(err)
+	}
+
+	// отрицательный запас — аренда истекает сразу после выдачи
+	expire := func() ([]*models.Task, []string) {
+		t.Helper()
+		claimed, err := repo.ClaimTasks("agent-1", []string{"e-1"}, -time.Second)
+		if err != nil || len(claimed) != 1 {
+			t.Fatalf("claim: %v (%v)", claimed, err)
+		}
+		requeued, dead, err := repo.RequeueExpiredTasks(time.Now(), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return requeued, dead
+	}
+	if requeued, dead := expire(); len(requeued) != 1 || len(dead) != 0 {
+		t.Fatalf("first expiry must requeue the task, got %v, dead %v", requeued, dead)
+	}
+	if requeued, dead := expire(); len(requeued) != 0 || len(dead) != 1 || dead[0] != "e-1" {
+		t.Fatalf("second expiry must mark the task dead, got %v, dead %v", requeued, dead)
+	}
+
+	deadTasks, err := repo.DeadTasks()
+	if err != nil || len(deadTasks) != 1 || len(deadTasks[0].Attempts) != 2 {
+		t.Fatalf("unexpected dead tasks: %+v (%v)", deadTasks, err)
+	}
+	for _, a := range deadTasks[0].Attempts {
+		if a.Outcome != repository.OutcomeLeaseExpired {
+			t.Fatalf("unexpected attempt outcome: %+v", a)
+		}
+	}
 }
@@ -8,13 +8,16 @@ import (
 	"calculator_app/internal/orchestrator/repository"
 	"calculator_app/internal/orchestrator/service"
 	pb "calculator_app/internal/proto"
+	"context"
 	"database/sql"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"log"
 	_ "modernc.org/sqlite"
 	"net"
 	"net/http"
 	"os"
+	"time"
 )
 
 func main() {
@@ -58,24 +61,66 @@ func main() {
 	}
 
 	repo := repository.NewRepository(dbConn)
-	orc := service.NewOrchestrator(cfg.TimeAdditionMS, cfg.TimeSubtractionMS, cfg.TimeMultiplicationMS, cfg.TimeDivisionMS, repo)
-	OrchHandler := handler.NewHandler(orc)
+	orc := service.NewOrchestrator(cfg.TimeAdditionMS, cfg.TimeSubtractionMS, cfg.TimeMultiplicationMS, cfg.TimeDivisionMS, repo).
+		WithUserWeights(cfg.UserWeights).
+		WithRetryPolicy(repository.RetryPolicy{
+			MaxAttempts: cfg.TaskMaxAttempts,
+			Backoff:     time.Duration(cfg.TaskRetryBackoffMS) * time.Millisecond,
+			MaxBackoff:  time.Duration(cfg.TaskRetryMaxBackoffMS) * time.Millisecond,
+		}).
+		WithVerification(cfg.VerifyUsers, cfg.VerifyTolerance).
+		WithHealthPolicy(service.HealthPolicy{
+			MinScore:    cfg.HealthMinScore,
+			MinAttempts: cfg.HealthMinAttempts,
+			Window:      time.Duration(cfg.HealthWindowMS) * time.Millisecond,
+		})
+	if err := orc.LoadReadyQueue(); err != nil {
+		log.Fatalf("Failed to load ready queue: %v", err)
+	}
+	OrchHandler := handler.NewHandler(orc).WithAdmins(cfg.AdminLogins)
+
+	go orc.RunLeaseReaper(context.Background(), service.LeaseReapInterval)
+	go orc.RunHealthMonitor(context.Background(), service.HealthCheckInterval)
 
 	http.HandleFunc("POST /api/v1/register", OrchHandler.RegisterUser)
 	http.HandleFunc("POST /api/v1/login", OrchHandler.LoginUser)
 	http.HandleFunc("POST /api/v1/calculate", OrchHandler.AddExpression)
 	http.HandleFunc("GET /api/v1/expressions", OrchHandler.GetExpressions)
 	http.HandleFunc("GET /api/v1/expressions/{id}", OrchHandler.GetExpressionByID)
+	http.HandleFunc("GET /api/v1/expressions/{id}/tasks", OrchHandler.GetExpressionTasks)
+	http.HandleFunc("POST /api/v1/expressions/{id}/cancel", OrchHandler.CancelExpression)
+	http.HandleFunc("GET /api/v1/admin/agents", OrchHandler.GetAgents)
+	http.HandleFunc("GET /api/v1/admin/agents/health", OrchHandler.GetAgentHealth)
+	http.HandleFunc("DELETE /api/v1/admin/agents/{id}/quarantine", OrchHandler.ReleaseQuarantine)
+	http.HandleFunc("GET /api/v1/admin/queue", OrchHandler.GetQueue)
+	http.HandleFunc("GET /api/v1/admin/dead-tasks", OrchHandler.GetDeadTasks)
+	http.HandleFunc("POST /api/v1/admin/join-tokens", OrchHandler.CreateJoinToken)
+	http.HandleFunc("GET /api/v1/admin/enrolled-agents", OrchHandler.GetEnrolledAgents)
+	http.HandleFunc("DELETE /api/v1/admin/enrolled-agents/{id}", OrchHandler.RevokeAgent)
+
+	auth := grpcservice.NewAuthenticator(cfg.GRPCAuthToken, cfg.GRPCAgentTokens)
+	if cfg.GRPCAgentEnrollment {
+		auth.WithEnrollment(orc)
+	}
+	grpcOpts := auth.ServerOptions()
+	if cfg.GRPCTLS.CertFile != "" {
+		tlsConfig, err := cfg.GRPCTLS.ServerConfig()
+		if err != nil {
+			log.Fatalf("Failed to load gRPC TLS config: %v", err)
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
 
 	go func() {
 		lis, err := net.Listen("tcp", ":50051")
 		if err != nil {
 			log.Fatalf("failed to listen: %v", err)
 		}
-		s := grpc.NewServer()
+		s := grpc.NewServer(grpcOpts...)
 		pb.RegisterOrchestratorServiceServer(s, grpcservice.NewOrchestratorGRPCServer(orc))
 
-		log.Println("gRPC сервер запущен на порту 50051")
+		log.Printf("gRPC сервер запущен на порту 50051 (TLS: %t, mTLS: %t, токены: %t)",
+			cfg.GRPCTLS.CertFile != "", cfg.GRPCTLS.CAFile != "", auth.Enabled())
 		if err := s.Serve(lis); err != nil {
 			log.Fatalf("failed to serve: %v", err)
 		}
@@ -21,22 +21,107 @@ func RunMigrations(db *sql.DB) error {
             id TEXT PRIMARY KEY,
 			status TEXT NOT NULL,
 			result REAL,
+			result_imag REAL,
+			result_lower REAL,
+			result_upper REAL,
+			result_text TEXT,
+			result_type TEXT,
 			owner TEXT NOT NULL,
+			mode TEXT NOT NULL DEFAULT 'real',
+			priority INTEGER NOT NULL DEFAULT 0,
+			verify INTEGER NOT NULL DEFAULT 0,
 			FOREIGN KEY (owner) REFERENCES users(login)
         );`,
 		`CREATE TABLE IF NOT EXISTS tasks (
             id TEXT PRIMARY KEY,
 			arg1 REAL NOT NULL,
 			arg2 REAL NOT NULL,
+			arg1_text TEXT,
+			arg2_text TEXT,
+			arg1_imag REAL NOT NULL DEFAULT 0,
+			arg2_imag REAL NOT NULL DEFAULT 0,
+			arg1_upper REAL NOT NULL DEFAULT 0,
+			arg2_upper REAL NOT NULL DEFAULT 0,
+			arg1_type TEXT NOT NULL DEFAULT '',
+			arg2_type TEXT NOT NULL DEFAULT '',
 			operation TEXT NOT NULL,
+			mode TEXT NOT NULL DEFAULT 'real',
 			operation_time INTEGER,
 			result REAL,
-			depends_on TEXT,
+			result_imag REAL,
+			result_upper REAL,
+			result_text TEXT,
+			result_type TEXT,
+			depends_on TEXT, -- устарело: зависимости хранятся в task_dependencies
 			user_login TEXT NOT NULL,
+			priority INTEGER NOT NULL DEFAULT 0,
 			status TEXT NOT NULL DEFAULT 'pending',
+			agent_id TEXT,
+			lease_id TEXT,
+			lease_expires_at INTEGER,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			retry_at INTEGER,
+			verify INTEGER NOT NULL DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_login) REFERENCES users(login)
+        );`,
+		`CREATE TABLE IF NOT EXISTS task_dependencies (
+            task_id TEXT NOT NULL,
+			depends_on TEXT NOT NULL,
+			position TEXT NOT NULL CHECK (position IN ('left', 'right')),
+			PRIMARY KEY (task_id, position),
+			FOREIGN KEY (task_id) REFERENCES tasks(id),
+			FOREIGN KEY (depends_on) REFERENCES tasks(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_task_dependencies_depends_on ON task_dependencies(depends_on);`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);`,
+		`CREATE TABLE IF NOT EXISTS task_attempts (
+            task_id TEXT NOT NULL,
+			attempt INTEGER NOT NULL,
+			agent_id TEXT,
+			agent_version TEXT,
+			worker INTEGER,
+			lease_id TEXT NOT NULL,
+			started_at INTEGER NOT NULL,
+			finished_at INTEGER,
+			outcome TEXT,
+			-- результат исполнения задачи с верификацией; vote = 1, если он учтён при сверке
+			vote INTEGER NOT NULL DEFAULT 0,
+			result REAL,
+			result_imag REAL,
+			result_upper REAL,
+			result_text TEXT,
+			result_type TEXT,
+			PRIMARY KEY (task_id, attempt),
+			FOREIGN KEY (task_id) REFERENCES tasks(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS agents (
+            id TEXT PRIMARY KEY,
+			hostname TEXT NOT NULL DEFAULT '',
+			version TEXT NOT NULL DEFAULT '',
+			computing_power INTEGER NOT NULL DEFAULT 0,
+			labels TEXT NOT NULL DEFAULT '{}',
+			registered_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_seen DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			quarantined_at INTEGER,
+			quarantine_reason TEXT,
+			health_reset_at INTEGER
+        );`,
+		`CREATE TABLE IF NOT EXISTS join_tokens (
+            token_hash TEXT PRIMARY KEY,
+			created_by TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			used_at INTEGER,
+			agent_id TEXT
+        );`,
+		`CREATE TABLE IF NOT EXISTS agent_credentials (
+            agent_id TEXT PRIMARY KEY,
+			credential_hash TEXT NOT NULL,
+			enrolled_by TEXT NOT NULL,
+			enrolled_at INTEGER NOT NULL,
+			revoked_at INTEGER
         );`,
 	}
 
@@ -49,12 +134,132 @@ func RunMigrations(db *sql.DB) error {
 		}
 	}
 
+	// Колонки, добавленные после первой версии схемы: для существующих баз
+	// CREATE TABLE IF NOT EXISTS их не создаст.
+	columns := []struct {
+		table      string
+		definition string
+	}{
+		{"expressions", "result_text TEXT"},
+		{"tasks", "arg1_text TEXT"},
+		{"tasks", "arg2_text TEXT"},
+		{"tasks", "result_text TEXT"},
+		{"expressions", "mode TEXT NOT NULL DEFAULT 'real'"},
+		{"tasks", "mode TEXT NOT NULL DEFAULT 'real'"},
+		{"expressions", "result_imag REAL"},
+		{"tasks", "arg1_imag REAL NOT NULL DEFAULT 0"},
+		{"tasks", "arg2_imag REAL NOT NULL DEFAULT 0"},
+		{"tasks", "result_imag REAL"},
+		{"expressions", "result_lower REAL"},
+		{"expressions", "result_upper REAL"},
+		{"tasks", "arg1_upper REAL NOT NULL DEFAULT 0"},
+		{"tasks", "arg2_upper REAL NOT NULL DEFAULT 0"},
+		{"tasks", "result_upper REAL"},
+		{"expressions", "result_type TEXT"},
+		{"tasks", "arg1_type TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "arg2_type TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "result_type TEXT"},
+		{"tasks", "agent_id TEXT"},
+		{"tasks", "lease_id TEXT"},
+		{"tasks", "lease_expires_at INTEGER"},
+		{"expressions", "priority INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "priority INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "attempts INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "retry_at INTEGER"},
+		{"agents", "labels TEXT NOT NULL DEFAULT '{}'"},
+		{"task_attempts", "agent_version TEXT"},
+		{"task_attempts", "worker INTEGER"},
+		{"expressions", "verify INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "verify INTEGER NOT NULL DEFAULT 0"},
+		{"task_attempts", "vote INTEGER NOT NULL DEFAULT 0"},
+		{"task_attempts", "result REAL"},
+		{"task_attempts", "result_imag REAL"},
+		{"task_attempts", "result_upper REAL"},
+		{"task_attempts", "result_text TEXT"},
+		{"task_attempts", "result_type TEXT"},
+		{"agents", "quarantined_at INTEGER"},
+		{"agents", "quarantine_reason TEXT"},
+		{"agents", "health_reset_at INTEGER"},
+	}
+
+	for _, col := range columns {
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", col.table, col.definition)
+		if _, err := db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return fmt.Errorf("migration failed for column %s.%s: %w", col.table, col.definition, err)
+		}
+	}
+
+	if err := migrateDependsOn(db); err != nil {
+		return fmt.Errorf("migration failed for task_dependencies: %w", err)
+	}
+
 	fmt.Println("DB migrations completed")
 	return nil
 }
 
+// migrateDependsOn переносит зависимости из строки tasks.depends_on в task_dependencies.
+// Позиция одиночной зависимости определяется так же, как её раньше подставлял агент:
+// в левый аргумент, если он пустой, иначе в правый.
+func migrateDependsOn(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT id, depends_on, arg1 = 0 AND COALESCE(arg1_text, '') = '' AND arg1_imag = 0 AND arg1_upper = 0 AND arg1_type = ''
+		FROM tasks
+		WHERE depends_on IS NOT NULL AND depends_on != ''`)
+	if err != nil {
+		return err
+	}
+
+	type legacy struct {
+		id, dependsOn string
+		leftEmpty     bool
+	}
+	var tasks []legacy
+	for rows.Next() {
+		var t legacy
+		if err := rows.Scan(&t.id, &t.dependsOn, &t.leftEmpty); err != nil {
+			rows.Close()
+			return err
+		}
+		tasks = append(tasks, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range tasks {
+		deps := strings.Split(t.dependsOn, ",")
+		positions := []string{"left", "right"}
+		if len(deps) == 1 && !t.leftEmpty {
+			positions = []string{"right"}
+		}
+		for i, dep := range deps {
+			if i >= len(positions) {
+				break
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO task_dependencies (task_id, depends_on, position) VALUES (?, ?, ?)`,
+				t.id, dep, positions[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`UPDATE tasks SET depends_on = NULL WHERE id = ?`, t.id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func extractTableName(stmt string) string {
-	re := regexp.MustCompile(`(?i)CREATE\s+TABLE\s+IF\s+NOT\s+EXISTS\s+(\w+)`)
+	re := regexp.MustCompile(`(?i)CREATE\s+(?:TABLE|INDEX)\s+IF\s+NOT\s+EXISTS\s+(\w+)`)
 	matches := re.FindStringSubmatch(stmt)
 	if len(matches) >= 2 {
 		return strings.TrimSpace(matches[1])
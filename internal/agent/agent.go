@@ -1,219 +1,1046 @@
 package agent
 
 import (
+	"calculator_app/internal/config"
 	"calculator_app/internal/pkg/models"
+	"calculator_app/internal/pkg/operations"
 	pb "calculator_app/internal/proto"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"io"
 	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Version сообщается оркестратору при регистрации; задаётся при сборке
+// через -ldflags "-X calculator_app/internal/agent.Version=...".
+var Version = "dev"
+
+// defaultHeartbeatInterval используется, если оркестратор не прислал свой интервал.
+const defaultHeartbeatInterval = 5 * time.Second
+
+// maxSubmitBatch — сколько результатов агент отправляет одним SubmitResults.
+const maxSubmitBatch = 32
+
+// DefaultShutdownGrace — сколько Stop по умолчанию ждёт завершения начатых задач.
+const DefaultShutdownGrace = 10 * time.Second
+
+// releaseTimeout ограничивает ReleaseTasks при остановке, чтобы недоступный
+// оркестратор не задерживал выход.
+const releaseTimeout = 5 * time.Second
+
+// maxReleaseBatch — сколько задач агент возвращает одним ReleaseTasks.
+const maxReleaseBatch = 100
+
 type Agent struct {
 	orchestratorURL string
-	ComputingPower  int
-	db              *sql.DB
-	Client          pb.OrchestratorServiceClient
-	ctx             context.Context
-	cancel          context.CancelFunc
+	ID              string
+	Hostname        string
+	// ComputingPower — число воркеров; при автомасштабировании — начальное
+	ComputingPower int
+	// Автомасштабирование включено, если MaxWorkers > 0: пока оркестратор
+	// сообщает, что в очереди остались задачи, агент добавляет воркеров до
+	// MaxWorkers, а после ScaleIdle простоя убирает по одному до MinWorkers.
+	MinWorkers int
+	MaxWorkers int
+	ScaleIdle  time.Duration
+	// Labels сообщаются оркестратору при регистрации
+	Labels map[string]string
+	// Capabilities отправляются оркестратору при запросе задачи; по умолчанию —
+	// все зарегистрированные операции и все режимы.
+	Capabilities models.Capabilities
+	// ShutdownGrace — сколько Stop ждёт завершения начатых задач; не успевшие
+	// задачи возвращаются оркестратору. TaskTimeout — сколько может выполняться
+	// одна задача; 0 — без ограничения.
+	ShutdownGrace time.Duration
+	TaskTimeout   time.Duration
+	// SubmitAttempts — попыток отправки пакета результатов. Между попытками, как и
+	// между переподключениями потока задач, пауза Backoff удваивается до MaxBackoff.
+	SubmitAttempts int
+	Backoff        time.Duration
+	MaxBackoff     time.Duration
+	db             *sql.DB
+	Client         pb.OrchestratorServiceClient
+	conn           *grpc.ClientConn
+	ctx            context.Context
+	cancel         context.CancelFunc
+
+	// draining закрывается в начале остановки: агент больше не берёт задачи.
+	// flush закрывается, когда воркеры остановлены: submitter отправляет остаток и выходит.
+	draining   chan struct{}
+	flush      chan struct{}
+	stopOnce   sync.Once
+	receiving  sync.WaitGroup
+	working    sync.WaitGroup
+	submitting sync.WaitGroup
+
+	// held — полученные, но ещё не сданные задачи: ID задачи → аренда;
+	// running — отмена выполняющихся задач; revoked — задачи, отменённые
+	// оркестратором до начала выполнения
+	mu      sync.Mutex
+	held    map[string]string
+	running map[string]context.CancelCauseFunc
+	revoked map[string]bool
+
+	// unsent — результаты, которые не удалось отправить; resender переотправляет
+	// их, получив сигнал stashed или по истечении паузы
+	unsent  *Outbox
+	stashed chan struct{}
+
+	// grow запускает ещё n воркеров; retire останавливает по одному простаивающему.
+	// workers — текущее число воркеров, busy — из них занятых задачами.
+	grow    func(n int)
+	retire  chan struct{}
+	workers atomic.Int32
+	busy    atomic.Int32
 }
 
+// NewAgent создаёт агента с настройками по умолчанию и подключением без TLS.
 func NewAgent(orchestratorURL string, ComputingPower int) *Agent {
-	conn, err := grpc.NewClient(
-		orchestratorURL,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+	cfg := config.DefaultAgentConfig()
+	cfg.OrchestratorAddr = orchestratorURL
+	cfg.Workers = ComputingPower
+	a, err := NewAgentWithConfig(cfg)
 	if err != nil {
 		log.Fatalf("failed to connect to gRPC server: %v", err)
 	}
+	return a
+}
+
+// NewAgentWithConfig создаёт агента по проверенным настройкам cfg
+// (см. config.LoadAgentConfig). Если в cfg.CredentialsFile сохранён ключ
+// агента, агент работает под его ID и ключом; если файла нет, а задан
+// cfg.JoinToken, ключ сначала запрашивается у оркестратора (Enroll).
+func NewAgentWithConfig(cfg *config.AgentConfig) (*Agent, error) {
+	creds := insecure.NewCredentials()
+	if cfg.TLS.Enabled {
+		tlsConfig, err := cfg.TLS.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	hostname, _ := os.Hostname()
+
+	id, token := cfg.AgentID, cfg.AuthToken
+	if cfg.CredentialsFile != "" {
+		saved, err := loadOrEnroll(cfg.CredentialsFile, cfg.JoinToken, func() (*Credentials, error) {
+			conn, err := grpc.NewClient(cfg.OrchestratorAddr, grpc.WithTransportCredentials(creds))
+			if err != nil {
+				return nil, fmt.Errorf("connect to %s: %w", cfg.OrchestratorAddr, err)
+			}
+			defer conn.Close()
+			return Enroll(pb.NewOrchestratorServiceClient(conn), cfg.JoinToken, cfg.AgentID, hostname)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if saved != nil {
+			if cfg.AgentID != "" && cfg.AgentID != saved.AgentID {
+				log.Printf("Agent ID %s from config is ignored: enrolled as %s", cfg.AgentID, saved.AgentID)
+			}
+			id, token = saved.AgentID, saved.Credential
+		}
+	}
+	if id == "" {
+		id = uuid.NewString()
+	}
+	unsent, err := OpenOutbox(cfg.OutboxFile)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if token != "" {
+		if !cfg.TLS.Enabled {
+			log.Printf("Agent token is sent to %s without TLS", cfg.OrchestratorAddr)
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{agentID: id, token: token}))
+	}
+	conn, err := grpc.NewClient(cfg.OrchestratorAddr, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", cfg.OrchestratorAddr, err)
+	}
 
 	client := pb.NewOrchestratorServiceClient(conn)
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Agent{
-		orchestratorURL: orchestratorURL,
-		ComputingPower:  ComputingPower,
+		orchestratorURL: cfg.OrchestratorAddr,
+		ID:              id,
+		Hostname:        hostname,
+		ComputingPower:  cfg.Workers,
+		MinWorkers:      cfg.MinWorkers,
+		MaxWorkers:      cfg.MaxWorkers,
+		ScaleIdle:       time.Duration(cfg.ScaleIdleMS) * time.Millisecond,
+		Labels:          cfg.Labels,
+		Capabilities:    DefaultCapabilities(),
+		ShutdownGrace:   time.Duration(cfg.ShutdownGraceMS) * time.Millisecond,
+		SubmitAttempts:  cfg.SubmitAttempts,
+		Backoff:         time.Duration(cfg.RetryBackoffMS) * time.Millisecond,
+		MaxBackoff:      time.Duration(cfg.RetryMaxBackoffMS) * time.Millisecond,
+		TaskTimeout:     time.Duration(cfg.TaskTimeoutMS) * time.Millisecond,
 		Client:          client,
+		conn:            conn,
 		ctx:             ctx,
 		cancel:          cancel,
-	}
+		draining:        make(chan struct{}),
+		flush:           make(chan struct{}),
+		held:            make(map[string]string),
+		running:         make(map[string]context.CancelCauseFunc),
+		revoked:         make(map[string]bool),
+		unsent:          unsent,
+		stashed:         make(chan struct{}, 1),
+	}, nil
+}
+
+// tokenCredentials добавляет к каждому вызову токен агента и его ID
+// (проверяются перехватчиком оркестратора).
+type tokenCredentials struct {
+	agentID, token string
+}
+
+func (c tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + c.token,
+		"x-agent-id":    c.agentID,
+	}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return false
 }
 
 func (a *Agent) Start() {
-	for i := 0; i < a.ComputingPower; i++ {
-		go a.worker()
+	go a.heartbeat()
+
+	// в буфере задач умещается всё, что оркестратор может выдать на объявленные
+	// слоты, поэтому поток задач не ждёт воркеров, даже если слот только что отозван
+	capacity := max(a.ComputingPower, a.MaxWorkers)
+	tasks := make(chan *models.Task, capacity)
+	done := make(chan struct{})
+	outbox := make(chan Outcome, a.ComputingPower)
+	a.retire = make(chan struct{}, capacity)
+	nextID := 0
+	a.grow = func(n int) {
+		a.working.Add(n)
+		a.workers.Add(int32(n))
+		for range n {
+			nextID++
+			go a.worker(nextID, tasks, done, outbox)
+		}
 	}
+	a.grow(a.ComputingPower)
+	a.receiving.Add(1)
+	go a.receiveTasks(tasks, done)
+	a.submitting.Add(1)
+	go a.submitter(outbox)
+	go a.resender()
 }
 
-func (a *Agent) worker() {
+// Register сообщает оркестратору о себе и возвращает интервал heartbeat.
+func (a *Agent) Register() (time.Duration, error) {
+	resp, err := a.Client.RegisterAgent(a.ctx, &pb.RegisterAgentRequest{
+		AgentId:        a.ID,
+		Hostname:       a.Hostname,
+		Version:        Version,
+		ComputingPower: int32(a.ComputingPower),
+		Labels:         a.Labels,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("register agent %s: %w", a.ID, err)
+	}
+	if resp.HeartbeatIntervalMs <= 0 {
+		return defaultHeartbeatInterval, nil
+	}
+	return time.Duration(resp.HeartbeatIntervalMs) * time.Millisecond, nil
+}
+
+// heartbeat регистрирует агента и периодически подтверждает, что он жив.
+// Если оркестратор агента не знает (или регистрация не удалась), агент
+// регистрируется заново на следующем тике.
+func (a *Agent) heartbeat() {
+	registered := true
+	interval, err := a.Register()
+	if err != nil {
+		log.Printf("Failed to register agent: %v", err)
+		registered = false
+		interval = defaultHeartbeatInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-a.ctx.Done():
-			log.Println("Worker shutting down")
 			return
-		default:
+		case <-ticker.C:
+		}
+
+		if !registered {
+			next, err := a.Register()
+			if err != nil {
+				log.Printf("Failed to register agent: %v", err)
+				continue
+			}
+			if next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+			registered = true
+			continue
 		}
 
-		task, err := a.FetchTask()
+		resp, err := a.Client.Heartbeat(a.ctx, &pb.HeartbeatRequest{AgentId: a.ID, Workers: a.workers.Load()})
 		if err != nil {
-			log.Printf("Failed to fetch task: %v", err)
-			time.Sleep(1 * time.Second)
+			log.Printf("Heartbeat failed: %v", err)
 			continue
 		}
+		registered = resp.Registered
+	}
+}
 
-		for _, depID := range task.DependsOn {
-			for attempt := 0; attempt < 10; attempt++ {
-				result, err := a.GetDependencyResult(depID)
-				if err == nil {
-					if task.Arg1 == 0 {
-						task.Arg1 = result
-					} else {
-						task.Arg2 = result
-					}
-					break
-				}
+// Metrics — текущее состояние агента.
+type Metrics struct {
+	Workers       int `json:"workers"`
+	BusyWorkers   int `json:"busy_workers"`
+	UnsentResults int `json:"unsent_results"`
+}
 
-				if attempt == 9 {
-					log.Printf("Dependency %s not ready after 10 attempts", depID)
-					continue
-				}
-				time.Sleep(time.Duration(attempt+1) * 100 * time.Millisecond)
+func (a *Agent) Metrics() Metrics {
+	return Metrics{
+		Workers:       int(a.workers.Load()),
+		BusyWorkers:   int(a.busy.Load()),
+		UnsentResults: a.unsent.Len(),
+	}
+}
+
+func (a *Agent) autoscaling() bool {
+	return a.MaxWorkers > 0
+}
+
+// Outcome — результат или ошибка выполненной задачи, ожидающие отправки.
+// Worker — номер выполнившего задачу воркера (с 1), сообщается оркестратору.
+type Outcome struct {
+	Task   *models.Task
+	Result *models.TaskResult
+	Err    *models.TaskError
+	Worker int
+}
+
+// worker выполняет задачи из tasks, кладёт итог в outbox и сообщает в done, что освободился.
+// При остановке агента воркер доделывает текущую задачу и выходит (после
+// ShutdownGrace задача прерывается); простаивающий воркер выходит и по сигналу retire.
+func (a *Agent) worker(id int, tasks <-chan *models.Task, done chan<- struct{}, outbox chan<- Outcome) {
+	defer a.working.Done()
+	for {
+		select {
+		case <-a.ctx.Done():
+			log.Println("Worker shutting down")
+			return
+		case <-a.draining:
+			return
+		case <-a.retire:
+			return
+		case task := <-tasks:
+			// задача из буфера после начала остановки возвращается оркестратору
+			if a.stopping() {
+				return
+			}
+			if task.ID == "" || task.Operation == "" {
+				continue
+			}
+			a.busy.Add(1)
+			ctx, finish := a.startTask(task)
+			outcome := a.process(ctx, task)
+			finish()
+			a.busy.Add(-1)
+			// прерванная остановкой задача уже возвращена оркестратору
+			if a.ctx.Err() != nil {
+				return
+			}
+			outcome.Worker = id
+			select {
+			case outbox <- outcome:
+			case <-a.ctx.Done():
+				return
+			}
+			select {
+			case done <- struct{}{}:
+			case <-a.draining:
+				return
+			case <-a.ctx.Done():
+				return
 			}
 		}
-		if task.ID == "" || task.Operation == "" {
+	}
+}
+
+// startTask создаёт контекст выполнения задачи с ограничением TaskTimeout и
+// регистрирует его, чтобы оркестратор мог отменить задачу. finish снимает
+// регистрацию и освобождает контекст.
+func (a *Agent) startTask(task *models.Task) (ctx context.Context, finish func()) {
+	ctx, cancel := context.WithCancelCause(a.ctx)
+	a.mu.Lock()
+	if a.revoked[task.ID] {
+		delete(a.revoked, task.ID)
+		cancel(errTaskCancelled)
+	}
+	a.running[task.ID] = cancel
+	a.mu.Unlock()
+
+	stop := context.CancelFunc(func() {})
+	if a.TaskTimeout > 0 {
+		ctx, stop = context.WithTimeout(ctx, a.TaskTimeout)
+	}
+	return ctx, func() {
+		a.mu.Lock()
+		delete(a.running, task.ID)
+		a.mu.Unlock()
+		stop()
+		cancel(nil)
+	}
+}
+
+// errTaskCancelled — причина отмены задачи, выражение которой отменил пользователь.
+var errTaskCancelled = errors.New("expression cancelled")
+
+// cancelTasks прерывает задачи, которые отменил оркестратор. Задачи, ещё
+// ждущие воркера, завершатся ошибкой cancelled без выполнения.
+func (a *Agent) cancelTasks(taskIDs []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, id := range taskIDs {
+		if cancel, ok := a.running[id]; ok {
+			cancel(errTaskCancelled)
+		} else if _, ok := a.held[id]; ok {
+			a.revoked[id] = true
+		} else {
 			continue
 		}
+		log.Printf("Task %s cancelled by orchestrator", id)
+	}
+}
 
-		result, err := a.ExecuteTask(task)
-		if err != nil {
-			log.Printf("Task %s failed: %v", task.ID, err)
+func (a *Agent) process(ctx context.Context, task *models.Task) Outcome {
+	result, err := a.ExecuteTaskContext(ctx, task)
+	if err != nil {
+		log.Printf("Task %s failed: %v", task.ID, err)
 
-			var taskErr *models.TaskError
-			if errors.As(err, &taskErr) {
-				if subErr := a.SubmitWithRetry(task.ID, nil, 3, taskErr); subErr != nil {
-					log.Printf("Failed to submit error for task %s: %v", task.ID, subErr)
+		var taskErr *models.TaskError
+		if !errors.As(err, &taskErr) {
+			taskErr = models.NewTaskError(models.ErrInternalError, err.Error())
+		}
+		return Outcome{Task: task, Err: taskErr}
+	}
+	return Outcome{Task: task, Result: result}
+}
+
+// submitter отправляет итоги задач пакетами: всё, что успело накопиться в outbox
+// (но не больше maxSubmitBatch), уходит одним SubmitResults. После закрытия flush
+// отправляет остаток outbox и выходит.
+func (a *Agent) submitter(outbox <-chan Outcome) {
+	defer a.submitting.Done()
+	for {
+		var batch []Outcome
+		flushing := false
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-a.flush:
+			flushing = true
+		case o := <-outbox:
+			batch = append(batch, o)
+		}
+	drain:
+		for len(batch) < maxSubmitBatch {
+			select {
+			case o := <-outbox:
+				batch = append(batch, o)
+			default:
+				break drain
+			}
+		}
+
+		if len(batch) > 0 {
+			if err := a.SubmitBatchWithRetry(batch, a.SubmitAttempts); err != nil {
+				log.Printf("Failed to submit %d results: %v", len(batch), err)
+				if a.stash(outcomeRequests(batch)) {
+					a.forget(batch)
 				}
 			} else {
-				internalErr := models.NewTaskError(models.ErrInternalError, err.Error())
-				_ = a.SubmitWithRetry(task.ID, nil, 3, internalErr)
+				a.forget(batch)
 			}
+		}
+		if flushing && len(batch) < maxSubmitBatch {
+			return
+		}
+	}
+}
+
+func (a *Agent) hold(task *models.Task) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.held[task.ID] = task.LeaseID
+}
+
+func (a *Agent) forget(batch []Outcome) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, o := range batch {
+		delete(a.held, o.Task.ID)
+		delete(a.revoked, o.Task.ID)
+	}
+}
+
+// SubmitBatchWithRetry отправляет пакет результатов, повторяя при ошибках сети.
+func (a *Agent) SubmitBatchWithRetry(batch []Outcome, maxRetries int) error {
+	var lastErr error
+	for i := 0; i < maxRetries; i++ {
+		err := a.SubmitResults(batch)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if i < maxRetries-1 {
+			time.Sleep(a.backoff(i))
+		}
+	}
+	return fmt.Errorf("after %d attempts: %w", maxRetries, lastErr)
+}
+
+// SubmitResults отправляет пакет результатов одним запросом. Отклонённые оркестратором
+// результаты (истёкшая аренда) только логируются.
+func (a *Agent) SubmitResults(batch []Outcome) error {
+	return a.sendResults(outcomeRequests(batch))
+}
+
+func outcomeRequests(batch []Outcome) []*pb.SubmitResultRequest {
+	reqs := make([]*pb.SubmitResultRequest, 0, len(batch))
+	for _, o := range batch {
+		var r *pb.SubmitResultRequest
+		if o.Err != nil {
+			r = errorRequest(o.Task, o.Err)
+		} else {
+			r = resultRequest(o.Task, o.Result)
+		}
+		r.Worker = int32(o.Worker)
+		reqs = append(reqs, r)
+	}
+	return reqs
+}
+
+func (a *Agent) sendResults(reqs []*pb.SubmitResultRequest) error {
+	resp, err := a.Client.SubmitResults(a.ctx, &pb.SubmitResultsRequest{Results: reqs})
+	if err != nil {
+		return err
+	}
+	for i, ok := range resp.Success {
+		if !ok && i < len(reqs) {
+			log.Printf("Result for task %s was rejected by orchestrator (lease expired or expression cancelled?)", reqs[i].TaskId)
+		}
+	}
+	return nil
+}
+
+// stash сохраняет неотправленные результаты в outbox и будит resender; false,
+// если сохранить не удалось (задачи тогда остаются в held и возвращаются
+// оркестратору при остановке).
+func (a *Agent) stash(reqs []*pb.SubmitResultRequest) bool {
+	if err := a.unsent.Add(reqs); err != nil {
+		log.Printf("Failed to save %d results to outbox: %v", len(reqs), err)
+		return false
+	}
+	log.Printf("Saved %d results to outbox, %d pending", len(reqs), a.unsent.Len())
+	select {
+	case a.stashed <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// resender переотправляет результаты из outbox пакетами до maxSubmitBatch.
+// После неудачи пауза растёт как при переподключении потока задач; при
+// остановке агента неотправленное остаётся в файле до следующего запуска.
+func (a *Agent) resender() {
+	failures := 0
+	for {
+		if a.unsent.Len() == 0 {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-a.stashed:
+			}
+		} else if failures > 0 {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-time.After(a.backoff(failures - 1)):
+			}
+		}
+
+		batch := a.unsent.Peek(maxSubmitBatch)
+		if len(batch) == 0 {
+			continue
+		}
+		if err := a.sendResults(batch); err != nil {
+			if a.ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Printf("Failed to resend %d results from outbox: %v", len(batch), err)
 			continue
 		}
+		failures = 0
+		if err := a.unsent.Remove(batch); err != nil {
+			log.Printf("Failed to update outbox: %v", err)
+		}
+		log.Printf("Resent %d results from outbox, %d pending", len(batch), a.unsent.Len())
+	}
+}
 
-		if err := a.SubmitWithRetry(task.ID, &result, 3, nil); err != nil {
-			log.Printf("Failed to submit result for task %s: %v", task.ID, err)
+// receiveTasks держит поток StreamTasks и раздаёт полученные задачи воркерам.
+// idle — число свободных воркеров: оно объявляется при (пере)подключении, а после
+// каждой выполненной задачи оркестратору сообщается об одном освободившемся слоте.
+// При остановке агент закрывает свою сторону потока и дочитывает задачи, которые
+// оркестратор успел выдать: они не выполняются, а возвращаются через ReleaseTasks.
+// При автомасштабировании число воркеров меняется здесь же (см. serveStream).
+func (a *Agent) receiveTasks(tasks chan<- *models.Task, done <-chan struct{}) {
+	defer a.receiving.Done()
+	idle := a.ComputingPower
+	failures := 0
+	for {
+		stream, err := a.Client.StreamTasks(a.ctx)
+		if err == nil {
+			err = stream.Send(&pb.TaskStreamRequest{
+				AgentId:    a.ID,
+				Operations: a.Capabilities.Operations,
+				Modes:      a.Capabilities.Modes,
+				FreeSlots:  int32(idle),
+			})
+		}
+		if err == nil {
+			failures = 0
+			err = a.serveStream(stream, tasks, done, &idle)
+		}
+		if a.ctx.Err() != nil || a.stopping() {
+			return
+		}
+		log.Printf("Task stream interrupted: %v", err)
+
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-a.draining:
+			return
+		case <-time.After(a.backoff(failures)):
+		}
+		failures++
+	}
+}
+
+// backoff — пауза перед повтором номер attempt (с нуля): Backoff, удвоенный attempt
+// раз, но не больше MaxBackoff.
+func (a *Agent) backoff(attempt int) time.Duration {
+	d := a.Backoff
+	for i := 0; i < attempt && d < a.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, max(a.MaxBackoff, a.Backoff))
+}
+
+// serveStream раздаёт задачи из потока воркерам. При автомасштабировании
+// агент, получив задачу с непустой очередью (queue_depth) и не имея больше
+// свободных слотов, запускает новых воркеров и объявляет их слоты; если слоты
+// простаивают ScaleIdle, агент отзывает один (free_slots = -1) и останавливает
+// простаивающего воркера.
+func (a *Agent) serveStream(stream pb.OrchestratorService_StreamTasksClient, tasks chan<- *models.Task, done <-chan struct{}, idle *int) error {
+	received := make(chan *pb.GetTaskResponse)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case received <- resp:
+			case <-a.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	draining := a.draining
+	closed := false
+	for {
+		var shrink <-chan time.Time
+		if a.autoscaling() && !closed && *idle > 0 && int(a.workers.Load()) > a.MinWorkers {
+			shrink = time.After(a.ScaleIdle)
+		}
+
+		select {
+		case <-a.ctx.Done():
+			return a.ctx.Err()
+		case <-draining:
+			// оркестратор дошлёт уже выданные задачи и закроет поток
+			draining = nil
+			closed = true
+			if err := stream.CloseSend(); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				if closed {
+					return nil
+				}
+				return fmt.Errorf("stream closed by orchestrator")
+			}
+			return err
+		case resp := <-received:
+			if resp.TaskId == "" {
+				a.cancelTasks(resp.CancelTaskIds)
+				continue
+			}
+			task := taskFromResponse(resp)
+			a.hold(task)
+			if closed {
+				continue
+			}
+			*idle--
+			select {
+			case tasks <- task:
+			case <-a.draining:
+			case <-a.ctx.Done():
+				return a.ctx.Err()
+			}
+			if a.autoscaling() && resp.QueueDepth > 0 && *idle <= 0 && !a.stopping() {
+				n := min(int(resp.QueueDepth), a.MaxWorkers-int(a.workers.Load()))
+				if n > 0 {
+					a.grow(n)
+					*idle += n
+					log.Printf("Scaled up to %d workers: %d tasks are queued", a.workers.Load(), resp.QueueDepth)
+					if err := stream.Send(&pb.TaskStreamRequest{FreeSlots: int32(n)}); err != nil {
+						return err
+					}
+				}
+			}
+		case <-shrink:
+			*idle--
+			a.workers.Add(-1)
+			a.retire <- struct{}{}
+			log.Printf("Scaled down to %d workers after %s idle", a.workers.Load(), a.ScaleIdle)
+			if err := stream.Send(&pb.TaskStreamRequest{FreeSlots: -1}); err != nil {
+				return err
+			}
+		case <-done:
+			*idle++
+			if closed {
+				continue
+			}
+			if err := stream.Send(&pb.TaskStreamRequest{FreeSlots: 1}); err != nil {
+				return err
+			}
 		}
 	}
 }
 
+// Stop останавливает агента: он перестаёт брать задачи, ждёт до ShutdownGrace
+// завершения начатых, отправляет накопленные результаты (неотправленные остаются
+// в outbox), возвращает оркестратору невыполненные задачи и закрывает соединение.
+// Повторные вызовы ничего не делают.
 func (a *Agent) Stop() {
-	if a.cancel != nil {
+	a.stopOnce.Do(func() {
+		close(a.draining)
+		deadline := time.Now().Add(a.ShutdownGrace)
+		if !waitUntil(&a.receiving, deadline) || !waitUntil(&a.working, deadline) {
+			log.Printf("Grace period %s expired, unfinished tasks will be released", a.ShutdownGrace)
+		}
+		close(a.flush)
+		a.submitting.Wait()
+
+		if err := a.ReleaseTasks(); err != nil {
+			log.Printf("Failed to release tasks: %v", err)
+		}
 		a.cancel()
+		if n := a.unsent.Len(); n > 0 {
+			log.Printf("%d unsent results stay in outbox until the next start", n)
+		}
+		if a.conn != nil {
+			if err := a.conn.Close(); err != nil {
+				log.Printf("Failed to close connection: %v", err)
+			}
+		}
+	})
+}
+
+func (a *Agent) stopping() bool {
+	select {
+	case <-a.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitUntil ждёт wg до deadline; false, если время вышло.
+func waitUntil(wg *sync.WaitGroup, deadline time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
 	}
 }
 
-func (a *Agent) SubmitWithRetry(taskID string, result *float64, maxRetries int, taskErr *models.TaskError) error {
+// ReleaseTasks возвращает оркестратору задачи, которые агент получил, но не сдал.
+func (a *Agent) ReleaseTasks() error {
+	a.mu.Lock()
+	leases := make([]*pb.TaskLease, 0, len(a.held))
+	for id, lease := range a.held {
+		leases = append(leases, &pb.TaskLease{TaskId: id, LeaseId: lease})
+	}
+	a.mu.Unlock()
+	sort.Slice(leases, func(i, j int) bool { return leases[i].TaskId < leases[j].TaskId })
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	for len(leases) > 0 {
+		n := min(len(leases), maxReleaseBatch)
+		resp, err := a.Client.ReleaseTasks(ctx, &pb.ReleaseTasksRequest{AgentId: a.ID, Tasks: leases[:n]})
+		if err != nil {
+			return fmt.Errorf("release %d tasks: %w", len(leases), err)
+		}
+
+		released := 0
+		a.mu.Lock()
+		for i, l := range leases[:n] {
+			if i < len(resp.Success) && resp.Success[i] {
+				released++
+			}
+			delete(a.held, l.TaskId)
+		}
+		a.mu.Unlock()
+		log.Printf("Released %d of %d unfinished tasks", released, n)
+		leases = leases[n:]
+	}
+	return nil
+}
+
+// SubmitWithRetry отправляет результат одной задачи, повторяя при ошибках сети;
+// если все попытки не удались, результат сохраняется в outbox для повторной отправки.
+func (a *Agent) SubmitWithRetry(task *models.Task, result *models.TaskResult, maxRetries int, taskErr *models.TaskError) error {
 	var lastErr error
 	for i := 0; i < maxRetries; i++ {
 		var err error
 		if taskErr != nil {
-			err = a.SubmitError(taskID, taskErr)
+			err = a.SubmitError(task, taskErr)
 		} else {
-			err = a.SubmitResult(taskID, result)
+			err = a.SubmitResult(task, result)
 		}
 
 		if err == nil {
 			return nil
 		}
 		lastErr = err
-		time.Sleep(time.Second * time.Duration(i+1))
+		if i < maxRetries-1 {
+			time.Sleep(a.backoff(i))
+		}
 	}
+	a.stash(outcomeRequests([]Outcome{{Task: task, Result: result, Err: taskErr}}))
 	return fmt.Errorf("after %d attempts: %w", maxRetries, lastErr)
 }
 
+// FetchTask запрашивает одну задачу; nil без ошибки — задач нет.
 func (a *Agent) FetchTask() (*models.Task, error) {
-	resp, err := a.Client.GetTask(context.Background(), &pb.GetTaskRequest{})
+	resp, err := a.Client.GetTask(context.Background(), &pb.GetTaskRequest{
+		Operations: a.Capabilities.Operations,
+		Modes:      a.Capabilities.Modes,
+		AgentId:    a.ID,
+	})
 	if err != nil {
 		return nil, err
 	}
+	if resp.TaskId == "" {
+		return nil, nil
+	}
+
+	return taskFromResponse(resp), nil
+}
 
+// FetchTasks запрашивает до maxN готовых задач одним вызовом GetTasks.
+func (a *Agent) FetchTasks(maxN int) ([]*models.Task, error) {
+	resp, err := a.Client.GetTasks(context.Background(), &pb.GetTasksRequest{
+		Operations: a.Capabilities.Operations,
+		Modes:      a.Capabilities.Modes,
+		AgentId:    a.ID,
+		MaxN:       int32(maxN),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*models.Task, 0, len(resp.Tasks))
+	for _, t := range resp.Tasks {
+		tasks = append(tasks, taskFromResponse(t))
+	}
+	return tasks, nil
+}
+
+func taskFromResponse(resp *pb.GetTaskResponse) *models.Task {
 	return &models.Task{
 		ID:            resp.TaskId,
 		Operation:     resp.Operation,
 		Arg1:          resp.Arg1,
 		Arg2:          resp.Arg2,
+		Arg1Text:      resp.Arg1Text,
+		Arg2Text:      resp.Arg2Text,
+		Mode:          resp.Mode,
+		Arg1Imag:      resp.Arg1Imag,
+		Arg2Imag:      resp.Arg2Imag,
+		Arg1Upper:     resp.Arg1Upper,
+		Arg2Upper:     resp.Arg2Upper,
+		Arg1Type:      resp.Arg1Type,
+		Arg2Type:      resp.Arg2Type,
 		OperationTime: int(resp.OperationTime),
-		DependsOn:     resp.DependsOn,
 		UserLogin:     resp.UserLogin,
-	}, nil
+		LeaseID:       resp.LeaseId,
+	}
+}
+
+// ExecuteTask выполняет задачу без ограничения времени.
+func (a *Agent) ExecuteTask(task *models.Task) (*models.TaskResult, error) {
+	return a.ExecuteTaskContext(context.Background(), task)
 }
 
-func (a *Agent) ExecuteTask(task *models.Task) (float64, error) {
+// ExecuteTaskContext выполняет задачу, пока не завершён ctx: по истечении срока
+// ctx задача завершается ошибкой timeout, при отмене — cancelled. Долгие
+// вычисления (operations.Interruptible) прерываются вместе с ожиданием.
+func (a *Agent) ExecuteTaskContext(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
 	log.Printf("Executing task: %s %f %s %f", task.Operation, task.Arg1, task.Operation, task.Arg2)
-	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
-
-	switch task.Operation {
-	case "+":
-		return task.Arg1 + task.Arg2, nil
-	case "-":
-		return task.Arg1 - task.Arg2, nil
-	case "*":
-		return task.Arg1 * task.Arg2, nil
-	case "/":
-		if task.Arg2 == 0 {
-			log.Printf("Division by zero in task ID: %s", task.ID)
-			return 0, models.NewTaskError(models.ErrDivisionByZero, "division by zero")
-		}
-		return task.Arg1 / task.Arg2, nil
-	default:
+	timer := time.NewTimer(time.Duration(task.OperationTime) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, interruptError(ctx)
+	}
+
+	op, ok := operations.Lookup(task.Operation)
+	if !ok {
 		log.Printf("Unknown operation: %s in task ID: %s", task.Operation, task.ID)
-		return 0, models.NewTaskError(models.ErrUnknownOperation, "unknown operation")
+		return nil, models.NewTaskError(models.ErrUnknownOperation, "unknown operation")
+	}
+	interruptible, ok := op.(operations.Interruptible)
+	if !ok {
+		return op.Execute(task)
+	}
+	result, err := interruptible.ExecuteContext(ctx, task)
+	if err != nil && ctx.Err() != nil {
+		return nil, interruptError(ctx)
 	}
+	return result, err
 }
 
-func (a *Agent) SubmitResult(taskID string, result *float64) error {
-	_, err := a.Client.SubmitResult(context.Background(), &pb.SubmitResultRequest{
-		TaskId: taskID,
+// interruptError описывает, почему прервано выполнение задачи с контекстом ctx.
+func interruptError(ctx context.Context) *models.TaskError {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, context.DeadlineExceeded) {
+		return models.NewTaskError(models.ErrTimeout, "task execution timed out")
+	}
+	return models.NewTaskError(models.ErrCancelled, cause.Error())
+}
+
+// SubmitResult отправляет результат вместе с арендой задачи. Отклонённый результат
+// (аренда истекла, задача выдана другому агенту) не считается ошибкой: повторять его бессмысленно.
+func (a *Agent) SubmitResult(task *models.Task, result *models.TaskResult) error {
+	return a.submit(resultRequest(task, result))
+}
+
+func resultRequest(task *models.Task, result *models.TaskResult) *pb.SubmitResultRequest {
+	req := &pb.SubmitResultRequest{
+		TaskId:  task.ID,
+		LeaseId: task.LeaseID,
 		Outcome: &pb.SubmitResultRequest_Result{
-			Result: *result,
+			Result: result.Value,
 		},
-	})
-	return err
+		ResultImag:  result.Imag,
+		ResultUpper: result.Upper,
+		ResultType:  result.Type,
+	}
+	if result.Text != "" {
+		req.Outcome = &pb.SubmitResultRequest_ResultText{ResultText: result.Text}
+	}
+	return req
 }
 
-func (a *Agent) SubmitError(taskID string, taskErr *models.TaskError) error {
-	_, err := a.Client.SubmitResult(context.Background(), &pb.SubmitResultRequest{
-		TaskId: taskID,
+func (a *Agent) SubmitError(task *models.Task, taskErr *models.TaskError) error {
+	return a.submit(errorRequest(task, taskErr))
+}
+
+func errorRequest(task *models.Task, taskErr *models.TaskError) *pb.SubmitResultRequest {
+	return &pb.SubmitResultRequest{
+		TaskId:  task.ID,
+		LeaseId: task.LeaseID,
 		Outcome: &pb.SubmitResultRequest_Error{
 			Error: string(taskErr.Code),
 		},
-	})
-	return err
+	}
 }
 
-func (a *Agent) GetDependencyResult(taskID string) (float64, error) {
-	resp, err := a.Client.GetTaskResult(context.Background(), &pb.GetTaskResultRequest{TaskId: taskID})
-	if err != nil || !resp.TaskExists {
-		return 0, fmt.Errorf("result not available")
+func (a *Agent) submit(req *pb.SubmitResultRequest) error {
+	resp, err := a.Client.SubmitResult(context.Background(), req)
+	if err != nil {
+		return err
 	}
-
-	if resp.Result != nil {
-		return resp.Result.GetValue(), nil // Извлекаем значение из DoubleValue
+	if !resp.Success {
+		log.Printf("Result for task %s was rejected by orchestrator (lease expired?)", req.TaskId)
 	}
+	return nil
+}
 
-	return 0, fmt.Errorf("result not available")
+func DefaultCapabilities() models.Capabilities {
+	return models.Capabilities{
+		Operations: operations.Names(),
+		Modes:      models.Modes,
+	}
 }
 
 func NewTestAgent(client pb.OrchestratorServiceClient, power int) *Agent {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Agent{
+		ID:             "test-agent",
 		Client:         client,
 		ComputingPower: power,
+		ShutdownGrace:  DefaultShutdownGrace,
+		SubmitAttempts: 3,
+		Backoff:        10 * time.Millisecond,
+		MaxBackoff:     100 * time.Millisecond,
 		ctx:            ctx,
 		cancel:         cancel,
+		draining:       make(chan struct{}),
+		flush:          make(chan struct{}),
+		held:           make(map[string]string),
+		running:        make(map[string]context.CancelCauseFunc),
+		revoked:        make(map[string]bool),
+		unsent:         &Outbox{},
+		stashed:        make(chan struct{}, 1),
 	}
 }
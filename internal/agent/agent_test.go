@@ -3,12 +3,18 @@ package agent_test
 import (
 	"calculator_app/internal/agent"
 	"calculator_app/internal/agent/mocks"
+	"calculator_app/internal/config"
 	"calculator_app/internal/pkg/models"
 	pb "calculator_app/internal/proto"
+	"context"
 	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
-	"google.golang.org/protobuf/types/known/wrapperspb"
+	"google.golang.org/grpc"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -30,6 +36,26 @@ func TestExecuteTask(t *testing.T) {
 		{"Division", &models.Task{Arg1: 4, Arg2: 2, Operation: "/"}, 2, false},
 		{"DivisionByZero", &models.Task{Arg1: 4, Arg2: 0, Operation: "/"}, 0, true},
 		{"UnknownOperation", &models.Task{Arg1: 4, Arg2: 2, Operation: "%"}, 0, true},
+		{"Factorial", &models.Task{Arg1: 5, Operation: "fact"}, 120, false},
+		{"FactorialOfZero", &models.Task{Arg1: 0, Operation: "fact"}, 1, false},
+		{"FactorialNonInteger", &models.Task{Arg1: 2.5, Operation: "fact"}, 0, true},
+		{"FactorialNegative", &models.Task{Arg1: -3, Operation: "fact"}, 0, true},
+		{"GCD", &models.Task{Arg1: 12, Arg2: 18, Operation: "gcd"}, 6, false},
+		{"LCM", &models.Task{Arg1: 4, Arg2: 6, Operation: "lcm"}, 12, false},
+		{"IsPrime", &models.Task{Arg1: 97, Operation: "isprime"}, 1, false},
+		{"IsNotPrime", &models.Task{Arg1: 91, Operation: "isprime"}, 0, false},
+		{"Choose", &models.Task{Arg1: 10, Arg2: 3, Operation: "choose"}, 120, false},
+		{"Fibonacci", &models.Task{Arg1: 10, Operation: "fib"}, 55, false},
+		{"And", &models.Task{Arg1: 12, Arg2: 10, Operation: "&"}, 8, false},
+		{"Or", &models.Task{Arg1: 12, Arg2: 10, Operation: "|"}, 14, false},
+		{"Xor", &models.Task{Arg1: 12, Arg2: 10, Operation: "xor"}, 6, false},
+		{"Not", &models.Task{Arg1: 5, Operation: "~"}, -6, false},
+		{"ShiftLeft", &models.Task{Arg1: 1, Arg2: 4, Operation: "<<"}, 16, false},
+		{"ShiftRight", &models.Task{Arg1: 255, Arg2: 4, Operation: ">>"}, 15, false},
+		{"BitwiseNonInteger", &models.Task{Arg1: 1.5, Arg2: 1, Operation: "&"}, 0, true},
+		{"IntegerDivision", &models.Task{Arg1: 7, Arg2: 2, Operation: "/", Mode: models.ModeInteger}, 3, false},
+		{"IntegerDivisionByZero", &models.Task{Arg1: 7, Arg2: 0, Operation: "/", Mode: models.ModeInteger}, 0, true},
+		{"IntegerModeNonInteger", &models.Task{Arg1: 7.5, Arg2: 2, Operation: "+", Mode: models.ModeInteger}, 0, true},
 	}
 
 	for _, tt := range tests {
@@ -39,12 +65,164 @@ func TestExecuteTask(t *testing.T) {
 				t.Errorf("ExecuteTask() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if !tt.wantErr {
-				assert.Equal(t, tt.expected, result)
+				assert.Equal(t, tt.expected, result.Value)
 			}
 		})
 	}
 }
 
+func TestExecuteTask_Complex(t *testing.T) {
+	a := &agent.Agent{}
+
+	tests := []struct {
+		name     string
+		task     *models.Task
+		expected complex128
+		wantErr  bool
+	}{
+		{"SqrtOfMinusOne", &models.Task{Arg1: -1, Operation: "sqrt", Mode: models.ModeComplex}, 1i, false},
+		{"Addition", &models.Task{Arg1: 3, Arg2Imag: 4, Operation: "+", Mode: models.ModeComplex}, 3 + 4i, false},
+		{"Multiplication", &models.Task{Arg1: 1, Arg1Imag: 2, Arg2: 3, Arg2Imag: -1, Operation: "*", Mode: models.ModeComplex}, 5 + 5i, false},
+		{"Division", &models.Task{Arg1Imag: 2, Arg2Imag: 1, Operation: "/", Mode: models.ModeComplex}, 2, false},
+		{"Abs", &models.Task{Arg1: 3, Arg1Imag: 4, Operation: "abs", Mode: models.ModeComplex}, 5, false},
+		{"Conj", &models.Task{Arg1: 3, Arg1Imag: 4, Operation: "conj", Mode: models.ModeComplex}, 3 - 4i, false},
+		{"Im", &models.Task{Arg1: 3, Arg1Imag: 4, Operation: "im", Mode: models.ModeComplex}, 4, false},
+		{"DivisionByZero", &models.Task{Arg1: 1, Operation: "/", Mode: models.ModeComplex}, 0, true},
+		{"IntegerFunction", &models.Task{Arg1: 3, Arg1Imag: 1, Operation: "fact", Mode: models.ModeComplex}, 0, true},
+		{"RealSqrtOfNegative", &models.Task{Arg1: -1, Operation: "sqrt"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := a.ExecuteTask(tt.task)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExecuteTask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.InDelta(t, real(tt.expected), result.Value, 1e-12)
+				assert.InDelta(t, imag(tt.expected), result.Imag, 1e-12)
+			}
+		})
+	}
+}
+
+func TestExecuteTask_Interval(t *testing.T) {
+	a := &agent.Agent{}
+
+	tests := []struct {
+		name         string
+		task         *models.Task
+		lower, upper float64
+		wantErr      bool
+	}{
+		{"Addition", &models.Task{Arg1: 9.79, Arg1Upper: 9.83, Arg2: 1, Arg2Upper: 1, Operation: "+", Mode: models.ModeInterval}, 10.79, 10.83, false},
+		{"Subtraction", &models.Task{Arg1: 1, Arg1Upper: 2, Arg2: 0.5, Arg2Upper: 1, Operation: "-", Mode: models.ModeInterval}, 0, 1.5, false},
+		{"Multiplication", &models.Task{Arg1: -1, Arg1Upper: 2, Arg2: 3, Arg2Upper: 4, Operation: "*", Mode: models.ModeInterval}, -4, 8, false},
+		{"Division", &models.Task{Arg1: 1, Arg1Upper: 2, Arg2: 4, Arg2Upper: 8, Operation: "/", Mode: models.ModeInterval}, 0.125, 0.5, false},
+		{"DivisorContainsZero", &models.Task{Arg1: 1, Arg1Upper: 2, Arg2: -1, Arg2Upper: 1, Operation: "/", Mode: models.ModeInterval}, 0, 0, true},
+		{"AbsAcrossZero", &models.Task{Arg1: -3, Arg1Upper: 2, Operation: "abs", Mode: models.ModeInterval}, 0, 3, false},
+		{"Sqrt", &models.Task{Arg1: 4, Arg1Upper: 9, Operation: "sqrt", Mode: models.ModeInterval}, 2, 3, false},
+		{"SqrtOfNegative", &models.Task{Arg1: -1, Arg1Upper: 4, Operation: "sqrt", Mode: models.ModeInterval}, 0, 0, true},
+		{"DegenerateFunction", &models.Task{Arg1: 5, Arg1Upper: 5, Operation: "fact", Mode: models.ModeInterval}, 120, 120, false},
+		{"FunctionOfInterval", &models.Task{Arg1: 5, Arg1Upper: 6, Operation: "fact", Mode: models.ModeInterval}, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := a.ExecuteTask(tt.task)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExecuteTask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.InDelta(t, tt.lower, result.Value, 1e-12)
+				if assert.NotNil(t, result.Upper) {
+					assert.InDelta(t, tt.upper, *result.Upper, 1e-12)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteTask_DatesAndDurations(t *testing.T) {
+	a := &agent.Agent{}
+	date, _ := models.ParseDate("2026-10-18")
+
+	result, err := a.ExecuteTask(&models.Task{Arg1: date, Arg1Type: models.TypeDate, Arg2: 90 * 86400, Arg2Type: models.TypeDuration, Operation: "+"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.TypeDate, result.Type)
+	assert.Equal(t, "2027-01-16", models.FormatDate(result.Value))
+
+	result, err = a.ExecuteTask(&models.Task{Arg1: 11700, Arg1Type: models.TypeDuration, Arg2: 4, Operation: "*"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.TypeDuration, result.Type)
+	assert.Equal(t, 46800.0, result.Value)
+
+	result, err = a.ExecuteTask(&models.Task{Arg1: 7200, Arg1Type: models.TypeDuration, Arg2: 1800, Arg2Type: models.TypeDuration, Operation: "/"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.TypeNumber, result.Type)
+	assert.Equal(t, 4.0, result.Value)
+
+	_, err = a.ExecuteTask(&models.Task{Arg1: date, Arg1Type: models.TypeDate, Arg2: date, Arg2Type: models.TypeDate, Operation: "+"})
+	var taskErr *models.TaskError
+	if assert.ErrorAs(t, err, &taskErr) {
+		assert.Equal(t, models.ErrTypeMismatch, taskErr.Code)
+	}
+}
+
+func TestExecuteTask_LargeIntegers(t *testing.T) {
+	a := &agent.Agent{}
+
+	result, err := a.ExecuteTask(&models.Task{Arg1: 30, Operation: "fact"})
+	assert.NoError(t, err)
+	assert.Equal(t, "265252859812191058636308480000000", result.Text)
+
+	result, err = a.ExecuteTask(&models.Task{Arg1Text: "265252859812191058636308480000000", Arg2: 1, Operation: "+"})
+	assert.NoError(t, err)
+	assert.Equal(t, "265252859812191058636308480000001", result.Text)
+
+	result, err = a.ExecuteTask(&models.Task{Arg1: 100, Operation: "fib"})
+	assert.NoError(t, err)
+	assert.Equal(t, "354224848179261915075", result.Text)
+
+	_, err = a.ExecuteTask(&models.Task{Arg1: -1, Operation: "fib"})
+	var taskErr *models.TaskError
+	assert.ErrorAs(t, err, &taskErr)
+	assert.Equal(t, models.ErrNegativeArgument, taskErr.Code)
+}
+
+func TestExecuteTaskContext(t *testing.T) {
+	a := &agent.Agent{}
+	task := &models.Task{Arg1: 1, Arg2: 2, Operation: "+", OperationTime: 5000}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := a.ExecuteTaskContext(ctx, task)
+	var taskErr *models.TaskError
+	if assert.ErrorAs(t, err, &taskErr) {
+		assert.Equal(t, models.ErrTimeout, taskErr.Code)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = a.ExecuteTaskContext(ctx, task)
+	if assert.ErrorAs(t, err, &taskErr) {
+		assert.Equal(t, models.ErrCancelled, taskErr.Code)
+	}
+
+	// срок истекает уже во время вычисления, а не во время ожидания OperationTime
+	long := &models.Task{Arg1: 100000, Arg2: 50000, Operation: "choose"}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = a.ExecuteTaskContext(ctx, long)
+	if assert.ErrorAs(t, err, &taskErr) {
+		assert.Equal(t, models.ErrTimeout, taskErr.Code)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+}
+
 func TestFetchTask(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -57,19 +235,65 @@ func TestFetchTask(t *testing.T) {
 		Arg1:          1.0,
 		Arg2:          2.0,
 		OperationTime: 100,
-		DependsOn:     []string{},
 		UserLogin:     "test_user",
 	}
 
+	capabilities := models.Capabilities{Operations: []string{"+", "-"}, Modes: []string{models.ModeReal}}
 	mockClient.EXPECT().
-		GetTask(gomock.Any(), gomock.Any()).
+		GetTask(gomock.Any(), &pb.GetTaskRequest{Operations: capabilities.Operations, Modes: capabilities.Modes, AgentId: "agent-1"}).
 		Return(mockResp, nil)
 
-	a := &agent.Agent{Client: mockClient}
+	a := &agent.Agent{ID: "agent-1", Client: mockClient, Capabilities: capabilities}
 	task, err := a.FetchTask()
 	assert.NoError(t, err)
 	assert.Equal(t, "1", task.ID)
 	assert.Equal(t, "+", task.Operation)
+
+	// задач нет: пустой ответ, а не ошибка
+	mockClient.EXPECT().GetTask(gomock.Any(), gomock.Any()).Return(&pb.GetTaskResponse{}, nil)
+	task, err = a.FetchTask()
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+}
+
+// fakeTaskStream — поток StreamTasks, которым управляет тест.
+type fakeTaskStream struct {
+	grpc.ClientStream
+	sent   chan *pb.TaskStreamRequest
+	tasks  chan *pb.GetTaskResponse
+	closed chan struct{}
+	ctx    context.Context
+}
+
+func newFakeTaskStream(ctx context.Context) *fakeTaskStream {
+	return &fakeTaskStream{
+		sent:   make(chan *pb.TaskStreamRequest, 10),
+		tasks:  make(chan *pb.GetTaskResponse, 10),
+		closed: make(chan struct{}),
+		ctx:    ctx,
+	}
+}
+
+// CloseSend ведёт себя как оркестратор: после закрытия стороны агента поток завершается.
+func (s *fakeTaskStream) CloseSend() error {
+	close(s.closed)
+	return nil
+}
+
+func (s *fakeTaskStream) Send(req *pb.TaskStreamRequest) error {
+	s.sent <- req
+	return nil
+}
+
+func (s *fakeTaskStream) Recv() (*pb.GetTaskResponse, error) {
+	select {
+	case task := <-s.tasks:
+		return task, nil
+	case <-s.closed:
+		return nil, io.EOF
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
 }
 
 func TestStart(t *testing.T) {
@@ -78,24 +302,386 @@ func TestStart(t *testing.T) {
 
 	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
 
-	mockClient.EXPECT().GetTask(gomock.Any(), gomock.Any()).Return(&pb.GetTaskResponse{
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newFakeTaskStream(ctx)
+	stream.tasks <- &pb.GetTaskResponse{
 		TaskId:        "test",
 		Operation:     "+",
 		Arg1:          1,
 		Arg2:          2,
 		OperationTime: 10,
-		DependsOn:     nil,
-	}, nil).AnyTimes()
+		LeaseId:       "lease-1",
+	}
+
+	mockClient.EXPECT().StreamTasks(gomock.Any()).Return(stream, nil)
+	submitted := make(chan *pb.SubmitResultRequest, 1)
+	mockClient.EXPECT().SubmitResults(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *pb.SubmitResultsRequest, _ ...grpc.CallOption) (*pb.SubmitResultsResponse, error) {
+			for _, r := range req.Results {
+				submitted <- r
+			}
+			return &pb.SubmitResultsResponse{Success: make([]bool, len(req.Results))}, nil
+		})
+	mockClient.EXPECT().RegisterAgent(gomock.Any(), gomock.Any()).Return(&pb.RegisterAgentResponse{HeartbeatIntervalMs: 50}, nil).AnyTimes()
+	mockClient.EXPECT().Heartbeat(gomock.Any(), gomock.Any()).Return(&pb.HeartbeatResponse{Registered: true}, nil).AnyTimes()
+
+	testAgent := agent.NewTestAgent(mockClient, 2)
+	testAgent.Start()
+	defer testAgent.Stop()
+
+	// при подключении агент объявляет всех свободных воркеров
+	select {
+	case hello := <-stream.sent:
+		assert.Equal(t, "test-agent", hello.AgentId)
+		assert.Equal(t, int32(2), hello.FreeSlots)
+	case <-time.After(time.Second):
+		t.Fatal("agent did not open task stream")
+	}
+
+	select {
+	case req := <-submitted:
+		assert.Equal(t, "test", req.TaskId)
+		assert.Equal(t, "lease-1", req.LeaseId)
+		assert.Equal(t, 3.0, req.GetResult())
+	case <-time.After(time.Second):
+		t.Fatal("result was not submitted")
+	}
+
+	// после выполнения задачи освободившийся воркер возвращается в поток
+	select {
+	case req := <-stream.sent:
+		assert.Equal(t, int32(1), req.FreeSlots)
+	case <-time.After(time.Second):
+		t.Fatal("agent did not report a free slot")
+	}
+}
 
-	mockClient.EXPECT().SubmitResult(gomock.Any(), gomock.Any()).Return(&pb.SubmitResultResponse{}, nil).AnyTimes()
+func TestStart_ResendsFromOutbox(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newFakeTaskStream(ctx)
+	stream.tasks <- &pb.GetTaskResponse{TaskId: "test", Operation: "+", Arg1: 1, Arg2: 2, LeaseId: "lease-1"}
+
+	mockClient.EXPECT().StreamTasks(gomock.Any()).Return(stream, nil)
+	// оркестратор недоступен дольше, чем SubmitAttempts попыток submitter
+	calls := 0
+	submitted := make(chan *pb.SubmitResultRequest, 1)
+	mockClient.EXPECT().SubmitResults(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *pb.SubmitResultsRequest, _ ...grpc.CallOption) (*pb.SubmitResultsResponse, error) {
+			calls++
+			if calls <= 5 {
+				return nil, fmt.Errorf("connection refused")
+			}
+			for _, r := range req.Results {
+				submitted <- r
+			}
+			return &pb.SubmitResultsResponse{Success: []bool{true}}, nil
+		}).Times(6)
+	mockClient.EXPECT().RegisterAgent(gomock.Any(), gomock.Any()).Return(&pb.RegisterAgentResponse{HeartbeatIntervalMs: 50}, nil).AnyTimes()
+	mockClient.EXPECT().Heartbeat(gomock.Any(), gomock.Any()).Return(&pb.HeartbeatResponse{Registered: true}, nil).AnyTimes()
+	mockClient.EXPECT().ReleaseTasks(gomock.Any(), gomock.Any()).Times(0)
 
 	testAgent := agent.NewTestAgent(mockClient, 1)
+	testAgent.Start()
+	defer testAgent.Stop()
+
+	select {
+	case req := <-submitted:
+		assert.Equal(t, "test", req.TaskId)
+		assert.Equal(t, "lease-1", req.LeaseId)
+		assert.Equal(t, 3.0, req.GetResult())
+	case <-time.After(2 * time.Second):
+		t.Fatal("result was not resent from outbox")
+	}
+}
+
+func TestStart_Autoscaling(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
 
-	go testAgent.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newFakeTaskStream(ctx)
+	stream.tasks <- &pb.GetTaskResponse{TaskId: "test", Operation: "+", Arg1: 1, Arg2: 2, OperationTime: 100, LeaseId: "lease-1", QueueDepth: 5}
 
-	time.Sleep(300 * time.Millisecond)
+	mockClient.EXPECT().StreamTasks(gomock.Any()).Return(stream, nil)
+	mockClient.EXPECT().SubmitResults(gomock.Any(), gomock.Any()).Return(&pb.SubmitResultsResponse{Success: []bool{true}}, nil).AnyTimes()
+	mockClient.EXPECT().RegisterAgent(gomock.Any(), gomock.Any()).Return(&pb.RegisterAgentResponse{HeartbeatIntervalMs: 50}, nil).AnyTimes()
+	mockClient.EXPECT().Heartbeat(gomock.Any(), gomock.Any()).Return(&pb.HeartbeatResponse{Registered: true}, nil).AnyTimes()
 
+	testAgent := agent.NewTestAgent(mockClient, 1)
+	testAgent.MinWorkers = 1
+	testAgent.MaxWorkers = 3
+	testAgent.ScaleIdle = 50 * time.Millisecond
+	testAgent.Start()
+	defer testAgent.Stop()
+
+	var slots []int32
+	next := func() int32 {
+		select {
+		case req := <-stream.sent:
+			slots = append(slots, req.FreeSlots)
+			return req.FreeSlots
+		case <-time.After(time.Second):
+			t.Fatalf("agent sent only %v", slots)
+			return 0
+		}
+	}
+	assert.Equal(t, int32(1), next())
+	// очередь не пуста, а свободных слотов нет — агент вырастает до MaxWorkers
+	assert.Equal(t, int32(2), next())
+	assert.Equal(t, 3, testAgent.Metrics().Workers)
+
+	// задача выполнена, новых нет — лишние воркеры убираются до MinWorkers
+	total := int32(3)
+	for testAgent.Metrics().Workers > 1 || total != 2 {
+		total += next()
+	}
+	assert.Equal(t, 1, testAgent.Metrics().Workers)
+	assert.Equal(t, 0, testAgent.Metrics().BusyWorkers)
+	// всего объявлено: один воркер плюс выданная задача
+	assert.Equal(t, int32(2), total)
+}
+
+func TestStart_CancelAndTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newFakeTaskStream(ctx)
+	stream.tasks <- &pb.GetTaskResponse{TaskId: "cancelled", Operation: "+", OperationTime: 5000, LeaseId: "l1"}
+	stream.tasks <- &pb.GetTaskResponse{TaskId: "slow", Operation: "+", OperationTime: 5000, LeaseId: "l2"}
+
+	mockClient.EXPECT().StreamTasks(gomock.Any()).Return(stream, nil)
+	submitted := make(chan *pb.SubmitResultRequest, 2)
+	mockClient.EXPECT().SubmitResults(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *pb.SubmitResultsRequest, _ ...grpc.CallOption) (*pb.SubmitResultsResponse, error) {
+			for _, r := range req.Results {
+				submitted <- r
+			}
+			return &pb.SubmitResultsResponse{Success: make([]bool, len(req.Results))}, nil
+		}).AnyTimes()
+	mockClient.EXPECT().RegisterAgent(gomock.Any(), gomock.Any()).Return(&pb.RegisterAgentResponse{}, nil).AnyTimes()
+
+	testAgent := agent.NewTestAgent(mockClient, 2)
+	testAgent.TaskTimeout = 300 * time.Millisecond
+	testAgent.Start()
+	defer testAgent.Stop()
+
+	select {
+	case <-stream.sent:
+	case <-time.After(time.Second):
+		t.Fatal("agent did not open task stream")
+	}
+	time.Sleep(50 * time.Millisecond)
+	// оркестратор отменил выражение первой задачи
+	stream.tasks <- &pb.GetTaskResponse{CancelTaskIds: []string{"cancelled"}}
+
+	for _, want := range []struct{ id, code string }{
+		{"cancelled", string(models.ErrCancelled)},
+		{"slow", string(models.ErrTimeout)},
+	} {
+		select {
+		case req := <-submitted:
+			assert.Equal(t, want.id, req.TaskId)
+			assert.Equal(t, want.code, req.GetError())
+		case <-time.After(time.Second):
+			t.Fatalf("task %s was not interrupted", want.id)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "outbox.jsonl")
+	outbox, err := agent.OpenOutbox(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, outbox.Len())
+
+	reqs := []*pb.SubmitResultRequest{
+		{TaskId: "1", LeaseId: "l1", Outcome: &pb.SubmitResultRequest_Result{Result: math.Inf(1)}, Worker: 2},
+		{TaskId: "2", LeaseId: "l2", Outcome: &pb.SubmitResultRequest_Error{Error: "division_by_zero"}},
+	}
+	assert.NoError(t, outbox.Add(reqs))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// результаты переживают перезапуск агента
+	reopened, err := agent.OpenOutbox(path)
+	assert.NoError(t, err)
+	batch := reopened.Peek(10)
+	if assert.Len(t, batch, 2) {
+		assert.True(t, math.IsInf(batch[0].GetResult(), 1))
+		assert.Equal(t, int32(2), batch[0].Worker)
+		assert.Equal(t, "division_by_zero", batch[1].GetError())
+	}
+	assert.NoError(t, reopened.Remove(batch[:1]))
+
+	reopened, err = agent.OpenOutbox(path)
+	assert.NoError(t, err)
+	if batch := reopened.Peek(10); assert.Len(t, batch, 1) {
+		assert.Equal(t, "2", batch[0].TaskId)
+	}
+}
+
+func TestStop_FinishesRunningAndReleasesQueued(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newFakeTaskStream(ctx)
+	stream.tasks <- &pb.GetTaskResponse{TaskId: "running", Operation: "+", Arg1: 1, Arg2: 2, OperationTime: 200, LeaseId: "l1"}
+	stream.tasks <- &pb.GetTaskResponse{TaskId: "queued", Operation: "+", Arg1: 3, Arg2: 4, LeaseId: "l2"}
+
+	mockClient.EXPECT().StreamTasks(gomock.Any()).Return(stream, nil)
+	mockClient.EXPECT().RegisterAgent(gomock.Any(), gomock.Any()).Return(&pb.RegisterAgentResponse{}, nil).AnyTimes()
+	// начатая задача доделывается и сдаётся до возврата остальных
+	gomock.InOrder(
+		mockClient.EXPECT().SubmitResults(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.SubmitResultsRequest, _ ...grpc.CallOption) (*pb.SubmitResultsResponse, error) {
+				assert.Len(t, req.Results, 1)
+				assert.Equal(t, "running", req.Results[0].TaskId)
+				return &pb.SubmitResultsResponse{Success: []bool{true}}, nil
+			}),
+		mockClient.EXPECT().ReleaseTasks(gomock.Any(), &pb.ReleaseTasksRequest{
+			AgentId: "test-agent",
+			Tasks:   []*pb.TaskLease{{TaskId: "queued", LeaseId: "l2"}},
+		}).Return(&pb.ReleaseTasksResponse{Success: []bool{true}}, nil),
+	)
+
+	testAgent := agent.NewTestAgent(mockClient, 1)
+	testAgent.ShutdownGrace = time.Second
+	testAgent.Start()
+
+	select {
+	case <-stream.sent:
+	case <-time.After(time.Second):
+		t.Fatal("agent did not open task stream")
+	}
+	// даём агенту получить обе задачи: первая выполняется, вторая ждёт воркера
+	time.Sleep(50 * time.Millisecond)
+	testAgent.Stop()
+}
+
+func TestStop_GracePeriodExpires(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newFakeTaskStream(ctx)
+	stream.tasks <- &pb.GetTaskResponse{TaskId: "slow", Operation: "+", OperationTime: 1000, LeaseId: "l1"}
+
+	mockClient.EXPECT().StreamTasks(gomock.Any()).Return(stream, nil)
+	mockClient.EXPECT().RegisterAgent(gomock.Any(), gomock.Any()).Return(&pb.RegisterAgentResponse{}, nil).AnyTimes()
+	mockClient.EXPECT().ReleaseTasks(gomock.Any(), &pb.ReleaseTasksRequest{
+		AgentId: "test-agent",
+		Tasks:   []*pb.TaskLease{{TaskId: "slow", LeaseId: "l1"}},
+	}).Return(&pb.ReleaseTasksResponse{Success: []bool{true}}, nil)
+
+	testAgent := agent.NewTestAgent(mockClient, 1)
+	testAgent.ShutdownGrace = 50 * time.Millisecond
+	testAgent.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
 	testAgent.Stop()
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRegister(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+	mockClient.EXPECT().
+		RegisterAgent(gomock.Any(), &pb.RegisterAgentRequest{AgentId: "test-agent", Version: agent.Version, ComputingPower: 2}).
+		Return(&pb.RegisterAgentResponse{HeartbeatIntervalMs: 1500}, nil)
+
+	testAgent := agent.NewTestAgent(mockClient, 2)
+	interval, err := testAgent.Register()
+	assert.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, interval)
+}
+
+func TestNewAgentWithConfig(t *testing.T) {
+	cfg := config.DefaultAgentConfig()
+	cfg.OrchestratorAddr = "orchestrator.internal:50051"
+	cfg.AgentID = "agent-7"
+	cfg.Workers = 3
+	cfg.Labels = map[string]string{"zone": "eu"}
+	cfg.RetryBackoffMS = 250
+
+	a, err := agent.NewAgentWithConfig(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "agent-7", a.ID)
+	assert.Equal(t, 3, a.ComputingPower)
+	assert.Equal(t, map[string]string{"zone": "eu"}, a.Labels)
+	assert.Equal(t, 250*time.Millisecond, a.Backoff)
+	assert.Equal(t, 10*time.Second, a.ShutdownGrace)
+
+	// метки уходят оркестратору при регистрации
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+	mockClient.EXPECT().
+		RegisterAgent(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *pb.RegisterAgentRequest, _ ...grpc.CallOption) (*pb.RegisterAgentResponse, error) {
+			assert.Equal(t, "agent-7", req.AgentId)
+			assert.Equal(t, map[string]string{"zone": "eu"}, req.Labels)
+			return &pb.RegisterAgentResponse{}, nil
+		})
+	a.Client = mockClient
+	_, err = a.Register()
+	assert.NoError(t, err)
+
+	cfg.TLS = config.TLSConfig{Enabled: true, CAFile: "missing-ca.pem"}
+	_, err = agent.NewAgentWithConfig(cfg)
+	assert.ErrorContains(t, err, "missing-ca.pem")
+}
+
+func TestHeartbeat_Reregisters(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+	registered := make(chan struct{}, 10)
+	mockClient.EXPECT().RegisterAgent(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ interface{}, _ *pb.RegisterAgentRequest, _ ...interface{}) (*pb.RegisterAgentResponse, error) {
+			registered <- struct{}{}
+			return &pb.RegisterAgentResponse{HeartbeatIntervalMs: 10}, nil
+		}).MinTimes(2)
+	// оркестратор «забыл» агента — тот должен зарегистрироваться снова
+	mockClient.EXPECT().Heartbeat(gomock.Any(), gomock.Any()).Return(&pb.HeartbeatResponse{Registered: false}, nil).AnyTimes()
+	mockClient.EXPECT().StreamTasks(gomock.Any()).Return(nil, fmt.Errorf("unavailable")).AnyTimes()
+
+	testAgent := agent.NewTestAgent(mockClient, 0)
+	testAgent.Start()
+	defer testAgent.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-registered:
+		case <-time.After(time.Second):
+			t.Fatal("agent did not re-register")
+		}
+	}
 }
 
 func TestSubmitWithRetry_Success(t *testing.T) {
@@ -108,8 +694,8 @@ func TestSubmitWithRetry_Success(t *testing.T) {
 
 	testAgent := agent.NewTestAgent(mockClient, 1)
 
-	result := 10.0
-	err := testAgent.SubmitWithRetry("task1", &result, 3, nil)
+	result := &models.TaskResult{Value: 10.0}
+	err := testAgent.SubmitWithRetry(&models.Task{ID: "task1"}, result, 3, nil)
 
 	assert.NoError(t, err)
 }
@@ -124,13 +710,53 @@ func TestSubmitWithRetry_Failure(t *testing.T) {
 
 	testAgent := agent.NewTestAgent(mockClient, 1)
 
-	result := 10.0
-	err := testAgent.SubmitWithRetry("task1", &result, 3, nil)
+	result := &models.TaskResult{Value: 10.0}
+	err := testAgent.SubmitWithRetry(&models.Task{ID: "task1"}, result, 3, nil)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "after 3 attempts")
 }
 
+func TestFetchTasks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+	mockClient.EXPECT().
+		GetTasks(gomock.Any(), &pb.GetTasksRequest{AgentId: "test-agent", MaxN: 8}).
+		Return(&pb.GetTasksResponse{Tasks: []*pb.GetTaskResponse{
+			{TaskId: "1", Operation: "+", LeaseId: "l1"},
+			{TaskId: "2", Operation: "*", LeaseId: "l2"},
+		}}, nil)
+
+	testAgent := agent.NewTestAgent(mockClient, 8)
+	tasks, err := testAgent.FetchTasks(8)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 2) {
+		assert.Equal(t, "l2", tasks[1].LeaseID)
+	}
+}
+
+func TestSubmitResults_Batch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+	mockClient.EXPECT().
+		SubmitResults(gomock.Any(), &pb.SubmitResultsRequest{Results: []*pb.SubmitResultRequest{
+			{TaskId: "1", LeaseId: "l1", Outcome: &pb.SubmitResultRequest_Result{Result: 3}, Worker: 1},
+			{TaskId: "2", LeaseId: "l2", Outcome: &pb.SubmitResultRequest_Error{Error: "division_by_zero"}, Worker: 2},
+		}}).
+		Return(&pb.SubmitResultsResponse{Success: []bool{true, false}}, nil)
+
+	testAgent := agent.NewTestAgent(mockClient, 2)
+	err := testAgent.SubmitResults([]agent.Outcome{
+		{Task: &models.Task{ID: "1", LeaseID: "l1"}, Result: &models.TaskResult{Value: 3}, Worker: 1},
+		{Task: &models.Task{ID: "2", LeaseID: "l2"}, Err: models.NewTaskError(models.ErrDivisionByZero, "division by zero"), Worker: 2},
+	})
+	assert.NoError(t, err)
+}
+
 func TestSubmitResult_Success(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -138,7 +764,8 @@ func TestSubmitResult_Success(t *testing.T) {
 	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
 
 	expectedReq := &pb.SubmitResultRequest{
-		TaskId: "task123",
+		TaskId:  "task123",
+		LeaseId: "lease-1",
 		Outcome: &pb.SubmitResultRequest_Result{
 			Result: 42.0,
 		},
@@ -150,8 +777,32 @@ func TestSubmitResult_Success(t *testing.T) {
 
 	testAgent := agent.NewTestAgent(mockClient, 1)
 
-	result := 42.0
-	err := testAgent.SubmitResult("task123", &result)
+	result := &models.TaskResult{Value: 42.0}
+	err := testAgent.SubmitResult(&models.Task{ID: "task123", LeaseID: "lease-1"}, result)
+
+	assert.NoError(t, err)
+}
+
+func TestSubmitResult_Text(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
+
+	expectedReq := &pb.SubmitResultRequest{
+		TaskId: "task123",
+		Outcome: &pb.SubmitResultRequest_ResultText{
+			ResultText: "265252859812191058636308480000000",
+		},
+	}
+
+	mockClient.EXPECT().
+		SubmitResult(gomock.Any(), expectedReq).
+		Return(&pb.SubmitResultResponse{Success: true}, nil)
+
+	testAgent := agent.NewTestAgent(mockClient, 1)
+
+	err := testAgent.SubmitResult(&models.Task{ID: "task123"}, &models.TaskResult{Text: "265252859812191058636308480000000"})
 
 	assert.NoError(t, err)
 }
@@ -177,27 +828,37 @@ func TestSubmitError_Success(t *testing.T) {
 
 	taskErr := &models.TaskError{Code: models.ErrDivisionByZero, Message: "division by zero"}
 
-	err := testAgent.SubmitError("task123", taskErr)
+	err := testAgent.SubmitError(&models.Task{ID: "task123"}, taskErr)
 
 	assert.NoError(t, err)
 }
 
-func TestGetDependencyResult_Success(t *testing.T) {
+func TestEnrollAndCredentials(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
-
 	mockClient := mocks.NewMockOrchestratorServiceClient(ctrl)
-
 	mockClient.EXPECT().
-		GetTaskResult(gomock.Any(), &pb.GetTaskResultRequest{TaskId: "dep1"}).
-		Return(&pb.GetTaskResultResponse{
-			Result:     &wrapperspb.DoubleValue{Value: 7.5},
-			TaskExists: true,
-		}, nil)
+		Enroll(gomock.Any(), &pb.EnrollRequest{JoinToken: "join", Hostname: "host"}).
+		Return(&pb.EnrollResponse{AgentId: "agent-9", Credential: "secret"}, nil)
 
-	testAgent := agent.NewTestAgent(mockClient, 1)
+	creds, err := agent.Enroll(mockClient, "join", "", "host")
+	assert.NoError(t, err)
+	assert.Equal(t, &agent.Credentials{AgentID: "agent-9", Credential: "secret"}, creds)
 
-	result, err := testAgent.GetDependencyResult("dep1")
+	path := filepath.Join(t.TempDir(), "state", "credentials.json")
+	_, err = agent.LoadCredentials(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, agent.SaveCredentials(path, creds))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// сохранённый ключ важнее AGENT_ID и токена подключения: повторно Enroll не вызывается
+	cfg := config.DefaultAgentConfig()
+	cfg.AgentID = "agent-7"
+	cfg.JoinToken = "join"
+	cfg.CredentialsFile = path
+	a, err := agent.NewAgentWithConfig(cfg)
 	assert.NoError(t, err)
-	assert.Equal(t, 7.5, result)
+	assert.Equal(t, "agent-9", a.ID)
 }
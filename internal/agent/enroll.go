@@ -0,0 +1,94 @@
+package agent
+
+import (
+	pb "calculator_app/internal/proto"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// enrollTimeout ограничивает вызов Enroll при первом запуске агента.
+const enrollTimeout = 10 * time.Second
+
+// Credentials — постоянный ключ агента, полученный в обмен на токен подключения.
+type Credentials struct {
+	AgentID    string `json:"agent_id"`
+	Credential string `json:"credential"`
+}
+
+// LoadCredentials читает ключ агента; отсутствие файла — ошибка os.ErrNotExist.
+func LoadCredentials(path string) (*Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parse credentials %s: %w", path, err)
+	}
+	if creds.AgentID == "" || creds.Credential == "" {
+		return nil, fmt.Errorf("credentials %s: agent_id and credential are required", path)
+	}
+	return &creds, nil
+}
+
+// SaveCredentials записывает ключ агента с правами 0600: сначала во временный
+// файл, затем переименованием, чтобы сбой не оставил файл наполовину записанным.
+func SaveCredentials(path string, creds *Credentials) error {
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create credentials directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	return nil
+}
+
+// Enroll обменивает одноразовый токен подключения на постоянный ключ агента.
+// Пустой agentID — ID назначит оркестратор.
+func Enroll(client pb.OrchestratorServiceClient, joinToken, agentID, hostname string) (*Credentials, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), enrollTimeout)
+	defer cancel()
+	resp, err := client.Enroll(ctx, &pb.EnrollRequest{JoinToken: joinToken, AgentId: agentID, Hostname: hostname})
+	if err != nil {
+		return nil, fmt.Errorf("enroll agent: %w", err)
+	}
+	return &Credentials{AgentID: resp.AgentId, Credential: resp.Credential}, nil
+}
+
+// loadOrEnroll возвращает сохранённый ключ агента или, если его нет и задан
+// joinToken, получает новый через Enroll и сохраняет в path. nil без ошибки —
+// ключа нет и подключаться не по чему.
+func loadOrEnroll(path, joinToken string, enroll func() (*Credentials, error)) (*Credentials, error) {
+	creds, err := LoadCredentials(path)
+	switch {
+	case err == nil:
+		return creds, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	case joinToken == "":
+		return nil, nil
+	}
+
+	creds, err = enroll()
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveCredentials(path, creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
@@ -36,6 +36,26 @@ func (m *MockOrchestratorServiceClient) EXPECT() *MockOrchestratorServiceClientM
 	return m.recorder
 }
 
+// Enroll mocks base method.
+func (m *MockOrchestratorServiceClient) Enroll(arg0 context.Context, arg1 *proto.EnrollRequest, arg2 ...grpc.CallOption) (*proto.EnrollResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Enroll", varargs...)
+	ret0, _ := ret[0].(*proto.EnrollResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Enroll indicates an expected call of Enroll.
+func (mr *MockOrchestratorServiceClientMockRecorder) Enroll(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enroll", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).Enroll), varargs...)
+}
+
 // GetTask mocks base method.
 func (m *MockOrchestratorServiceClient) GetTask(arg0 context.Context, arg1 *proto.GetTaskRequest, arg2 ...grpc.CallOption) (*proto.GetTaskResponse, error) {
 	m.ctrl.T.Helper()
@@ -76,6 +96,106 @@ func (mr *MockOrchestratorServiceClientMockRecorder) GetTaskResult(arg0, arg1 in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTaskResult", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).GetTaskResult), varargs...)
 }
 
+// GetTasks mocks base method.
+func (m *MockOrchestratorServiceClient) GetTasks(arg0 context.Context, arg1 *proto.GetTasksRequest, arg2 ...grpc.CallOption) (*proto.GetTasksResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetTasks", varargs...)
+	ret0, _ := ret[0].(*proto.GetTasksResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTasks indicates an expected call of GetTasks.
+func (mr *MockOrchestratorServiceClientMockRecorder) GetTasks(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTasks", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).GetTasks), varargs...)
+}
+
+// Heartbeat mocks base method.
+func (m *MockOrchestratorServiceClient) Heartbeat(arg0 context.Context, arg1 *proto.HeartbeatRequest, arg2 ...grpc.CallOption) (*proto.HeartbeatResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Heartbeat", varargs...)
+	ret0, _ := ret[0].(*proto.HeartbeatResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Heartbeat indicates an expected call of Heartbeat.
+func (mr *MockOrchestratorServiceClientMockRecorder) Heartbeat(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Heartbeat", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).Heartbeat), varargs...)
+}
+
+// RegisterAgent mocks base method.
+func (m *MockOrchestratorServiceClient) RegisterAgent(arg0 context.Context, arg1 *proto.RegisterAgentRequest, arg2 ...grpc.CallOption) (*proto.RegisterAgentResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RegisterAgent", varargs...)
+	ret0, _ := ret[0].(*proto.RegisterAgentResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RegisterAgent indicates an expected call of RegisterAgent.
+func (mr *MockOrchestratorServiceClientMockRecorder) RegisterAgent(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterAgent", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).RegisterAgent), varargs...)
+}
+
+// ReleaseTasks mocks base method.
+func (m *MockOrchestratorServiceClient) ReleaseTasks(arg0 context.Context, arg1 *proto.ReleaseTasksRequest, arg2 ...grpc.CallOption) (*proto.ReleaseTasksResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ReleaseTasks", varargs...)
+	ret0, _ := ret[0].(*proto.ReleaseTasksResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReleaseTasks indicates an expected call of ReleaseTasks.
+func (mr *MockOrchestratorServiceClientMockRecorder) ReleaseTasks(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseTasks", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).ReleaseTasks), varargs...)
+}
+
+// StreamTasks mocks base method.
+func (m *MockOrchestratorServiceClient) StreamTasks(arg0 context.Context, arg1 ...grpc.CallOption) (grpc.BidiStreamingClient[proto.TaskStreamRequest, proto.GetTaskResponse], error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "StreamTasks", varargs...)
+	ret0, _ := ret[0].(grpc.BidiStreamingClient[proto.TaskStreamRequest, proto.GetTaskResponse])
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StreamTasks indicates an expected call of StreamTasks.
+func (mr *MockOrchestratorServiceClientMockRecorder) StreamTasks(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamTasks", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).StreamTasks), varargs...)
+}
+
 // SubmitResult mocks base method.
 func (m *MockOrchestratorServiceClient) SubmitResult(arg0 context.Context, arg1 *proto.SubmitResultRequest, arg2 ...grpc.CallOption) (*proto.SubmitResultResponse, error) {
 	m.ctrl.T.Helper()
@@ -95,3 +215,23 @@ func (mr *MockOrchestratorServiceClientMockRecorder) SubmitResult(arg0, arg1 int
 	varargs := append([]interface{}{arg0, arg1}, arg2...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubmitResult", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).SubmitResult), varargs...)
 }
+
+// SubmitResults mocks base method.
+func (m *MockOrchestratorServiceClient) SubmitResults(arg0 context.Context, arg1 *proto.SubmitResultsRequest, arg2 ...grpc.CallOption) (*proto.SubmitResultsResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SubmitResults", varargs...)
+	ret0, _ := ret[0].(*proto.SubmitResultsResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubmitResults indicates an expected call of SubmitResults.
+func (mr *MockOrchestratorServiceClientMockRecorder) SubmitResults(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubmitResults", reflect.TypeOf((*MockOrchestratorServiceClient)(nil).SubmitResults), varargs...)
+}
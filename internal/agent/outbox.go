@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	pb "calculator_app/internal/proto"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Outbox — результаты, которые агент не смог отправить оркестратору. Они
+// сохраняются в файл (по строке protojson на запрос), переживают перезапуск
+// агента и переотправляются, пока оркестратор их не примет. Оркестратор
+// узнаёт уже принятый результат по аренде, поэтому повтор безопасен.
+// Пустой путь — результаты хранятся только в памяти.
+type Outbox struct {
+	path string
+
+	mu      sync.Mutex
+	pending []*pb.SubmitResultRequest
+}
+
+// OpenOutbox читает сохранённые в path результаты; отсутствие файла — пустой outbox.
+func OpenOutbox(path string) (*Outbox, error) {
+	o := &Outbox{path: path}
+	if path == "" {
+		return o, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		req := &pb.SubmitResultRequest{}
+		if err := protojson.Unmarshal(scanner.Bytes(), req); err != nil {
+			return nil, fmt.Errorf("parse outbox %s:%d: %w", path, line, err)
+		}
+		o.pending = append(o.pending, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+	return o, nil
+}
+
+// Len — сколько результатов ждут отправки.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// Add сохраняет результаты в конец outbox.
+func (o *Outbox) Add(reqs []*pb.SubmitResultRequest) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending := append(o.pending[:len(o.pending):len(o.pending)], reqs...)
+	if err := o.save(pending); err != nil {
+		return err
+	}
+	o.pending = pending
+	return nil
+}
+
+// Peek возвращает до n самых старых результатов, не удаляя их.
+func (o *Outbox) Peek(n int) []*pb.SubmitResultRequest {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*pb.SubmitResultRequest(nil), o.pending[:min(n, len(o.pending))]...)
+}
+
+// Remove удаляет отправленные результаты reqs (полученные из Peek).
+func (o *Outbox) Remove(reqs []*pb.SubmitResultRequest) error {
+	sent := make(map[*pb.SubmitResultRequest]bool, len(reqs))
+	for _, r := range reqs {
+		sent[r] = true
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending := make([]*pb.SubmitResultRequest, 0, len(o.pending))
+	for _, r := range o.pending {
+		if !sent[r] {
+			pending = append(pending, r)
+		}
+	}
+	if err := o.save(pending); err != nil {
+		return err
+	}
+	o.pending = pending
+	return nil
+}
+
+// save перезаписывает файл outbox через временный файл и переименование, как SaveCredentials.
+func (o *Outbox) save(pending []*pb.SubmitResultRequest) error {
+	if o.path == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, r := range pending {
+		line, err := protojson.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("encode result of task %s: %w", r.TaskId, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0o700); err != nil {
+		return fmt.Errorf("create outbox directory: %w", err)
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("write outbox: %w", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write outbox: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AgentConfig — настройки агента. Каждая настройка задаётся ключом в файле,
+// переменной окружения с тем же именем или флагом; приоритет по возрастанию:
+// значения по умолчанию, файл, окружение, флаги.
+type AgentConfig struct {
+	// OrchestratorAddr — адрес gRPC оркестратора, host:port
+	OrchestratorAddr string
+	// Workers — число воркеров; при автомасштабировании — начальное
+	Workers int
+	// Автомасштабирование включено, если MaxWorkers > 0: воркеры добавляются,
+	// пока оркестратор сообщает об очереди, и убираются до MinWorkers после
+	// ScaleIdleMS простоя
+	MinWorkers  int
+	MaxWorkers  int
+	ScaleIdleMS int
+	// MetricsAddr — адрес HTTP, где агент отдаёт GET /metrics; пустой — не отдаёт
+	MetricsAddr string
+	// AgentID — идентификатор агента; пустой — новый UUID при каждом запуске
+	AgentID string
+	// Labels сообщаются оркестратору при регистрации
+	Labels map[string]string
+	TLS    TLSConfig
+	// AuthToken передаётся оркестратору в каждом вызове вместе с AgentID
+	AuthToken string
+	// JoinToken — одноразовый токен подключения от администратора: при первом
+	// запуске обменивается на постоянный ключ, который сохраняется в CredentialsFile
+	// и дальше заменяет AgentID и AuthToken
+	JoinToken       string
+	CredentialsFile string
+	// OutboxFile — где агент хранит результаты, которые не удалось отправить;
+	// пустой — только в памяти, при перезапуске они теряются
+	OutboxFile string
+	// Повтор отправки результатов и переподключения потока задач: пауза
+	// RetryBackoffMS удваивается с каждой попыткой до RetryMaxBackoffMS
+	SubmitAttempts    int
+	RetryBackoffMS    int
+	RetryMaxBackoffMS int
+	ShutdownGraceMS   int
+	// TaskTimeoutMS — сколько может выполняться одна задача; не успевшая
+	// завершается ошибкой timeout. 0 — без ограничения
+	TaskTimeoutMS int
+}
+
+// DefaultAgentConfigPath — файл настроек, если не задан -config или AGENT_CONFIG.
+const DefaultAgentConfigPath = "config/config.txt"
+
+// DefaultAgentCredentialsPath — где агент хранит ключ, полученный по токену подключения.
+const DefaultAgentCredentialsPath = "config/agent-credentials.json"
+
+// DefaultAgentOutboxPath — где агент хранит неотправленные результаты.
+const DefaultAgentOutboxPath = "config/agent-outbox.jsonl"
+
+// agentKeys — ключи файла и переменные окружения агента с соответствующими флагами.
+var agentKeys = []struct {
+	key, flag, usage string
+}{
+	{"ORCHESTRATOR_ADDR", "orchestrator", "адрес оркестратора host:port (по умолчанию localhost:50051)"},
+	{"COMPUTING_POWER", "workers", "число воркеров (по умолчанию 4); при автомасштабировании — начальное"},
+	{"AGENT_MIN_WORKERS", "min-workers", "минимум воркеров при автомасштабировании (по умолчанию 1)"},
+	{"AGENT_MAX_WORKERS", "max-workers", "максимум воркеров; больше 0 включает автомасштабирование (по умолчанию 0)"},
+	{"AGENT_SCALE_IDLE_MS", "scale-idle-ms", "простой, после которого убирается лишний воркер, мс (по умолчанию 30000)"},
+	{"AGENT_METRICS_ADDR", "metrics-addr", "адрес HTTP для GET /metrics, например :9100 (по умолчанию выключено)"},
+	{"AGENT_ID", "id", "идентификатор агента (по умолчанию случайный UUID)"},
+	{"AGENT_LABELS", "labels", "метки агента key=value через запятую"},
+	{"TLS_ENABLED", "tls", "подключаться по TLS (включается сам, если задан TLS_CA_FILE или TLS_CERT_FILE)"},
+	{"TLS_CA_FILE", "tls-ca", "CA для проверки сертификата оркестратора (PEM)"},
+	{"TLS_CERT_FILE", "tls-cert", "сертификат агента для mTLS (PEM)"},
+	{"TLS_KEY_FILE", "tls-key", "ключ сертификата агента (PEM)"},
+	{"TLS_SERVER_NAME", "tls-server-name", "имя сервера в сертификате оркестратора"},
+	{"AGENT_TOKEN", "token", "токен агента для оркестратора (GRPC_AUTH_TOKEN или токен из GRPC_AGENT_TOKENS)"},
+	{"AGENT_JOIN_TOKEN", "join-token", "одноразовый токен подключения от администратора"},
+	{"AGENT_CREDENTIALS_FILE", "credentials", "файл ключа агента, полученного по токену подключения (по умолчанию " + DefaultAgentCredentialsPath + ")"},
+	{"AGENT_OUTBOX_FILE", "outbox", "файл неотправленных результатов; пустой — хранить только в памяти (по умолчанию " + DefaultAgentOutboxPath + ")"},
+	{"SUBMIT_ATTEMPTS", "submit-attempts", "попыток отправки результатов (по умолчанию 3)"},
+	{"RETRY_BACKOFF_MS", "retry-backoff-ms", "начальная пауза между попытками, мс (по умолчанию 1000)"},
+	{"RETRY_MAX_BACKOFF_MS", "retry-max-backoff-ms", "максимальная пауза между попытками, мс (по умолчанию 30000)"},
+	{"SHUTDOWN_GRACE_MS", "shutdown-grace-ms", "ожидание начатых задач при остановке, мс (по умолчанию 10000)"},
+	{"AGENT_TASK_TIMEOUT_MS", "task-timeout-ms", "предельное время выполнения задачи, мс; 0 — без ограничения (по умолчанию 60000)"},
+}
+
+func DefaultAgentConfig() *AgentConfig {
+	return &AgentConfig{
+		OrchestratorAddr:  "localhost:50051",
+		Workers:           defaultComputingPower,
+		MinWorkers:        1,
+		ScaleIdleMS:       30000,
+		SubmitAttempts:    3,
+		RetryBackoffMS:    1000,
+		RetryMaxBackoffMS: 30000,
+		ShutdownGraceMS:   10000,
+		TaskTimeoutMS:     60000,
+		CredentialsFile:   DefaultAgentCredentialsPath,
+		OutboxFile:        DefaultAgentOutboxPath,
+	}
+}
+
+// LoadAgentConfig собирает настройки агента из файла, окружения (lookupEnv —
+// обычно os.LookupEnv) и аргументов командной строки args и проверяет их.
+// Файл берётся из -config, затем из AGENT_CONFIG, иначе DefaultAgentConfigPath;
+// отсутствие файла по умолчанию не ошибка.
+func LoadAgentConfig(args []string, lookupEnv func(string) (string, bool)) (*AgentConfig, error) {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	path := fs.String("config", "", "файл настроек (по умолчанию "+DefaultAgentConfigPath+")")
+	for _, k := range agentKeys {
+		fs.String(k.flag, "", k.usage+"; переменная "+k.key)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	explicit := *path != ""
+	if !explicit {
+		*path, explicit = lookupEnv("AGENT_CONFIG")
+	}
+	if !explicit {
+		*path = DefaultAgentConfigPath
+	}
+
+	cfg := DefaultAgentConfig()
+	known := make(map[string]bool, len(agentKeys))
+	for _, k := range agentKeys {
+		known[k.key] = true
+	}
+
+	// файл общий с оркестратором: чужие ключи пропускаются
+	file, err := os.Open(*path)
+	switch {
+	case err == nil:
+		err = scanConfig(file, func(key, value string) error {
+			if !known[key] {
+				return nil
+			}
+			value = stripComment(key, value)
+			if err := cfg.set(key, value); err != nil {
+				return fmt.Errorf("%s: %s: %w", *path, key, err)
+			}
+			return nil
+		})
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+	default:
+		return nil, fmt.Errorf("open agent config: %w", err)
+	}
+
+	for _, k := range agentKeys {
+		if value, ok := lookupEnv(k.key); ok {
+			if err := cfg.set(k.key, value); err != nil {
+				return nil, fmt.Errorf("environment %s: %w", k.key, err)
+			}
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, k := range agentKeys {
+		if !set[k.flag] {
+			continue
+		}
+		if err := cfg.set(k.key, fs.Lookup(k.flag).Value.String()); err != nil {
+			return nil, fmt.Errorf("flag -%s: %w", k.flag, err)
+		}
+	}
+
+	if cfg.TLS.CAFile != "" || cfg.TLS.CertFile != "" {
+		cfg.TLS.Enabled = true
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid agent config: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c *AgentConfig) set(key, value string) error {
+	value = strings.TrimSpace(value)
+	switch key {
+	case "ORCHESTRATOR_ADDR":
+		c.OrchestratorAddr = value
+	case "AGENT_ID":
+		c.AgentID = value
+	case "AGENT_LABELS":
+		labels, err := parseLabels(value)
+		if err != nil {
+			return err
+		}
+		c.Labels = labels
+	case "TLS_ENABLED":
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		c.TLS.Enabled = v
+	case "TLS_CA_FILE":
+		c.TLS.CAFile = value
+	case "TLS_CERT_FILE":
+		c.TLS.CertFile = value
+	case "TLS_KEY_FILE":
+		c.TLS.KeyFile = value
+	case "TLS_SERVER_NAME":
+		c.TLS.ServerName = value
+	case "AGENT_TOKEN":
+		c.AuthToken = value
+	case "AGENT_JOIN_TOKEN":
+		c.JoinToken = value
+	case "AGENT_CREDENTIALS_FILE":
+		c.CredentialsFile = value
+	case "AGENT_OUTBOX_FILE":
+		c.OutboxFile = value
+	case "AGENT_METRICS_ADDR":
+		c.MetricsAddr = value
+	default:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		switch key {
+		case "COMPUTING_POWER":
+			c.Workers = v
+		case "AGENT_MIN_WORKERS":
+			c.MinWorkers = v
+		case "AGENT_MAX_WORKERS":
+			c.MaxWorkers = v
+		case "AGENT_SCALE_IDLE_MS":
+			c.ScaleIdleMS = v
+		case "SUBMIT_ATTEMPTS":
+			c.SubmitAttempts = v
+		case "RETRY_BACKOFF_MS":
+			c.RetryBackoffMS = v
+		case "RETRY_MAX_BACKOFF_MS":
+			c.RetryMaxBackoffMS = v
+		case "SHUTDOWN_GRACE_MS":
+			c.ShutdownGraceMS = v
+		case "AGENT_TASK_TIMEOUT_MS":
+			c.TaskTimeoutMS = v
+		default:
+			return fmt.Errorf("unknown key %s", key)
+		}
+	}
+	return nil
+}
+
+// secretAgentKeys — значения, которые берутся из файла целиком: в токене может быть " #".
+var secretAgentKeys = map[string]bool{"AGENT_TOKEN": true, "AGENT_JOIN_TOKEN": true}
+
+// stripComment отрезает комментарий после значения ключа агента в файле:
+// KEY=VALUE  # пояснение. Токены не обрезаются.
+func stripComment(key, value string) string {
+	if secretAgentKeys[key] {
+		return value
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+// parseLabels разбирает "key=value,key2=value2"; пустая строка — без меток.
+func parseLabels(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(entry, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q, want key=value", entry)
+		}
+		labels[k] = strings.TrimSpace(v)
+	}
+	return labels, nil
+}
+
+// Validate проверяет настройки и возвращает все найденные ошибки разом.
+func (c *AgentConfig) Validate() error {
+	var errs []error
+	if host, port, err := net.SplitHostPort(c.OrchestratorAddr); err != nil || host == "" || port == "" {
+		errs = append(errs, fmt.Errorf("orchestrator address %q must be host:port", c.OrchestratorAddr))
+	}
+	if c.Workers < 1 {
+		errs = append(errs, fmt.Errorf("workers must be at least 1, got %d", c.Workers))
+	}
+	if c.MaxWorkers > 0 {
+		if c.MinWorkers < 1 || c.MinWorkers > c.Workers || c.Workers > c.MaxWorkers {
+			errs = append(errs, fmt.Errorf("autoscaling workers must satisfy 1 <= min %d <= workers %d <= max %d",
+				c.MinWorkers, c.Workers, c.MaxWorkers))
+		}
+		if c.ScaleIdleMS <= 0 {
+			errs = append(errs, fmt.Errorf("scale idle must be positive, got %d", c.ScaleIdleMS))
+		}
+	} else if c.MaxWorkers < 0 {
+		errs = append(errs, fmt.Errorf("max workers must not be negative, got %d", c.MaxWorkers))
+	}
+	if c.SubmitAttempts < 1 {
+		errs = append(errs, fmt.Errorf("submit attempts must be at least 1, got %d", c.SubmitAttempts))
+	}
+	if c.RetryBackoffMS < 0 || c.RetryMaxBackoffMS < c.RetryBackoffMS {
+		errs = append(errs, fmt.Errorf("retry backoff must satisfy 0 <= %d <= max %d", c.RetryBackoffMS, c.RetryMaxBackoffMS))
+	}
+	if c.ShutdownGraceMS < 0 {
+		errs = append(errs, fmt.Errorf("shutdown grace must not be negative, got %d", c.ShutdownGraceMS))
+	}
+	if c.TaskTimeoutMS < 0 {
+		errs = append(errs, fmt.Errorf("task timeout must not be negative, got %d", c.TaskTimeoutMS))
+	}
+	if err := c.TLS.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestLoadAgentConfig_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.txt")
+	content := `# общий файл с оркестратором
+TIME_ADDITION_MS=150
+ORCHESTRATOR_ADDR=file-host:1
+COMPUTING_POWER=2  # перекрывается окружением и флагом
+AGENT_LABELS=zone=eu, gpu=true
+RETRY_BACKOFF_MS=200
+AGENT_JOIN_TOKEN=join #1
+AGENT_CREDENTIALS_FILE=/var/lib/agent/credentials.json
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadAgentConfig(
+		[]string{"-workers", "5", "-id", "agent-7", "-token", "s3cret", "-outbox", ""},
+		env(map[string]string{
+			"AGENT_CONFIG":      path,
+			"ORCHESTRATOR_ADDR": "env-host:2",
+			"COMPUTING_POWER":   "3",
+		}),
+	)
+	assert.NoError(t, err)
+	// файл < окружение < флаги
+	assert.Equal(t, "env-host:2", cfg.OrchestratorAddr)
+	assert.Equal(t, 5, cfg.Workers)
+	assert.Equal(t, "agent-7", cfg.AgentID)
+	assert.Equal(t, "s3cret", cfg.AuthToken)
+	assert.Equal(t, "join #1", cfg.JoinToken)
+	assert.Equal(t, "/var/lib/agent/credentials.json", cfg.CredentialsFile)
+	assert.Equal(t, "", cfg.OutboxFile)
+	assert.Equal(t, map[string]string{"zone": "eu", "gpu": "true"}, cfg.Labels)
+	assert.Equal(t, 200, cfg.RetryBackoffMS)
+	assert.Equal(t, 30000, cfg.RetryMaxBackoffMS)
+	assert.False(t, cfg.TLS.Enabled)
+}
+
+func TestLoadAgentConfig_Defaults(t *testing.T) {
+	cfg, err := LoadAgentConfig(nil, env(map[string]string{}))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAgentConfig(), cfg)
+
+	_, err = LoadAgentConfig([]string{"-config", "missing.txt"}, env(map[string]string{}))
+	assert.ErrorContains(t, err, "missing.txt")
+}
+
+func TestLoadAgentConfig_Validation(t *testing.T) {
+	_, err := LoadAgentConfig(nil, env(map[string]string{"COMPUTING_POWER": "four"}))
+	assert.ErrorContains(t, err, "COMPUTING_POWER")
+
+	_, err = LoadAgentConfig([]string{"-labels", "zone"}, env(map[string]string{}))
+	assert.ErrorContains(t, err, "-labels")
+
+	_, err = LoadAgentConfig(
+		[]string{"-orchestrator", "no-port", "-workers", "0", "-tls-cert", "agent.pem", "-retry-max-backoff-ms", "10"},
+		env(map[string]string{}),
+	)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no-port")
+		assert.Contains(t, err.Error(), "workers must be at least 1")
+		assert.Contains(t, err.Error(), "TLS_CERT_FILE and TLS_KEY_FILE")
+		assert.Contains(t, err.Error(), "retry backoff")
+	}
+
+	_, err = LoadAgentConfig([]string{"-workers", "8", "-min-workers", "2", "-max-workers", "6"}, env(map[string]string{}))
+	assert.ErrorContains(t, err, "1 <= min 2 <= workers 8 <= max 6")
+
+	cfg, err := LoadAgentConfig(nil, env(map[string]string{"AGENT_MAX_WORKERS": "16", "AGENT_SCALE_IDLE_MS": "5000"}))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cfg.MinWorkers)
+	assert.Equal(t, 16, cfg.MaxWorkers)
+	assert.Equal(t, 5000, cfg.ScaleIdleMS)
+	assert.Equal(t, 60000, cfg.TaskTimeoutMS)
+
+	_, err = LoadAgentConfig([]string{"-task-timeout-ms", "-1"}, env(map[string]string{}))
+	assert.ErrorContains(t, err, "task timeout must not be negative")
+}
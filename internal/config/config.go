@@ -7,4 +7,34 @@ type Config struct {
 	TimeDivisionMS       int
 	ComputingPower       int
 	JwtSecretKey         string
+	// AdminLogins — пользователи с доступом к /api/v1/admin/*
+	AdminLogins []string
+	// UserWeights — веса пользователей при справедливой выдаче задач (по умолчанию 1)
+	UserWeights map[string]int
+	// Повтор задач после временных ошибок (internal_error; timeout и cancelled
+	// не повторяются): число попыток и пауза перед повтором, удваивающаяся
+	// с каждой попыткой до TaskRetryMaxBackoffMS. Истёкшие аренды тоже тратят попытки
+	TaskMaxAttempts       int
+	TaskRetryBackoffMS    int
+	TaskRetryMaxBackoffMS int
+	// GRPCTLS — сертификат gRPC-сервера; CAFile, если задан, включает mTLS:
+	// агент обязан предъявить сертификат, подписанный этим CA
+	GRPCTLS TLSConfig
+	// GRPCAuthToken — общий токен агентов, GRPCAgentTokens — токены отдельных
+	// агентов (ID → токен). Без токенов gRPC-вызовы не проверяются
+	GRPCAuthToken   string
+	GRPCAgentTokens map[string]string
+	// GRPCAgentEnrollment включает проверку вызовов и принимает ключи агентов,
+	// подключённых по токенам от администратора
+	GRPCAgentEnrollment bool
+	// VerifyUsers — пользователи, все выражения которых проверяются повторным
+	// выполнением на другом агенте; VerifyTolerance — допуск сверки (0 — по умолчанию)
+	VerifyUsers     []string
+	VerifyTolerance float64
+	// Карантин агентов: агент, у которого за HealthWindowMS не меньше
+	// HealthMinAttempts попыток и доля успешных ниже HealthMinScore, перестаёт
+	// получать задачи. HealthMinScore = 0 отключает карантин
+	HealthMinScore    float64
+	HealthMinAttempts int
+	HealthWindowMS    int
 }
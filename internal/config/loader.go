@@ -3,6 +3,7 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -10,22 +11,34 @@ import (
 )
 
 const (
-	defaultTimeAdditionMS       = 100
-	defaultTimeSubtractionMS    = 100
-	defaultTimeMultiplicationMS = 200
-	defaultTimeDivisionMS       = 200
-	defaultComputingPower       = 4
-	defaultJwtSecretKey         = ""
+	defaultTimeAdditionMS        = 100
+	defaultTimeSubtractionMS     = 100
+	defaultTimeMultiplicationMS  = 200
+	defaultTimeDivisionMS        = 200
+	defaultComputingPower        = 4
+	defaultJwtSecretKey          = ""
+	defaultTaskMaxAttempts       = 3
+	defaultTaskRetryBackoffMS    = 1000
+	defaultTaskRetryMaxBackoffMS = 30000
+	defaultHealthMinScore        = 0.5
+	defaultHealthMinAttempts     = 10
+	defaultHealthWindowMS        = 600000
 )
 
 func LoadConfig(path string) (*Config, error) {
 	cfg := &Config{
-		TimeAdditionMS:       defaultTimeAdditionMS,
-		TimeSubtractionMS:    defaultTimeSubtractionMS,
-		TimeMultiplicationMS: defaultTimeMultiplicationMS,
-		TimeDivisionMS:       defaultTimeDivisionMS,
-		ComputingPower:       defaultComputingPower,
-		JwtSecretKey:         defaultJwtSecretKey,
+		TimeAdditionMS:        defaultTimeAdditionMS,
+		TimeSubtractionMS:     defaultTimeSubtractionMS,
+		TimeMultiplicationMS:  defaultTimeMultiplicationMS,
+		TimeDivisionMS:        defaultTimeDivisionMS,
+		ComputingPower:        defaultComputingPower,
+		JwtSecretKey:          defaultJwtSecretKey,
+		TaskMaxAttempts:       defaultTaskMaxAttempts,
+		TaskRetryBackoffMS:    defaultTaskRetryBackoffMS,
+		TaskRetryMaxBackoffMS: defaultTaskRetryMaxBackoffMS,
+		HealthMinScore:        defaultHealthMinScore,
+		HealthMinAttempts:     defaultHealthMinAttempts,
+		HealthWindowMS:        defaultHealthWindowMS,
 	}
 
 	file, err := os.Open(path)
@@ -39,21 +52,7 @@ func LoadConfig(path string) (*Config, error) {
 		}
 	}()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
+	err = scanConfig(file, func(key, value string) error {
 		switch key {
 		case "TIME_ADDITION_MS":
 			if v, err := strconv.Atoi(value); err == nil {
@@ -75,15 +74,112 @@ func LoadConfig(path string) (*Config, error) {
 			if v, err := strconv.Atoi(value); err == nil {
 				cfg.ComputingPower = v
 			}
+		case "TASK_MAX_ATTEMPTS":
+			// 0 отключает повторы: временная ошибка сразу завершает выражение
+			if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+				cfg.TaskMaxAttempts = v
+			}
+		case "TASK_RETRY_BACKOFF_MS":
+			if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+				cfg.TaskRetryBackoffMS = v
+			}
+		case "TASK_RETRY_MAX_BACKOFF_MS":
+			if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+				cfg.TaskRetryMaxBackoffMS = v
+			}
+		case "GRPC_TLS_CERT_FILE":
+			cfg.GRPCTLS.CertFile = value
+		case "GRPC_TLS_KEY_FILE":
+			cfg.GRPCTLS.KeyFile = value
+		case "GRPC_TLS_CLIENT_CA_FILE":
+			cfg.GRPCTLS.CAFile = value
+		case "GRPC_AUTH_TOKEN":
+			cfg.GRPCAuthToken = value
+		case "GRPC_AGENT_ENROLLMENT":
+			if v, err := strconv.ParseBool(value); err == nil {
+				cfg.GRPCAgentEnrollment = v
+			}
+		case "GRPC_AGENT_TOKENS":
+			// agent_id:token через запятую
+			cfg.GRPCAgentTokens = make(map[string]string)
+			for _, entry := range strings.Split(value, ",") {
+				id, token, ok := strings.Cut(entry, ":")
+				if id, token = strings.TrimSpace(id), strings.TrimSpace(token); ok && id != "" && token != "" {
+					cfg.GRPCAgentTokens[id] = token
+				}
+			}
 		case "JWT_SECRET_KEY":
 			cfg.JwtSecretKey = value
+		case "ADMIN_LOGINS":
+			cfg.AdminLogins = nil
+			for _, login := range strings.Split(value, ",") {
+				if login = strings.TrimSpace(login); login != "" {
+					cfg.AdminLogins = append(cfg.AdminLogins, login)
+				}
+			}
+		case "VERIFY_USERS":
+			cfg.VerifyUsers = nil
+			for _, login := range strings.Split(value, ",") {
+				if login = strings.TrimSpace(login); login != "" {
+					cfg.VerifyUsers = append(cfg.VerifyUsers, login)
+				}
+			}
+		case "VERIFY_TOLERANCE":
+			if v, err := strconv.ParseFloat(value, 64); err == nil && v >= 0 {
+				cfg.VerifyTolerance = v
+			}
+		case "HEALTH_MIN_SCORE":
+			if v, err := strconv.ParseFloat(value, 64); err == nil && v >= 0 && v <= 1 {
+				cfg.HealthMinScore = v
+			}
+		case "HEALTH_MIN_ATTEMPTS":
+			if v, err := strconv.Atoi(value); err == nil && v > 0 {
+				cfg.HealthMinAttempts = v
+			}
+		case "HEALTH_WINDOW_MS":
+			if v, err := strconv.Atoi(value); err == nil && v > 0 {
+				cfg.HealthWindowMS = v
+			}
+		case "USER_WEIGHTS":
+			// login:weight через запятую; записи с неположительным весом пропускаются
+			cfg.UserWeights = make(map[string]int)
+			for _, entry := range strings.Split(value, ",") {
+				login, weight, ok := strings.Cut(entry, ":")
+				if !ok {
+					continue
+				}
+				if v, err := strconv.Atoi(strings.TrimSpace(weight)); err == nil && v > 0 {
+					cfg.UserWeights[strings.TrimSpace(login)] = v
+				}
+			}
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
+		return nil
+	})
+	if err != nil {
 		return nil, fmt.Errorf("error reading config file: %v", err)
 	}
 
 	log.Printf("Config loaded completed")
 	return cfg, nil
 }
+
+// scanConfig читает строки KEY=VALUE; пустые строки и комментарии (#) пропускаются.
+// Значение берётся целиком до конца строки.
+func scanConfig(r io.Reader, fn func(key, value string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if err := fn(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -12,7 +12,20 @@ TIME_SUBTRACTION_MS=200
 TIME_MULTIPLICATION_MS=250
 TIME_DIVISION_MS=300
 COMPUTING_POWER=8
-JWT_SECRET_KEY=some-secret-key
+JWT_SECRET_KEY=some-secret #key
+ADMIN_LOGINS=root, ops
+USER_WEIGHTS=alice:4, nightly:1, broken, zero:0
+TASK_MAX_ATTEMPTS=5
+GRPC_TLS_CERT_FILE=certs/server.pem
+GRPC_TLS_KEY_FILE=certs/server-key.pem
+GRPC_TLS_CLIENT_CA_FILE=certs/ca.pem
+GRPC_AGENT_TOKENS=agent-1:s3cret, agent-2:, broken
+GRPC_AGENT_ENROLLMENT=true
+TASK_RETRY_BACKOFF_MS=250
+VERIFY_USERS=bank, audit
+VERIFY_TOLERANCE=1e-6
+HEALTH_MIN_SCORE=0.8
+HEALTH_MIN_ATTEMPTS=0
 `
 	tmpFile, err := os.CreateTemp("", "config_test_*.env")
 	if err != nil {
@@ -37,7 +50,21 @@ JWT_SECRET_KEY=some-secret-key
 	assert.Equal(t, 250, cfg.TimeMultiplicationMS)
 	assert.Equal(t, 300, cfg.TimeDivisionMS)
 	assert.Equal(t, 8, cfg.ComputingPower)
-	assert.Equal(t, "some-secret-key", cfg.JwtSecretKey)
+	// " #" внутри значения — часть секрета, а не комментарий
+	assert.Equal(t, "some-secret #key", cfg.JwtSecretKey)
+	assert.Equal(t, []string{"root", "ops"}, cfg.AdminLogins)
+	assert.Equal(t, map[string]int{"alice": 4, "nightly": 1}, cfg.UserWeights)
+	assert.Equal(t, 5, cfg.TaskMaxAttempts)
+	assert.Equal(t, TLSConfig{CertFile: "certs/server.pem", KeyFile: "certs/server-key.pem", CAFile: "certs/ca.pem"}, cfg.GRPCTLS)
+	assert.Equal(t, map[string]string{"agent-1": "s3cret"}, cfg.GRPCAgentTokens)
+	assert.True(t, cfg.GRPCAgentEnrollment)
+	assert.Equal(t, 250, cfg.TaskRetryBackoffMS)
+	assert.Equal(t, defaultTaskRetryMaxBackoffMS, cfg.TaskRetryMaxBackoffMS)
+	assert.Equal(t, []string{"bank", "audit"}, cfg.VerifyUsers)
+	assert.Equal(t, 1e-6, cfg.VerifyTolerance)
+	assert.Equal(t, 0.8, cfg.HealthMinScore)
+	assert.Equal(t, defaultHealthMinAttempts, cfg.HealthMinAttempts)
+	assert.Equal(t, defaultHealthWindowMS, cfg.HealthWindowMS)
 }
 
 func TestLoadConfig_FileNotFound(t *testing.T) {
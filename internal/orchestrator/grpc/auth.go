@@ -0,0 +1,196 @@
+package grpc
+
+import (
+	pb "calculator_app/internal/proto"
+	"context"
+	"crypto/subtle"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// Метаданные вызова агента: токен ("Bearer <token>") и ID агента.
+const (
+	AuthorizationHeader = "authorization"
+	AgentIDHeader       = "x-agent-id"
+)
+
+// Authenticator проверяет токен агента в каждом gRPC-вызове. Общий токен
+// пускает любого агента; токен из agentTokens и ключ, полученный при
+// подключении (Enroll), — только агента, которому они выданы, и такой агент
+// не может действовать от имени другого.
+type Authenticator struct {
+	sharedToken string
+	agentTokens map[string]string
+	credentials CredentialVerifier
+}
+
+// CredentialVerifier проверяет ключи подключённых агентов (service.Orchestrator).
+type CredentialVerifier interface {
+	VerifyAgentCredential(agentID, credential string) (bool, error)
+}
+
+func NewAuthenticator(sharedToken string, agentTokens map[string]string) *Authenticator {
+	return &Authenticator{sharedToken: sharedToken, agentTokens: agentTokens}
+}
+
+// WithEnrollment принимает ключи агентов, подключённых по токенам; отозванный
+// ключ отклоняется со следующего вызова (в потоке — со следующего сообщения
+// агента или перед следующей выдачей задач).
+func (a *Authenticator) WithEnrollment(credentials CredentialVerifier) *Authenticator {
+	a.credentials = credentials
+	return a
+}
+
+// Enabled сообщает, включена ли проверка; иначе вызовы не проверяются.
+func (a *Authenticator) Enabled() bool {
+	return a.sharedToken != "" || len(a.agentTokens) > 0 || a.credentials != nil
+}
+
+// ServerOptions — перехватчики для grpc.NewServer; пусто, если проверка выключена.
+func (a *Authenticator) ServerOptions() []gogrpc.ServerOption {
+	if !a.Enabled() {
+		return nil
+	}
+	return []gogrpc.ServerOption{
+		gogrpc.UnaryInterceptor(a.UnaryInterceptor()),
+		gogrpc.StreamInterceptor(a.StreamInterceptor()),
+	}
+}
+
+func (a *Authenticator) UnaryInterceptor() gogrpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (any, error) {
+		// Enroll проверяет токен подключения сам
+		if info.FullMethod == pb.OrchestratorService_Enroll_FullMethodName {
+			return handler(ctx, req)
+		}
+		agentID, err := a.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAgent(agentID, req); err != nil {
+			return nil, err
+		}
+		return handler(context.WithValue(ctx, agentIDKey{}, agentID), req)
+	}
+}
+
+func (a *Authenticator) StreamInterceptor() gogrpc.StreamServerInterceptor {
+	return func(srv any, ss gogrpc.ServerStream, _ *gogrpc.StreamServerInfo, handler gogrpc.StreamHandler) error {
+		agentID, err := a.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		s := &agentStream{ServerStream: ss, auth: a, agentID: agentID}
+		s.ctx = context.WithValue(context.WithValue(ss.Context(), agentStreamKey{}, s), agentIDKey{}, agentID)
+		return handler(srv, s)
+	}
+}
+
+// authenticate возвращает ID агента, которому выдан токен; пустой ID — общий токен.
+func (a *Authenticator) authenticate(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token, ok := strings.CutPrefix(firstValue(md, AuthorizationHeader), "Bearer ")
+	if !ok || token == "" {
+		return "", status.Error(codes.Unauthenticated, "missing agent token")
+	}
+	if a.sharedToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.sharedToken)) == 1 {
+		return "", nil
+	}
+	agentID := firstValue(md, AgentIDHeader)
+	if expected, ok := a.agentTokens[agentID]; ok && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+		return agentID, nil
+	}
+	if a.credentials != nil {
+		valid, err := a.credentials.VerifyAgentCredential(agentID, token)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "check agent credential: %v", err)
+		}
+		if valid {
+			return agentID, nil
+		}
+	}
+	return "", status.Error(codes.Unauthenticated, "invalid agent token")
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// checkAgent запрещает агенту с собственным токеном запросы от чужого или пустого
+// agent_id: все вызовы такого агента выполняются от его имени. С общим токеном
+// agent_id тоже обязателен — иначе вызов не связан ни с каким агентом.
+func checkAgent(agentID string, msg any) error {
+	req, ok := msg.(interface{ GetAgentId() string })
+	if !ok {
+		return nil
+	}
+	if req.GetAgentId() == "" {
+		return status.Error(codes.PermissionDenied, "agent_id is required")
+	}
+	if agentID != "" && req.GetAgentId() != agentID {
+		return status.Errorf(codes.PermissionDenied, "token of agent %s cannot act as agent %s", agentID, req.GetAgentId())
+	}
+	return nil
+}
+
+type agentIDKey struct{}
+
+// callerAgent возвращает ID агента, от имени которого выполняется вызов: агента,
+// которому выдан токен или ключ, а с общим токеном или без проверки — claimed из запроса.
+func callerAgent(ctx context.Context, claimed string) string {
+	if agentID, _ := ctx.Value(agentIDKey{}).(string); agentID != "" {
+		return agentID
+	}
+	return claimed
+}
+
+// agentStream проверяет токен и agent_id в каждом сообщении потока, чтобы
+// отозванный агент не держал поток открытым. Молчащий агент проверяется
+// через reauthenticate в контексте потока.
+type agentStream struct {
+	gogrpc.ServerStream
+	ctx     context.Context
+	auth    *Authenticator
+	agentID string
+}
+
+type agentStreamKey struct{}
+
+func (s *agentStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *agentStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if err := s.reauthenticate(); err != nil {
+		return err
+	}
+	return checkAgent(s.agentID, m)
+}
+
+// reauthenticate повторно проверяет ключ агента, если ключи можно отозвать.
+func (s *agentStream) reauthenticate() error {
+	if s.auth.credentials == nil {
+		return nil
+	}
+	// метаданные потока не меняются, поэтому agentID остаётся прежним
+	_, err := s.auth.authenticate(s.ctx)
+	return err
+}
+
+// reauthenticate проверяет, что агент потока с контекстом ctx всё ещё допущен;
+// без проверки токенов всегда nil.
+func reauthenticate(ctx context.Context) error {
+	if s, ok := ctx.Value(agentStreamKey{}).(*agentStream); ok {
+		return s.reauthenticate()
+	}
+	return nil
+}
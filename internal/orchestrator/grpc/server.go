@@ -1,63 +1,175 @@
 package grpc
 
 import (
+	"calculator_app/internal/orchestrator/repository"
 	"calculator_app/internal/orchestrator/service"
 	"calculator_app/internal/pkg/models"
 	pb "calculator_app/internal/proto"
 	"context"
+	"errors"
 	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"time"
 )
 
+// streamPollInterval — страховочный опрос БД потоком задач на случай, если
+// сигнал о новых задачах не пришёл (например, задачи добавил другой процесс).
+const streamPollInterval = time.Second
+
 type OrchestratorGRPCServer struct {
 	pb.UnimplementedOrchestratorServiceServer
 	orc *service.Orchestrator
 }
 
 type Orchestrator interface {
-	GetTask() (*models.Task, bool, error)
-	SubmitResult(taskID string, result float64, taskErr *models.TaskError) (bool, error)
-	GetTaskResult(taskID string) (float64, bool, error)
+	GetTask(agentID string, capabilities models.Capabilities) (*models.Task, bool, error)
+	GetTasks(agentID string, capabilities models.Capabilities, maxN int) ([]*models.Task, error)
+	SubmitResults(updates []repository.ResultUpdate) ([]bool, error)
+	SubmitResult(taskID, leaseID string, result *models.TaskResult, taskErr *models.TaskError) (bool, error)
+	ReleaseTasks(agentID string, leases []repository.TaskLease) ([]bool, error)
+	GetTaskResult(taskID string) (*models.TaskResult, bool, error)
+	RegisterAgent(agent *models.Agent) error
+	Heartbeat(agentID string, workers int) (bool, error)
+	TasksReady() <-chan struct{}
+	Cancellations() <-chan struct{}
+	TakeCancellations(agentID string) []string
+	QueueDepth(capabilities models.Capabilities) int
+	EnrollAgent(joinToken, agentID string) (string, string, error)
+	VerifyAgentCredential(agentID, credential string) (bool, error)
 }
 
 func NewOrchestratorGRPCServer(orc *service.Orchestrator) *OrchestratorGRPCServer {
 	return &OrchestratorGRPCServer{orc: orc}
 }
 
-func (s *OrchestratorGRPCServer) GetTask(ctx context.Context, _ *pb.GetTaskRequest) (*pb.GetTaskResponse, error) {
-	task, exists, err := s.orc.GetTask()
-	if err != nil || !exists {
+// GetTask выдаёт одну задачу; если задач нет — пустой ответ (task_id не задан).
+func (s *OrchestratorGRPCServer) GetTask(ctx context.Context, req *pb.GetTaskRequest) (*pb.GetTaskResponse, error) {
+	task, exists, err := s.orc.GetTask(callerAgent(ctx, req.AgentId), models.Capabilities{Operations: req.Operations, Modes: req.Modes})
+	if err != nil {
 		return nil, err
 	}
+	if !exists {
+		return &pb.GetTaskResponse{}, nil
+	}
+
+	return taskResponse(task), nil
+}
+
+// StreamTasks выдаёт задачи по мере появления, но не больше, чем агент
+// объявил свободных воркеров. Агент может и отозвать слоты (free_slots < 0),
+// когда убирает простаивающих воркеров. Отмены задач, которые выполняет агент,
+// приходят в том же потоке сообщением с cancel_task_ids.
+func (s *OrchestratorGRPCServer) StreamTasks(stream pb.OrchestratorService_StreamTasksServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	agentID := callerAgent(ctx, first.AgentId)
+	capabilities := models.Capabilities{Operations: first.Operations, Modes: first.Modes}
+	free := int(first.FreeSlots)
+
+	slots := make(chan int32)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case slots <- req.FreeSlots:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
-	resp := &pb.GetTaskResponse{
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		cancelled := s.orc.Cancellations()
+		if taskIDs := s.orc.TakeCancellations(agentID); len(taskIDs) > 0 {
+			if err := stream.Send(&pb.GetTaskResponse{CancelTaskIds: taskIDs}); err != nil {
+				return err
+			}
+		}
+
+		var ready <-chan struct{}
+		if free > 0 {
+			// ключ агента могли отозвать, пока он молчал: задачи ему больше не выдаются
+			if err := reauthenticate(ctx); err != nil {
+				return err
+			}
+			ready = s.orc.TasksReady()
+			tasks, err := s.orc.GetTasks(agentID, capabilities, free)
+			if err != nil {
+				return err
+			}
+			depth := int32(s.orc.QueueDepth(capabilities))
+			for _, task := range tasks {
+				resp := taskResponse(task)
+				resp.QueueDepth = depth
+				if err := stream.Send(resp); err != nil {
+					return err
+				}
+				free--
+			}
+		}
+		if free <= 0 {
+			ready = nil
+		}
+
+		select {
+		case n := <-slots:
+			free += int(n)
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-ready:
+		case <-cancelled:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func taskResponse(task *models.Task) *pb.GetTaskResponse {
+	return &pb.GetTaskResponse{
 		TaskId:        task.ID,
 		Operation:     task.Operation,
 		Arg1:          task.Arg1,
 		Arg2:          task.Arg2,
+		Arg1Text:      task.Arg1Text,
+		Arg2Text:      task.Arg2Text,
+		Mode:          task.Mode,
+		Arg1Imag:      task.Arg1Imag,
+		Arg2Imag:      task.Arg2Imag,
+		Arg1Upper:     task.Arg1Upper,
+		Arg2Upper:     task.Arg2Upper,
+		Arg1Type:      task.Arg1Type,
+		Arg2Type:      task.Arg2Type,
 		OperationTime: int32(task.OperationTime),
-		DependsOn:     task.DependsOn,
 		UserLogin:     task.UserLogin,
+		LeaseId:       task.LeaseID,
 	}
-
-	return resp, nil
 }
 
 func (s *OrchestratorGRPCServer) SubmitResult(ctx context.Context, req *pb.SubmitResultRequest) (*pb.SubmitResultResponse, error) {
-	var (
-		success bool
-		err     error
-	)
-	switch outcome := req.Outcome.(type) {
-	case *pb.SubmitResultRequest_Result:
-		success, err = s.orc.SubmitResult(req.TaskId, outcome.Result, nil)
-	case *pb.SubmitResultRequest_Error:
-		taskErr := models.NewTaskError(models.TaskErrorCode(outcome.Error), outcome.Error)
-		success, err = s.orc.SubmitResult(req.TaskId, 0, taskErr)
-	default:
-		return nil, fmt.Errorf("invalid outcome in SubmitResultRequest")
+	u, err := resultUpdate(req)
+	if err != nil {
+		return nil, err
 	}
 
+	success, err := s.orc.SubmitResult(u.TaskID, u.LeaseID, u.Result, u.Err)
 	if err != nil {
 		return nil, err
 	}
@@ -65,18 +177,117 @@ func (s *OrchestratorGRPCServer) SubmitResult(ctx context.Context, req *pb.Submi
 	return &pb.SubmitResultResponse{Success: success}, nil
 }
 
+func (s *OrchestratorGRPCServer) GetTasks(ctx context.Context, req *pb.GetTasksRequest) (*pb.GetTasksResponse, error) {
+	tasks, err := s.orc.GetTasks(callerAgent(ctx, req.AgentId), models.Capabilities{Operations: req.Operations, Modes: req.Modes}, int(req.MaxN))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.GetTasksResponse{Tasks: make([]*pb.GetTaskResponse, 0, len(tasks))}
+	depth := int32(s.orc.QueueDepth(models.Capabilities{Operations: req.Operations, Modes: req.Modes}))
+	for _, task := range tasks {
+		t := taskResponse(task)
+		t.QueueDepth = depth
+		resp.Tasks = append(resp.Tasks, t)
+	}
+	return resp, nil
+}
+
+func (s *OrchestratorGRPCServer) SubmitResults(ctx context.Context, req *pb.SubmitResultsRequest) (*pb.SubmitResultsResponse, error) {
+	updates := make([]repository.ResultUpdate, 0, len(req.Results))
+	for _, r := range req.Results {
+		u, err := resultUpdate(r)
+		if err != nil {
+			return nil, fmt.Errorf("task %s: %w", r.TaskId, err)
+		}
+		updates = append(updates, u)
+	}
+
+	success, err := s.orc.SubmitResults(updates)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SubmitResultsResponse{Success: success}, nil
+}
+
+func (s *OrchestratorGRPCServer) ReleaseTasks(ctx context.Context, req *pb.ReleaseTasksRequest) (*pb.ReleaseTasksResponse, error) {
+	leases := make([]repository.TaskLease, 0, len(req.Tasks))
+	for _, t := range req.Tasks {
+		leases = append(leases, repository.TaskLease{TaskID: t.TaskId, LeaseID: t.LeaseId})
+	}
+
+	success, err := s.orc.ReleaseTasks(callerAgent(ctx, req.AgentId), leases)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ReleaseTasksResponse{Success: success}, nil
+}
+
+func (s *OrchestratorGRPCServer) Enroll(ctx context.Context, req *pb.EnrollRequest) (*pb.EnrollResponse, error) {
+	agentID, credential, err := s.orc.EnrollAgent(req.JoinToken, req.AgentId)
+	switch {
+	case errors.Is(err, repository.ErrInvalidJoinToken):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, repository.ErrAgentEnrolled):
+		return nil, status.Errorf(codes.AlreadyExists, "agent %s is already enrolled", req.AgentId)
+	case err != nil:
+		return nil, err
+	}
+	return &pb.EnrollResponse{AgentId: agentID, Credential: credential}, nil
+}
+
+func resultUpdate(req *pb.SubmitResultRequest) (repository.ResultUpdate, error) {
+	u := repository.ResultUpdate{TaskID: req.TaskId, LeaseID: req.LeaseId, Worker: int(req.Worker)}
+	switch outcome := req.Outcome.(type) {
+	case *pb.SubmitResultRequest_Result:
+		u.Result = &models.TaskResult{Value: outcome.Result, Imag: req.ResultImag, Upper: req.ResultUpper, Type: req.ResultType}
+	case *pb.SubmitResultRequest_ResultText:
+		u.Result = &models.TaskResult{Text: outcome.ResultText}
+	case *pb.SubmitResultRequest_Error:
+		u.Err = models.NewTaskError(models.TaskErrorCode(outcome.Error), outcome.Error)
+	default:
+		return u, fmt.Errorf("invalid outcome in SubmitResultRequest")
+	}
+	return u, nil
+}
+
 func (s *OrchestratorGRPCServer) GetTaskResult(ctx context.Context, req *pb.GetTaskResultRequest) (*pb.GetTaskResultResponse, error) {
 	result, exists, err := s.orc.GetTaskResult(req.TaskId)
 	if err != nil {
 		return nil, err
 	}
-	var resultProto *wrapperspb.DoubleValue
+	resp := &pb.GetTaskResultResponse{TaskExists: exists}
 	if exists {
-		resultProto = wrapperspb.Double(result)
+		resp.Result = wrapperspb.Double(result.Value)
+		resp.ResultText = result.Text
+		resp.ResultImag = result.Imag
+		resp.ResultUpper = result.Upper
+		resp.ResultType = result.Type
 	}
 
-	return &pb.GetTaskResultResponse{
-		Result:     resultProto,
-		TaskExists: exists,
+	return resp, nil
+}
+
+func (s *OrchestratorGRPCServer) RegisterAgent(ctx context.Context, req *pb.RegisterAgentRequest) (*pb.RegisterAgentResponse, error) {
+	err := s.orc.RegisterAgent(&models.Agent{
+		ID:             callerAgent(ctx, req.AgentId),
+		Hostname:       req.Hostname,
+		Version:        req.Version,
+		ComputingPower: int(req.ComputingPower),
+		Labels:         req.Labels,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RegisterAgentResponse{
+		HeartbeatIntervalMs: int32(service.HeartbeatInterval.Milliseconds()),
 	}, nil
 }
+
+func (s *OrchestratorGRPCServer) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	registered, err := s.orc.Heartbeat(callerAgent(ctx, req.AgentId), int(req.Workers))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.HeartbeatResponse{Registered: registered}, nil
+}
@@ -2,13 +2,16 @@ package handler
 
 import (
 	"calculator_app/internal/config"
+	"calculator_app/internal/orchestrator/repository"
 	"calculator_app/internal/orchestrator/service"
 	"calculator_app/internal/pkg/models"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -25,15 +28,25 @@ func NewHandler(orc *service.Orchestrator) *Handler {
 */
 
 type Handler struct {
-	orc service.OrchestratorInterface
+	orc    service.OrchestratorInterface
+	admins map[string]bool
 }
 
 func NewHandler(orc service.OrchestratorInterface) *Handler {
 	return &Handler{
-		orc: orc,
+		orc:    orc,
+		admins: make(map[string]bool),
 	}
 }
 
+// WithAdmins задаёт пользователей, которым доступны административные эндпоинты.
+func (h *Handler) WithAdmins(logins []string) *Handler {
+	for _, login := range logins {
+		h.admins[login] = true
+	}
+	return h
+}
+
 func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
@@ -121,6 +134,26 @@ func (h *Handler) authorize(w http.ResponseWriter, r *http.Request) (string, err
 	return login, nil
 }
 
+// authorizeAdmin пишет ответ 401/403 сам и возвращает false, если доступа нет.
+func (h *Handler) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	_, ok := h.adminLogin(w, r)
+	return ok
+}
+
+// adminLogin — как authorizeAdmin, но возвращает и логин администратора.
+func (h *Handler) adminLogin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	login, err := h.authorize(w, r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if !h.admins[login] {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return login, true
+}
+
 func (h *Handler) AddExpression(w http.ResponseWriter, r *http.Request) {
 	login, err := h.authorize(w, r)
 	if err != nil {
@@ -141,13 +174,16 @@ func (h *Handler) AddExpression(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Expression string `json:"expression"`
+		Mode       string `json:"mode"`
+		Priority   int    `json:"priority"`
+		Verify     bool   `json:"verify"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusUnprocessableEntity)
 		return
 	}
 
-	id, err := h.orc.AddExpression(req.Expression, login)
+	id, err := h.orc.AddExpression(req.Expression, login, req.Mode, req.Priority, req.Verify)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
@@ -206,9 +242,238 @@ func (h *Handler) GetExpressionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if format := r.URL.Query().Get("format"); format != "" {
+		base, ok := service.FormatBases[format]
+		if !ok {
+			http.Error(w, "unsupported format: "+format, http.StatusBadRequest)
+			return
+		}
+		formatted, err := service.FormatResult(expr, base)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		expr.Formatted = formatted
+	}
+
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{"expression": expr}); err != nil {
 		log.Printf("Failed to encode response: %v", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 	}
 }
+
+// GetExpressionTasks возвращает подзадачи выражения с историей выдач: какой агент
+// (и его версия) и какой воркер посчитал задачу, когда её захватили и завершили.
+func (h *Handler) GetExpressionTasks(w http.ResponseWriter, r *http.Request) {
+	owner, err := h.authorize(w, r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tasks, exists, err := h.orc.ExpressionTasks(r.PathValue("id"), owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "expression not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"tasks": tasks}); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// CancelExpression отменяет вычисление выражения {id}: оставшиеся задачи не
+// выдаются, а агенты прерывают уже начатые.
+func (h *Handler) CancelExpression(w http.ResponseWriter, r *http.Request) {
+	owner, err := h.authorize(w, r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	found, err := h.orc.CancelExpression(r.PathValue("id"), owner)
+	if errors.Is(err, repository.ErrExpressionFinished) {
+		http.Error(w, "expression is already finished", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "expression not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetAgents(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	agents, err := h.orc.ListAgents()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"agents": agents})
+}
+
+// GetDeadTasks возвращает задачи, исчерпавшие попытки, с историей попыток.
+func (h *Handler) GetDeadTasks(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	tasks, err := h.orc.DeadTasks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"tasks": tasks})
+}
+
+// CreateJoinToken выпускает одноразовый токен подключения агента. Необязательное
+// тело {"ttl_seconds": N} задаёт время жизни токена.
+func (h *Handler) CreateJoinToken(w http.ResponseWriter, r *http.Request) {
+	login, ok := h.adminLogin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TTLSeconds int `json:"ttl_seconds"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	ttl := service.DefaultJoinTokenTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > service.MaxJoinTokenTTL {
+		http.Error(w, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(service.MaxJoinTokenTTL.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.orc.CreateJoinToken(login, ttl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+// GetEnrolledAgents возвращает агентов, подключённых по токенам, включая отозванных.
+func (h *Handler) GetEnrolledAgents(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	agents, err := h.orc.EnrolledAgents()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"agents": agents})
+}
+
+// RevokeAgent отзывает ключ агента {id}.
+func (h *Handler) RevokeAgent(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	revoked, err := h.orc.RevokeAgent(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "enrolled agent not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAgentHealth возвращает оценки надёжности агентов и состояние карантина.
+func (h *Handler) GetAgentHealth(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	health, err := h.orc.AgentHealth()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"agents": health})
+}
+
+// ReleaseQuarantine снимает карантин с агента {id}.
+func (h *Handler) ReleaseQuarantine(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	released, err := h.orc.ReleaseQuarantine(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !released {
+		http.Error(w, "agent is not quarantined", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// defaultQueuePreview — сколько задач из начала очереди показывает GetQueue по умолчанию.
+const defaultQueuePreview = 20
+
+// GetQueue показывает доли пользователей в очереди и ближайшие задачи в порядке выдачи.
+func (h *Handler) GetQueue(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	limit := defaultQueuePreview
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > service.MaxBatchSize {
+			http.Error(w, fmt.Sprintf("limit must be between 0 and %d", service.MaxBatchSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.orc.QueueStats(limit))
+}
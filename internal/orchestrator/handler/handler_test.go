@@ -3,11 +3,13 @@ package handler
 import (
 	"bytes"
 	"calculator_app/internal/orchestrator/repository"
+	"calculator_app/internal/orchestrator/service"
 	"calculator_app/internal/pkg/models"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,7 +42,7 @@ func (m *MockOrchestrator) UserExists(login string) (bool, error) {
 	return login == "validUser", nil
 }
 
-func (m *MockOrchestrator) AddExpression(expression, owner string) (string, error) {
+func (m *MockOrchestrator) AddExpression(expression, owner, mode string, priority int, verify bool) (string, error) {
 	if owner == "validUser" {
 		return "123", nil
 	}
@@ -58,6 +60,62 @@ func (m *MockOrchestrator) GetExpressions(owner string) (map[string]*models.Expr
 	}, nil
 }
 
+func (m *MockOrchestrator) ListAgents() ([]*models.Agent, error) {
+	return []*models.Agent{
+		{ID: "agent-1", Hostname: "worker-1", Version: "dev", ComputingPower: 4, InFlight: 2, Completed: 10, Alive: true},
+	}, nil
+}
+
+func (m *MockOrchestrator) QueueStats(limit int) *models.QueueStats {
+	return &models.QueueStats{
+		Users: []models.UserQueueStats{{Login: "alice", Weight: 4, Queued: 1, Dispatched: 8, VirtualTime: 2}},
+		Next:  []models.QueuedTask{{TaskID: "t1", UserLogin: "alice", Priority: 1, Operation: "+", Mode: models.ModeReal}}[:min(limit, 1)],
+	}
+}
+
+func (m *MockOrchestrator) CreateJoinToken(createdBy string, ttl time.Duration) (*models.JoinToken, error) {
+	return &models.JoinToken{Token: "join-token", CreatedBy: createdBy, ExpiresAt: time.Unix(0, 0).Add(ttl)}, nil
+}
+
+func (m *MockOrchestrator) EnrolledAgents() ([]*models.EnrolledAgent, error) {
+	return []*models.EnrolledAgent{{ID: "agent-1", EnrolledBy: "admin", EnrolledAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}}, nil
+}
+
+func (m *MockOrchestrator) RevokeAgent(agentID string) (bool, error) {
+	return agentID == "agent-1", nil
+}
+
+func (m *MockOrchestrator) AgentHealth() ([]*models.AgentHealth, error) {
+	return []*models.AgentHealth{{AgentID: "agent-1", Attempts: 20, InternalErrors: 15, Score: 0.25, Quarantined: true}}, nil
+}
+
+func (m *MockOrchestrator) CancelExpression(id, owner string) (bool, error) {
+	switch {
+	case owner != "validUser":
+		return false, nil
+	case id == "done":
+		return true, repository.ErrExpressionFinished
+	}
+	return id == "123", nil
+}
+
+func (m *MockOrchestrator) ReleaseQuarantine(agentID string) (bool, error) {
+	return agentID == "agent-1", nil
+}
+
+func (m *MockOrchestrator) DeadTasks() ([]*models.DeadTask, error) {
+	finished := time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC)
+	return []*models.DeadTask{{
+		ID:        "123-1",
+		Operation: "+",
+		Mode:      models.ModeReal,
+		UserLogin: "validUser",
+		Attempts: []models.TaskAttempt{
+			{Attempt: 1, AgentID: "agent-1", StartedAt: finished.Add(-time.Second), FinishedAt: &finished, Outcome: "internal_error"},
+		},
+	}}, nil
+}
+
 func (m *MockOrchestrator) GetExpressionByID(id, owner string) (*models.Expression, bool, error) {
 	if id == "123" && owner == "validUser" {
 		return &models.Expression{
@@ -71,6 +129,18 @@ func (m *MockOrchestrator) GetExpressionByID(id, owner string) (*models.Expressi
 	return nil, false, fmt.Errorf("expression not found")
 }
 
+func (m *MockOrchestrator) ExpressionTasks(id, owner string) ([]*models.ExpressionTask, bool, error) {
+	if id != "123" || owner != "validUser" {
+		return nil, false, nil
+	}
+	result := 5.0
+	return []*models.ExpressionTask{{
+		ID: "123-1", Operation: "+", Mode: models.ModeReal, Status: "completed", Result: &result,
+		Attempts: []models.TaskAttempt{{Attempt: 1, AgentID: "agent-1", AgentVersion: "1.2.0", Worker: 2,
+			StartedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Outcome: "completed"}},
+	}}, true, nil
+}
+
 func TestRegisterUser(t *testing.T) {
 	orc := &MockOrchestrator{}
 	handler := NewHandler(orc)
@@ -149,3 +219,228 @@ func TestGetExpressions(t *testing.T) {
 	assert.Equal(t, "validUser", expr["owner"])
 	assert.Nil(t, expr["result"])
 }
+
+func TestGetAgents(t *testing.T) {
+	orc := &MockOrchestrator{}
+	handler := NewHandler(orc).WithAdmins([]string{"admin"})
+
+	request := func(login string) *httptest.ResponseRecorder {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"login": login})
+		tokenString, _ := token.SignedString([]byte(""))
+
+		req := httptest.NewRequest("GET", "/api/v1/admin/agents", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		handler.GetAgents(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("validUser").Code)
+
+	w := request("admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Agents []models.Agent `json:"agents"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	if assert.Len(t, response.Agents, 1) {
+		assert.Equal(t, "agent-1", response.Agents[0].ID)
+		assert.Equal(t, 2, response.Agents[0].InFlight)
+		assert.True(t, response.Agents[0].Alive)
+	}
+}
+
+func TestGetQueue(t *testing.T) {
+	orc := &MockOrchestrator{}
+	handler := NewHandler(orc).WithAdmins([]string{"admin"})
+
+	request := func(login, query string) *httptest.ResponseRecorder {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"login": login})
+		tokenString, _ := token.SignedString([]byte(""))
+
+		req := httptest.NewRequest("GET", "/api/v1/admin/queue"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		handler.GetQueue(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("validUser", "").Code)
+	assert.Equal(t, http.StatusBadRequest, request("admin", "?limit=abc").Code)
+
+	w := request("admin", "?limit=5")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var stats models.QueueStats
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+	if assert.Len(t, stats.Users, 1) {
+		assert.Equal(t, 4, stats.Users[0].Weight)
+	}
+	if assert.Len(t, stats.Next, 1) {
+		assert.Equal(t, "t1", stats.Next[0].TaskID)
+	}
+}
+
+func TestGetDeadTasks(t *testing.T) {
+	orc := &MockOrchestrator{}
+	handler := NewHandler(orc).WithAdmins([]string{"admin"})
+
+	request := func(login string) *httptest.ResponseRecorder {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"login": login})
+		tokenString, _ := token.SignedString([]byte(""))
+
+		req := httptest.NewRequest("GET", "/api/v1/admin/dead-tasks", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		handler.GetDeadTasks(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("validUser").Code)
+
+	w := request("admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Tasks []models.DeadTask `json:"tasks"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	if assert.Len(t, resp.Tasks, 1) {
+		assert.Equal(t, "123-1", resp.Tasks[0].ID)
+		if assert.Len(t, resp.Tasks[0].Attempts, 1) {
+			assert.Equal(t, "internal_error", resp.Tasks[0].Attempts[0].Outcome)
+		}
+	}
+}
+
+func TestAgentEnrollmentAdmin(t *testing.T) {
+	orc := &MockOrchestrator{}
+	handler := NewHandler(orc).WithAdmins([]string{"admin"})
+
+	do := func(login string, req *http.Request, h http.HandlerFunc) *httptest.ResponseRecorder {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"login": login})
+		tokenString, _ := token.SignedString([]byte(""))
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	w := do("validUser", httptest.NewRequest("POST", "/api/v1/admin/join-tokens", nil), handler.CreateJoinToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do("admin", httptest.NewRequest("POST", "/api/v1/admin/join-tokens", nil), handler.CreateJoinToken)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var token models.JoinToken
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&token))
+	assert.Equal(t, "join-token", token.Token)
+	assert.Equal(t, "admin", token.CreatedBy)
+	assert.Equal(t, int64(service.DefaultJoinTokenTTL.Seconds()), token.ExpiresAt.Unix())
+
+	w = do("admin", httptest.NewRequest("POST", "/api/v1/admin/join-tokens", strings.NewReader(`{"ttl_seconds": 60}`)), handler.CreateJoinToken)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&token))
+	assert.Equal(t, int64(60), token.ExpiresAt.Unix())
+
+	w = do("admin", httptest.NewRequest("POST", "/api/v1/admin/join-tokens", strings.NewReader(`{"ttl_seconds": -1}`)), handler.CreateJoinToken)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("admin", httptest.NewRequest("GET", "/api/v1/admin/enrolled-agents", nil), handler.GetEnrolledAgents)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":"agent-1"`)
+
+	revoke := func(id string) int {
+		req := httptest.NewRequest("DELETE", "/api/v1/admin/enrolled-agents/"+id, nil)
+		req.SetPathValue("id", id)
+		return do("admin", req, handler.RevokeAgent).Code
+	}
+	assert.Equal(t, http.StatusNoContent, revoke("agent-1"))
+	assert.Equal(t, http.StatusNotFound, revoke("agent-2"))
+}
+
+func TestAgentHealthAdmin(t *testing.T) {
+	orc := &MockOrchestrator{}
+	handler := NewHandler(orc).WithAdmins([]string{"admin"})
+
+	do := func(login string, req *http.Request, h http.HandlerFunc) *httptest.ResponseRecorder {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"login": login})
+		tokenString, _ := token.SignedString([]byte(""))
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	w := do("validUser", httptest.NewRequest("GET", "/api/v1/admin/agents/health", nil), handler.GetAgentHealth)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do("admin", httptest.NewRequest("GET", "/api/v1/admin/agents/health", nil), handler.GetAgentHealth)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Agents []models.AgentHealth `json:"agents"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	if assert.Len(t, resp.Agents, 1) {
+		assert.Equal(t, 0.25, resp.Agents[0].Score)
+		assert.True(t, resp.Agents[0].Quarantined)
+	}
+
+	release := func(id string) int {
+		req := httptest.NewRequest("DELETE", "/api/v1/admin/agents/"+id+"/quarantine", nil)
+		req.SetPathValue("id", id)
+		return do("admin", req, handler.ReleaseQuarantine).Code
+	}
+	assert.Equal(t, http.StatusNoContent, release("agent-1"))
+	assert.Equal(t, http.StatusNotFound, release("agent-2"))
+}
+
+func TestGetExpressionTasks(t *testing.T) {
+	orc := &MockOrchestrator{}
+	handler := NewHandler(orc)
+
+	request := func(login, id string) *httptest.ResponseRecorder {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"login": login})
+		tokenString, _ := token.SignedString([]byte(""))
+
+		req := httptest.NewRequest("GET", "/api/v1/expressions/"+id+"/tasks", nil)
+		req.SetPathValue("id", id)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		handler.GetExpressionTasks(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, request("otherUser", "123").Code)
+
+	w := request("validUser", "123")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Tasks []models.ExpressionTask `json:"tasks"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	if assert.Len(t, resp.Tasks, 1) && assert.Len(t, resp.Tasks[0].Attempts, 1) {
+		attempt := resp.Tasks[0].Attempts[0]
+		assert.Equal(t, "agent-1", attempt.AgentID)
+		assert.Equal(t, "1.2.0", attempt.AgentVersion)
+		assert.Equal(t, 2, attempt.Worker)
+	}
+}
+
+func TestCancelExpression(t *testing.T) {
+	handler := NewHandler(&MockOrchestrator{})
+
+	cancel := func(login, id string) int {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"login": login})
+		tokenString, _ := token.SignedString([]byte(""))
+
+		req := httptest.NewRequest("POST", "/api/v1/expressions/"+id+"/cancel", nil)
+		req.SetPathValue("id", id)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		handler.CancelExpression(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNoContent, cancel("validUser", "123"))
+	assert.Equal(t, http.StatusConflict, cancel("validUser", "done"))
+	assert.Equal(t, http.StatusNotFound, cancel("otherUser", "123"))
+}
@@ -3,17 +3,42 @@ package repository
 import (
 	"calculator_app/internal/pkg/models"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"log"
+	"math"
 	"strings"
+	"time"
 )
 
 const (
 	TaskStatusPending    = "pending"
 	TaskStatusProcessing = "processing"
 	TaskStatusCompleted  = "completed"
-	ExprStatusDone       = "done"
+	// TaskStatusDead — задача исчерпала попытки после временных ошибок
+	TaskStatusDead = "dead"
+	ExprStatusDone = "done"
+
+	// OutcomeLeaseExpired — исход попытки, аренда которой истекла без результата
+	OutcomeLeaseExpired = "lease_expired"
+	// OutcomeReleased — исход попытки, которую агент вернул при остановке
+	OutcomeReleased = "released"
+	// OutcomeMismatch — исход попытки, результат которой не совпал с принятым при верификации
+	OutcomeMismatch = "mismatch"
+
+	// TaskStatusCancelled — статус выражения, отменённого пользователем, его
+	// невыполненных задач и их незавершённых попыток
+	TaskStatusCancelled = string(models.ErrCancelled)
+)
+
+// Задача с верификацией принимается, когда VerifyVotes исполнений на разных
+// агентах дали одинаковый результат; если за VerifyMaxRuns исполнений согласия
+// нет, задача завершается ошибкой verification_failed.
+const (
+	VerifyVotes   = 2
+	VerifyMaxRuns = 3
 )
 
 type Repository struct {
@@ -23,19 +48,45 @@ type Repository struct {
 type RepositoryInterface interface {
 	AddExpression(expr *models.Expression) error
 	AddTask(task *models.Task) error
-	GetAndLockTask() (*models.Task, bool, error)
-	UpdateTaskResult(taskID string, result *float64, taskErr *models.TaskError) (bool, string, error)
-	UpdateExpression(id string, status string, result float64) (bool, error)
-	CalculateFinalResult(expressionID string) (float64, error)
+	ReadyTasks() ([]*models.Task, error)
+	ReadyDependents(taskID string) ([]*models.Task, error)
+	ClaimTasks(agentID string, taskIDs []string, leaseSlack time.Duration) ([]*models.Task, error)
+	UpdateTaskResults(updates []ResultUpdate) ([]ResultStatus, error)
+	RequeueExpiredTasks(now time.Time, maxAttempts int) ([]*models.Task, []string, error)
+	ReleaseTasks(agentID string, leases []TaskLease) ([]*models.Task, error)
+	UpdateExpression(id string, status string, result *models.TaskResult) (bool, error)
+	CalculateFinalResult(expressionID string) (*models.TaskResult, error)
 	AreAllTasksCompleted(expressionID string) (bool, error)
 	GetExpressionsByOwner(owner string) (map[string]*models.Expression, error)
 	GetExpressionByIDAndOwner(id, owner string) (*models.Expression, bool, error)
 	RegisterUser(user models.User) error
 	FindUser(login string) (*models.User, error)
-	GetTaskResult(taskID string) (float64, bool, error)
+	GetTaskResult(taskID string) (*models.TaskResult, bool, error)
+	RegisterAgent(agent *models.Agent) error
+	TouchAgent(agentID string, workers int) (bool, error)
+	ListAgents() ([]*models.Agent, error)
+	DeadTasks() ([]*models.DeadTask, error)
+	ExpressionTasks(expressionID string) ([]*models.ExpressionTask, error)
+	CreateJoinToken(tokenHash, createdBy string, expiresAt time.Time) error
+	EnrollAgent(tokenHash, agentID, credentialHash string, now time.Time) error
+	ListEnrolledAgents() ([]*models.EnrolledAgent, error)
+	RevokeAgent(agentID string, now time.Time) (bool, error)
+	AgentCredentialValid(agentID, credentialHash string) (bool, error)
+	AgentHealth(since time.Time) ([]*models.AgentHealth, error)
+	QuarantineAgent(agentID, reason string, now time.Time) (bool, error)
+	UnquarantineAgent(agentID string, now time.Time) (bool, error)
+	CancelExpression(id, owner string, now time.Time) (bool, map[string][]string, error)
 }
 
-var ErrUserExists = errors.New("user already exists")
+var (
+	ErrUserExists = errors.New("user already exists")
+	// ErrInvalidJoinToken — токен подключения неизвестен, истёк или уже использован.
+	ErrInvalidJoinToken = errors.New("join token is invalid, expired or already used")
+	// ErrAgentEnrolled — у агента с таким ID уже есть действующий ключ.
+	ErrAgentEnrolled = errors.New("agent is already enrolled")
+	// ErrExpressionFinished — выражение уже вычислено или отменено.
+	ErrExpressionFinished = errors.New("expression is already finished")
+)
 
 func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
@@ -48,32 +99,59 @@ func (r *Repository) AddExpression(expr *models.Expression) error {
 	}
 
 	_, err := r.db.Exec(
-		`INSERT INTO expressions (id, status, result, owner) VALUES (?, ?, ?, ?)`,
-		expr.ID, expr.Status, result, expr.Owner,
+		`INSERT INTO expressions (id, status, result, owner, mode, priority, verify) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		expr.ID, expr.Status, result, expr.Owner, expr.Mode, expr.Priority, expr.Verify,
 	)
 	return err
 }
 
+// AddTask сохраняет задачу вместе с рёбрами зависимостей.
 func (r *Repository) AddTask(task *models.Task) error {
 	var result interface{} = nil
 	if task.Result != nil {
 		result = *task.Result
 	}
 
-	dependsOn := strings.Join(task.DependsOn, ",")
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("Warning: transaction rollback failed: %v", rErr)
+		}
+	}()
 
-	_, err := r.db.Exec(
+	_, err = tx.Exec(
 		`INSERT INTO tasks 
-			(id, arg1, arg2, operation, operation_time, result, depends_on, user_login) 
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			(id, arg1, arg2, operation, operation_time, result, user_login, arg1_text, arg2_text, mode,
+			 arg1_imag, arg2_imag, arg1_upper, arg2_upper, arg1_type, arg2_type, priority, verify) 
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		task.ID, task.Arg1, task.Arg2, task.Operation, task.OperationTime,
-		result, dependsOn, task.UserLogin,
+		result, task.UserLogin, nullString(task.Arg1Text), nullString(task.Arg2Text), task.Mode,
+		task.Arg1Imag, task.Arg2Imag, task.Arg1Upper, task.Arg2Upper, task.Arg1Type, task.Arg2Type, task.Priority, task.Verify,
 	)
+	if err != nil {
+		return err
+	}
+
+	for _, dep := range task.Dependencies {
+		if _, err := tx.Exec(
+			`INSERT INTO task_dependencies (task_id, depends_on, position) VALUES (?, ?, ?)`,
+			task.ID, dep.TaskID, dep.Position,
+		); err != nil {
+			return fmt.Errorf("failed to add dependency %s of task %s: %w", dep.TaskID, task.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %w", err)
+	}
 	if task.Status == "" {
 		task.Status = TaskStatusPending
 	}
 
-	return err
+	return nil
 }
 
 func (r *Repository) RegisterUser(user models.User) error {
@@ -101,25 +179,35 @@ func (r *Repository) FindUser(login string) (*models.User, error) {
 	return &user, err
 }
 
-func (r *Repository) GetTaskResult(taskID string) (float64, bool, error) {
-	var result float64
+func (r *Repository) GetTaskResult(taskID string) (*models.TaskResult, bool, error) {
+	var (
+		result      sql.NullFloat64
+		resultImag  sql.NullFloat64
+		resultUpper sql.NullFloat64
+		resultText  sql.NullString
+		resultType  sql.NullString
+	)
 	err := r.db.QueryRow(
-		`SELECT result FROM tasks WHERE id = ? AND result IS NOT NULL AND result != 0`,
+		`SELECT result, result_text, result_imag, result_upper, result_type FROM tasks
+         WHERE id = ? AND ((result IS NOT NULL AND (result != 0 OR COALESCE(result_imag, 0) != 0
+                                                    OR COALESCE(result_upper, 0) != 0))
+                           OR result_text IS NOT NULL)`,
 		taskID,
-	).Scan(&result)
+	).Scan(&result, &resultText, &resultImag, &resultUpper, &resultType)
 
 	if errors.Is(err, sql.ErrNoRows) {
-		return 0, false, nil
+		return nil, false, nil
 	}
 	if err != nil {
-		return 0, false, err
+		return nil, false, err
 	}
-	return result, true, nil
+	return scanTaskResult(result, resultImag, resultUpper, resultText, resultType), true, nil
 }
 
 func (r *Repository) GetExpressionsByOwner(owner string) (map[string]*models.Expression, error) {
 	rows, err := r.db.Query(
-		`SELECT id, status, result, owner FROM expressions WHERE owner = ?`,
+		`SELECT id, status, result, owner, result_text, mode, result_imag, result_lower, result_upper, result_type, priority, verify
+		 FROM expressions WHERE owner = ?`,
 		owner,
 	)
 	if err != nil {
@@ -134,9 +222,14 @@ func (r *Repository) GetExpressionsByOwner(owner string) (map[string]*models.Exp
 	expressions := make(map[string]*models.Expression)
 	for rows.Next() {
 		var expr models.Expression
-		if err := rows.Scan(&expr.ID, &expr.Status, &expr.Result, &expr.Owner); err != nil {
+		var resultText sql.NullString
+		var resultImag sql.NullFloat64
+		var resultType sql.NullString
+		if err := rows.Scan(&expr.ID, &expr.Status, &expr.Result, &expr.Owner, &resultText, &expr.Mode, &resultImag,
+			&expr.ResultLower, &expr.ResultUpper, &resultType, &expr.Priority, &expr.Verify); err != nil {
 			return nil, err
 		}
+		setExpressionExtras(&expr, resultText, resultImag, resultType)
 		expressions[expr.ID] = &expr
 	}
 	return expressions, nil
@@ -144,10 +237,15 @@ func (r *Repository) GetExpressionsByOwner(owner string) (map[string]*models.Exp
 
 func (r *Repository) GetExpressionByIDAndOwner(id string, owner string) (*models.Expression, bool, error) {
 	var expr models.Expression
+	var resultText sql.NullString
+	var resultImag sql.NullFloat64
+	var resultType sql.NullString
 	err := r.db.QueryRow(
-		`SELECT id, status, result, owner FROM expressions WHERE id = ? AND owner = ?`,
+		`SELECT id, status, result, owner, result_text, mode, result_imag, result_lower, result_upper, result_type, priority, verify
+		 FROM expressions WHERE id = ? AND owner = ?`,
 		id, owner,
-	).Scan(&expr.ID, &expr.Status, &expr.Result, &expr.Owner)
+	).Scan(&expr.ID, &expr.Status, &expr.Result, &expr.Owner, &resultText, &expr.Mode, &resultImag,
+		&expr.ResultLower, &expr.ResultUpper, &resultType, &expr.Priority, &expr.Verify)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, false, nil
@@ -155,14 +253,87 @@ func (r *Repository) GetExpressionByIDAndOwner(id string, owner string) (*models
 	if err != nil {
 		return nil, false, err
 	}
+	setExpressionExtras(&expr, resultText, resultImag, resultType)
 	return &expr, true, nil
 }
 
-func (r *Repository) GetAndLockTask() (*models.Task, bool, error) {
+// queuedColumns — колонки задачи для очереди в памяти (см. scanQueuedTasks).
+// Последняя — агенты, чьи результаты уже учтены при верификации.
+const queuedColumns = `id, operation, mode, user_login, priority, retry_at,
+		COALESCE((SELECT group_concat(agent_id) FROM task_attempts WHERE task_id = tasks.id AND vote = 1), ''), verify`
+
+// readyCondition отбирает задачи, все зависимости которых уже выполнены.
+const readyCondition = `status = ? AND result IS NULL
+		  AND NOT EXISTS (
+		      SELECT 1
+		      FROM task_dependencies AS d
+		      JOIN tasks AS dep ON dep.id = d.depends_on
+		      WHERE d.task_id = tasks.id AND dep.status != ?
+		  )`
 
+// ReadyTasks возвращает все задачи, готовые к выдаче, в порядке создания.
+// По ним оркестратор заново строит очередь в памяти при старте.
+func (r *Repository) ReadyTasks() ([]*models.Task, error) {
+	rows, err := r.db.Query(`
+		SELECT `+queuedColumns+`
+		FROM tasks
+		WHERE `+readyCondition+`
+		ORDER BY created_at ASC, rowid ASC`,
+		TaskStatusPending, TaskStatusCompleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ready tasks: %w", err)
+	}
+	return scanQueuedTasks(rows)
+}
+
+// ReadyDependents возвращает задачи, ожидавшие taskID, у которых теперь
+// выполнены все зависимости.
+func (r *Repository) ReadyDependents(taskID string) ([]*models.Task, error) {
+	rows, err := r.db.Query(`
+		SELECT `+queuedColumns+`
+		FROM tasks
+		WHERE id IN (SELECT task_id FROM task_dependencies WHERE depends_on = ?)
+		  AND `+readyCondition,
+		taskID, TaskStatusPending, TaskStatusCompleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dependents of task %s: %w", taskID, err)
+	}
+	return scanQueuedTasks(rows)
+}
+
+func scanQueuedTasks(rows *sql.Rows) ([]*models.Task, error) {
+	defer rows.Close()
+
+	var tasks []*models.Task
+	for rows.Next() {
+		var task models.Task
+		var retryAt sql.NullInt64
+		var voters string
+		if err := rows.Scan(&task.ID, &task.Operation, &task.Mode, &task.UserLogin, &task.Priority, &retryAt, &voters, &task.Verify); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if retryAt.Valid {
+			task.RetryAt = time.UnixMilli(retryAt.Int64)
+		}
+		if voters != "" {
+			task.Voters = strings.Split(voters, ",")
+		}
+		task.Status = TaskStatusPending
+		tasks = append(tasks, &task)
+	}
+	return tasks, rows.Err()
+}
+
+// ClaimTasks захватывает задачи taskIDs в аренду на operation_time + leaseSlack
+// одной транзакцией и подставляет в аргументы результаты зависимостей. Каждый
+// захват — новая попытка в task_attempts.
+// Задачи, которые уже не ждут выдачи, пропускаются: очередь в памяти лишь
+// подсказывает кандидатов, решает статус в БД. Задачу с верификацией получает
+// только агент с ID, ещё не голосовавший за неё: голоса должны быть от разных агентов.
+func (r *Repository) ClaimTasks(agentID string, taskIDs []string, leaseSlack time.Duration) ([]*models.Task, error) {
 	tx, err := r.db.Begin()
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer func() {
 		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
@@ -170,111 +341,860 @@ func (r *Repository) GetAndLockTask() (*models.Task, bool, error) {
 		}
 	}()
 
-	var task models.Task
-	var dependsOnStr string
-	var result sql.NullFloat64
+	now := time.Now()
+	leaseBase := now.Add(leaseSlack).UnixMilli()
+	tasks := make([]*models.Task, 0, len(taskIDs))
+	for _, id := range taskIDs {
+		task := models.Task{LeaseID: uuid.NewString()}
+		var result sql.NullFloat64
+		var operationTime sql.NullInt64
+		err := tx.QueryRow(`
+        UPDATE tasks 
+        SET status = ?, 
+            agent_id = ?,
+            lease_id = ?,
+            lease_expires_at = ? + COALESCE(operation_time, 0),
+            attempts = attempts + 1,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = ? 
+          AND status = ?
+          AND (retry_at IS NULL OR retry_at <= ?)
+          AND (verify = 0 OR (? != '' AND NOT EXISTS (
+              SELECT 1 FROM task_attempts WHERE task_id = tasks.id AND vote = 1 AND agent_id = ?
+          )))
+        RETURNING id, arg1, arg2, operation, operation_time, user_login, result,
+                  COALESCE(arg1_text, ''), COALESCE(arg2_text, ''), mode, arg1_imag, arg2_imag,
+                  arg1_upper, arg2_upper, arg1_type, arg2_type, attempts`,
+			TaskStatusProcessing, nullString(agentID), task.LeaseID, leaseBase, id, TaskStatusPending, now.UnixMilli(),
+			agentID, agentID,
+		).Scan(
+			&task.ID, &task.Arg1, &task.Arg2, &task.Operation,
+			&operationTime, &task.UserLogin, &result,
+			&task.Arg1Text, &task.Arg2Text, &task.Mode, &task.Arg1Imag, &task.Arg2Imag,
+			&task.Arg1Upper, &task.Arg2Upper, &task.Arg1Type, &task.Arg2Type, &task.Attempts,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim task %s: %w", id, err)
+		}
+		if result.Valid {
+			task.Result = &result.Float64
+		}
+		task.OperationTime = int(operationTime.Int64)
+		task.LeaseExpiresAt = time.UnixMilli(leaseBase + operationTime.Int64)
 
-	err = tx.QueryRow(`
-		SELECT id, arg1, arg2, operation, operation_time, depends_on, user_login, result
-		FROM tasks 
-		WHERE status = ? AND result IS NULL
-		ORDER BY created_at ASC
-		LIMIT 1`,
-		TaskStatusPending,
-	).Scan(
-		&task.ID, &task.Arg1, &task.Arg2, &task.Operation,
-		&task.OperationTime, &dependsOnStr, &task.UserLogin, &result,
-	)
+		if _, err := tx.Exec(
+			`INSERT INTO task_attempts (task_id, attempt, agent_id, agent_version, lease_id, started_at)
+			 VALUES (?, ?, ?, (SELECT version FROM agents WHERE id = ?), ?, ?)`,
+			task.ID, task.Attempts, nullString(agentID), agentID, task.LeaseID, now.UnixMilli(),
+		); err != nil {
+			return nil, fmt.Errorf("failed to record attempt of task %s: %w", task.ID, err)
+		}
 
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, false, nil
-	}
-	if err != nil {
-		return nil, false, fmt.Errorf("query error: %w", err)
+		if err := resolveDependencies(tx, &task); err != nil {
+			return nil, err
+		}
+		task.Status = TaskStatusProcessing
+		tasks = append(tasks, &task)
 	}
 
-	if result.Valid {
-		task.Result = &result.Float64
-	} else {
-		task.Result = nil
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit error: %w", err)
 	}
 
-	res, err := tx.Exec(`
-        UPDATE tasks 
-        SET status = ?, 
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = ? 
-          AND status = ?`,
-		TaskStatusProcessing, task.ID, TaskStatusPending)
-	if err != nil {
-		return nil, false, fmt.Errorf("update error: %w", err)
-	}
+	return tasks, nil
+}
 
-	rowsAffected, err := res.RowsAffected()
+// resolveDependencies подставляет результаты выполненных зависимостей в аргументы задачи.
+func resolveDependencies(tx *sql.Tx, task *models.Task) error {
+	rows, err := tx.Query(`
+		SELECT d.depends_on, d.position, dep.result, dep.result_imag, dep.result_upper, dep.result_text, dep.result_type
+		FROM task_dependencies AS d
+		JOIN tasks AS dep ON dep.id = d.depends_on
+		WHERE d.task_id = ?`, task.ID)
 	if err != nil {
-		return nil, false, fmt.Errorf("rows affected error: %w", err)
+		return fmt.Errorf("failed to load dependencies of task %s: %w", task.ID, err)
 	}
+	defer rows.Close()
 
-	if rowsAffected == 0 {
-		return nil, false, nil
+	for rows.Next() {
+		var (
+			dep                             models.Dependency
+			result, resultImag, resultUpper sql.NullFloat64
+			resultText, resultType          sql.NullString
+		)
+		if err := rows.Scan(&dep.TaskID, &dep.Position, &result, &resultImag, &resultUpper, &resultText, &resultType); err != nil {
+			return fmt.Errorf("failed to scan dependency of task %s: %w", task.ID, err)
+		}
+		task.SetArg(dep.Position, scanTaskResult(result, resultImag, resultUpper, resultText, resultType))
+		task.Dependencies = append(task.Dependencies, dep)
 	}
+	return rows.Err()
+}
 
-	if err := tx.Commit(); err != nil {
-		return nil, false, fmt.Errorf("commit error: %w", err)
-	}
+// RetryPolicy — повтор задач после временных ошибок: задача выдаётся не больше
+// MaxAttempts раз, перед попыткой n+1 выжидается Backoff * 2^(n-1), но не больше MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
 
-	if dependsOnStr != "" {
-		task.DependsOn = strings.Split(dependsOnStr, ",")
+// Delay возвращает паузу перед попыткой, следующей за attempt.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
 	}
+	return delay
+}
 
-	task.Status = TaskStatusProcessing
+// ResultUpdate — результат (или ошибка) задачи, полученный по аренде LeaseID.
+// Если задан Retry, ошибка считается временной и задача повторяется по этой политике.
+type ResultUpdate struct {
+	TaskID  string
+	LeaseID string
+	Result  *models.TaskResult
+	Err     *models.TaskError
+	Retry   *RetryPolicy
+	// Worker — номер воркера агента, выполнившего задачу; 0 — не указан
+	Worker int
+	// Tolerance — допустимое относительное расхождение результатов задачи с верификацией
+	Tolerance float64
+}
 
-	return &task, true, nil
+// ResultStatus — итог ResultUpdate: Updated = false, если аренда уже не действует.
+// Если задача возвращена в очередь для повтора, Status = pending, а Requeued
+// содержит её данные для очереди в памяти. Duplicate — результат по этой аренде
+// уже был принят раньше (агент отправил его повторно), Status — исход той попытки.
+type ResultStatus struct {
+	Updated   bool
+	Duplicate bool
+	Status    string
+	Requeued  *models.Task
+	// recorded — исход попытки уже записан (verifyTask)
+	recorded bool
 }
 
-func (r *Repository) UpdateTaskResult(taskID string, result *float64, taskErr *models.TaskError) (bool, string, error) {
-	var (
-		status      string
-		resultValue sql.NullFloat64
-		//errorMessage sql.NullString
-	)
-	if taskErr != nil {
-		status = string(taskErr.Code)
-		resultValue = sql.NullFloat64{}
-		//errorMessage = sql.NullString{
-		//	String: taskErr.Message,
-		//	Valid:  true,
-		//}
-	} else {
-		status = TaskStatusCompleted
-		resultValue = sql.NullFloat64{
-			Float64: *result,
-			Valid:   true,
+// UpdateTaskResults сохраняет результаты одной транзакцией. Результат принимается,
+// только если задача всё ещё в аренде LeaseID: результат от агента, чья аренда истекла
+// и задача передана другому, отбрасывается (см. staleResult). Результат задачи с
+// верификацией учитывается как голос агента (см. verifyTask). Статусы возвращаются
+// в порядке updates.
+func (r *Repository) UpdateTaskResults(updates []ResultUpdate) ([]ResultStatus, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("Warning: transaction rollback failed: %v", rErr)
+		}
+	}()
+
+	now := time.Now()
+	statuses := make([]ResultStatus, 0, len(updates))
+	for _, u := range updates {
+		var st ResultStatus
+		if u.Err != nil && u.Retry != nil {
+			st, err = retryTask(tx, u, now)
+		} else {
+			st, err = updateTaskResult(tx, u, false)
+			if err == nil && !st.Updated {
+				st, err = verifyTask(tx, u, now, st)
+			}
+		}
+		if err == nil && !st.Updated {
+			st, err = staleResult(tx, u, st)
+		}
+		if err != nil {
+			return nil, err
 		}
-		//errorMessage = sql.NullString{} // нет ошибки — поле пустое
+		if st.Updated && !st.recorded {
+			outcome := st.Status
+			if u.Err != nil {
+				outcome = string(u.Err.Code)
+			}
+			if _, err := tx.Exec(
+				`UPDATE task_attempts SET finished_at = ?, outcome = ?, worker = ? WHERE task_id = ? AND lease_id = ?`,
+				now.UnixMilli(), outcome, nullInt(u.Worker), u.TaskID, u.LeaseID,
+			); err != nil {
+				return nil, fmt.Errorf("failed to record attempt outcome: %w", err)
+			}
+		}
+		statuses = append(statuses, st)
 	}
 
-	res, err := r.db.Exec(
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit error: %w", err)
+	}
+	return statuses, nil
+}
+
+// updateTaskResult завершает задачу результатом или ошибкой u. verify отбирает
+// задачи с верификацией: их результат записывается только после сверки голосов.
+func updateTaskResult(tx *sql.Tx, u ResultUpdate, verify bool) (ResultStatus, error) {
+	status := updateStatus(u)
+	resultValue, resultText, resultImag, resultUpper, resultType := resultArgs(u)
+
+	res, err := tx.Exec(
 		`UPDATE tasks SET 
             result = ?, 
+            result_text = ?,
+            result_imag = ?,
+            result_upper = ?,
+            result_type = ?,
             status = ?,
             updated_at = CURRENT_TIMESTAMP
-         WHERE id = ? AND status = ?`,
+         WHERE id = ? AND status = ? AND lease_id = ? AND verify = ?`,
 		resultValue,
+		resultText,
+		resultImag,
+		resultUpper,
+		resultType,
 		status,
-		taskID,
+		u.TaskID,
 		TaskStatusProcessing,
+		u.LeaseID,
+		verify,
 	)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to update task result: %w", err)
+		return ResultStatus{}, fmt.Errorf("failed to update task result: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return ResultStatus{}, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return ResultStatus{Updated: rowsAffected > 0, Status: status}, nil
+}
+
+// staleResult разбирает результат, аренда которого уже не действует. Повтор
+// результата, уже принятого по этой аренде, помечается Duplicate. Результат,
+// опоздавший к истечению аренды (например, пока оркестратор перезапускался),
+// принимается, если задачу с тех пор никто не взял; временная ошибка и
+// результат задачи с верификацией в этом случае отбрасываются.
+func staleResult(tx *sql.Tx, u ResultUpdate, rejected ResultStatus) (ResultStatus, error) {
+	var attempt int
+	var outcome sql.NullString
+	err := tx.QueryRow(
+		`SELECT attempt, outcome FROM task_attempts WHERE task_id = ? AND lease_id = ? AND finished_at IS NOT NULL`,
+		u.TaskID, u.LeaseID,
+	).Scan(&attempt, &outcome)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rejected, nil
+	}
+	if err != nil {
+		return ResultStatus{}, fmt.Errorf("failed to load attempt of task %s: %w", u.TaskID, err)
+	}
+
+	switch outcome.String {
+	case OutcomeReleased, TaskStatusCancelled:
+		return rejected, nil
+	case OutcomeLeaseExpired:
+		if u.Retry != nil {
+			return rejected, nil
+		}
+	default:
+		return ResultStatus{Duplicate: true, Status: outcome.String}, nil
 	}
 
+	status := updateStatus(u)
+	resultValue, resultText, resultImag, resultUpper, resultType := resultArgs(u)
+	res, err := tx.Exec(`
+		UPDATE tasks
+		SET result = ?, result_text = ?, result_imag = ?, result_upper = ?, result_type = ?,
+		    status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ? AND attempts = ? AND verify = 0`,
+		resultValue, resultText, resultImag, resultUpper, resultType,
+		status, u.TaskID, TaskStatusPending, attempt)
+	if err != nil {
+		return ResultStatus{}, fmt.Errorf("failed to accept late result of task %s: %w", u.TaskID, err)
+	}
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
-		return false, "", fmt.Errorf("failed to get rows affected: %w", err)
+		return ResultStatus{}, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return rejected, nil
+	}
+	log.Printf("Task %s: accepted late result of expired lease %s", u.TaskID, u.LeaseID)
+	return ResultStatus{Updated: true, Status: status}, nil
+}
+
+// updateStatus — статус задачи после u: completed или код ошибки.
+func updateStatus(u ResultUpdate) string {
+	if u.Err != nil {
+		return string(u.Err.Code)
+	}
+	return TaskStatusCompleted
+}
+
+// resultArgs — значения колонок result, result_text, result_imag, result_upper
+// и result_type для u; у ошибки все они NULL.
+func resultArgs(u ResultUpdate) (value sql.NullFloat64, text sql.NullString, imag, upper sql.NullFloat64, typ sql.NullString) {
+	if u.Err != nil || u.Result == nil {
+		return
+	}
+	value, text = resultColumns(u.Result)
+	imag = sql.NullFloat64{Float64: u.Result.Imag, Valid: true}
+	if u.Result.Upper != nil {
+		upper = sql.NullFloat64{Float64: *u.Result.Upper, Valid: true}
+	}
+	typ = nullString(u.Result.Type)
+	return
+}
+
+// vote — результат одного исполнения задачи с верификацией.
+type vote struct {
+	agentID string
+	leaseID string
+	status  string
+	result  *models.TaskResult
+}
+
+// agrees сообщает, совпадают ли два результата: ошибки — по коду, текстовые
+// результаты — точно, числа — с относительной точностью tolerance.
+func (v vote) agrees(other vote, tolerance float64) bool {
+	if v.status != other.status {
+		return false
+	}
+	if v.status != TaskStatusCompleted {
+		return true
+	}
+	a, b := v.result, other.result
+	if a.Type != b.Type || a.Text != b.Text || (a.Upper == nil) != (b.Upper == nil) {
+		return false
+	}
+	if a.Upper != nil && !approxEqual(*a.Upper, *b.Upper, tolerance) {
+		return false
+	}
+	return approxEqual(a.Value, b.Value, tolerance) && approxEqual(a.Imag, b.Imag, tolerance)
+}
+
+func approxEqual(a, b, tolerance float64) bool {
+	if a == b || (math.IsNaN(a) && math.IsNaN(b)) {
+		return true
+	}
+	return math.Abs(a-b) <= tolerance*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// verifyTask учитывает результат задачи с верификацией как голос агента.
+// Задача завершается, когда VerifyVotes голосов совпали, а несогласные попытки
+// помечаются mismatch. Иначе задача возвращается в очередь для агента, который
+// её ещё не выполнял, а после VerifyMaxRuns голосов без согласия завершается
+// ошибкой verification_failed. rejected возвращается, если аренда уже не действует.
+func verifyTask(tx *sql.Tx, u ResultUpdate, now time.Time, rejected ResultStatus) (ResultStatus, error) {
+	var agentID sql.NullString
+	err := tx.QueryRow(
+		`SELECT agent_id FROM tasks WHERE id = ? AND status = ? AND lease_id = ? AND verify = 1`,
+		u.TaskID, TaskStatusProcessing, u.LeaseID,
+	).Scan(&agentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rejected, nil
+	}
+	if err != nil {
+		return ResultStatus{}, fmt.Errorf("failed to load task %s: %w", u.TaskID, err)
+	}
+
+	current := vote{agentID: agentID.String, leaseID: u.LeaseID, status: updateStatus(u), result: u.Result}
+	resultValue, resultText, resultImag, resultUpper, resultType := resultArgs(u)
+	if _, err := tx.Exec(`
+		UPDATE task_attempts
+		SET finished_at = ?, outcome = ?, worker = ?, vote = 1,
+		    result = ?, result_text = ?, result_imag = ?, result_upper = ?, result_type = ?
+		WHERE task_id = ? AND lease_id = ?`,
+		now.UnixMilli(), current.status, nullInt(u.Worker),
+		resultValue, resultText, resultImag, resultUpper, resultType,
+		u.TaskID, u.LeaseID,
+	); err != nil {
+		return ResultStatus{}, fmt.Errorf("failed to record vote: %w", err)
+	}
+
+	votes, err := loadVotes(tx, u.TaskID)
+	if err != nil {
+		return ResultStatus{}, err
+	}
+	var agreed, disagreed []vote
+	for _, v := range votes {
+		if v.agrees(current, u.Tolerance) {
+			agreed = append(agreed, v)
+		} else {
+			disagreed = append(disagreed, v)
+		}
+	}
+
+	switch {
+	case len(agreed) >= VerifyVotes:
+		for _, v := range disagreed {
+			if _, err := tx.Exec(
+				`UPDATE task_attempts SET outcome = ? WHERE task_id = ? AND lease_id = ?`,
+				OutcomeMismatch, u.TaskID, v.leaseID,
+			); err != nil {
+				return ResultStatus{}, fmt.Errorf("failed to record attempt outcome: %w", err)
+			}
+		}
+		if len(disagreed) > 0 {
+			log.Printf("Task %s verified by agents %s, rejected result of %s",
+				u.TaskID, voteAgents(agreed), voteAgents(disagreed))
+		}
+		st, err := updateTaskResult(tx, u, true)
+		st.recorded = true
+		return st, err
+	case len(votes) >= VerifyMaxRuns:
+		log.Printf("Task %s failed verification: results of agents %s disagree", u.TaskID, voteAgents(votes))
+		failed := ResultUpdate{
+			TaskID:  u.TaskID,
+			LeaseID: u.LeaseID,
+			Err:     models.NewTaskError(models.ErrVerificationFailed, "agents disagree on the result"),
+		}
+		st, err := updateTaskResult(tx, failed, true)
+		st.recorded = true
+		return st, err
+	}
+
+	if len(disagreed) > 0 {
+		log.Printf("Task %s: results of agents %s disagree, running it once more", u.TaskID, voteAgents(votes))
+	}
+	rows, err := tx.Query(`
+		UPDATE tasks
+		SET status = ?,
+		    agent_id = NULL,
+		    lease_id = NULL,
+		    lease_expires_at = NULL,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING `+queuedColumns,
+		TaskStatusPending, u.TaskID)
+	if err != nil {
+		return ResultStatus{}, fmt.Errorf("failed to requeue task %s: %w", u.TaskID, err)
+	}
+	tasks, err := scanQueuedTasks(rows)
+	if err != nil || len(tasks) == 0 {
+		return ResultStatus{}, fmt.Errorf("failed to requeue task %s: %w", u.TaskID, err)
+	}
+	return ResultStatus{Updated: true, Status: TaskStatusPending, Requeued: tasks[0], recorded: true}, nil
+}
+
+// loadVotes возвращает голоса задачи в порядке попыток.
+func loadVotes(tx *sql.Tx, taskID string) ([]vote, error) {
+	rows, err := tx.Query(`
+		SELECT COALESCE(agent_id, ''), lease_id, outcome, result, result_imag, result_upper, result_text, result_type
+		FROM task_attempts
+		WHERE task_id = ? AND vote = 1
+		ORDER BY attempt`, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load votes of task %s: %w", taskID, err)
+	}
+	defer rows.Close()
+
+	var votes []vote
+	for rows.Next() {
+		var (
+			v                               vote
+			result, resultImag, resultUpper sql.NullFloat64
+			resultText, resultType          sql.NullString
+		)
+		if err := rows.Scan(&v.agentID, &v.leaseID, &v.status, &result, &resultImag, &resultUpper, &resultText, &resultType); err != nil {
+			return nil, fmt.Errorf("failed to scan vote of task %s: %w", taskID, err)
+		}
+		if v.status == TaskStatusCompleted {
+			v.result = scanTaskResult(result, resultImag, resultUpper, resultText, resultType)
+		}
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
+
+func voteAgents(votes []vote) string {
+	agents := make([]string, len(votes))
+	for i, v := range votes {
+		agents[i] = v.agentID
+	}
+	return strings.Join(agents, ", ")
+}
+
+// retryTask возвращает задачу после временной ошибки в очередь с задержкой по
+// u.Retry или, если попытки исчерпаны, переводит её в dead.
+func retryTask(tx *sql.Tx, u ResultUpdate, now time.Time) (ResultStatus, error) {
+	// исполнения, давшие голос при верификации, попытками не считаются
+	var attempts int
+	err := tx.QueryRow(
+		`SELECT attempts - (SELECT COUNT(*) FROM task_attempts WHERE task_id = tasks.id AND vote = 1)
+		 FROM tasks WHERE id = ? AND status = ? AND lease_id = ?`,
+		u.TaskID, TaskStatusProcessing, u.LeaseID,
+	).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ResultStatus{Status: string(u.Err.Code)}, nil
+	}
+	if err != nil {
+		return ResultStatus{}, fmt.Errorf("failed to load attempts of task %s: %w", u.TaskID, err)
+	}
+
+	if attempts >= u.Retry.MaxAttempts {
+		if _, err := tx.Exec(`
+			UPDATE tasks
+			SET status = ?, lease_id = NULL, lease_expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`,
+			TaskStatusDead, u.TaskID); err != nil {
+			return ResultStatus{}, fmt.Errorf("failed to mark task %s dead: %w", u.TaskID, err)
+		}
+		return ResultStatus{Updated: true, Status: TaskStatusDead}, nil
+	}
+
+	rows, err := tx.Query(`
+		UPDATE tasks
+		SET status = ?,
+		    agent_id = NULL,
+		    lease_id = NULL,
+		    lease_expires_at = NULL,
+		    retry_at = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING `+queuedColumns,
+		TaskStatusPending, now.Add(u.Retry.Delay(attempts)).UnixMilli(), u.TaskID)
+	if err != nil {
+		return ResultStatus{}, fmt.Errorf("failed to requeue task %s: %w", u.TaskID, err)
+	}
+	tasks, err := scanQueuedTasks(rows)
+	if err != nil || len(tasks) == 0 {
+		return ResultStatus{}, fmt.Errorf("failed to requeue task %s: %w", u.TaskID, err)
+	}
+	return ResultStatus{Updated: true, Status: TaskStatusPending, Requeued: tasks[0]}, nil
+}
+
+// RequeueExpiredTasks возвращает в очередь задачи с истёкшей арендой
+// (агент упал или пропал) и возвращает их для очереди в памяти. Задачи, выданные
+// уже maxAttempts раз (голоса верификации не считаются, как в retryTask), вместо
+// этого переводятся в dead, и возвращаются их ID; maxAttempts <= 0 — без ограничения.
+func (r *Repository) RequeueExpiredTasks(now time.Time, maxAttempts int) ([]*models.Task, []string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("Warning: transaction rollback failed: %v", rErr)
+		}
+	}()
+
+	if _, err := tx.Exec(`
+		UPDATE task_attempts
+		SET finished_at = ?, outcome = ?
+		WHERE finished_at IS NULL
+		  AND lease_id IN (
+		      SELECT lease_id FROM tasks
+		      WHERE status = ? AND lease_expires_at < ?
+		  )`,
+		now.UnixMilli(), OutcomeLeaseExpired, TaskStatusProcessing, now.UnixMilli()); err != nil {
+		return nil, nil, fmt.Errorf("failed to close expired attempts: %w", err)
+	}
+
+	var dead []string
+	if maxAttempts > 0 {
+		rows, err := tx.Query(`
+			UPDATE tasks
+			SET status = ?, lease_id = NULL, lease_expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE status = ?
+			  AND (lease_expires_at IS NULL OR lease_expires_at < ?)
+			  AND attempts - (SELECT COUNT(*) FROM task_attempts WHERE task_id = tasks.id AND vote = 1) >= ?
+			RETURNING id`,
+			TaskStatusDead, TaskStatusProcessing, now.UnixMilli(), maxAttempts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to mark expired tasks dead: %w", err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, nil, fmt.Errorf("scan error: %w", err)
+			}
+			dead = append(dead, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, fmt.Errorf("failed to mark expired tasks dead: %w", err)
+		}
+	}
+
+	rows, err := tx.Query(`
+		UPDATE tasks
+		SET status = ?,
+		    agent_id = NULL,
+		    lease_id = NULL,
+		    lease_expires_at = NULL,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE status = ?
+		  AND (lease_expires_at IS NULL OR lease_expires_at < ?)
+		RETURNING `+queuedColumns,
+		TaskStatusPending, TaskStatusProcessing, now.UnixMilli())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to requeue expired tasks: %w", err)
+	}
+	tasks, err := scanQueuedTasks(rows)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to requeue expired tasks: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("commit error: %w", err)
+	}
+	return tasks, dead, nil
+}
+
+// TaskLease — задача и аренда, под которой агент её получил.
+type TaskLease struct {
+	TaskID  string
+	LeaseID string
+}
+
+// ReleaseTasks возвращает в pending задачи, которые агент agentID получил, но не
+// выполнил. Результат выровнен по leases: nil, если аренда уже не действует
+// (истекла, задача выполнена или выдана другому агенту).
+func (r *Repository) ReleaseTasks(agentID string, leases []TaskLease) ([]*models.Task, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("Warning: transaction rollback failed: %v", rErr)
+		}
+	}()
+
+	now := time.Now().UnixMilli()
+	released := make([]*models.Task, len(leases))
+	for i, l := range leases {
+		rows, err := tx.Query(`
+			UPDATE tasks
+			SET status = ?,
+			    agent_id = NULL,
+			    lease_id = NULL,
+			    lease_expires_at = NULL,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status = ? AND lease_id = ? AND agent_id = ?
+			RETURNING `+queuedColumns,
+			TaskStatusPending, l.TaskID, TaskStatusProcessing, l.LeaseID, agentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to release task %s: %w", l.TaskID, err)
+		}
+		tasks, err := scanQueuedTasks(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to release task %s: %w", l.TaskID, err)
+		}
+		if len(tasks) == 0 {
+			continue
+		}
+		released[i] = tasks[0]
+
+		if _, err := tx.Exec(
+			`UPDATE task_attempts SET finished_at = ?, outcome = ? WHERE task_id = ? AND lease_id = ?`,
+			now, OutcomeReleased, l.TaskID, l.LeaseID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to record attempt outcome: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit error: %w", err)
+	}
+	return released, nil
+}
+
+// CancelExpression отменяет выражение id владельца owner: невыполненные задачи
+// и их незавершённые попытки получают статус cancelled. Возвращает ID задач,
+// которые сейчас выполняются, по агентам; false — выражение не найдено.
+func (r *Repository) CancelExpression(id, owner string, now time.Time) (bool, map[string][]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("Warning: transaction rollback failed: %v", rErr)
+		}
+	}()
+
+	var status string
+	err = tx.QueryRow(`SELECT status FROM expressions WHERE id = ? AND owner = ?`, id, owner).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to load expression %s: %w", id, err)
+	}
+	if status != TaskStatusPending {
+		return true, nil, ErrExpressionFinished
 	}
 
-	return rowsAffected > 0, status, nil
+	rows, err := tx.Query(
+		`SELECT id, agent_id FROM tasks WHERE id LIKE ? || '-%' AND status = ? AND agent_id IS NOT NULL`,
+		id, TaskStatusProcessing)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to load running tasks: %w", err)
+	}
+	running := make(map[string][]string)
+	for rows.Next() {
+		var taskID, agentID string
+		if err := rows.Scan(&taskID, &agentID); err != nil {
+			rows.Close()
+			return false, nil, fmt.Errorf("failed to scan running task: %w", err)
+		}
+		running[agentID] = append(running[agentID], taskID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, nil, fmt.Errorf("failed to load running tasks: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE task_attempts SET finished_at = ?, outcome = ? WHERE task_id LIKE ? || '-%' AND finished_at IS NULL`,
+		now.UnixMilli(), TaskStatusCancelled, id,
+	); err != nil {
+		return false, nil, fmt.Errorf("failed to record attempt outcome: %w", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE tasks
+		SET status = ?, lease_id = NULL, lease_expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id LIKE ? || '-%' AND status IN (?, ?)`,
+		TaskStatusCancelled, id, TaskStatusPending, TaskStatusProcessing,
+	); err != nil {
+		return false, nil, fmt.Errorf("failed to cancel tasks: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE expressions SET status = ? WHERE id = ?`, TaskStatusCancelled, id); err != nil {
+		return false, nil, fmt.Errorf("failed to cancel expression: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, nil, fmt.Errorf("commit error: %w", err)
+	}
+	return true, running, nil
+}
+
+// attemptColumns — колонки task_attempts в запросах с LEFT JOIN: у задачи,
+// которую ещё не выдавали, все они NULL.
+const attemptColumns = `a.attempt, COALESCE(a.agent_id, ''), COALESCE(a.agent_version, ''), a.worker,
+		       a.started_at, a.finished_at, COALESCE(a.outcome, '')`
+
+type attemptRow struct {
+	attempt, worker, startedAt, finishedAt sql.NullInt64
+	agentID, agentVersion, outcome         string
+}
+
+func (a *attemptRow) dest() []any {
+	return []any{&a.attempt, &a.agentID, &a.agentVersion, &a.worker, &a.startedAt, &a.finishedAt, &a.outcome}
+}
+
+// taskAttempt возвращает попытку; false — у задачи попыток нет.
+func (a *attemptRow) taskAttempt() (models.TaskAttempt, bool) {
+	if !a.attempt.Valid {
+		return models.TaskAttempt{}, false
+	}
+	attempt := models.TaskAttempt{
+		Attempt:      int(a.attempt.Int64),
+		AgentID:      a.agentID,
+		AgentVersion: a.agentVersion,
+		Worker:       int(a.worker.Int64),
+		StartedAt:    time.UnixMilli(a.startedAt.Int64),
+		Outcome:      a.outcome,
+	}
+	if a.finishedAt.Valid {
+		t := time.UnixMilli(a.finishedAt.Int64)
+		attempt.FinishedAt = &t
+	}
+	return attempt, true
+}
+
+// ExpressionTasks возвращает подзадачи выражения с результатами и историей выдач.
+func (r *Repository) ExpressionTasks(expressionID string) ([]*models.ExpressionTask, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.operation, t.mode, t.status,
+		       t.result, t.result_imag, t.result_upper, t.result_type, t.result_text,
+		       `+attemptColumns+`
+		FROM tasks AS t
+		LEFT JOIN task_attempts AS a ON a.task_id = t.id
+		WHERE t.id LIKE ? || '-%'
+		ORDER BY t.rowid, a.attempt`,
+		expressionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load expression tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := make([]*models.ExpressionTask, 0)
+	for rows.Next() {
+		var (
+			task                            models.ExpressionTask
+			result, resultImag, resultUpper sql.NullFloat64
+			resultType, resultText          sql.NullString
+			a                               attemptRow
+		)
+		dest := append([]any{&task.ID, &task.Operation, &task.Mode, &task.Status,
+			&result, &resultImag, &resultUpper, &resultType, &resultText}, a.dest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if len(tasks) == 0 || tasks[len(tasks)-1].ID != task.ID {
+			if result.Valid {
+				task.Result = &result.Float64
+			}
+			if resultUpper.Valid {
+				task.ResultUpper = &resultUpper.Float64
+			}
+			task.ResultImag = resultImag.Float64
+			task.ResultType = resultType.String
+			task.ResultText = resultText.String
+			task.Attempts = make([]models.TaskAttempt, 0)
+			tasks = append(tasks, &task)
+		}
+		if attempt, ok := a.taskAttempt(); ok {
+			last := tasks[len(tasks)-1]
+			last.Attempts = append(last.Attempts, attempt)
+		}
+	}
+	return tasks, rows.Err()
+}
+
+// DeadTasks возвращает задачи в статусе dead вместе с историей попыток.
+func (r *Repository) DeadTasks() ([]*models.DeadTask, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.operation, t.mode, t.user_login, `+attemptColumns+`
+		FROM tasks AS t
+		LEFT JOIN task_attempts AS a ON a.task_id = t.id
+		WHERE t.status = ?
+		ORDER BY t.updated_at, t.id, a.attempt`,
+		TaskStatusDead)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dead tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := make([]*models.DeadTask, 0)
+	for rows.Next() {
+		var (
+			task models.DeadTask
+			a    attemptRow
+		)
+		dest := append([]any{&task.ID, &task.Operation, &task.Mode, &task.UserLogin}, a.dest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if len(tasks) == 0 || tasks[len(tasks)-1].ID != task.ID {
+			task.Attempts = make([]models.TaskAttempt, 0)
+			tasks = append(tasks, &task)
+		}
+		if attempt, ok := a.taskAttempt(); ok {
+			last := tasks[len(tasks)-1]
+			last.Attempts = append(last.Attempts, attempt)
+		}
+	}
+	return tasks, rows.Err()
 }
 
 func (r *Repository) AreAllTasksCompleted(exprID string) (bool, error) {
@@ -288,39 +1208,63 @@ func (r *Repository) AreAllTasksCompleted(exprID string) (bool, error) {
 	return count == 0, err
 }
 
-func (r *Repository) CalculateFinalResult(exprID string) (float64, error) {
-	var result float64
+func (r *Repository) CalculateFinalResult(exprID string) (*models.TaskResult, error) {
+	var (
+		result      sql.NullFloat64
+		resultImag  sql.NullFloat64
+		resultUpper sql.NullFloat64
+		resultText  sql.NullString
+		resultType  sql.NullString
+	)
 	err := r.db.QueryRow(
 		`
-        SELECT t.result
+        SELECT t.result, t.result_text, t.result_imag, t.result_upper, t.result_type
         FROM tasks AS t
         WHERE t.id LIKE ? || '-%'
           AND t.status = ?
           AND NOT EXISTS (
               SELECT 1
-              FROM tasks AS t2
-              WHERE t2.id LIKE ? || '-%'
-                AND t2.depends_on LIKE '%' || t.id || '%'
+              FROM task_dependencies AS d
+              WHERE d.depends_on = t.id
           )
-        ORDER BY LENGTH(t.depends_on) DESC
         LIMIT 1;`,
-		exprID, TaskStatusCompleted, exprID,
-	).Scan(&result)
+		exprID, TaskStatusCompleted,
+	).Scan(&result, &resultText, &resultImag, &resultUpper, &resultType)
+	if err != nil {
+		return nil, err
+	}
 
-	return result, err
+	return scanTaskResult(result, resultImag, resultUpper, resultText, resultType), nil
 }
 
-func (r *Repository) UpdateExpression(exprID string, status string, result float64) (bool, error) {
+func (r *Repository) UpdateExpression(exprID string, status string, result *models.TaskResult) (bool, error) {
 
 	if status == TaskStatusCompleted {
 		status = ExprStatusDone
 	}
 
+	resultValue, resultText := resultColumns(result)
+	var resultImag, resultLower, resultUpper sql.NullFloat64
+	var resultType sql.NullString
+	if result == nil {
+		resultValue = sql.NullFloat64{Valid: true}
+	} else {
+		resultImag = sql.NullFloat64{Float64: result.Imag, Valid: true}
+		resultType = nullString(result.Type)
+		if result.Upper != nil {
+			// для интервала в result пишем середину, границы — отдельно
+			resultLower = sql.NullFloat64{Float64: result.Value, Valid: true}
+			resultUpper = sql.NullFloat64{Float64: *result.Upper, Valid: true}
+			resultValue = sql.NullFloat64{Float64: (result.Value + *result.Upper) / 2, Valid: true}
+		}
+	}
+
 	res, err := r.db.Exec(
 		`UPDATE expressions 
-			   SET status = ?, result = ? 
+			   SET status = ?, result = ?, result_text = ?, result_imag = ?, result_lower = ?, result_upper = ?, 
+			       result_type = ? 
 			   WHERE id = ?`,
-		status, result, exprID,
+		status, resultValue, resultText, resultImag, resultLower, resultUpper, resultType, exprID,
 	)
 
 	if err != nil {
@@ -334,3 +1278,336 @@ func (r *Repository) UpdateExpression(exprID string, status string, result float
 
 	return rowsAffected > 0, nil
 }
+
+// RegisterAgent добавляет агента или обновляет данные уже известного агента.
+func (r *Repository) RegisterAgent(agent *models.Agent) error {
+	labels, err := json.Marshal(agent.Labels)
+	if err != nil {
+		return fmt.Errorf("failed to encode agent labels: %w", err)
+	}
+	if agent.Labels == nil {
+		labels = []byte("{}")
+	}
+
+	_, err = r.db.Exec(`
+		INSERT INTO agents (id, hostname, version, computing_power, labels) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			hostname = excluded.hostname,
+			version = excluded.version,
+			computing_power = excluded.computing_power,
+			labels = excluded.labels,
+			last_seen = CURRENT_TIMESTAMP`,
+		agent.ID, agent.Hostname, agent.Version, agent.ComputingPower, string(labels),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register agent: %w", err)
+	}
+	return nil
+}
+
+// TouchAgent обновляет время последнего heartbeat и, если workers > 0, текущее
+// число воркеров агента; false — агент не зарегистрирован.
+func (r *Repository) TouchAgent(agentID string, workers int) (bool, error) {
+	res, err := r.db.Exec(`
+		UPDATE agents
+		SET last_seen = CURRENT_TIMESTAMP,
+		    computing_power = CASE WHEN ? > 0 THEN ? ELSE computing_power END
+		WHERE id = ?`,
+		workers, workers, agentID)
+	if err != nil {
+		return false, fmt.Errorf("failed to update agent: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+func (r *Repository) ListAgents() ([]*models.Agent, error) {
+	rows, err := r.db.Query(`
+		SELECT a.id, a.hostname, a.version, a.computing_power, a.labels, a.registered_at, a.last_seen,
+		       (SELECT COUNT(*) FROM tasks t WHERE t.agent_id = a.id AND t.status = ?),
+		       (SELECT COUNT(*) FROM tasks t WHERE t.agent_id = a.id AND t.status = ?)
+		FROM agents AS a
+		ORDER BY a.id`,
+		TaskStatusProcessing, TaskStatusCompleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := make([]*models.Agent, 0)
+	for rows.Next() {
+		var agent models.Agent
+		var labels string
+		if err := rows.Scan(&agent.ID, &agent.Hostname, &agent.Version, &agent.ComputingPower, &labels,
+			&agent.RegisteredAt, &agent.LastSeen, &agent.InFlight, &agent.Completed); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(labels), &agent.Labels); err != nil {
+			return nil, fmt.Errorf("failed to decode labels of agent %s: %w", agent.ID, err)
+		}
+		agents = append(agents, &agent)
+	}
+	return agents, rows.Err()
+}
+
+// AgentHealth считает попытки агентов, начатые не раньше since и после последнего
+// снятия карантина; попытки, возвращённые агентом при остановке или прерванные
+// отменой выражения, не учитываются.
+func (r *Repository) AgentHealth(since time.Time) ([]*models.AgentHealth, error) {
+	rows, err := r.db.Query(`
+		SELECT ag.id,
+		       COUNT(a.task_id),
+		       COALESCE(SUM(a.outcome = ?), 0),
+		       COALESCE(SUM(a.outcome = ?), 0),
+		       COALESCE(SUM(a.outcome = ?), 0),
+		       ag.quarantined_at,
+		       COALESCE(ag.quarantine_reason, '')
+		FROM agents AS ag
+		LEFT JOIN task_attempts AS a
+		       ON a.agent_id = ag.id
+		      AND a.finished_at IS NOT NULL
+		      AND a.outcome NOT IN (?, ?)
+		      AND a.started_at >= MAX(?, COALESCE(ag.health_reset_at, 0))
+		GROUP BY ag.id
+		ORDER BY ag.id`,
+		string(models.ErrInternalError), OutcomeLeaseExpired, OutcomeMismatch, OutcomeReleased, TaskStatusCancelled, since.UnixMilli(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load agent health: %w", err)
+	}
+	defer rows.Close()
+
+	health := make([]*models.AgentHealth, 0)
+	for rows.Next() {
+		var h models.AgentHealth
+		var quarantinedAt sql.NullInt64
+		if err := rows.Scan(&h.AgentID, &h.Attempts, &h.InternalErrors, &h.LeaseExpired, &h.Mismatches,
+			&quarantinedAt, &h.QuarantineReason); err != nil {
+			return nil, fmt.Errorf("failed to scan agent health: %w", err)
+		}
+		h.Score = 1
+		if h.Attempts > 0 {
+			h.Score = 1 - float64(h.InternalErrors+h.LeaseExpired+h.Mismatches)/float64(h.Attempts)
+		}
+		if quarantinedAt.Valid {
+			t := time.UnixMilli(quarantinedAt.Int64)
+			h.Quarantined, h.QuarantinedAt = true, &t
+		}
+		health = append(health, &h)
+	}
+	return health, rows.Err()
+}
+
+// QuarantineAgent отправляет агента в карантин; false — агент не найден или уже на карантине.
+func (r *Repository) QuarantineAgent(agentID, reason string, now time.Time) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE agents SET quarantined_at = ?, quarantine_reason = ? WHERE id = ? AND quarantined_at IS NULL`,
+		now.UnixMilli(), reason, agentID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to quarantine agent: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// UnquarantineAgent снимает карантин и обнуляет историю, по которой считается
+// оценка агента; false — агент не на карантине.
+func (r *Repository) UnquarantineAgent(agentID string, now time.Time) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE agents SET quarantined_at = NULL, quarantine_reason = NULL, health_reset_at = ?
+		 WHERE id = ? AND quarantined_at IS NOT NULL`,
+		now.UnixMilli(), agentID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to release agent from quarantine: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// CreateJoinToken сохраняет хэш нового токена подключения.
+func (r *Repository) CreateJoinToken(tokenHash, createdBy string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO join_tokens (token_hash, created_by, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		tokenHash, createdBy, time.Now().UnixMilli(), expiresAt.UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create join token: %w", err)
+	}
+	return nil
+}
+
+// EnrollAgent гасит токен подключения и сохраняет хэш ключа агента. Отозванный
+// агент может подключиться заново под тем же ID, действующий — нет (ErrAgentEnrolled);
+// в этом случае токен остаётся неиспользованным.
+func (r *Repository) EnrollAgent(tokenHash, agentID, credentialHash string, now time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("Warning: transaction rollback failed: %v", rErr)
+		}
+	}()
+
+	var createdBy string
+	err = tx.QueryRow(`
+		UPDATE join_tokens SET used_at = ?, agent_id = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING created_by`,
+		now.UnixMilli(), agentID, tokenHash, now.UnixMilli(),
+	).Scan(&createdBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidJoinToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to use join token: %w", err)
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO agent_credentials (agent_id, credential_hash, enrolled_by, enrolled_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(agent_id) DO UPDATE SET
+			credential_hash = excluded.credential_hash,
+			enrolled_by = excluded.enrolled_by,
+			enrolled_at = excluded.enrolled_at,
+			revoked_at = NULL
+		WHERE agent_credentials.revoked_at IS NOT NULL`,
+		agentID, credentialHash, createdBy, now.UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save agent credential: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrAgentEnrolled
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit error: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) ListEnrolledAgents() ([]*models.EnrolledAgent, error) {
+	rows, err := r.db.Query(
+		`SELECT agent_id, enrolled_by, enrolled_at, revoked_at FROM agent_credentials ORDER BY agent_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := make([]*models.EnrolledAgent, 0)
+	for rows.Next() {
+		var agent models.EnrolledAgent
+		var enrolledAt int64
+		var revokedAt sql.NullInt64
+		if err := rows.Scan(&agent.ID, &agent.EnrolledBy, &enrolledAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		agent.EnrolledAt = time.UnixMilli(enrolledAt)
+		if revokedAt.Valid {
+			t := time.UnixMilli(revokedAt.Int64)
+			agent.RevokedAt = &t
+		}
+		agents = append(agents, &agent)
+	}
+	return agents, rows.Err()
+}
+
+// RevokeAgent отзывает ключ агента; false — агент не подключён или уже отозван.
+func (r *Repository) RevokeAgent(agentID string, now time.Time) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE agent_credentials SET revoked_at = ? WHERE agent_id = ? AND revoked_at IS NULL`,
+		now.UnixMilli(), agentID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke agent: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// AgentCredentialValid проверяет, что у агента действующий ключ с таким хэшем.
+func (r *Repository) AgentCredentialValid(agentID, credentialHash string) (bool, error) {
+	var n int
+	err := r.db.QueryRow(
+		`SELECT COUNT(*) FROM agent_credentials WHERE agent_id = ? AND credential_hash = ? AND revoked_at IS NULL`,
+		agentID, credentialHash,
+	).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to check agent credential: %w", err)
+	}
+	return n > 0, nil
+}
+
+// resultColumns раскладывает результат по колонкам result/result_text:
+// большие целые хранятся только строкой, чтобы не терять точность в REAL.
+func resultColumns(result *models.TaskResult) (sql.NullFloat64, sql.NullString) {
+	if result == nil {
+		return sql.NullFloat64{}, sql.NullString{}
+	}
+	if result.Text != "" {
+		return sql.NullFloat64{}, sql.NullString{String: result.Text, Valid: true}
+	}
+	return sql.NullFloat64{Float64: result.Value, Valid: true}, sql.NullString{}
+}
+
+func scanTaskResult(result, resultImag, resultUpper sql.NullFloat64, resultText, resultType sql.NullString) *models.TaskResult {
+	taskResult := &models.TaskResult{
+		Value: result.Float64,
+		Imag:  resultImag.Float64,
+		Text:  resultText.String,
+		Type:  resultType.String,
+	}
+	if resultUpper.Valid {
+		taskResult.Upper = &resultUpper.Float64
+	}
+	return taskResult
+}
+
+// setExpressionExtras заполняет поля результата, которые есть не у всех выражений:
+// мнимая часть показывается только для комплексного режима, даты и длительности
+// дополнительно выводятся текстом.
+func setExpressionExtras(expr *models.Expression, resultText sql.NullString, resultImag sql.NullFloat64, resultType sql.NullString) {
+	expr.ResultText = resultText.String
+	if expr.Mode == models.ModeComplex && resultImag.Valid {
+		expr.ResultImag = &resultImag.Float64
+	}
+
+	expr.ResultType = resultType.String
+	if expr.Result != nil {
+		switch expr.ResultType {
+		case models.TypeDate:
+			expr.ResultText = models.FormatDate(*expr.Result)
+		case models.TypeDuration:
+			expr.ResultText = models.FormatDuration(*expr.Result)
+		}
+	}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
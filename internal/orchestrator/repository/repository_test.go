@@ -3,6 +3,7 @@ package repository_test
 import (
 	"database/sql"
 	"testing"
+	"time"
 
 	"calculator_app/internal/orchestrator/repository"
 	"calculator_app/internal/pkg/models"
@@ -26,15 +27,17 @@ func TestAddExpression(t *testing.T) {
 
 	repo := repository.NewRepository(db)
 	expr := &models.Expression{
-		ID:     "expr123",
-		Status: "pending",
-		Result: nil,
-		Owner:  "user1",
+		ID:       "expr123",
+		Status:   "pending",
+		Result:   nil,
+		Owner:    "user1",
+		Mode:     models.ModeReal,
+		Priority: 5,
 	}
 
 	// Регексп, матчущий начало INSERT
 	mock.ExpectExec(`^INSERT INTO expressions`).
-		WithArgs(expr.ID, expr.Status, nil, expr.Owner).
+		WithArgs(expr.ID, expr.Status, nil, expr.Owner, expr.Mode, expr.Priority, expr.Verify).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	err := repo.AddExpression(expr)
@@ -54,12 +57,17 @@ func TestAddTask(t *testing.T) {
 		Operation:     "+",
 		OperationTime: 5,
 		Result:        nil,
-		DependsOn:     []string{"task0", "taskX"},
-		UserLogin:     "user1",
-		Status:        "",
+		Dependencies: []models.Dependency{
+			{TaskID: "task0", Position: models.ArgLeft},
+			{TaskID: "taskX", Position: models.ArgRight},
+		},
+		UserLogin: "user1",
+		Mode:      models.ModeReal,
+		Status:    "",
 	}
 
 	// Простая регулярка по префиксу
+	mock.ExpectBegin()
 	mock.ExpectExec(`^INSERT INTO tasks`).
 		WithArgs(
 			task.ID,
@@ -68,10 +76,27 @@ func TestAddTask(t *testing.T) {
 			task.Operation,
 			task.OperationTime,
 			nil,
-			"task0,taskX",
 			task.UserLogin,
+			nil,
+			nil,
+			task.Mode,
+			task.Arg1Imag,
+			task.Arg2Imag,
+			task.Arg1Upper,
+			task.Arg2Upper,
+			task.Arg1Type,
+			task.Arg2Type,
+			task.Priority,
+			task.Verify,
 		).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`^INSERT INTO task_dependencies`).
+		WithArgs("task1", "task0", models.ArgLeft).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`^INSERT INTO task_dependencies`).
+		WithArgs("task1", "taskX", models.ArgRight).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	err := repo.AddTask(task)
 	assert.NoError(t, err)
@@ -129,17 +154,17 @@ func TestGetTaskResult_WithResult(t *testing.T) {
 	taskID := "task1"
 	expectedResult := 42.0
 
-	rows := sqlmock.NewRows([]string{"result"}).
-		AddRow(expectedResult)
+	rows := sqlmock.NewRows([]string{"result", "result_text", "result_imag", "result_upper", "result_type"}).
+		AddRow(expectedResult, nil, 0, nil, nil)
 
-	mock.ExpectQuery(`^SELECT result FROM tasks`).
+	mock.ExpectQuery(`^SELECT result, result_text, result_imag, result_upper, result_type FROM tasks`).
 		WithArgs(taskID).
 		WillReturnRows(rows)
 
 	result, ok, err := repo.GetTaskResult(taskID)
 	assert.NoError(t, err)
 	assert.True(t, ok)
-	assert.Equal(t, expectedResult, result)
+	assert.Equal(t, expectedResult, result.Value)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -151,10 +176,10 @@ func TestGetExpressionByIDAndOwner_Found(t *testing.T) {
 	id, owner := "expr123", "user1"
 	expectedVal := 3.14
 
-	rows := sqlmock.NewRows([]string{"id", "status", "result", "owner"}).
-		AddRow(id, "done", expectedVal, owner)
+	rows := sqlmock.NewRows([]string{"id", "status", "result", "owner", "result_text", "mode", "result_imag", "result_lower", "result_upper", "result_type", "priority", "verify"}).
+		AddRow(id, "done", expectedVal, owner, nil, models.ModeReal, 0, nil, nil, nil, 3, true)
 
-	mock.ExpectQuery(`^SELECT id, status, result, owner FROM expressions`).
+	mock.ExpectQuery(`^SELECT id, status, result, owner, result_text, mode, result_imag, result_lower, result_upper, result_type, priority, verify\s+FROM expressions`).
 		WithArgs(id, owner).
 		WillReturnRows(rows)
 
@@ -165,5 +190,675 @@ func TestGetExpressionByIDAndOwner_Found(t *testing.T) {
 	assert.Equal(t, "done", expr.Status)
 	assert.NotNil(t, expr.Result)
 	assert.Equal(t, expectedVal, *expr.Result)
+	assert.Nil(t, expr.ResultImag)
+	assert.Equal(t, 3, expr.Priority)
+	assert.True(t, expr.Verify)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTaskResult_LargeInteger(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE tasks SET`).
+		WithArgs(nil, "265252859812191058636308480000000", 0.0, nil, nil, repository.TaskStatusCompleted, "task1", repository.TaskStatusProcessing, "lease1", false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE task_attempts SET finished_at = \?, outcome = \?`).
+		WithArgs(sqlmock.AnyArg(), repository.TaskStatusCompleted, nil, "task1", "lease1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	statuses, err := repo.UpdateTaskResults([]repository.ResultUpdate{
+		{TaskID: "task1", LeaseID: "lease1", Result: &models.TaskResult{Text: "265252859812191058636308480000000"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []repository.ResultStatus{{Updated: true, Status: repository.TaskStatusCompleted}}, statuses)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTaskResult_StaleLease(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	// аренда истекла и задача выдана заново — результат отбрасывается
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE tasks SET .* WHERE id = \? AND status = \? AND lease_id = \? AND verify = \?`).
+		WithArgs(5.0, nil, 0.0, nil, nil, repository.TaskStatusCompleted, "task1", repository.TaskStatusProcessing, "old-lease", false).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT agent_id FROM tasks`).
+		WithArgs("task1", repository.TaskStatusProcessing, "old-lease").
+		WillReturnRows(sqlmock.NewRows([]string{"agent_id"}))
+	mock.ExpectQuery(`SELECT attempt, outcome FROM task_attempts`).
+		WithArgs("task1", "old-lease").
+		WillReturnRows(sqlmock.NewRows([]string{"attempt", "outcome"}).AddRow(1, repository.OutcomeLeaseExpired))
+	mock.ExpectExec(`UPDATE tasks\s+SET result = .* WHERE id = \? AND status = \? AND attempts = \? AND verify = 0`).
+		WithArgs(5.0, nil, 0.0, nil, nil, repository.TaskStatusCompleted, "task1", repository.TaskStatusPending, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	statuses, err := repo.UpdateTaskResults([]repository.ResultUpdate{
+		{TaskID: "task1", LeaseID: "old-lease", Result: &models.TaskResult{Value: 5}},
+	})
+	assert.NoError(t, err)
+	assert.False(t, statuses[0].Updated)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTaskResult_Replay(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	rejected := func(taskID, leaseID string) {
+		mock.ExpectExec(`^UPDATE tasks SET .* WHERE id = \? AND status = \? AND lease_id = \? AND verify = \?`).
+			WithArgs(5.0, nil, 0.0, nil, nil, repository.TaskStatusCompleted, taskID, repository.TaskStatusProcessing, leaseID, false).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT agent_id FROM tasks`).
+			WithArgs(taskID, repository.TaskStatusProcessing, leaseID).
+			WillReturnRows(sqlmock.NewRows([]string{"agent_id"}))
+	}
+
+	mock.ExpectBegin()
+	// повтор уже принятого результата
+	rejected("task1", "lease1")
+	mock.ExpectQuery(`SELECT attempt, outcome FROM task_attempts`).
+		WithArgs("task1", "lease1").
+		WillReturnRows(sqlmock.NewRows([]string{"attempt", "outcome"}).AddRow(1, repository.TaskStatusCompleted))
+	// результат опоздал к истечению аренды, но задачу ещё никто не взял
+	rejected("task2", "lease2")
+	mock.ExpectQuery(`SELECT attempt, outcome FROM task_attempts`).
+		WithArgs("task2", "lease2").
+		WillReturnRows(sqlmock.NewRows([]string{"attempt", "outcome"}).AddRow(2, repository.OutcomeLeaseExpired))
+	mock.ExpectExec(`UPDATE tasks\s+SET result = .* WHERE id = \? AND status = \? AND attempts = \? AND verify = 0`).
+		WithArgs(5.0, nil, 0.0, nil, nil, repository.TaskStatusCompleted, "task2", repository.TaskStatusPending, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE task_attempts SET finished_at = \?, outcome = \?`).
+		WithArgs(sqlmock.AnyArg(), repository.TaskStatusCompleted, nil, "task2", "lease2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// задачу агент вернул при остановке — результат отбрасывается
+	rejected("task3", "lease3")
+	mock.ExpectQuery(`SELECT attempt, outcome FROM task_attempts`).
+		WithArgs("task3", "lease3").
+		WillReturnRows(sqlmock.NewRows([]string{"attempt", "outcome"}).AddRow(1, repository.OutcomeReleased))
+	mock.ExpectCommit()
+
+	statuses, err := repo.UpdateTaskResults([]repository.ResultUpdate{
+		{TaskID: "task1", LeaseID: "lease1", Result: &models.TaskResult{Value: 5}},
+		{TaskID: "task2", LeaseID: "lease2", Result: &models.TaskResult{Value: 5}},
+		{TaskID: "task3", LeaseID: "lease3", Result: &models.TaskResult{Value: 5}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []repository.ResultStatus{
+		{Duplicate: true, Status: repository.TaskStatusCompleted},
+		{Updated: true, Status: repository.TaskStatusCompleted},
+		{Status: repository.TaskStatusCompleted},
+	}, statuses)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequeueExpiredTasks(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	// незавершённые попытки закрываются исходом lease_expired
+	mock.ExpectExec(`UPDATE task_attempts\s+SET finished_at = \?, outcome = \?\s+WHERE finished_at IS NULL`).
+		WithArgs(now.UnixMilli(), repository.OutcomeLeaseExpired, repository.TaskStatusProcessing, now.UnixMilli()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	// задача, выданная уже 3 раза, больше не повторяется
+	mock.ExpectQuery(`UPDATE tasks\s+SET status = \?.*WHERE status = \?.*attempts - .*>= \?\s+RETURNING id`).
+		WithArgs(repository.TaskStatusDead, repository.TaskStatusProcessing, now.UnixMilli(), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("task3"))
+	mock.ExpectQuery(`UPDATE tasks\s+SET status = \?.*lease_id = NULL.*WHERE status = \?\s+AND \(lease_expires_at IS NULL OR lease_expires_at < \?\)\s+RETURNING`).
+		WithArgs(repository.TaskStatusPending, repository.TaskStatusProcessing, now.UnixMilli()).
+		WillReturnRows(sqlmock.NewRows(queuedColumns).
+			AddRow("task1", "+", models.ModeReal, "user", 0, nil, "", false).
+			AddRow("task2", "*", models.ModeReal, "user", 0, nil, "", false))
+	mock.ExpectCommit()
+
+	tasks, dead, err := repo.RequeueExpiredTasks(now, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"task3"}, dead)
+	if assert.Len(t, tasks, 2) {
+		assert.Equal(t, "task2", tasks[1].ID)
+		assert.Equal(t, repository.TaskStatusPending, tasks[1].Status)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReleaseTasks(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE tasks\s+SET status = \?.*WHERE id = \? AND status = \? AND lease_id = \? AND agent_id = \?\s+RETURNING`).
+		WithArgs(repository.TaskStatusPending, "task1", repository.TaskStatusProcessing, "l1", "agent-1").
+		WillReturnRows(sqlmock.NewRows(queuedColumns).AddRow("task1", "+", models.ModeReal, "user", 2, nil, "", false))
+	mock.ExpectExec(`UPDATE task_attempts SET finished_at = \?, outcome = \? WHERE task_id = \? AND lease_id = \?`).
+		WithArgs(sqlmock.AnyArg(), repository.OutcomeReleased, "task1", "l1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// аренда task2 уже истекла: задача не возвращается
+	mock.ExpectQuery(`UPDATE tasks`).
+		WithArgs(repository.TaskStatusPending, "task2", repository.TaskStatusProcessing, "stale", "agent-1").
+		WillReturnRows(sqlmock.NewRows(queuedColumns))
+	mock.ExpectCommit()
+
+	released, err := repo.ReleaseTasks("agent-1", []repository.TaskLease{
+		{TaskID: "task1", LeaseID: "l1"},
+		{TaskID: "task2", LeaseID: "stale"},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, released, 2) {
+		assert.Equal(t, "task1", released[0].ID)
+		assert.Equal(t, 2, released[0].Priority)
+		assert.Nil(t, released[1])
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCancelExpression(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	now := time.UnixMilli(1_700_000_000_000)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM expressions WHERE id = \? AND owner = \?`).
+		WithArgs("expr1", "user").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(repository.TaskStatusPending))
+	mock.ExpectQuery(`SELECT id, agent_id FROM tasks WHERE id LIKE \? \|\| '-%' AND status = \?`).
+		WithArgs("expr1", repository.TaskStatusProcessing).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "agent_id"}).
+			AddRow("expr1-1", "agent-1").
+			AddRow("expr1-2", "agent-2").
+			AddRow("expr1-3", "agent-1"))
+	mock.ExpectExec(`UPDATE task_attempts SET finished_at = \?, outcome = \? WHERE task_id LIKE \? \|\| '-%' AND finished_at IS NULL`).
+		WithArgs(now.UnixMilli(), repository.TaskStatusCancelled, "expr1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE tasks\s+SET status = \?, lease_id = NULL, lease_expires_at = NULL.*status IN \(\?, \?\)`).
+		WithArgs(repository.TaskStatusCancelled, "expr1", repository.TaskStatusPending, repository.TaskStatusProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(`UPDATE expressions SET status = \? WHERE id = \?`).
+		WithArgs(repository.TaskStatusCancelled, "expr1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	found, running, err := repo.CancelExpression("expr1", "user", now)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, map[string][]string{
+		"agent-1": {"expr1-1", "expr1-3"},
+		"agent-2": {"expr1-2"},
+	}, running)
+
+	// уже вычисленное выражение не отменяется
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM expressions`).
+		WithArgs("expr2", "user").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(repository.ExprStatusDone))
+	mock.ExpectRollback()
+	found, _, err = repo.CancelExpression("expr2", "user", now)
+	assert.True(t, found)
+	assert.ErrorIs(t, err, repository.ErrExpressionFinished)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM expressions`).
+		WithArgs("expr3", "user").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}))
+	mock.ExpectRollback()
+	found, _, err = repo.CancelExpression("expr3", "user", now)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEnrollAgent(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	now := time.UnixMilli(1_700_000_000_000)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE join_tokens SET used_at = \?, agent_id = \?\s+WHERE token_hash = \? AND used_at IS NULL AND expires_at > \?\s+RETURNING created_by`).
+		WithArgs(now.UnixMilli(), "agent-1", "token-hash", now.UnixMilli()).
+		WillReturnRows(sqlmock.NewRows([]string{"created_by"}).AddRow("admin"))
+	mock.ExpectExec(`INSERT INTO agent_credentials .* ON CONFLICT\(agent_id\) DO UPDATE .* WHERE agent_credentials.revoked_at IS NOT NULL`).
+		WithArgs("agent-1", "cred-hash", "admin", now.UnixMilli()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, repo.EnrollAgent("token-hash", "agent-1", "cred-hash", now))
+
+	// токен уже использован или истёк
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE join_tokens`).WillReturnRows(sqlmock.NewRows([]string{"created_by"}))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, repo.EnrollAgent("token-hash", "agent-1", "cred-hash", now), repository.ErrInvalidJoinToken)
+
+	// у агента действующий ключ: токен не гасится
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE join_tokens`).WillReturnRows(sqlmock.NewRows([]string{"created_by"}).AddRow("admin"))
+	mock.ExpectExec(`INSERT INTO agent_credentials`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, repo.EnrollAgent("other-hash", "agent-1", "cred-hash", now), repository.ErrAgentEnrolled)
+
+	mock.ExpectExec(`UPDATE agent_credentials SET revoked_at = \? WHERE agent_id = \? AND revoked_at IS NULL`).
+		WithArgs(now.UnixMilli(), "agent-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	revoked, err := repo.RevokeAgent("agent-1", now)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM agent_credentials WHERE agent_id = \? AND credential_hash = \? AND revoked_at IS NULL`).
+		WithArgs("agent-1", "cred-hash").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	valid, err := repo.AgentCredentialValid("agent-1", "cred-hash")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	mock.ExpectQuery(`SELECT agent_id, enrolled_by, enrolled_at, revoked_at FROM agent_credentials`).
+		WillReturnRows(sqlmock.NewRows([]string{"agent_id", "enrolled_by", "enrolled_at", "revoked_at"}).
+			AddRow("agent-1", "admin", now.UnixMilli(), now.UnixMilli()).
+			AddRow("agent-2", "admin", now.UnixMilli(), nil))
+	agents, err := repo.ListEnrolledAgents()
+	assert.NoError(t, err)
+	if assert.Len(t, agents, 2) {
+		assert.Equal(t, now, *agents[0].RevokedAt)
+		assert.Nil(t, agents[1].RevokedAt)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAgentHealth(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	now := time.UnixMilli(1_700_000_000_000)
+
+	mock.ExpectQuery(`FROM agents AS ag\s+LEFT JOIN task_attempts AS a.*started_at >= MAX\(\?, COALESCE\(ag.health_reset_at, 0\)\)\s+GROUP BY ag.id`).
+		WithArgs(string(models.ErrInternalError), repository.OutcomeLeaseExpired, repository.OutcomeMismatch, repository.OutcomeReleased, repository.TaskStatusCancelled, now.UnixMilli()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "attempts", "internal_errors", "lease_expired", "mismatches", "quarantined_at", "reason"}).
+			AddRow("agent-1", 20, 6, 2, 2, now.UnixMilli(), "score 0.50").
+			AddRow("agent-2", 0, 0, 0, 0, nil, ""))
+	health, err := repo.AgentHealth(now)
+	assert.NoError(t, err)
+	if assert.Len(t, health, 2) {
+		assert.InDelta(t, 0.5, health[0].Score, 1e-9)
+		assert.True(t, health[0].Quarantined)
+		assert.Equal(t, now, *health[0].QuarantinedAt)
+		assert.Equal(t, 1.0, health[1].Score)
+		assert.False(t, health[1].Quarantined)
+	}
+
+	mock.ExpectExec(`UPDATE agents SET quarantined_at = \?, quarantine_reason = \? WHERE id = \? AND quarantined_at IS NULL`).
+		WithArgs(now.UnixMilli(), "bad", "agent-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := repo.QuarantineAgent("agent-2", "bad", now)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// снятие карантина сбрасывает окно оценки
+	mock.ExpectExec(`UPDATE agents SET quarantined_at = NULL, quarantine_reason = NULL, health_reset_at = \?\s+WHERE id = \? AND quarantined_at IS NOT NULL`).
+		WithArgs(now.UnixMilli(), "agent-3").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = repo.UnquarantineAgent("agent-3", now)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+var claimColumns = []string{"id", "arg1", "arg2", "operation", "operation_time", "user_login", "result",
+	"arg1_text", "arg2_text", "mode", "arg1_imag", "arg2_imag", "arg1_upper", "arg2_upper", "arg1_type", "arg2_type", "attempts"}
+
+var attemptColumns = []string{"attempt", "agent_id", "agent_version", "worker", "started_at", "finished_at", "outcome"}
+
+var queuedColumns = []string{"id", "operation", "mode", "user_login", "priority", "retry_at", "voters", "verify"}
+
+var depColumns = []string{"depends_on", "position", "result", "result_imag", "result_upper", "result_text", "result_type"}
+
+func TestReadyTasks(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectQuery(`FROM tasks\s+WHERE status = \? AND result IS NULL .* NOT EXISTS .* ORDER BY created_at ASC, rowid ASC`).
+		WithArgs(repository.TaskStatusPending, repository.TaskStatusCompleted).
+		WillReturnRows(sqlmock.NewRows(queuedColumns).
+			AddRow("task1", "fact", models.ModeInteger, "user", 0, nil, "", false))
+
+	tasks, err := repo.ReadyTasks()
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		assert.Equal(t, "fact", tasks[0].Operation)
+		assert.Equal(t, models.ModeInteger, tasks[0].Mode)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadyDependents(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectQuery(`WHERE id IN \(SELECT task_id FROM task_dependencies WHERE depends_on = \?\)\s+AND status = \?`).
+		WithArgs("expr-1", repository.TaskStatusPending, repository.TaskStatusCompleted).
+		WillReturnRows(sqlmock.NewRows(queuedColumns).
+			AddRow("expr-3", "*", models.ModeReal, "user", 2, nil, "", false))
+
+	tasks, err := repo.ReadyDependents("expr-1")
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		assert.Equal(t, "expr-3", tasks[0].ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimTasks_ResolvesDependencies(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE tasks .* lease_expires_at = \? \+ COALESCE\(operation_time, 0\),.* WHERE id = \? AND status = \? AND \(retry_at IS NULL OR retry_at <= \?\) AND \(verify = 0 OR .*\) RETURNING`).
+		WithArgs(repository.TaskStatusProcessing, "agent-1", sqlmock.AnyArg(), sqlmock.AnyArg(), "expr-3", repository.TaskStatusPending, sqlmock.AnyArg(), "agent-1", "agent-1").
+		WillReturnRows(sqlmock.NewRows(claimColumns).
+			AddRow("expr-3", 0, 0, "*", 500, "user", nil, "", "", models.ModeReal, 0, 0, 0, 0, "", "", 2))
+	mock.ExpectExec(`INSERT INTO task_attempts`).
+		WithArgs("expr-3", 2, "agent-1", "agent-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// обе стороны — результаты других задач, причём левая равна нулю
+	mock.ExpectQuery(`FROM task_dependencies`).
+		WithArgs("expr-3").
+		WillReturnRows(sqlmock.NewRows(depColumns).
+			AddRow("expr-1", models.ArgLeft, 0.0, 0.0, nil, nil, nil).
+			AddRow("expr-2", models.ArgRight, 7.0, 0.0, nil, nil, nil))
+	mock.ExpectCommit()
+
+	tasks, err := repo.ClaimTasks("agent-1", []string{"expr-3"}, time.Second)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		task := tasks[0]
+		assert.Equal(t, 0.0, task.Arg1)
+		assert.Equal(t, 7.0, task.Arg2)
+		assert.Len(t, task.Dependencies, 2)
+		assert.Equal(t, repository.TaskStatusProcessing, task.Status)
+		assert.Equal(t, 2, task.Attempts)
+		assert.NotEmpty(t, task.LeaseID)
+		assert.WithinDuration(t, time.Now().Add(1500*time.Millisecond), task.LeaseExpiresAt, 100*time.Millisecond)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimTasks_SkipsTakenTasks(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE tasks .* RETURNING`).
+		WithArgs(repository.TaskStatusProcessing, "agent-1", sqlmock.AnyArg(), sqlmock.AnyArg(), "task1", repository.TaskStatusPending, sqlmock.AnyArg(), "agent-1", "agent-1").
+		WillReturnRows(sqlmock.NewRows(claimColumns).
+			AddRow("task1", 1, 2, "+", 0, "user", nil, "", "", models.ModeReal, 0, 0, 0, 0, "", "", 1))
+	mock.ExpectExec(`INSERT INTO task_attempts`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM task_dependencies`).WithArgs("task1").WillReturnRows(sqlmock.NewRows(depColumns))
+	// task2 в БД уже не ждёт выдачи: очередь в памяти устарела
+	mock.ExpectQuery(`UPDATE tasks .* RETURNING`).
+		WithArgs(repository.TaskStatusProcessing, "agent-1", sqlmock.AnyArg(), sqlmock.AnyArg(), "task2", repository.TaskStatusPending, sqlmock.AnyArg(), "agent-1", "agent-1").
+		WillReturnRows(sqlmock.NewRows(claimColumns))
+	mock.ExpectCommit()
+
+	tasks, err := repo.ClaimTasks("agent-1", []string{"task1", "task2"}, time.Second)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		assert.Equal(t, "task1", tasks[0].ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTaskResults_Batch(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE tasks SET`).
+		WithArgs(5.0, nil, 0.0, nil, nil, repository.TaskStatusCompleted, "task1", repository.TaskStatusProcessing, "l1", false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE task_attempts`).
+		WithArgs(sqlmock.AnyArg(), repository.TaskStatusCompleted, 3, "task1", "l1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE tasks SET`).
+		WithArgs(nil, nil, nil, nil, nil, string(models.ErrDivisionByZero), "task2", repository.TaskStatusProcessing, "l2", false).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT agent_id FROM tasks WHERE id = \? AND status = \? AND lease_id = \? AND verify = 1`).
+		WithArgs("task2", repository.TaskStatusProcessing, "l2").
+		WillReturnRows(sqlmock.NewRows([]string{"agent_id"}))
+	mock.ExpectQuery(`SELECT attempt, outcome FROM task_attempts`).
+		WithArgs("task2", "l2").
+		WillReturnRows(sqlmock.NewRows([]string{"attempt", "outcome"}))
+	mock.ExpectCommit()
+
+	statuses, err := repo.UpdateTaskResults([]repository.ResultUpdate{
+		{TaskID: "task1", LeaseID: "l1", Result: &models.TaskResult{Value: 5}, Worker: 3},
+		{TaskID: "task2", LeaseID: "l2", Err: models.NewTaskError(models.ErrDivisionByZero, "division by zero")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []repository.ResultStatus{
+		{Updated: true, Status: repository.TaskStatusCompleted},
+		{Updated: false, Status: string(models.ErrDivisionByZero)},
+	}, statuses)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTaskResults_RetryTransientError(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	policy := &repository.RetryPolicy{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Minute}
+
+	mock.ExpectBegin()
+	// вторая попытка из трёх: задача возвращается в очередь с паузой 2 с
+	mock.ExpectQuery(`SELECT attempts - \(SELECT COUNT\(\*\) FROM task_attempts WHERE task_id = tasks.id AND vote = 1\)\s+FROM tasks WHERE id = \? AND status = \? AND lease_id = \?`).
+		WithArgs("task1", repository.TaskStatusProcessing, "l1").
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(2))
+	mock.ExpectQuery(`UPDATE tasks\s+SET status = \?.*retry_at = \?.*RETURNING`).
+		WithArgs(repository.TaskStatusPending, sqlmock.AnyArg(), "task1").
+		WillReturnRows(sqlmock.NewRows(queuedColumns).
+			AddRow("task1", "+", models.ModeReal, "user", 0, time.Now().Add(2*time.Second).UnixMilli(), "", false))
+	mock.ExpectExec(`^UPDATE task_attempts`).
+		WithArgs(sqlmock.AnyArg(), string(models.ErrInternalError), nil, "task1", "l1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// третья попытка — последняя: задача переходит в dead
+	mock.ExpectQuery(`SELECT attempts`).
+		WithArgs("task2", repository.TaskStatusProcessing, "l2").
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(3))
+	mock.ExpectExec(`UPDATE tasks\s+SET status = \?`).
+		WithArgs(repository.TaskStatusDead, "task2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE task_attempts`).
+		WithArgs(sqlmock.AnyArg(), string(models.ErrInternalError), nil, "task2", "l2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	internalErr := models.NewTaskError(models.ErrInternalError, "agent crashed")
+	statuses, err := repo.UpdateTaskResults([]repository.ResultUpdate{
+		{TaskID: "task1", LeaseID: "l1", Err: internalErr, Retry: policy},
+		{TaskID: "task2", LeaseID: "l2", Err: internalErr, Retry: policy},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 2) {
+		assert.Equal(t, repository.TaskStatusPending, statuses[0].Status)
+		if assert.NotNil(t, statuses[0].Requeued) {
+			assert.WithinDuration(t, time.Now().Add(2*time.Second), statuses[0].Requeued.RetryAt, 100*time.Millisecond)
+		}
+		assert.Equal(t, repository.ResultStatus{Updated: true, Status: repository.TaskStatusDead}, statuses[1])
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+var voteColumns = []string{"agent_id", "lease_id", "outcome", "result", "result_imag", "result_upper", "result_text", "result_type"}
+
+func TestUpdateTaskResults_Verification(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+
+	// второй голос расходится с первым: задача ждёт третьего агента
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE tasks SET`).
+		WithArgs(5.5, nil, 0.0, nil, nil, repository.TaskStatusCompleted, "task1", repository.TaskStatusProcessing, "l2", false).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT agent_id FROM tasks WHERE id = \? AND status = \? AND lease_id = \? AND verify = 1`).
+		WithArgs("task1", repository.TaskStatusProcessing, "l2").
+		WillReturnRows(sqlmock.NewRows([]string{"agent_id"}).AddRow("agent-2"))
+	mock.ExpectExec(`UPDATE task_attempts\s+SET finished_at = \?, outcome = \?, worker = \?, vote = 1`).
+		WithArgs(sqlmock.AnyArg(), repository.TaskStatusCompleted, nil, 5.5, nil, 0.0, nil, nil, "task1", "l2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM task_attempts\s+WHERE task_id = \? AND vote = 1`).
+		WithArgs("task1").
+		WillReturnRows(sqlmock.NewRows(voteColumns).
+			AddRow("agent-1", "l1", repository.TaskStatusCompleted, 5.0, 0.0, nil, nil, nil).
+			AddRow("agent-2", "l2", repository.TaskStatusCompleted, 5.5, 0.0, nil, nil, nil))
+	mock.ExpectQuery(`UPDATE tasks\s+SET status = \?.*lease_id = NULL.*WHERE id = \?\s+RETURNING`).
+		WithArgs(repository.TaskStatusPending, "task1").
+		WillReturnRows(sqlmock.NewRows(queuedColumns).AddRow("task1", "+", models.ModeReal, "user", 0, nil, "agent-1,agent-2", true))
+	mock.ExpectCommit()
+
+	statuses, err := repo.UpdateTaskResults([]repository.ResultUpdate{
+		{TaskID: "task1", LeaseID: "l2", Result: &models.TaskResult{Value: 5.5}, Tolerance: 1e-9},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 1) {
+		assert.True(t, statuses[0].Updated)
+		assert.Equal(t, repository.TaskStatusPending, statuses[0].Status)
+		if assert.NotNil(t, statuses[0].Requeued) {
+			assert.Equal(t, []string{"agent-1", "agent-2"}, statuses[0].Requeued.Voters)
+		}
+	}
+
+	// третий голос совпадает с первым в пределах допуска: результат принят,
+	// попытка agent-2 помечается mismatch
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE tasks SET`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT agent_id FROM tasks`).
+		WillReturnRows(sqlmock.NewRows([]string{"agent_id"}).AddRow("agent-3"))
+	mock.ExpectExec(`UPDATE task_attempts\s+SET finished_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM task_attempts\s+WHERE task_id = \? AND vote = 1`).
+		WillReturnRows(sqlmock.NewRows(voteColumns).
+			AddRow("agent-1", "l1", repository.TaskStatusCompleted, 5.0, 0.0, nil, nil, nil).
+			AddRow("agent-2", "l2", repository.TaskStatusCompleted, 5.5, 0.0, nil, nil, nil).
+			AddRow("agent-3", "l3", repository.TaskStatusCompleted, 5.000000000001, 0.0, nil, nil, nil))
+	mock.ExpectExec(`UPDATE task_attempts SET outcome = \? WHERE task_id = \? AND lease_id = \?`).
+		WithArgs(repository.OutcomeMismatch, "task1", "l2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE tasks SET`).
+		WithArgs(5.000000000001, nil, 0.0, nil, nil, repository.TaskStatusCompleted, "task1", repository.TaskStatusProcessing, "l3", true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	statuses, err = repo.UpdateTaskResults([]repository.ResultUpdate{
+		{TaskID: "task1", LeaseID: "l3", Result: &models.TaskResult{Value: 5.000000000001}, Tolerance: 1e-9},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 1) {
+		assert.True(t, statuses[0].Updated)
+		assert.Equal(t, repository.TaskStatusCompleted, statuses[0].Status)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := repository.RetryPolicy{MaxAttempts: 10, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 4*time.Second, policy.Delay(3))
+	assert.Equal(t, 5*time.Second, policy.Delay(4))
+	assert.Equal(t, 5*time.Second, policy.Delay(9))
+}
+
+func TestDeadTasks(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	started := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+
+	mock.ExpectQuery(`FROM tasks AS t\s+LEFT JOIN task_attempts AS a ON a.task_id = t.id\s+WHERE t.status = \?`).
+		WithArgs(repository.TaskStatusDead).
+		WillReturnRows(sqlmock.NewRows(append([]string{"id", "operation", "mode", "user_login"}, attemptColumns...)).
+			AddRow("task1", "+", models.ModeReal, "user", 1, "agent-1", "1.0", 2, started.UnixMilli(), started.Add(time.Second).UnixMilli(), "internal_error").
+			AddRow("task1", "+", models.ModeReal, "user", 2, "agent-2", "", nil, started.Add(2*time.Second).UnixMilli(), nil, "").
+			AddRow("task2", "*", models.ModeReal, "user", nil, "", "", nil, nil, nil, ""))
+
+	tasks, err := repo.DeadTasks()
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 2) {
+		if assert.Len(t, tasks[0].Attempts, 2) {
+			assert.Equal(t, "agent-1", tasks[0].Attempts[0].AgentID)
+			assert.True(t, started.Equal(tasks[0].Attempts[0].StartedAt))
+			assert.Equal(t, "internal_error", tasks[0].Attempts[0].Outcome)
+			assert.Equal(t, "1.0", tasks[0].Attempts[0].AgentVersion)
+			assert.Equal(t, 2, tasks[0].Attempts[0].Worker)
+			assert.Nil(t, tasks[0].Attempts[1].FinishedAt)
+		}
+		assert.Empty(t, tasks[1].Attempts)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExpressionTasks(t *testing.T) {
+	db, mock := setupMock(t)
+	defer db.Close()
+
+	repo := repository.NewRepository(db)
+	claimed := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	completed := claimed.Add(time.Second)
+
+	mock.ExpectQuery(`FROM tasks AS t\s+LEFT JOIN task_attempts AS a ON a.task_id = t.id\s+WHERE t.id LIKE \? \|\| '-%'`).
+		WithArgs("expr").
+		WillReturnRows(sqlmock.NewRows(append([]string{"id", "operation", "mode", "status",
+			"result", "result_imag", "result_upper", "result_type", "result_text"}, attemptColumns...)).
+			AddRow("expr-1", "+", models.ModeReal, repository.TaskStatusCompleted, 5.0, 0.0, nil, nil, nil,
+				1, "agent-1", "1.0", nil, claimed.UnixMilli(), completed.UnixMilli(), repository.OutcomeLeaseExpired).
+			AddRow("expr-1", "+", models.ModeReal, repository.TaskStatusCompleted, 5.0, 0.0, nil, nil, nil,
+				2, "agent-2", "1.1", 3, claimed.Add(2*time.Second).UnixMilli(), completed.Add(2*time.Second).UnixMilli(), repository.TaskStatusCompleted).
+			AddRow("expr-2", "*", models.ModeReal, repository.TaskStatusPending, nil, nil, nil, nil, nil,
+				nil, "", "", nil, nil, nil, ""))
+
+	tasks, err := repo.ExpressionTasks("expr")
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 2) {
+		assert.Equal(t, 5.0, *tasks[0].Result)
+		if assert.Len(t, tasks[0].Attempts, 2) {
+			assert.Equal(t, models.TaskAttempt{
+				Attempt: 2, AgentID: "agent-2", AgentVersion: "1.1", Worker: 3,
+				StartedAt: claimed.Add(2 * time.Second), FinishedAt: tasks[0].Attempts[1].FinishedAt,
+				Outcome: repository.TaskStatusCompleted,
+			}, tasks[0].Attempts[1])
+			assert.True(t, completed.Add(2*time.Second).Equal(*tasks[0].Attempts[1].FinishedAt))
+		}
+		assert.Nil(t, tasks[1].Result)
+		assert.Empty(t, tasks[1].Attempts)
+	}
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
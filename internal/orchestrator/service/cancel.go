@@ -0,0 +1,60 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// cancellations — задачи отменённых выражений, которые ещё выполняются на
+// агентах. Поток StreamTasks агента забирает их через TakeCancellations и
+// передаёт агенту, чтобы тот прервал выполнение.
+type cancellations struct {
+	mu      sync.Mutex
+	byAgent map[string][]string
+	signal  *taskSignal
+}
+
+func newCancellations() *cancellations {
+	return &cancellations{byAgent: make(map[string][]string), signal: newTaskSignal()}
+}
+
+// CancelExpression отменяет выражение id пользователя owner: невыполненные задачи
+// больше не выдаются, а агентам, которые их выполняют, отправляется отмена.
+// false — у owner нет такого выражения; repository.ErrExpressionFinished —
+// выражение уже вычислено или отменено.
+func (o *Orchestrator) CancelExpression(id, owner string) (bool, error) {
+	found, running, err := o.repo.CancelExpression(id, owner, time.Now())
+	if err != nil || !found {
+		return found, err
+	}
+	o.queue.RemoveExpression(id)
+
+	log.Printf("Expression %s cancelled by %s", id, owner)
+	if len(running) == 0 {
+		return true, nil
+	}
+	o.cancels.mu.Lock()
+	for agentID, taskIDs := range running {
+		log.Printf("Cancelling tasks %v on agent %s", taskIDs, agentID)
+		o.cancels.byAgent[agentID] = append(o.cancels.byAgent[agentID], taskIDs...)
+	}
+	o.cancels.mu.Unlock()
+	o.cancels.signal.Broadcast()
+	return true, nil
+}
+
+// TakeCancellations возвращает и забывает задачи, которые агент agentID должен прервать.
+func (o *Orchestrator) TakeCancellations(agentID string) []string {
+	o.cancels.mu.Lock()
+	defer o.cancels.mu.Unlock()
+	taskIDs := o.cancels.byAgent[agentID]
+	delete(o.cancels.byAgent, agentID)
+	return taskIDs
+}
+
+// Cancellations возвращает канал, который закроется при следующей отмене;
+// после этого стоит проверить TakeCancellations.
+func (o *Orchestrator) Cancellations() <-chan struct{} {
+	return o.cancels.signal.Wait()
+}
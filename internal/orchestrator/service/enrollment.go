@@ -0,0 +1,88 @@
+package service
+
+import (
+	"calculator_app/internal/pkg/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Время жизни токена подключения агента: по умолчанию и максимальное.
+const (
+	DefaultJoinTokenTTL = 15 * time.Minute
+	MaxJoinTokenTTL     = 24 * time.Hour
+)
+
+// CreateJoinToken выпускает одноразовый токен, по которому новый агент
+// один раз получает постоянный ключ (EnrollAgent).
+func (o *Orchestrator) CreateJoinToken(createdBy string, ttl time.Duration) (*models.JoinToken, error) {
+	if ttl <= 0 || ttl > MaxJoinTokenTTL {
+		return nil, fmt.Errorf("join token ttl must be between 1s and %s", MaxJoinTokenTTL)
+	}
+	token, err := randomSecret()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	if err := o.repo.CreateJoinToken(hashSecret(token), createdBy, expiresAt); err != nil {
+		return nil, err
+	}
+	log.Printf("Join token issued by %s, expires at %s", createdBy, expiresAt.Format(time.RFC3339))
+	return &models.JoinToken{Token: token, CreatedBy: createdBy, ExpiresAt: expiresAt}, nil
+}
+
+// EnrollAgent обменивает токен подключения на постоянный ключ агента. Пустой
+// agentID — агенту назначается новый UUID. Ошибки — repository.ErrInvalidJoinToken
+// и repository.ErrAgentEnrolled.
+func (o *Orchestrator) EnrollAgent(joinToken, agentID string) (string, string, error) {
+	if agentID == "" {
+		agentID = generateUUID()
+	}
+	credential, err := randomSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := o.repo.EnrollAgent(hashSecret(joinToken), agentID, hashSecret(credential), time.Now()); err != nil {
+		return "", "", err
+	}
+	log.Printf("Agent enrolled: %s", agentID)
+	return agentID, credential, nil
+}
+
+func (o *Orchestrator) EnrolledAgents() ([]*models.EnrolledAgent, error) {
+	return o.repo.ListEnrolledAgents()
+}
+
+// RevokeAgent отзывает ключ агента: дальнейшие вызовы с ним отклоняются.
+func (o *Orchestrator) RevokeAgent(agentID string) (bool, error) {
+	revoked, err := o.repo.RevokeAgent(agentID, time.Now())
+	if err == nil && revoked {
+		log.Printf("Agent revoked: %s", agentID)
+	}
+	return revoked, err
+}
+
+// VerifyAgentCredential проверяет ключ, выданный агенту в EnrollAgent.
+func (o *Orchestrator) VerifyAgentCredential(agentID, credential string) (bool, error) {
+	if agentID == "" || credential == "" {
+		return false, nil
+	}
+	return o.repo.AgentCredentialValid(agentID, hashSecret(credential))
+}
+
+// randomSecret — 256 случайных бит в hex; в БД хранится только hashSecret от него.
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
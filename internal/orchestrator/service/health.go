@@ -0,0 +1,116 @@
+package service
+
+import (
+	"calculator_app/internal/pkg/models"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// HealthPolicy — автоматический карантин агентов: агент, у которого за Window
+// набралось не меньше MinAttempts попыток, а оценка (models.AgentHealth.Score)
+// опустилась ниже MinScore, перестаёт получать задачи, пока администратор не
+// снимет карантин. MinScore = 0 отключает карантин.
+type HealthPolicy struct {
+	MinScore    float64
+	MinAttempts int
+	Window      time.Duration
+}
+
+// DefaultHealthPolicy — карантин, если за 10 минут сбоем закончилось больше
+// половины из как минимум 10 попыток.
+var DefaultHealthPolicy = HealthPolicy{
+	MinScore:    0.5,
+	MinAttempts: 10,
+	Window:      10 * time.Minute,
+}
+
+// HealthCheckInterval — как часто RunHealthMonitor пересчитывает оценки агентов.
+const HealthCheckInterval = 5 * time.Second
+
+// WithHealthPolicy задаёт условия автоматического карантина агентов.
+func (o *Orchestrator) WithHealthPolicy(policy HealthPolicy) *Orchestrator {
+	o.health = policy
+	return o
+}
+
+// AgentHealth возвращает оценки агентов за окно политики карантина.
+func (o *Orchestrator) AgentHealth() ([]*models.AgentHealth, error) {
+	return o.repo.AgentHealth(time.Now().Add(-o.health.Window))
+}
+
+// CheckAgentHealth отправляет в карантин агентов с низкой оценкой и обновляет
+// список агентов на карантине, которым GetTasks не выдаёт задачи.
+func (o *Orchestrator) CheckAgentHealth() error {
+	now := time.Now()
+	health, err := o.repo.AgentHealth(now.Add(-o.health.Window))
+	if err != nil {
+		return fmt.Errorf("failed to check agent health: %w", err)
+	}
+
+	quarantined := make(map[string]bool)
+	for _, h := range health {
+		if !h.Quarantined && o.health.MinScore > 0 && h.Attempts >= o.health.MinAttempts && h.Score < o.health.MinScore {
+			reason := fmt.Sprintf("score %.2f below %.2f: %d internal errors, %d expired leases, %d mismatches in %d attempts",
+				h.Score, o.health.MinScore, h.InternalErrors, h.LeaseExpired, h.Mismatches, h.Attempts)
+			ok, err := o.repo.QuarantineAgent(h.AgentID, reason, now)
+			if err != nil {
+				return err
+			}
+			if ok {
+				log.Printf("Agent %s quarantined: %s", h.AgentID, reason)
+				h.Quarantined = true
+			}
+		}
+		if h.Quarantined {
+			quarantined[h.AgentID] = true
+		}
+	}
+
+	o.healthMu.Lock()
+	o.quarantined = quarantined
+	o.healthMu.Unlock()
+	return nil
+}
+
+// RunHealthMonitor проверяет агентов сразу и затем раз в interval, пока не отменён ctx.
+func (o *Orchestrator) RunHealthMonitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := o.CheckAgentHealth(); err != nil {
+			log.Printf("Health monitor: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseQuarantine снимает карантин с агента; оценка агента считается заново
+// с этого момента. false — агент не на карантине.
+func (o *Orchestrator) ReleaseQuarantine(agentID string) (bool, error) {
+	released, err := o.repo.UnquarantineAgent(agentID, time.Now())
+	if err != nil || !released {
+		return released, err
+	}
+
+	o.healthMu.Lock()
+	delete(o.quarantined, agentID)
+	o.healthMu.Unlock()
+
+	log.Printf("Agent %s released from quarantine", agentID)
+	o.tasksReady.Broadcast()
+	return true, nil
+}
+
+// isQuarantined сообщает, на карантине ли агент по последней проверке.
+func (o *Orchestrator) isQuarantined(agentID string) bool {
+	o.healthMu.Lock()
+	defer o.healthMu.Unlock()
+	return o.quarantined[agentID]
+}
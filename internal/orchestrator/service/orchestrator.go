@@ -4,6 +4,8 @@ import (
 	"calculator_app/internal/config"
 	"calculator_app/internal/orchestrator/repository"
 	"calculator_app/internal/pkg/models"
+	"calculator_app/internal/pkg/operations"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -11,122 +13,323 @@ import (
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"log"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Orchestrator struct {
 	//repo                 *repository.Repository
-	repo                 repository.RepositoryInterface
-	timeAdditionMS       int
-	timeSubtractionMS    int
-	timeMultiplicationMS int
-	timeDivisionMS       int
+	repo repository.RepositoryInterface
+	// время операций из конфигурации; для остальных берётся время из реестра операций
+	operationTimesMS map[string]int
+	// tasksReady сигналит потокам StreamTasks, что могли появиться задачи
+	tasksReady *taskSignal
+	// queue — готовые к выдаче задачи; строится из БД в LoadReadyQueue
+	queue *readyQueue
+	// retry — повтор задач после временных ошибок
+	retry repository.RetryPolicy
+	// verifiedUsers — пользователи, все выражения которых выполняются с верификацией;
+	// verifyTolerance — допустимое относительное расхождение результатов агентов
+	verifiedUsers   map[string]bool
+	verifyTolerance float64
+	// health — условия карантина; quarantined — агенты на карантине по
+	// последней проверке CheckAgentHealth
+	health      HealthPolicy
+	healthMu    sync.Mutex
+	quarantined map[string]bool
+	// cancels — отменённые задачи, о которых ещё не узнали выполняющие их агенты
+	cancels *cancellations
 }
 
 type OrchestratorInterface interface {
 	RegisterUser(user models.User) error
 	Authenticate(login, password string) (string, time.Time, error)
 	UserExists(login string) (bool, error)
-	AddExpression(expr string, login string) (string, error)
+	AddExpression(expr string, login string, mode string, priority int, verify bool) (string, error)
 	GetExpressions(owner string) (map[string]*models.Expression, error)
 	GetExpressionByID(id, owner string) (*models.Expression, bool, error)
+	ExpressionTasks(id, owner string) ([]*models.ExpressionTask, bool, error)
+	CancelExpression(id, owner string) (bool, error)
+	ListAgents() ([]*models.Agent, error)
+	DeadTasks() ([]*models.DeadTask, error)
+	QueueStats(limit int) *models.QueueStats
+	CreateJoinToken(createdBy string, ttl time.Duration) (*models.JoinToken, error)
+	EnrolledAgents() ([]*models.EnrolledAgent, error)
+	RevokeAgent(agentID string) (bool, error)
+	AgentHealth() ([]*models.AgentHealth, error)
+	ReleaseQuarantine(agentID string) (bool, error)
 }
 
+// HeartbeatInterval — как часто агент присылает heartbeat. Агент без heartbeat
+// дольше трёх интервалов считается недоступным.
+const HeartbeatInterval = 5 * time.Second
+
+// LeaseSlack добавляется к operation_time задачи при выдаче аренды: запас на
+// ожидание зависимостей и сеть. Задачи с истёкшей арендой reaper возвращает в очередь
+// раз в LeaseReapInterval.
+const (
+	LeaseSlack        = 10 * time.Second
+	LeaseReapInterval = time.Second
+)
+
 func NewOrchestrator(timeAdditionMS, timeSubtractionMS, timeMultiplicationMS, timeDivisionMS int, repo repository.RepositoryInterface) *Orchestrator {
 	return &Orchestrator{
-		repo:                 repo,
-		timeAdditionMS:       timeAdditionMS,
-		timeSubtractionMS:    timeSubtractionMS,
-		timeMultiplicationMS: timeMultiplicationMS,
-		timeDivisionMS:       timeDivisionMS,
+		repo:            repo,
+		tasksReady:      newTaskSignal(),
+		queue:           newReadyQueue(),
+		retry:           DefaultRetryPolicy,
+		verifyTolerance: DefaultVerifyTolerance,
+		health:          DefaultHealthPolicy,
+		cancels:         newCancellations(),
+		operationTimesMS: map[string]int{
+			"+": timeAdditionMS,
+			"-": timeSubtractionMS,
+			"*": timeMultiplicationMS,
+			"/": timeDivisionMS,
+		},
 	}
 }
 
-func (o *Orchestrator) AddExpression(expression string, owner string) (string, error) {
+// DefaultVerifyTolerance — допуск сверки результатов задач с верификацией: числа
+// у разных агентов могут расходиться в последних знаках.
+const DefaultVerifyTolerance = 1e-9
+
+// DefaultRetryPolicy — три попытки с паузой 1 с, 2 с, но не больше 30 с.
+var DefaultRetryPolicy = repository.RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     time.Second,
+	MaxBackoff:  30 * time.Second,
+}
+
+// WithRetryPolicy задаёт повтор задач после временных ошибок.
+func (o *Orchestrator) WithRetryPolicy(policy repository.RetryPolicy) *Orchestrator {
+	o.retry = policy
+	return o
+}
+
+// WithUserWeights задаёт веса пользователей при справедливой выдаче задач.
+func (o *Orchestrator) WithUserWeights(weights map[string]int) *Orchestrator {
+	o.queue.SetWeights(weights)
+	return o
+}
+
+// WithVerification включает верификацию для всех выражений пользователей users
+// и задаёт допуск сверки результатов (0 — DefaultVerifyTolerance).
+func (o *Orchestrator) WithVerification(users []string, tolerance float64) *Orchestrator {
+	o.verifiedUsers = make(map[string]bool, len(users))
+	for _, login := range users {
+		o.verifiedUsers[login] = true
+	}
+	if tolerance > 0 {
+		o.verifyTolerance = tolerance
+	}
+	return o
+}
+
+// AddExpression разбирает выражение на задачи. priority упорядочивает выражения
+// владельца между собой и не влияет на долю других пользователей. С verify каждая
+// задача выполняется двумя разными агентами, и результат принимается, только
+// если они совпали (см. repository.VerifyVotes).
+func (o *Orchestrator) AddExpression(expression string, owner string, mode string, priority int, verify bool) (string, error) {
+	if mode == "" {
+		mode = models.ModeReal
+	}
+	switch mode {
+	case models.ModeReal, models.ModeInteger, models.ModeComplex, models.ModeInterval:
+	default:
+		return "", fmt.Errorf("unsupported mode: %s", mode)
+	}
+
+	// интервальные литералы включают интервальный режим автоматически
+	if containsInterval(tokenize(expression)) {
+		if mode == models.ModeReal {
+			mode = models.ModeInterval
+		} else if mode != models.ModeInterval {
+			return "", fmt.Errorf("interval literals are not allowed in %s mode", mode)
+		}
+	}
+
+	verify = verify || o.verifiedUsers[owner]
+
 	id := generateUUID()
-	err := o.repo.AddExpression(&models.Expression{
-		ID:     id,
-		Status: repository.TaskStatusPending,
-		Result: nil,
-		Owner:  owner,
+	tasks, literal, err := o.parseExpressionToTasks(expression, id, owner, mode)
+	if err != nil {
+		return "", err
+	}
+	if literal != nil {
+		if mode != models.ModeComplex && literal.Imag != 0 {
+			return "", fmt.Errorf("imaginary literals are only allowed in complex mode")
+		}
+		if mode != models.ModeReal && literal.Type != models.TypeNumber {
+			return "", fmt.Errorf("dates and durations are only allowed in real mode")
+		}
+		if mode == models.ModeInteger && literal.Text == "" && literal.Value != math.Trunc(literal.Value) {
+			return "", fmt.Errorf("fractional literals are not allowed in integer mode")
+		}
+	}
+	for _, task := range tasks {
+		if mode != models.ModeComplex && (task.Arg1Imag != 0 || task.Arg2Imag != 0) {
+			return "", fmt.Errorf("imaginary literals are only allowed in complex mode")
+		}
+		if mode != models.ModeReal && (task.Arg1Type != models.TypeNumber || task.Arg2Type != models.TypeNumber) {
+			return "", fmt.Errorf("dates and durations are only allowed in real mode")
+		}
+	}
+
+	err = o.repo.AddExpression(&models.Expression{
+		ID:       id,
+		Status:   repository.TaskStatusPending,
+		Result:   nil,
+		Owner:    owner,
+		Mode:     mode,
+		Priority: priority,
+		Verify:   verify,
 	})
 
 	if err != nil {
 		return "", fmt.Errorf("failed to save expression: %w", err)
 	}
 
-	tasks, err := o.parseExpressionToTasks(expression, id, owner)
-	if err != nil {
-		return "", err
+	// выражение из одного литерала вычислять нечего: оно сразу выполнено
+	if literal != nil {
+		if _, err := o.repo.UpdateExpression(id, repository.TaskStatusCompleted, literal); err != nil {
+			return "", fmt.Errorf("failed to update expression: %w", err)
+		}
+		return id, nil
 	}
 
 	for _, task := range tasks {
 		task.UserLogin = owner
+		task.Priority = priority
+		task.Verify = verify
 		if err := o.repo.AddTask(task); err != nil {
 			return "", fmt.Errorf("failed to add task: %w", err)
 		}
 	}
+	for _, task := range tasks {
+		if len(task.Dependencies) == 0 {
+			o.queue.Push(task)
+		}
+	}
+	o.tasksReady.Broadcast()
 
 	return id, nil
 }
 
+// parseExpressionToTasks разбирает выражение на задачи. Выражение без операций
+// (один литерал) задач не даёт: вместо них возвращается значение литерала.
 func (o *Orchestrator) parseExpressionToTasks(
 	expression string,
 	expressionID string,
 	owner string,
+	mode string,
 
-) ([]*models.Task, error) {
+) ([]*models.Task, *models.TaskResult, error) {
 
-	postfix, err := shuntingYard(tokenize(expression))
+	tokens, err := foldDateCalls(tokenize(expression))
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, err = foldUnaryMinus(tokens)
 	if err != nil {
-		return nil, fmt.Errorf("shunting yard error: %v", err)
+		return nil, nil, err
+	}
+	postfix, err := shuntingYard(tokens)
+	if err != nil {
+		return nil, nil, fmt.Errorf("shunting yard error: %v", err)
 	}
 
 	var tasks []*models.Task
 	taskMap := make(map[string]*models.Task)
+	resultTypes := make(map[string]string)
 	var stack []string
 
 	log.Printf("Parsing expression: %s", expression)
 	log.Printf("Postfix notation: %v", postfix)
 
 	for _, token := range postfix {
-		if isNumber(token) {
+		if isLiteral(token) {
 			stack = append(stack, token)
 			continue
 		}
 
-		if isOperator(token) {
-			if len(stack) < 2 {
-				return nil, fmt.Errorf("not enough operands for operator %s", token)
+		if op, ok := operations.Lookup(token); ok {
+			arity := op.Arity()
+			if len(stack) < arity {
+				return nil, nil, fmt.Errorf("not enough operands for operator %s", token)
 			}
 
-			right := stack[len(stack)-1]
-			left := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
+			// у унарных функций второй аргумент не используется
+			right := "0"
+			if arity == 2 {
+				right = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			left := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
 
 			taskID := fmt.Sprintf("%s-%d", expressionID, len(tasks)+1)
 			task := &models.Task{
 				ID:        taskID,
 				Operation: token,
-				DependsOn: []string{},
 				UserLogin: owner,
+				Mode:      mode,
 			}
 
+			var leftType, rightType string
 			if strings.HasPrefix(left, "task:") {
 				depID := strings.TrimPrefix(left, "task:")
-				task.DependsOn = append(task.DependsOn, depID)
+				task.Dependencies = append(task.Dependencies, models.Dependency{TaskID: depID, Position: models.ArgLeft})
+				leftType = resultTypes[depID]
 				left = "0"
 			}
 			if strings.HasPrefix(right, "task:") {
 				depID := strings.TrimPrefix(right, "task:")
-				task.DependsOn = append(task.DependsOn, depID)
+				task.Dependencies = append(task.Dependencies, models.Dependency{TaskID: depID, Position: models.ArgRight})
+				rightType = resultTypes[depID]
 				right = "0"
 			}
 
-			task.Arg1 = parseFloat(left)
-			task.Arg2 = parseFloat(right)
+			arg1, err := parseOperand(left)
+			if err != nil {
+				return nil, nil, err
+			}
+			arg2, err := parseOperand(right)
+			if err != nil {
+				return nil, nil, err
+			}
+			task.Arg1, task.Arg1Imag, task.Arg1Text = arg1.value, arg1.imag, arg1.text
+			task.Arg2, task.Arg2Imag, task.Arg2Text = arg2.value, arg2.imag, arg2.text
+			task.Arg1Type, task.Arg2Type = arg1.kind, arg2.kind
+			if mode == models.ModeInterval {
+				task.Arg1Upper, task.Arg2Upper = arg1.upper, arg2.upper
+			}
+
+			// у аргумента-зависимости тип берётся из уже разобранной задачи
+			if leftType == models.TypeNumber {
+				leftType = arg1.kind
+			}
+			if rightType == models.TypeNumber {
+				rightType = arg2.kind
+			}
+			resultType, err := models.ResultType(token, leftType, rightType)
+			if err != nil {
+				if date := bareDate(tokens); date != "" {
+					return nil, nil, fmt.Errorf("%w: %s is a subtraction, write date(%s) for a date", err, date, date)
+				}
+				return nil, nil, err
+			}
+			resultTypes[taskID] = resultType
+
+			checked := *task
+			checked.Arg1Type, checked.Arg2Type = leftType, rightType
+			if err := op.Validate(&checked); err != nil {
+				return nil, nil, err
+			}
 			task.OperationTime = o.getOperationTime(token)
 
 			tasks = append(tasks, task)
@@ -137,10 +340,28 @@ func (o *Orchestrator) parseExpressionToTasks(
 		}
 	}
 
+	if len(stack) > 1 {
+		return nil, nil, fmt.Errorf("invalid expression: %d operands left without operator", len(stack)-1)
+	}
+	if len(tasks) == 0 {
+		if len(stack) == 0 {
+			return nil, nil, fmt.Errorf("empty expression")
+		}
+		literal, err := parseOperand(stack[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		result := &models.TaskResult{Value: literal.value, Imag: literal.imag, Text: literal.text, Type: literal.kind}
+		if mode == models.ModeInterval {
+			result.Upper = &literal.upper
+		}
+		return nil, result, nil
+	}
+
 	orderedTasks := topologicalSort(tasks, taskMap)
 	log.Printf("Ordered tasks: %+v", orderedTasks)
 
-	return orderedTasks, nil
+	return orderedTasks, nil, nil
 }
 
 func topologicalSort(tasks []*models.Task, taskMap map[string]*models.Task) []*models.Task {
@@ -153,8 +374,8 @@ func topologicalSort(tasks []*models.Task, taskMap map[string]*models.Task) []*m
 			return
 		}
 		visited[taskID] = true
-		for _, dep := range taskMap[taskID].DependsOn {
-			visit(dep)
+		for _, dep := range taskMap[taskID].Dependencies {
+			visit(dep.TaskID)
 		}
 		result = append(result, taskMap[taskID])
 	}
@@ -177,22 +398,239 @@ func parseFloat(s string) float64 {
 	return val
 }
 
+// operand — разобранный литерал. Для интервала value — нижняя граница, upper — верхняя;
+// у обычного числа обе границы совпадают. Даты и длительности хранятся в секундах.
+type operand struct {
+	value float64
+	imag  float64
+	upper float64
+	text  string
+	kind  string
+}
+
+// parseOperand разбирает литерал: число, мнимое число, интервал, дату или длительность.
+// Для целых больше 2^53 сохраняется точная строковая запись.
+func parseOperand(s string) (operand, error) {
+	if models.IsDate(s) || models.IsDuration(s) {
+		parse, kind := models.ParseDate, models.TypeDate
+		if models.IsDuration(s) {
+			parse, kind = models.ParseDuration, models.TypeDuration
+		}
+		v, err := parse(s)
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{value: v, upper: v, kind: kind}, nil
+	}
+	if isInterval(s) {
+		lower, upper, err := parseInterval(s)
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{value: lower, upper: upper}, nil
+	}
+	if isImaginary(s) {
+		if s == "i" {
+			return operand{imag: 1}, nil
+		}
+		return operand{imag: parseFloat(strings.TrimSuffix(s, "i"))}, nil
+	}
+	if n, ok := new(big.Int).SetString(s, 10); ok && n.CmpAbs(maxExactInteger) > 0 {
+		return operand{text: n.String()}, nil
+	}
+	v := parseFloat(s)
+	return operand{value: v, upper: v}, nil
+}
+
+// isInterval распознаёт интервальные литералы [a,b] и a±b.
+func isInterval(token string) bool {
+	if strings.HasPrefix(token, "[") && strings.HasSuffix(token, "]") {
+		return true
+	}
+	parts := strings.Split(token, "±")
+	return len(parts) == 2 && isNumber(parts[0]) && isNumber(parts[1])
+}
+
+func parseInterval(token string) (float64, float64, error) {
+	if strings.HasPrefix(token, "[") {
+		bounds := strings.Split(strings.Trim(token, "[]"), ",")
+		if len(bounds) != 2 || !isNumber(bounds[0]) || !isNumber(bounds[1]) {
+			return 0, 0, fmt.Errorf("invalid interval: %s", token)
+		}
+		lower, upper := parseFloat(bounds[0]), parseFloat(bounds[1])
+		if lower > upper {
+			return 0, 0, fmt.Errorf("invalid interval: lower bound is greater than upper in %s", token)
+		}
+		return lower, upper, nil
+	}
+
+	parts := strings.Split(token, "±")
+	center, radius := parseFloat(parts[0]), parseFloat(parts[1])
+	if radius < 0 {
+		return 0, 0, fmt.Errorf("invalid interval: negative uncertainty in %s", token)
+	}
+	return center - radius, center + radius, nil
+}
+
+// foldDateCalls заменяет вызовы date(y, m, d), date(2026-10-18) и today() литералами дат.
+// Аргументы date должны быть целыми числами или датой в ISO; today() — текущая дата
+// по UTC на момент отправки выражения.
+func foldDateCalls(tokens []string) ([]string, error) {
+	var folded []string
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "today":
+			if i+2 >= len(tokens) || tokens[i+1] != "(" || tokens[i+2] != ")" {
+				return nil, fmt.Errorf("today() takes no arguments")
+			}
+			folded = append(folded, time.Now().UTC().Format("2006-01-02"))
+			i += 2
+		case "date":
+			if i+3 < len(tokens) && tokens[i+1] == "(" && models.IsDate(tokens[i+2]) && tokens[i+3] == ")" {
+				if _, err := models.ParseDate(tokens[i+2]); err != nil {
+					return nil, err
+				}
+				folded = append(folded, tokens[i+2])
+				i += 3
+				continue
+			}
+			if i+7 >= len(tokens) || tokens[i+1] != "(" || tokens[i+3] != "," || tokens[i+5] != "," || tokens[i+7] != ")" {
+				return nil, fmt.Errorf("date() expects year, month and day")
+			}
+			year, errY := strconv.Atoi(tokens[i+2])
+			month, errM := strconv.Atoi(tokens[i+4])
+			day, errD := strconv.Atoi(tokens[i+6])
+			if errY != nil || errM != nil || errD != nil {
+				return nil, fmt.Errorf("date() expects integer arguments")
+			}
+			t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+			if year < 1 || year > 9999 || int(t.Month()) != month || t.Day() != day {
+				return nil, fmt.Errorf("invalid date: date(%d, %d, %d)", year, month, day)
+			}
+			folded = append(folded, t.Format("2006-01-02"))
+			i += 7
+		default:
+			folded = append(folded, tokens[i])
+		}
+	}
+	return folded, nil
+}
+
+// foldUnaryMinus убирает унарный минус — в начале выражения, после "(", "," или
+// другой операции. Перед числом он становится частью литерала (-1, -4i), в остальных
+// случаях -x заменяется на (-1 * x).
+func foldUnaryMinus(tokens []string) ([]string, error) {
+	var folded []string
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] != "-" || !unaryPosition(folded) {
+			folded = append(folded, tokens[i])
+			continue
+		}
+		if i+1 < len(tokens) && (isNumber(tokens[i+1]) || (isImaginary(tokens[i+1]) && tokens[i+1] != "i")) {
+			folded = append(folded, "-"+tokens[i+1])
+			i++
+			continue
+		}
+		end, err := operandEnd(tokens, i+1)
+		if err != nil {
+			return nil, err
+		}
+		operand, err := foldUnaryMinus(tokens[i+1 : end+1])
+		if err != nil {
+			return nil, err
+		}
+		folded = append(folded, "(", "-1", "*")
+		folded = append(folded, operand...)
+		folded = append(folded, ")")
+		i = end
+	}
+	return folded, nil
+}
+
+func unaryPosition(preceding []string) bool {
+	if len(preceding) == 0 {
+		return true
+	}
+	last := preceding[len(preceding)-1]
+	return last == "(" || last == "," || isOperator(last)
+}
+
+// operandEnd возвращает индекс последнего токена операнда, который начинается с tokens[start]:
+// литерала, выражения в скобках, вызова функции или операнда с унарной операцией.
+func operandEnd(tokens []string, start int) (int, error) {
+	if start >= len(tokens) {
+		return 0, fmt.Errorf("missing operand for unary minus")
+	}
+	token := tokens[start]
+	switch {
+	case token == "-" || isUnaryOperator(token):
+		return operandEnd(tokens, start+1)
+	case isLiteral(token):
+		return start, nil
+	case isFunction(token) && start+1 < len(tokens) && tokens[start+1] == "(":
+		start++
+	case token != "(":
+		return 0, fmt.Errorf("invalid operand for unary minus: %s", token)
+	}
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("mismatched parentheses")
+}
+
+// bareDate находит запись вида 2026-10-18 без date(...): она разбирается как вычитание,
+// и в ошибке типов стоит подсказать, как записать дату.
+func bareDate(tokens []string) string {
+	for i := 0; i+4 < len(tokens); i++ {
+		date := strings.Join(tokens[i:i+5], "")
+		if tokens[i+1] == "-" && tokens[i+3] == "-" && models.IsDate(date) {
+			if _, err := models.ParseDate(date); err == nil {
+				return date
+			}
+		}
+	}
+	return ""
+}
+
+func containsInterval(tokens []string) bool {
+	for _, token := range tokens {
+		if isInterval(token) {
+			return true
+		}
+	}
+	return false
+}
+
 func shuntingYard(tokens []string) ([]string, error) {
 	var output []string
 	var operators []string
 
-	precedence := map[string]int{
-		"+": 1, "-": 1,
-		"*": 2, "/": 2,
-	}
-
 	for _, token := range tokens {
-		if isNumber(token) {
+		if isLiteral(token) {
 			output = append(output, token)
+		} else if isFunction(token) || isUnaryOperator(token) {
+			operators = append(operators, token)
+		} else if token == "," {
+			for len(operators) > 0 && operators[len(operators)-1] != "(" {
+				output = append(output, operators[len(operators)-1])
+				operators = operators[:len(operators)-1]
+			}
+			if len(operators) == 0 {
+				return nil, fmt.Errorf("misplaced comma")
+			}
 		} else if isOperator(token) {
 			for len(operators) > 0 {
 				top := operators[len(operators)-1]
-				if precedence[top] >= precedence[token] {
+				if topPrecedence, ok := operations.Precedence(top); ok && topPrecedence >= precedence(token) {
 					output = append(output, top)
 					operators = operators[:len(operators)-1]
 				} else {
@@ -211,6 +649,10 @@ func shuntingYard(tokens []string) ([]string, error) {
 				}
 				output = append(output, top)
 			}
+			if len(operators) > 0 && isFunction(operators[len(operators)-1]) {
+				output = append(output, operators[len(operators)-1])
+				operators = operators[:len(operators)-1]
+			}
 		} else {
 			return nil, fmt.Errorf("invalid token: %s", token)
 		}
@@ -233,10 +675,39 @@ func isNumber(token string) bool {
 	return err == nil
 }
 
+// isImaginary распознаёт мнимые литералы вида 4i, 0.5i и i.
+func isImaginary(token string) bool {
+	return token == "i" || (strings.HasSuffix(token, "i") && isNumber(strings.TrimSuffix(token, "i")))
+}
+
+func isLiteral(token string) bool {
+	return isNumber(token) || isImaginary(token) || isInterval(token) || models.IsDate(token) || models.IsDuration(token)
+}
+
+// isOperator, isUnaryOperator и isFunction определяют синтаксис операции по реестру:
+// инфиксные операции записываются знаком, остальные — как вызов функции.
 func isOperator(token string) bool {
-	return token == "+" || token == "-" || token == "*" || token == "/"
+	_, ok := operations.Precedence(token)
+	return ok
+}
+
+func isUnaryOperator(token string) bool {
+	op, ok := operations.Lookup(token)
+	return ok && isOperator(token) && op.Arity() == 1
 }
 
+func isFunction(token string) bool {
+	_, ok := operations.Lookup(token)
+	return ok && !isOperator(token)
+}
+
+func precedence(token string) int {
+	p, _ := operations.Precedence(token)
+	return p
+}
+
+var maxExactInteger = new(big.Int).Lsh(big.NewInt(1), 53)
+
 func (o *Orchestrator) GetExpressions(owner string) (map[string]*models.Expression, error) {
 	return o.repo.GetExpressionsByOwner(owner)
 }
@@ -245,70 +716,399 @@ func (o *Orchestrator) GetExpressionByID(id string, owner string) (*models.Expre
 	return o.repo.GetExpressionByIDAndOwner(id, owner)
 }
 
-func (o *Orchestrator) GetTask() (*models.Task, bool, error) {
-	task, exists, err := o.repo.GetAndLockTask()
+// ExpressionTasks возвращает подзадачи выражения с историей выдач агентам;
+// false — у owner нет такого выражения.
+func (o *Orchestrator) ExpressionTasks(id, owner string) ([]*models.ExpressionTask, bool, error) {
+	_, exists, err := o.repo.GetExpressionByIDAndOwner(id, owner)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	tasks, err := o.repo.ExpressionTasks(id)
 	if err != nil {
-		log.Printf("Repository error: %v", err)
 		return nil, false, err
 	}
+	return tasks, true, nil
+}
+
+// FormatBases — допустимые значения параметра format для вывода результата.
+var FormatBases = map[string]int{
+	"2": 2, "bin": 2,
+	"8": 8, "oct": 8,
+	"16": 16, "hex": 16,
+	"36": 36, "base36": 36,
+}
+
+// FormatResult записывает целочисленный результат выражения в системе счисления base.
+func FormatResult(expr *models.Expression, base int) (string, error) {
+	var n *big.Int
+	switch {
+	case expr.ResultType != models.TypeNumber:
+		return "", fmt.Errorf("result is a %s", expr.ResultType)
+	case expr.ResultImag != nil && *expr.ResultImag != 0:
+		return "", fmt.Errorf("result is not an integer")
+	case expr.ResultLower != nil && expr.ResultUpper != nil && *expr.ResultLower != *expr.ResultUpper:
+		return "", fmt.Errorf("result is an interval")
+	case expr.ResultText != "":
+		v, ok := new(big.Int).SetString(expr.ResultText, 10)
+		if !ok {
+			return "", fmt.Errorf("result is not an integer")
+		}
+		n = v
+	case expr.Result != nil && *expr.Result == math.Trunc(*expr.Result) && !math.IsInf(*expr.Result, 0):
+		n, _ = big.NewFloat(*expr.Result).Int(nil)
+	case expr.Result != nil:
+		return "", fmt.Errorf("result is not an integer")
+	default:
+		return "", fmt.Errorf("expression has no result yet")
+	}
+	return n.Text(base), nil
+}
+
+func (o *Orchestrator) GetTask(agentID string, capabilities models.Capabilities) (*models.Task, bool, error) {
+	tasks, err := o.GetTasks(agentID, capabilities, 1)
+	if err != nil || len(tasks) == 0 {
+		return nil, false, err
+	}
+	log.Printf("Found task: %+v", tasks[0])
+	return tasks[0], true, nil
+}
+
+// LoadReadyQueue заново строит очередь готовых задач по БД. Вызывается при
+// старте, до того как оркестратор начнёт принимать запросы.
+func (o *Orchestrator) LoadReadyQueue() error {
+	tasks, err := o.repo.ReadyTasks()
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		o.enqueueAt(task, task.RetryAt)
+	}
+	log.Printf("Ready queue loaded: %d tasks", len(tasks))
+	return nil
+}
 
-	if !exists {
-	} else {
-		log.Printf("Found task: %+v", task)
+// TasksReady возвращает канал, который закроется, когда появятся новые задачи
+// (новое выражение, завершённая зависимость или возврат задачи в очередь).
+// Канал нужно получить до GetTask, иначе сигнал между ними можно пропустить.
+func (o *Orchestrator) TasksReady() <-chan struct{} {
+	return o.tasksReady.Wait()
+}
+
+// QueueStats показывает доли пользователей в очереди и первые limit задач в порядке выдачи.
+func (o *Orchestrator) QueueStats(limit int) *models.QueueStats {
+	return o.queue.Stats(limit)
+}
+
+// QueueDepth — сколько готовых задач ждут выдачи агенту с возможностями capabilities.
+func (o *Orchestrator) QueueDepth(capabilities models.Capabilities) int {
+	return o.queue.Depth(capabilities)
+}
+
+// MaxBatchSize ограничивает размер пакета в GetTasks и SubmitResults.
+const MaxBatchSize = 100
+
+// GetTasks захватывает до maxN готовых задач одной транзакцией. Кандидаты берутся
+// из очереди в памяти; задачи, которые в БД уже не ждут выдачи, из очереди выбрасываются.
+func (o *Orchestrator) GetTasks(agentID string, capabilities models.Capabilities, maxN int) ([]*models.Task, error) {
+	if maxN <= 0 {
+		maxN = 1
+	}
+	if maxN > MaxBatchSize {
+		maxN = MaxBatchSize
 	}
+	if o.isQuarantined(agentID) {
+		return nil, nil
+	}
+
+	var tasks []*models.Task
+	for len(tasks) < maxN {
+		entries := o.queue.Pop(agentID, capabilities, maxN-len(tasks))
+		if len(entries) == 0 {
+			break
+		}
+		ids := make([]string, len(entries))
+		for i, e := range entries {
+			ids[i] = e.id
+		}
 
-	return task, exists, nil
+		claimed, err := o.repo.ClaimTasks(agentID, ids, LeaseSlack)
+		if err != nil {
+			o.queue.Restore(entries)
+			log.Printf("Repository error: %v", err)
+			return nil, err
+		}
+		tasks = append(tasks, claimed...)
+	}
+	return tasks, nil
 }
 
-func (o *Orchestrator) SubmitResult(taskID string, result float64, taskErr *models.TaskError) (bool, error) {
-	var resultPtr *float64
-	if taskErr == nil {
-		resultPtr = &result
+func (o *Orchestrator) SubmitResult(taskID, leaseID string, result *models.TaskResult, taskErr *models.TaskError) (bool, error) {
+	if taskErr == nil && result == nil {
+		return false, fmt.Errorf("empty result for task %s", taskID)
 	}
 
-	updated, status, err := o.repo.UpdateTaskResult(taskID, resultPtr, taskErr)
+	accepted, err := o.SubmitResults([]repository.ResultUpdate{
+		{TaskID: taskID, LeaseID: leaseID, Result: result, Err: taskErr},
+	})
 	if err != nil {
-		return false, fmt.Errorf("failed to update task: %w", err)
+		return false, err
 	}
-	if !updated {
-		return false, nil
+	return accepted[0], nil
+}
+
+// SubmitResults сохраняет пакет результатов одной транзакцией. Для каждого элемента
+// возвращается, принят ли он (false — пустой результат или чужая аренда); повтор
+// уже принятого результата считается принятым.
+func (o *Orchestrator) SubmitResults(updates []repository.ResultUpdate) ([]bool, error) {
+	if len(updates) > MaxBatchSize {
+		return nil, fmt.Errorf("batch of %d results exceeds limit %d", len(updates), MaxBatchSize)
 	}
 
-	parts := strings.Split(taskID, "-")
-	if len(parts) < 6 {
-		return false, fmt.Errorf("invalid taskID format: %s", taskID)
+	accepted := make([]bool, len(updates))
+	valid := make([]repository.ResultUpdate, 0, len(updates))
+	index := make([]int, 0, len(updates))
+	for i, u := range updates {
+		if u.Err == nil && u.Result == nil {
+			log.Printf("Empty result for task %s", u.TaskID)
+			continue
+		}
+		u.Tolerance = o.verifyTolerance
+		if u.Err != nil {
+			u.Result = nil
+			if u.Err.Code.Transient() && o.retry.MaxAttempts > 0 {
+				u.Retry = &o.retry
+			}
+		}
+		valid = append(valid, u)
+		index = append(index, i)
+	}
+	if len(valid) == 0 {
+		return accepted, nil
+	}
+
+	statuses, err := o.repo.UpdateTaskResults(valid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update tasks: %w", err)
+	}
+
+	// выражение пересчитываем один раз, даже если в пакете несколько его задач
+	var completed []string
+	seen := make(map[string]bool)
+	for k, st := range statuses {
+		u := valid[k]
+		if st.Duplicate {
+			// агент переотправил уже принятый результат (например, из outbox)
+			log.Printf("Result for task %s under lease %s was already accepted (%s)", u.TaskID, u.LeaseID, st.Status)
+			accepted[index[k]] = true
+			continue
+		}
+		if !st.Updated {
+			log.Printf("Result for task %s rejected: lease %s is no longer held", u.TaskID, u.LeaseID)
+			continue
+		}
+		accepted[index[k]] = true
+
+		exprID, err := expressionIDOf(u.TaskID)
+		if err != nil {
+			return nil, err
+		}
+		switch st.Status {
+		case repository.TaskStatusCompleted:
+		case repository.TaskStatusPending:
+			if u.Retry != nil {
+				log.Printf("Task %s failed with %s, retry at %s", u.TaskID, u.Err.Code, st.Requeued.RetryAt.Format(time.RFC3339))
+			} else {
+				log.Printf("Task %s awaits verification by another agent", u.TaskID)
+			}
+			o.enqueueAt(st.Requeued, st.Requeued.RetryAt)
+			continue
+		case repository.TaskStatusDead:
+			// выражение завершается исходной ошибкой, задача остаётся в dead
+			log.Printf("Task %s is dead after %d attempts: %s", u.TaskID, o.retry.MaxAttempts, u.Err.Code)
+			_, _ = o.repo.UpdateExpression(exprID, string(u.Err.Code), nil)
+			continue
+		default:
+			_, _ = o.repo.UpdateExpression(exprID, st.Status, nil)
+			continue
+		}
+		if err := o.enqueueDependents(u.TaskID); err != nil {
+			return nil, err
+		}
+		if !seen[exprID] {
+			seen[exprID] = true
+			completed = append(completed, exprID)
+		}
 	}
-	exprID := strings.Join(parts[:5], "-")
+	if len(completed) == 0 {
+		return accepted, nil
+	}
+	o.tasksReady.Broadcast()
 
-	if status != repository.TaskStatusCompleted {
-		_, _ = o.repo.UpdateExpression(exprID, status, 0)
-		return true, nil
+	for _, exprID := range completed {
+		if err := o.completeExpressionIfDone(exprID); err != nil {
+			return nil, err
+		}
 	}
+	return accepted, nil
+}
+
+// enqueueAt ставит задачу в очередь в момент at (сразу, если он уже наступил).
+func (o *Orchestrator) enqueueAt(task *models.Task, at time.Time) {
+	if delay := time.Until(at); delay > 0 {
+		time.AfterFunc(delay, func() {
+			o.queue.Push(task)
+			o.tasksReady.Broadcast()
+		})
+		return
+	}
+	o.queue.Push(task)
+	o.tasksReady.Broadcast()
+}
 
+// enqueueDependents ставит в очередь задачи, которым не хватало только результата taskID.
+func (o *Orchestrator) enqueueDependents(taskID string) error {
+	tasks, err := o.repo.ReadyDependents(taskID)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue dependents: %w", err)
+	}
+	o.queue.Push(tasks...)
+	return nil
+}
+
+// completeExpressionIfDone записывает итог выражения, если все его задачи выполнены.
+func (o *Orchestrator) completeExpressionIfDone(exprID string) error {
 	allDone, err := o.repo.AreAllTasksCompleted(exprID)
 	if err != nil {
-		return false, fmt.Errorf("failed to check tasks: %w", err)
+		return fmt.Errorf("failed to check tasks: %w", err)
 	}
 
 	if !allDone {
-		return true, nil
+		return nil
 	}
 
 	finalResult, err := o.repo.CalculateFinalResult(exprID)
 	if err != nil {
-		return false, fmt.Errorf("failed to calculate result: %w", err)
+		return fmt.Errorf("failed to calculate result: %w", err)
 	}
 
 	exprUpdated, err := o.repo.UpdateExpression(exprID, repository.TaskStatusCompleted, finalResult)
 	if err != nil {
-		return false, fmt.Errorf("failed to update expression: %w", err)
+		return fmt.Errorf("failed to update expression: %w", err)
 	}
 	if !exprUpdated {
 		log.Printf("Failed to update expression with ID %s", exprID)
-		return false, fmt.Errorf("expression not found or not updated")
+		return fmt.Errorf("expression not found or not updated")
 	}
 
-	return true, nil
+	return nil
+}
+
+// expressionIDOf извлекает ID выражения из ID задачи вида <uuid выражения>-<номер>.
+func expressionIDOf(taskID string) (string, error) {
+	parts := strings.Split(taskID, "-")
+	if len(parts) < 6 {
+		return "", fmt.Errorf("invalid taskID format: %s", taskID)
+	}
+	return strings.Join(parts[:5], "-"), nil
+}
+
+// RequeueExpiredTasks возвращает в очередь задачи, аренда которых истекла. Задача,
+// которую выдавали уже retry.MaxAttempts раз (например, она роняет каждого агента),
+// переводится в dead, а её выражение завершается ошибкой internal_error.
+func (o *Orchestrator) RequeueExpiredTasks() (int, error) {
+	tasks, dead, err := o.repo.RequeueExpiredTasks(time.Now(), o.retry.MaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	for _, taskID := range dead {
+		log.Printf("Task %s is dead after %d attempts: lease expired", taskID, o.retry.MaxAttempts)
+		exprID, err := expressionIDOf(taskID)
+		if err != nil {
+			return 0, err
+		}
+		_, _ = o.repo.UpdateExpression(exprID, string(models.ErrInternalError), nil)
+	}
+	if len(tasks) > 0 {
+		for _, task := range tasks {
+			o.enqueueAt(task, task.RetryAt)
+		}
+		log.Printf("Requeued %d tasks with expired leases", len(tasks))
+		o.tasksReady.Broadcast()
+	}
+	return len(tasks), nil
+}
+
+// ReleaseTasks возвращает в очередь задачи, которые агент отдал при остановке.
+// success[i] относится к leases[i].
+func (o *Orchestrator) ReleaseTasks(agentID string, leases []repository.TaskLease) ([]bool, error) {
+	if len(leases) > MaxBatchSize {
+		return nil, fmt.Errorf("batch of %d leases exceeds limit %d", len(leases), MaxBatchSize)
+	}
+
+	released, err := o.repo.ReleaseTasks(agentID, leases)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release tasks: %w", err)
+	}
+	success := make([]bool, len(leases))
+	n := 0
+	for i, task := range released {
+		if task == nil {
+			continue
+		}
+		success[i] = true
+		o.enqueueAt(task, task.RetryAt)
+		n++
+	}
+	if n > 0 {
+		log.Printf("Agent %s released %d tasks", agentID, n)
+		o.tasksReady.Broadcast()
+	}
+	return success, nil
+}
+
+// RunLeaseReaper периодически вызывает RequeueExpiredTasks до отмены ctx.
+func (o *Orchestrator) RunLeaseReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := o.RequeueExpiredTasks(); err != nil {
+				log.Printf("Lease reaper: %v", err)
+			}
+		}
+	}
+}
+
+func (o *Orchestrator) RegisterAgent(agent *models.Agent) error {
+	if agent.ID == "" {
+		return fmt.Errorf("agent id is required")
+	}
+	log.Printf("Agent registered: %s (%s, version %s, %d workers)", agent.ID, agent.Hostname, agent.Version, agent.ComputingPower)
+	return o.repo.RegisterAgent(agent)
+}
+
+func (o *Orchestrator) Heartbeat(agentID string, workers int) (bool, error) {
+	return o.repo.TouchAgent(agentID, workers)
+}
+
+// DeadTasks возвращает задачи, исчерпавшие попытки, с историей попыток.
+func (o *Orchestrator) DeadTasks() ([]*models.DeadTask, error) {
+	return o.repo.DeadTasks()
+}
+
+func (o *Orchestrator) ListAgents() ([]*models.Agent, error) {
+	agents, err := o.repo.ListAgents()
+	if err != nil {
+		return nil, err
+	}
+	for _, agent := range agents {
+		agent.Alive = time.Since(agent.LastSeen) < 3*HeartbeatInterval
+	}
+	return agents, nil
 }
 
 func generateUUID() string {
@@ -316,43 +1116,75 @@ func generateUUID() string {
 }
 
 func (o *Orchestrator) getOperationTime(operation string) int {
-	switch operation {
-	case "+":
-		return o.timeAdditionMS
-	case "-":
-		return o.timeSubtractionMS
-	case "*":
-		return o.timeMultiplicationMS
-	case "/":
-		return o.timeDivisionMS
-	default:
-		return 0
+	if ms, ok := o.operationTimesMS[operation]; ok {
+		return ms
+	}
+	if op, ok := operations.Lookup(operation); ok {
+		return int(op.DefaultDuration().Milliseconds())
 	}
+	return 0
 }
 
 func tokenize(expression string) []string {
 	var tokens []string
 	var currentToken strings.Builder
 
-	for _, char := range expression {
+	flush := func() {
+		if currentToken.Len() > 0 {
+			tokens = append(tokens, currentToken.String())
+			currentToken.Reset()
+		}
+	}
+
+	chars := []rune(expression)
+	for i := 0; i < len(chars); i++ {
+		char := chars[i]
 		if char == ' ' {
+			flush()
 			continue
 		}
 
-		if isOperator(string(char)) || char == '(' || char == ')' {
-			if currentToken.Len() > 0 {
-				tokens = append(tokens, currentToken.String())
-				currentToken.Reset()
+		if currentToken.Len() == 0 && i+10 <= len(chars) && models.IsDate(string(chars[i:i+10])) &&
+			len(tokens) >= 2 && tokens[len(tokens)-2] == "date" && tokens[len(tokens)-1] == "(" {
+			// date(2026-10-18) — дата одним токеном; без date( это вычитание, как и раньше
+			tokens = append(tokens, string(chars[i:i+10]))
+			i += 9
+		} else if char == '[' {
+			// интервал [a, b] — один токен, пробелы внутри отбрасываются
+			flush()
+			j := i
+			for j < len(chars) && chars[j] != ']' {
+				j++
+			}
+			if j == len(chars) {
+				j--
 			}
+			tokens = append(tokens, strings.ReplaceAll(string(chars[i:j+1]), " ", ""))
+			i = j
+		} else if char == '±' {
+			// a ± b склеивается с соседними числами в один литерал
+			flush()
+			if len(tokens) > 0 && isNumber(tokens[len(tokens)-1]) {
+				currentToken.WriteString(tokens[len(tokens)-1])
+				tokens = tokens[:len(tokens)-1]
+			}
+			currentToken.WriteRune(char)
+			for i+1 < len(chars) && chars[i+1] == ' ' {
+				i++
+			}
+		} else if (char == '<' || char == '>') && i+1 < len(chars) && chars[i+1] == char {
+			flush()
+			tokens = append(tokens, string(chars[i:i+2]))
+			i++
+		} else if isOperator(string(char)) || char == '(' || char == ')' || char == ',' {
+			flush()
 			tokens = append(tokens, string(char))
 		} else {
 			currentToken.WriteRune(char)
 		}
 	}
 
-	if currentToken.Len() > 0 {
-		tokens = append(tokens, currentToken.String())
-	}
+	flush()
 
 	return tokens
 }
@@ -403,7 +1235,7 @@ func (o *Orchestrator) UserExists(login string) (bool, error) {
 	return true, nil
 }
 
-func (o *Orchestrator) GetTaskResult(taskID string) (float64, bool, error) {
+func (o *Orchestrator) GetTaskResult(taskID string) (*models.TaskResult, bool, error) {
 	return o.repo.GetTaskResult(taskID)
 }
 
@@ -1,11 +1,17 @@
 package service_test
 
 import (
+	"calculator_app/internal/orchestrator/repository"
 	"calculator_app/internal/orchestrator/service"
 	"calculator_app/internal/pkg/models"
+	"calculator_app/internal/pkg/operations"
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Мокаем методы репозитория
@@ -23,24 +29,39 @@ func (m *MockRepository) AddTask(task *models.Task) error {
 	return args.Error(0)
 }
 
-func (m *MockRepository) GetAndLockTask() (*models.Task, bool, error) {
+func (m *MockRepository) ReadyTasks() ([]*models.Task, error) {
 	args := m.Called()
-	return args.Get(0).(*models.Task), args.Bool(1), args.Error(2)
+	return args.Get(0).([]*models.Task), args.Error(1)
 }
 
-func (m *MockRepository) UpdateTaskResult(taskID string, result *float64, taskErr *models.TaskError) (bool, string, error) {
-	args := m.Called(taskID, result, taskErr)
-	return args.Bool(0), args.String(1), args.Error(2)
+func (m *MockRepository) ReadyDependents(taskID string) ([]*models.Task, error) {
+	args := m.Called(taskID)
+	return args.Get(0).([]*models.Task), args.Error(1)
+}
+
+func (m *MockRepository) ClaimTasks(agentID string, taskIDs []string, leaseSlack time.Duration) ([]*models.Task, error) {
+	args := m.Called(agentID, taskIDs, leaseSlack)
+	return args.Get(0).([]*models.Task), args.Error(1)
+}
+
+func (m *MockRepository) UpdateTaskResults(updates []repository.ResultUpdate) ([]repository.ResultStatus, error) {
+	args := m.Called(updates)
+	return args.Get(0).([]repository.ResultStatus), args.Error(1)
 }
 
-func (m *MockRepository) UpdateExpression(id string, status string, result float64) (bool, error) {
+func (m *MockRepository) RequeueExpiredTasks(now time.Time, maxAttempts int) ([]*models.Task, []string, error) {
+	args := m.Called(now, maxAttempts)
+	return args.Get(0).([]*models.Task), args.Get(1).([]string), args.Error(2)
+}
+
+func (m *MockRepository) UpdateExpression(id string, status string, result *models.TaskResult) (bool, error) {
 	args := m.Called(id, status, result)
 	return args.Bool(0), args.Error(1)
 }
 
-func (m *MockRepository) CalculateFinalResult(expressionID string) (float64, error) {
+func (m *MockRepository) CalculateFinalResult(expressionID string) (*models.TaskResult, error) {
 	args := m.Called(expressionID)
-	return args.Get(0).(float64), args.Error(1)
+	return args.Get(0).(*models.TaskResult), args.Error(1)
 }
 
 func (m *MockRepository) AreAllTasksCompleted(expressionID string) (bool, error) {
@@ -68,9 +89,85 @@ func (m *MockRepository) FindUser(login string) (*models.User, error) {
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockRepository) GetTaskResult(taskID string) (float64, bool, error) {
+func (m *MockRepository) RegisterAgent(agent *models.Agent) error {
+	args := m.Called(agent)
+	return args.Error(0)
+}
+
+func (m *MockRepository) TouchAgent(agentID string, workers int) (bool, error) {
+	args := m.Called(agentID, workers)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepository) ListAgents() ([]*models.Agent, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.Agent), args.Error(1)
+}
+
+func (m *MockRepository) ReleaseTasks(agentID string, leases []repository.TaskLease) ([]*models.Task, error) {
+	args := m.Called(agentID, leases)
+	return args.Get(0).([]*models.Task), args.Error(1)
+}
+
+func (m *MockRepository) DeadTasks() ([]*models.DeadTask, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.DeadTask), args.Error(1)
+}
+
+func (m *MockRepository) ExpressionTasks(expressionID string) ([]*models.ExpressionTask, error) {
+	args := m.Called(expressionID)
+	return args.Get(0).([]*models.ExpressionTask), args.Error(1)
+}
+
+func (m *MockRepository) CreateJoinToken(tokenHash, createdBy string, expiresAt time.Time) error {
+	args := m.Called(tokenHash, createdBy, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockRepository) EnrollAgent(tokenHash, agentID, credentialHash string, now time.Time) error {
+	args := m.Called(tokenHash, agentID, credentialHash, now)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListEnrolledAgents() ([]*models.EnrolledAgent, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.EnrolledAgent), args.Error(1)
+}
+
+func (m *MockRepository) RevokeAgent(agentID string, now time.Time) (bool, error) {
+	args := m.Called(agentID, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepository) AgentCredentialValid(agentID, credentialHash string) (bool, error) {
+	args := m.Called(agentID, credentialHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepository) AgentHealth(since time.Time) ([]*models.AgentHealth, error) {
+	args := m.Called(since)
+	return args.Get(0).([]*models.AgentHealth), args.Error(1)
+}
+
+func (m *MockRepository) QuarantineAgent(agentID, reason string, now time.Time) (bool, error) {
+	args := m.Called(agentID, reason, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepository) UnquarantineAgent(agentID string, now time.Time) (bool, error) {
+	args := m.Called(agentID, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepository) CancelExpression(id, owner string, now time.Time) (bool, map[string][]string, error) {
+	args := m.Called(id, owner, now)
+	running, _ := args.Get(1).(map[string][]string)
+	return args.Bool(0), running, args.Error(2)
+}
+
+func (m *MockRepository) GetTaskResult(taskID string) (*models.TaskResult, bool, error) {
 	args := m.Called(taskID)
-	return args.Get(0).(float64), args.Bool(1), args.Error(2)
+	return args.Get(0).(*models.TaskResult), args.Bool(1), args.Error(2)
 }
 
 func TestAddExpression_Success(t *testing.T) {
@@ -81,9 +178,826 @@ func TestAddExpression_Success(t *testing.T) {
 
 	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
 
-	id, err := orc.AddExpression("2 + 2", "test_user")
+	id, err := orc.AddExpression("2 + 2", "test_user", "", 0, false)
 
 	assert.NoError(t, err)
 	assert.NotEmpty(t, id)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestAddExpression_Functions(t *testing.T) {
+	mockRepo := new(MockRepository)
+
+	var tasks []*models.Task
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("AddTask", mock.Anything).Run(func(args mock.Arguments) {
+		tasks = append(tasks, args.Get(0).(*models.Task))
+	}).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	_, err := orc.AddExpression("gcd(fact(6), 36) + 123456789012345678901", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 3)
+
+	ops := map[string]*models.Task{}
+	for _, task := range tasks {
+		ops[task.Operation] = task
+	}
+	assert.Equal(t, 6.0, ops["fact"].Arg1)
+	assert.Equal(t, []models.Dependency{{TaskID: ops["fact"].ID, Position: models.ArgLeft}}, ops["gcd"].Dependencies)
+	// обе стороны «+» известны: левая — результат gcd, правая — литерал
+	assert.Equal(t, []models.Dependency{{TaskID: ops["gcd"].ID, Position: models.ArgLeft}}, ops["+"].Dependencies)
+	assert.Equal(t, 36.0, ops["gcd"].Arg2)
+	assert.Equal(t, "123456789012345678901", ops["+"].Arg2Text)
+
+	_, err = orc.AddExpression("fact(4, 5)", "test_user", "", 0, false)
+	assert.Error(t, err)
+}
+
+func TestAddExpression_IntegerMode(t *testing.T) {
+	mockRepo := new(MockRepository)
+
+	var tasks []*models.Task
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("AddTask", mock.Anything).Run(func(args mock.Arguments) {
+		tasks = append(tasks, args.Get(0).(*models.Task))
+	}).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	_, err := orc.AddExpression("1 | 6 & ~1 << 2 xor 3", "test_user", models.ModeInteger, 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 5)
+	// приоритеты как в C: | < xor < & < сдвиги < ~
+	byOp := map[string]*models.Task{}
+	for _, task := range tasks {
+		assert.Equal(t, models.ModeInteger, task.Mode)
+		byOp[task.Operation] = task
+	}
+	assert.Equal(t, []models.Dependency{{TaskID: byOp["xor"].ID, Position: models.ArgRight}}, byOp["|"].Dependencies)
+	assert.Equal(t, 1.0, byOp["|"].Arg1)
+	assert.Equal(t, 3.0, byOp["xor"].Arg2)
+	assert.Equal(t, 6.0, byOp["&"].Arg1)
+	assert.Equal(t, 2.0, byOp["<<"].Arg2)
+	assert.Equal(t, 1.0, byOp["~"].Arg1)
+
+	_, err = orc.AddExpression("1.5 + 2", "test_user", models.ModeInteger, 0, false)
+	assert.Error(t, err)
+
+	_, err = orc.AddExpression("1 + 2", "test_user", "octonion", 0, false)
+	assert.Error(t, err)
+}
+
+func TestAddExpression_ComplexMode(t *testing.T) {
+	mockRepo := new(MockRepository)
+
+	var tasks []*models.Task
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("AddTask", mock.Anything).Run(func(args mock.Arguments) {
+		tasks = append(tasks, args.Get(0).(*models.Task))
+	}).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	_, err := orc.AddExpression("abs(3+4i) * i", "test_user", models.ModeComplex, 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 3)
+
+	byOp := map[string]*models.Task{}
+	for _, task := range tasks {
+		byOp[task.Operation] = task
+	}
+	assert.Equal(t, 3.0, byOp["+"].Arg1)
+	assert.Equal(t, 4.0, byOp["+"].Arg2Imag)
+	assert.Equal(t, 1.0, byOp["*"].Arg2Imag)
+	assert.Equal(t, models.ModeComplex, byOp["abs"].Mode)
+
+	// отклонённое выражение не сохраняется
+	rejected := new(MockRepository)
+	orc = service.NewOrchestrator(10, 10, 10, 10, rejected)
+	_, err = orc.AddExpression("3+4i", "test_user", "", 0, false)
+	assert.Error(t, err)
+	rejected.AssertNotCalled(t, "AddExpression", mock.Anything)
+}
+
+func TestAddExpression_UnaryMinus(t *testing.T) {
+	mockRepo := new(MockRepository)
+
+	var tasks []*models.Task
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("AddTask", mock.Anything).Run(func(args mock.Arguments) {
+		tasks = append(tasks, args.Get(0).(*models.Task))
+	}).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	_, err := orc.AddExpression("sqrt(-1)", "test_user", models.ModeComplex, 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		assert.Equal(t, "sqrt", tasks[0].Operation)
+		assert.Equal(t, -1.0, tasks[0].Arg1)
+	}
+
+	tasks = nil
+	_, err = orc.AddExpression("(3+4i) * sqrt(-1)", "test_user", models.ModeComplex, 0, false)
+	assert.NoError(t, err)
+	byOp := map[string]*models.Task{}
+	for _, task := range tasks {
+		byOp[task.Operation] = task
+	}
+	if assert.Len(t, byOp, 3) {
+		assert.Equal(t, -1.0, byOp["sqrt"].Arg1)
+		assert.Len(t, byOp["*"].Dependencies, 2)
+	}
+
+	tasks = nil
+	_, err = orc.AddExpression("-2 - -4i", "test_user", models.ModeComplex, 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		assert.Equal(t, -2.0, tasks[0].Arg1)
+		assert.Equal(t, -4.0, tasks[0].Arg2Imag)
+	}
+
+	// -x перед скобкой или функцией становится (-1 * x) и не меняет порядок операций
+	tasks = nil
+	_, err = orc.AddExpression("8 / -(1 + 1)", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	byOp = map[string]*models.Task{}
+	for _, task := range tasks {
+		byOp[task.Operation] = task
+	}
+	if assert.Len(t, byOp, 3) {
+		assert.Equal(t, -1.0, byOp["*"].Arg1)
+		assert.Equal(t, models.Dependency{TaskID: byOp["+"].ID, Position: models.ArgRight}, byOp["*"].Dependencies[0])
+		assert.Equal(t, 8.0, byOp["/"].Arg1)
+		assert.Equal(t, models.Dependency{TaskID: byOp["*"].ID, Position: models.ArgRight}, byOp["/"].Dependencies[0])
+	}
+
+	tasks = nil
+	_, err = orc.AddExpression("-fact(3) + 1", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 3)
+
+	for _, expression := range []string{"2 * -", "-)", "- * 2"} {
+		_, err = orc.AddExpression(expression, "test_user", "", 0, false)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestAddExpression_IntervalMode(t *testing.T) {
+	mockRepo := new(MockRepository)
+
+	var tasks []*models.Task
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("AddTask", mock.Anything).Run(func(args mock.Arguments) {
+		tasks = append(tasks, args.Get(0).(*models.Task))
+	}).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	_, err := orc.AddExpression("9.81 ± 0.02 * [1.2, 1.4]", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		task := tasks[0]
+		assert.Equal(t, models.ModeInterval, task.Mode)
+		assert.InDelta(t, 9.79, task.Arg1, 1e-12)
+		assert.InDelta(t, 9.83, task.Arg1Upper, 1e-12)
+		assert.Equal(t, 1.2, task.Arg2)
+		assert.Equal(t, 1.4, task.Arg2Upper)
+	}
+
+	tasks = nil
+	_, err = orc.AddExpression("2 + 3", "test_user", models.ModeInterval, 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		assert.Equal(t, 2.0, tasks[0].Arg1Upper)
+		assert.Equal(t, 3.0, tasks[0].Arg2Upper)
+	}
+
+	_, err = orc.AddExpression("[2, 1] + 3", "test_user", "", 0, false)
+	assert.Error(t, err)
+
+	_, err = orc.AddExpression("[1, 2] + 3", "test_user", models.ModeInteger, 0, false)
+	assert.Error(t, err)
+}
+
+func TestAddExpression_DatesAndDurations(t *testing.T) {
+	mockRepo := new(MockRepository)
+
+	var tasks []*models.Task
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("AddTask", mock.Anything).Run(func(args mock.Arguments) {
+		tasks = append(tasks, args.Get(0).(*models.Task))
+	}).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	_, err := orc.AddExpression("date(2026-10-18) + 90d", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		date, _ := models.ParseDate("2026-10-18")
+		assert.Equal(t, date, tasks[0].Arg1)
+		assert.Equal(t, models.TypeDate, tasks[0].Arg1Type)
+		assert.Equal(t, 90*86400.0, tasks[0].Arg2)
+		assert.Equal(t, models.TypeDuration, tasks[0].Arg2Type)
+	}
+
+	tasks = nil
+	_, err = orc.AddExpression("date(2026,12,31) - today()", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		today, _ := models.ParseDate(time.Now().UTC().Format("2006-01-02"))
+		assert.Equal(t, today, tasks[0].Arg2)
+		assert.Equal(t, models.TypeDate, tasks[0].Arg2Type)
+	}
+
+	tasks = nil
+	_, err = orc.AddExpression("3h15m * 4 / 1h", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 2)
+
+	// без date( запись через дефис — вычитание, как до появления дат
+	tasks = nil
+	_, err = orc.AddExpression("1000-12-31", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 2) {
+		args := map[float64]bool{}
+		for _, task := range tasks {
+			assert.Equal(t, "-", task.Operation)
+			assert.Equal(t, models.TypeNumber, task.Arg2Type)
+			args[task.Arg2] = true
+		}
+		assert.Equal(t, map[float64]bool{12: true, 31: true}, args)
+	}
+
+	// пример из запроса: без date( это вычитание, и ошибка подсказывает запись даты
+	_, err = orc.AddExpression("2026-10-18 + 90d", "test_user", "", 0, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "date(2026-10-18)")
+	}
+
+	for _, expression := range []string{"date(2026-10-18) + date(2026-10-19)", "(today() - 1d) * 2", "date(2026,2,30) - 1d", "date(2026-02-30)"} {
+		_, err = orc.AddExpression(expression, "test_user", "", 0, false)
+		assert.Error(t, err, expression)
+	}
+
+	rejected := new(MockRepository)
+	orc = service.NewOrchestrator(10, 10, 10, 10, rejected)
+	_, err = orc.AddExpression("1h + 2h", "test_user", models.ModeInteger, 0, false)
+	assert.Error(t, err)
+	rejected.AssertNotCalled(t, "AddExpression", mock.Anything)
+}
+
+func TestAddExpression_Literal(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("UpdateExpression", mock.Anything, repository.TaskStatusCompleted, &models.TaskResult{Value: 5}).Return(true, nil).Once()
+
+	// выражение из одного литерала сразу выполнено, задач не создаётся
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	_, err := orc.AddExpression("5", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "AddTask", mock.Anything)
+
+	rejected := new(MockRepository)
+	orc = service.NewOrchestrator(10, 10, 10, 10, rejected)
+	for _, c := range []struct{ expression, mode string }{
+		{"2i", ""},
+		{"[]", ""},
+		{"", ""},
+		{"date(2026-10-18)", models.ModeInteger},
+		{"1.5", models.ModeInteger},
+	} {
+		_, err = orc.AddExpression(c.expression, "test_user", c.mode, 0, false)
+		assert.Error(t, err, c.expression)
+	}
+	rejected.AssertNotCalled(t, "AddExpression", mock.Anything)
+}
+
+type cube struct{}
+
+func (cube) Name() string                   { return "cube" }
+func (cube) Arity() int                     { return 1 }
+func (cube) Validate(*models.Task) error    { return nil }
+func (cube) DefaultDuration() time.Duration { return 30 * time.Millisecond }
+func (cube) Execute(task *models.Task) (*models.TaskResult, error) {
+	return &models.TaskResult{Value: task.Arg1 * task.Arg1 * task.Arg1}, nil
+}
+
+func TestAddExpression_CustomOperation(t *testing.T) {
+	operations.Register(cube{})
+
+	mockRepo := new(MockRepository)
+
+	var tasks []*models.Task
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("AddTask", mock.Anything).Run(func(args mock.Arguments) {
+		tasks = append(tasks, args.Get(0).(*models.Task))
+	}).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	_, err := orc.AddExpression("cube(2) + 1", "test_user", "", 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 2) {
+		byOp := map[string]*models.Task{}
+		for _, task := range tasks {
+			byOp[task.Operation] = task
+		}
+		assert.Equal(t, 30, byOp["cube"].OperationTime)
+		assert.Equal(t, 10, byOp["+"].OperationTime)
+	}
+}
+
+func TestListAgents_Alive(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("ListAgents").Return([]*models.Agent{
+		{ID: "fresh", LastSeen: time.Now().Add(-time.Second)},
+		{ID: "stale", LastSeen: time.Now().Add(-4 * service.HeartbeatInterval)},
+	}, nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	agents, err := orc.ListAgents()
+	assert.NoError(t, err)
+	if assert.Len(t, agents, 2) {
+		assert.True(t, agents[0].Alive)
+		assert.False(t, agents[1].Alive)
+	}
+}
+
+func TestRegisterAgent_RequiresID(t *testing.T) {
+	orc := service.NewOrchestrator(10, 10, 10, 10, new(MockRepository))
+	assert.Error(t, orc.RegisterAgent(&models.Agent{Hostname: "host"}))
+}
+
+func TestSubmitResult_LeaseLost(t *testing.T) {
+	mockRepo := new(MockRepository)
+	taskID := "11111111-2222-3333-4444-555555555555-1"
+	result := &models.TaskResult{Value: 5}
+	mockRepo.On("UpdateTaskResults", []repository.ResultUpdate{{TaskID: taskID, LeaseID: "stale-lease", Result: result, Tolerance: service.DefaultVerifyTolerance}}).
+		Return([]repository.ResultStatus{{Updated: false, Status: repository.TaskStatusCompleted}}, nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	ok, err := orc.SubmitResult(taskID, "stale-lease", result, nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	// выражение не трогаем: результат от чужой аренды отброшен
+	mockRepo.AssertNotCalled(t, "AreAllTasksCompleted", mock.Anything)
+}
+
+func TestSubmitResults_Batch(t *testing.T) {
+	mockRepo := new(MockRepository)
+	exprID := "11111111-2222-3333-4444-555555555555"
+	updates := []repository.ResultUpdate{
+		{TaskID: exprID + "-1", LeaseID: "l1", Result: &models.TaskResult{Value: 1}},
+		{TaskID: exprID + "-2", LeaseID: "l2"}, // пустой результат
+		{TaskID: exprID + "-3", LeaseID: "stale", Result: &models.TaskResult{Value: 3}},
+		{TaskID: exprID + "-4", LeaseID: "l4", Result: &models.TaskResult{Value: 4}},
+		{TaskID: exprID + "-5", LeaseID: "l5", Result: &models.TaskResult{Value: 5}}, // повтор из outbox агента
+	}
+	expected := []repository.ResultUpdate{updates[0], updates[2], updates[3], updates[4]}
+	for i := range expected {
+		expected[i].Tolerance = service.DefaultVerifyTolerance
+	}
+	mockRepo.On("UpdateTaskResults", expected).
+		Return([]repository.ResultStatus{
+			{Updated: true, Status: repository.TaskStatusCompleted},
+			{Updated: false, Status: repository.TaskStatusCompleted},
+			{Updated: true, Status: repository.TaskStatusCompleted},
+			{Duplicate: true, Status: repository.TaskStatusCompleted},
+		}, nil)
+	mockRepo.On("ReadyDependents", mock.Anything).Return([]*models.Task{}, nil).Twice()
+	// две задачи одного выражения — итог проверяется один раз
+	mockRepo.On("AreAllTasksCompleted", exprID).Return(false, nil).Once()
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	accepted, err := orc.SubmitResults(updates)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false, false, true, true}, accepted)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSubmitResults_RetryAndDead(t *testing.T) {
+	mockRepo := new(MockRepository)
+	exprID := "11111111-2222-3333-4444-555555555555"
+	internalErr := models.NewTaskError(models.ErrInternalError, "agent crashed")
+	divErr := models.NewTaskError(models.ErrDivisionByZero, "division by zero")
+	policy := repository.RetryPolicy{MaxAttempts: 2, Backoff: 10 * time.Millisecond}
+	requeued := &models.Task{ID: exprID + "-1", Operation: "+", UserLogin: "alice", RetryAt: time.Now().Add(50 * time.Millisecond)}
+
+	// временная ошибка повторяется по политике, деление на ноль — нет
+	mockRepo.On("UpdateTaskResults", []repository.ResultUpdate{
+		{TaskID: exprID + "-1", LeaseID: "l1", Err: internalErr, Retry: &policy, Tolerance: service.DefaultVerifyTolerance},
+		{TaskID: exprID + "-2", LeaseID: "l2", Err: divErr, Tolerance: service.DefaultVerifyTolerance},
+	}).Return([]repository.ResultStatus{
+		{Updated: true, Status: repository.TaskStatusPending, Requeued: requeued},
+		{Updated: true, Status: string(models.ErrDivisionByZero)},
+	}, nil).Once()
+	mockRepo.On("UpdateExpression", exprID, string(models.ErrDivisionByZero), (*models.TaskResult)(nil)).Return(true, nil).Once()
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo).WithRetryPolicy(policy)
+	ready := orc.TasksReady()
+	accepted, err := orc.SubmitResults([]repository.ResultUpdate{
+		{TaskID: exprID + "-1", LeaseID: "l1", Err: internalErr},
+		{TaskID: exprID + "-2", LeaseID: "l2", Err: divErr},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true}, accepted)
+
+	// задача возвращается в очередь только после паузы
+	mockRepo.On("ClaimTasks", "agent-1", []string{exprID + "-1"}, service.LeaseSlack).
+		Return([]*models.Task{{ID: exprID + "-1"}}, nil).Once()
+	tasks, err := orc.GetTasks("agent-1", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("retried task was not requeued")
+	}
+	tasks, err = orc.GetTasks("agent-1", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+
+	// попытки исчерпаны: выражение завершается исходной ошибкой
+	mockRepo.On("UpdateTaskResults", []repository.ResultUpdate{
+		{TaskID: exprID + "-1", LeaseID: "l3", Err: internalErr, Retry: &policy, Tolerance: service.DefaultVerifyTolerance},
+	}).Return([]repository.ResultStatus{{Updated: true, Status: repository.TaskStatusDead}}, nil).Once()
+	mockRepo.On("UpdateExpression", exprID, string(models.ErrInternalError), (*models.TaskResult)(nil)).Return(true, nil).Once()
+	ok, err := orc.SubmitResult(exprID+"-1", "l3", nil, internalErr)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetTasks_ClampsBatchSize(t *testing.T) {
+	mockRepo := new(MockRepository)
+	ready := make([]*models.Task, 150)
+	for i := range ready {
+		ready[i] = &models.Task{ID: fmt.Sprintf("t%d", i), Operation: "+", Mode: models.ModeReal}
+	}
+	mockRepo.On("ReadyTasks").Return(ready, nil)
+	mockRepo.On("ClaimTasks", "agent-1", mock.MatchedBy(func(ids []string) bool { return len(ids) == service.MaxBatchSize }), service.LeaseSlack).
+		Return([]*models.Task{{ID: "t0"}}, nil).Once()
+	// 99 задач уже забраны: очередь берёт следующих кандидатов
+	mockRepo.On("ClaimTasks", "agent-1", mock.MatchedBy(func(ids []string) bool { return len(ids) == 50 }), service.LeaseSlack).
+		Return([]*models.Task{}, nil).Once()
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	assert.NoError(t, orc.LoadReadyQueue())
+	tasks, err := orc.GetTasks("agent-1", models.Capabilities{}, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetTasks_ReadyQueue(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("AddExpression", mock.Anything).Return(nil)
+	mockRepo.On("AddTask", mock.Anything).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	exprID, err := orc.AddExpression("(1 + 2) * fact(3)", "user", models.ModeInteger, 0, false)
+	assert.NoError(t, err)
+
+	// задачи: -1 — сложение, -2 — fact, -3 — умножение, ждущее обе.
+	// Агент без fact получает только сложение.
+	plusOrMul := models.Capabilities{Operations: []string{"+", "*"}}
+	assert.Equal(t, 1, orc.QueueDepth(plusOrMul))
+	assert.Equal(t, 2, orc.QueueDepth(models.Capabilities{}))
+	mockRepo.On("ClaimTasks", "agent-1", []string{exprID + "-1"}, service.LeaseSlack).
+		Return([]*models.Task{}, fmt.Errorf("database is locked")).Once()
+	_, err = orc.GetTasks("agent-1", plusOrMul, 10)
+	assert.Error(t, err)
+
+	// после ошибки БД задача вернулась в очередь
+	mockRepo.On("ClaimTasks", "agent-1", []string{exprID + "-1"}, service.LeaseSlack).
+		Return([]*models.Task{{ID: exprID + "-1"}}, nil).Once()
+	tasks, err := orc.GetTasks("agent-1", plusOrMul, 10)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+
+	tasks, err = orc.GetTasks("agent-1", plusOrMul, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+	assert.Equal(t, 0, orc.QueueDepth(plusOrMul))
+
+	// результат fact делает готовым умножение
+	mockRepo.On("ClaimTasks", "agent-2", []string{exprID + "-2"}, service.LeaseSlack).
+		Return([]*models.Task{{ID: exprID + "-2"}}, nil).Once()
+	tasks, err = orc.GetTasks("agent-2", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+
+	mockRepo.On("UpdateTaskResults", mock.Anything).
+		Return([]repository.ResultStatus{{Updated: true, Status: repository.TaskStatusCompleted}}, nil)
+	mockRepo.On("ReadyDependents", exprID+"-2").
+		Return([]*models.Task{{ID: exprID + "-3", Operation: "*", Mode: models.ModeInteger}}, nil)
+	mockRepo.On("AreAllTasksCompleted", exprID).Return(false, nil)
+	_, err = orc.SubmitResult(exprID+"-2", "l2", &models.TaskResult{Value: 6}, nil)
+	assert.NoError(t, err)
+
+	mockRepo.On("ClaimTasks", "agent-1", []string{exprID + "-3"}, service.LeaseSlack).
+		Return([]*models.Task{{ID: exprID + "-3"}}, nil).Once()
+	tasks, err = orc.GetTasks("agent-1", plusOrMul, 10)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestVerification(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("AddExpression", mock.MatchedBy(func(e *models.Expression) bool { return e.Verify })).Return(nil)
+	mockRepo.On("AddTask", mock.MatchedBy(func(task *models.Task) bool { return task.Verify })).Return(nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo).WithVerification([]string{"bank"}, 0)
+	exprID, err := orc.AddExpression("2 + 3", "bank", "", 0, false)
+	assert.NoError(t, err)
+	taskID := exprID + "-1"
+
+	mockRepo.On("ClaimTasks", "agent-1", []string{taskID}, service.LeaseSlack).
+		Return([]*models.Task{{ID: taskID}}, nil).Once()
+	tasks, err := orc.GetTasks("agent-1", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+
+	// первый голос: задача возвращается в очередь для другого агента
+	mockRepo.On("UpdateTaskResults", mock.MatchedBy(func(updates []repository.ResultUpdate) bool {
+		return updates[0].Tolerance == service.DefaultVerifyTolerance
+	})).Return([]repository.ResultStatus{{
+		Updated:  true,
+		Status:   repository.TaskStatusPending,
+		Requeued: &models.Task{ID: taskID, UserLogin: "bank", Operation: "+", Mode: models.ModeReal, Verify: true, Voters: []string{"agent-1"}},
+	}}, nil).Once()
+	accepted, err := orc.SubmitResult(taskID, "l1", &models.TaskResult{Value: 5}, nil)
+	assert.NoError(t, err)
+	assert.True(t, accepted)
+
+	tasks, err = orc.GetTasks("agent-1", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+	// голос агента без ID нельзя отличить от других: задача ему не выдаётся
+	tasks, err = orc.GetTasks("", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	mockRepo.On("ClaimTasks", "agent-2", []string{taskID}, service.LeaseSlack).
+		Return([]*models.Task{{ID: taskID}}, nil).Once()
+	tasks, err = orc.GetTasks("agent-2", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetTasks_FairShare(t *testing.T) {
+	mockRepo := new(MockRepository)
+	var ready []*models.Task
+	for i := 1; i <= 6; i++ {
+		ready = append(ready, &models.Task{ID: fmt.Sprintf("b%d", i), UserLogin: "nightly", Operation: "+", Mode: models.ModeReal})
+	}
+	ready = append(ready,
+		&models.Task{ID: "a1", UserLogin: "alice", Operation: "+", Mode: models.ModeReal},
+		&models.Task{ID: "a2", UserLogin: "alice", Operation: "*", Mode: models.ModeReal},
+		&models.Task{ID: "a3", UserLogin: "alice", Operation: "+", Mode: models.ModeReal, Priority: 5},
+	)
+	mockRepo.On("ReadyTasks").Return(ready, nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo).WithUserWeights(map[string]int{"alice": 2})
+	assert.NoError(t, orc.LoadReadyQueue())
+
+	nextIDs := func(stats *models.QueueStats) []string {
+		var ids []string
+		for _, task := range stats.Next {
+			ids = append(ids, task.TaskID)
+		}
+		return ids
+	}
+
+	// alice с весом 2 получает две задачи на одну задачу nightly, хотя nightly
+	// поставила задачи раньше; a3 с высоким приоритетом идёт первой среди задач alice
+	assert.Equal(t, []string{"b1", "a3", "a1", "b2", "a2", "b3", "b4", "b5", "b6"}, nextIDs(orc.QueueStats(20)))
+
+	mockRepo.On("ClaimTasks", "agent-1", []string{"b1", "a3", "a1", "b2"}, service.LeaseSlack).
+		Return([]*models.Task{{ID: "b1"}, {ID: "a3"}, {ID: "a1"}, {ID: "b2"}}, nil).Once()
+	tasks, err := orc.GetTasks("agent-1", models.Capabilities{}, 4)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 4)
+
+	stats := orc.QueueStats(2)
+	assert.Equal(t, []string{"a2", "b3"}, nextIDs(stats))
+	assert.Equal(t, []models.UserQueueStats{
+		{Login: "alice", Weight: 2, Queued: 1, Dispatched: 2, VirtualTime: 1},
+		{Login: "nightly", Weight: 1, Queued: 4, Dispatched: 2, VirtualTime: 2},
+	}, stats.Users)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRunLeaseReaper(t *testing.T) {
+	mockRepo := new(MockRepository)
+	requeued := make(chan struct{}, 10)
+	mockRepo.On("RequeueExpiredTasks", mock.AnythingOfType("time.Time"), service.DefaultRetryPolicy.MaxAttempts).
+		Run(func(mock.Arguments) { requeued <- struct{}{} }).
+		Return([]*models.Task{{ID: "t1"}}, []string(nil), nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go orc.RunLeaseReaper(ctx, 10*time.Millisecond)
+
+	select {
+	case <-requeued:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not run")
+	}
+}
+
+func TestRequeueExpiredTasks_Dead(t *testing.T) {
+	mockRepo := new(MockRepository)
+	exprID := "11111111-2222-3333-4444-555555555555"
+	policy := repository.RetryPolicy{MaxAttempts: 2}
+	mockRepo.On("RequeueExpiredTasks", mock.AnythingOfType("time.Time"), 2).
+		Return([]*models.Task{}, []string{exprID + "-1"}, nil).Once()
+	mockRepo.On("UpdateExpression", exprID, string(models.ErrInternalError), (*models.TaskResult)(nil)).Return(true, nil).Once()
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo).WithRetryPolicy(policy)
+	n, err := orc.RequeueExpiredTasks()
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestReleaseTasks(t *testing.T) {
+	mockRepo := new(MockRepository)
+	leases := []repository.TaskLease{{TaskID: "t1", LeaseID: "l1"}, {TaskID: "t2", LeaseID: "stale"}}
+	mockRepo.On("ReleaseTasks", "agent-1", leases).
+		Return([]*models.Task{{ID: "t1", Operation: "+", UserLogin: "alice"}, nil}, nil)
+	mockRepo.On("ClaimTasks", "agent-2", []string{"t1"}, service.LeaseSlack).
+		Return([]*models.Task{{ID: "t1"}}, nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	success, err := orc.ReleaseTasks("agent-1", leases)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, success)
+
+	// возвращённая задача сразу доступна другому агенту
+	tasks, err := orc.GetTasks("agent-2", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestExpressionTasks(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetExpressionByIDAndOwner", "expr", "alice").Return(&models.Expression{ID: "expr", Owner: "alice"}, true, nil)
+	mockRepo.On("GetExpressionByIDAndOwner", "expr", "bob").Return((*models.Expression)(nil), false, nil)
+	mockRepo.On("ExpressionTasks", "expr").Return([]*models.ExpressionTask{{ID: "expr-1"}}, nil)
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	tasks, ok, err := orc.ExpressionTasks("expr", "alice")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, tasks, 1)
+
+	// чужое выражение не раскрывается
+	_, ok, err = orc.ExpressionTasks("expr", "bob")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	mockRepo.AssertNumberOfCalls(t, "ExpressionTasks", 1)
+}
+
+func TestEnrollAgent(t *testing.T) {
+	mockRepo := new(MockRepository)
+	var tokenHash, credentialHash string
+	mockRepo.On("CreateJoinToken", mock.Anything, "admin", mock.Anything).
+		Run(func(args mock.Arguments) { tokenHash = args.String(0) }).Return(nil)
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+
+	_, err := orc.CreateJoinToken("admin", 0)
+	assert.Error(t, err)
+	token, err := orc.CreateJoinToken("admin", time.Minute)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.Token)
+	// в БД попадает только хэш токена
+	assert.NotEqual(t, token.Token, tokenHash)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), token.ExpiresAt, time.Second)
+
+	mockRepo.On("EnrollAgent", tokenHash, "agent-1", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { credentialHash = args.String(2) }).Return(nil)
+	mockRepo.On("EnrollAgent", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(repository.ErrInvalidJoinToken)
+	agentID, credential, err := orc.EnrollAgent(token.Token, "agent-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "agent-1", agentID)
+	assert.NotEqual(t, credential, credentialHash)
+
+	_, _, err = orc.EnrollAgent("forged", "agent-2")
+	assert.ErrorIs(t, err, repository.ErrInvalidJoinToken)
+
+	mockRepo.On("AgentCredentialValid", "agent-1", credentialHash).Return(true, nil)
+	mockRepo.On("AgentCredentialValid", "agent-1", mock.Anything).Return(false, nil)
+	ok, err := orc.VerifyAgentCredential("agent-1", credential)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = orc.VerifyAgentCredential("agent-1", "guess")
+	assert.False(t, ok)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCheckAgentHealth(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("AgentHealth", mock.Anything).Return([]*models.AgentHealth{
+		// мало попыток для решения
+		{AgentID: "new", Attempts: 3, InternalErrors: 3, Score: 0},
+		{AgentID: "flaky", Attempts: 20, InternalErrors: 8, LeaseExpired: 4, Score: 0.4},
+		{AgentID: "good", Attempts: 20, InternalErrors: 1, Score: 0.95},
+	}, nil).Once()
+	mockRepo.On("QuarantineAgent", "flaky", mock.MatchedBy(func(reason string) bool {
+		return strings.Contains(reason, "8 internal errors, 4 expired leases")
+	}), mock.Anything).Return(true, nil).Once()
+	mockRepo.On("ReadyTasks").Return([]*models.Task{{ID: "t1", Operation: "+", Mode: models.ModeReal}}, nil)
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo).
+		WithHealthPolicy(service.HealthPolicy{MinScore: 0.5, MinAttempts: 10, Window: time.Minute})
+	assert.NoError(t, orc.LoadReadyQueue())
+	assert.NoError(t, orc.CheckAgentHealth())
+
+	// агент на карантине задач не получает, задача достаётся другому
+	tasks, err := orc.GetTasks("flaky", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+	mockRepo.On("ClaimTasks", "good", []string{"t1"}, service.LeaseSlack).Return([]*models.Task{{ID: "t1"}}, nil).Once()
+	tasks, err = orc.GetTasks("good", models.Capabilities{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+
+	mockRepo.On("UnquarantineAgent", "flaky", mock.Anything).Return(true, nil).Once()
+	mockRepo.On("UnquarantineAgent", "good", mock.Anything).Return(false, nil).Once()
+	released, err := orc.ReleaseQuarantine("flaky")
+	assert.NoError(t, err)
+	assert.True(t, released)
+	released, err = orc.ReleaseQuarantine("good")
+	assert.NoError(t, err)
+	assert.False(t, released)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCancelExpression(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("ReadyTasks").Return([]*models.Task{
+		{ID: "e1-1", Operation: "+", Mode: models.ModeReal, UserLogin: "user"},
+		{ID: "e2-1", Operation: "+", Mode: models.ModeReal, UserLogin: "user"},
+	}, nil)
+	mockRepo.On("CancelExpression", "e1", "user", mock.Anything).
+		Return(true, map[string][]string{"agent-1": {"e1-2", "e1-3"}}, nil).Once()
+	mockRepo.On("CancelExpression", "e2", "user", mock.Anything).
+		Return(true, nil, repository.ErrExpressionFinished).Once()
+	mockRepo.On("CancelExpression", "e3", "user", mock.Anything).Return(false, nil, nil).Once()
+
+	orc := service.NewOrchestrator(10, 10, 10, 10, mockRepo)
+	assert.NoError(t, orc.LoadReadyQueue())
+	cancelled := orc.Cancellations()
+
+	found, err := orc.CancelExpression("e1", "user")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("cancellation was not signalled")
+	}
+	// задачи отменённого выражения из очереди убраны
+	assert.Equal(t, 1, orc.QueueDepth(models.Capabilities{}))
+	assert.Equal(t, []string{"e1-2", "e1-3"}, orc.TakeCancellations("agent-1"))
+	assert.Empty(t, orc.TakeCancellations("agent-1"))
+
+	_, err = orc.CancelExpression("e2", "user")
+	assert.ErrorIs(t, err, repository.ErrExpressionFinished)
+	found, err = orc.CancelExpression("e3", "user")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestFormatResult(t *testing.T) {
+	v := 255.0
+	out, err := service.FormatResult(&models.Expression{Result: &v}, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, "ff", out)
+
+	out, err = service.FormatResult(&models.Expression{ResultText: "18446744073709551616"}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "1"+strings.Repeat("0", 64), out)
+
+	frac := 2.5
+	_, err = service.FormatResult(&models.Expression{Result: &frac}, 8)
+	assert.Error(t, err)
+
+	_, err = service.FormatResult(&models.Expression{Result: &v, ResultType: models.TypeDuration}, 16)
+	assert.Error(t, err)
+
+	lower, upper := 1.0, 3.0
+	_, err = service.FormatResult(&models.Expression{Result: &v, ResultLower: &lower, ResultUpper: &upper}, 2)
+	assert.Error(t, err)
+}
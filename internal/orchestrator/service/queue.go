@@ -0,0 +1,318 @@
+package service
+
+import (
+	"calculator_app/internal/pkg/models"
+	"container/heap"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// readyQueue — очередь готовых к выдаче задач в памяти. Источник истины — БД:
+// очередь только подсказывает, какие задачи пытаться захватить, захват всё равно
+// проверяет статус задачи в SQLite.
+//
+// Между пользователями задачи делятся взвешенной справедливой очередью (stride
+// scheduling): у каждого пользователя есть виртуальное время pass, которое растёт
+// на 1/вес с каждой выданной задачей, и следующей выдаётся задача пользователя
+// с наименьшим pass. Поэтому большое выражение одного пользователя не задерживает
+// остальных. Внутри пользователя задачи упорядочены по приоритету, затем по порядку
+// постановки в очередь, и разложены по кучам на каждую пару (операция, режим), чтобы
+// выбор под возможности агента не перебирал все задачи.
+type readyQueue struct {
+	mu      sync.Mutex
+	seq     uint64
+	queued  map[string]bool
+	users   map[string]*userQueue
+	weights map[string]int
+	// vtime — pass последнего обслуженного пользователя. Пользователь, у которого
+	// появились задачи после простоя, начинает с vtime и не копит долг за простой.
+	vtime float64
+}
+
+type userQueue struct {
+	login      string
+	heaps      map[queueKey]*taskHeap
+	size       int
+	pass       float64
+	dispatched int64
+}
+
+type queueKey struct {
+	operation string
+	mode      string
+}
+
+type queuedTask struct {
+	id       string
+	user     string
+	key      queueKey
+	priority int
+	// seq — порядок постановки в очередь; при равном приоритете меньший выдаётся раньше
+	seq uint64
+	// voters — агенты, уже выполнившие задачу с верификацией; им она не выдаётся,
+	// как и агенту без ID (см. repository.ClaimTasks)
+	verify bool
+	voters []string
+}
+
+func newReadyQueue() *readyQueue {
+	return &readyQueue{
+		queued:  make(map[string]bool),
+		users:   make(map[string]*userQueue),
+		weights: make(map[string]int),
+	}
+}
+
+// SetWeights задаёт веса пользователей; у пользователей без веса он равен 1.
+func (q *readyQueue) SetWeights(weights map[string]int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.weights = make(map[string]int, len(weights))
+	for login, w := range weights {
+		if w > 0 {
+			q.weights[login] = w
+		}
+	}
+}
+
+func (q *readyQueue) weight(login string) int {
+	if w, ok := q.weights[login]; ok {
+		return w
+	}
+	return 1
+}
+
+// Push ставит задачи в очередь; задачи, которые уже в очереди, пропускаются.
+func (q *readyQueue) Push(tasks ...*models.Task) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, task := range tasks {
+		q.seq++
+		q.push(&queuedTask{
+			id:       task.ID,
+			user:     task.UserLogin,
+			key:      queueKey{task.Operation, task.Mode},
+			priority: task.Priority,
+			seq:      q.seq,
+			verify:   task.Verify,
+			voters:   task.Voters,
+		})
+	}
+}
+
+// Restore возвращает в очередь извлечённые задачи на их прежние места
+// и возвращает пользователям потраченную на них долю.
+func (q *readyQueue) Restore(entries []*queuedTask) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, e := range entries {
+		q.push(e)
+		u := q.users[e.user]
+		u.pass -= 1 / float64(q.weight(e.user))
+		u.dispatched--
+	}
+}
+
+func (q *readyQueue) push(e *queuedTask) {
+	if q.queued[e.id] {
+		return
+	}
+	u, ok := q.users[e.user]
+	if !ok {
+		u = &userQueue{login: e.user, heaps: make(map[queueKey]*taskHeap)}
+		q.users[e.user] = u
+	}
+	if u.size == 0 && u.pass < q.vtime {
+		u.pass = q.vtime
+	}
+	h, ok := u.heaps[e.key]
+	if !ok {
+		h = &taskHeap{}
+		u.heaps[e.key] = h
+	}
+	heap.Push(h, e)
+	u.size++
+	q.queued[e.id] = true
+}
+
+// Pop извлекает до n задач, подходящих под возможности агента agentID, в порядке выдачи.
+func (q *readyQueue) Pop(agentID string, capabilities models.Capabilities, n int) []*queuedTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pop(agentID, capabilities, n)
+}
+
+func (q *readyQueue) pop(agentID string, capabilities models.Capabilities, n int) []*queuedTask {
+	var entries, skipped []*queuedTask
+	for len(entries) < n {
+		var bestUser *userQueue
+		var best *taskHeap
+		for _, u := range q.users {
+			if u.size == 0 {
+				continue
+			}
+			h := u.head(capabilities)
+			if h == nil {
+				continue
+			}
+			if bestUser == nil || u.pass < bestUser.pass ||
+				(u.pass == bestUser.pass && (*h)[0].seq < (*best)[0].seq) {
+				bestUser, best = u, h
+			}
+		}
+		if bestUser == nil {
+			break
+		}
+
+		e := heap.Pop(best).(*queuedTask)
+		delete(q.queued, e.id)
+		bestUser.size--
+		// результат этого агента уже учтён: задача ждёт другого, доля не тратится
+		if e.verify && (agentID == "" || slices.Contains(e.voters, agentID)) {
+			skipped = append(skipped, e)
+			continue
+		}
+		q.vtime = bestUser.pass
+		bestUser.pass += 1 / float64(q.weight(bestUser.login))
+		bestUser.dispatched++
+		entries = append(entries, e)
+	}
+	for _, e := range skipped {
+		q.push(e)
+	}
+	return entries
+}
+
+// RemoveExpression убирает из очереди задачи выражения exprID.
+func (q *readyQueue) RemoveExpression(exprID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	prefix := exprID + "-"
+	for _, u := range q.users {
+		for _, h := range u.heaps {
+			kept := (*h)[:0]
+			for _, e := range *h {
+				if strings.HasPrefix(e.id, prefix) {
+					delete(q.queued, e.id)
+					u.size--
+					continue
+				}
+				kept = append(kept, e)
+			}
+			*h = kept
+			heap.Init(h)
+		}
+	}
+}
+
+// Depth — сколько задач в очереди подходят под возможности агента.
+func (q *readyQueue) Depth(capabilities models.Capabilities) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	depth := 0
+	for _, u := range q.users {
+		for key, h := range u.heaps {
+			if matches(capabilities, key) {
+				depth += h.Len()
+			}
+		}
+	}
+	return depth
+}
+
+// head возвращает кучу с первой по порядку задачей пользователя среди подходящих агенту.
+func (u *userQueue) head(capabilities models.Capabilities) *taskHeap {
+	var best *taskHeap
+	for key, h := range u.heaps {
+		if h.Len() == 0 || !matches(capabilities, key) {
+			continue
+		}
+		if best == nil || before((*h)[0], (*best)[0]) {
+			best = h
+		}
+	}
+	return best
+}
+
+// Stats возвращает доли пользователей и первые limit задач в порядке, в котором
+// их получил бы агент без ограничений по возможностям. Очередь не меняется.
+func (q *readyQueue) Stats(limit int) *models.QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := &models.QueueStats{
+		Users: make([]models.UserQueueStats, 0, len(q.users)),
+		Next:  make([]models.QueuedTask, 0, limit),
+	}
+	for _, u := range q.users {
+		stats.Users = append(stats.Users, models.UserQueueStats{
+			Login:       u.login,
+			Weight:      q.weight(u.login),
+			Queued:      u.size,
+			Dispatched:  u.dispatched,
+			VirtualTime: u.pass,
+		})
+	}
+	sort.Slice(stats.Users, func(i, j int) bool { return stats.Users[i].Login < stats.Users[j].Login })
+
+	// пробная выдача с откатом состояния
+	vtime := q.vtime
+	saved := make(map[string]userQueue, len(q.users))
+	for login, u := range q.users {
+		saved[login] = *u
+	}
+	entries := q.pop("", models.Capabilities{}, limit)
+	for _, e := range entries {
+		stats.Next = append(stats.Next, models.QueuedTask{
+			TaskID:    e.id,
+			UserLogin: e.user,
+			Priority:  e.priority,
+			Operation: e.key.operation,
+			Mode:      e.key.mode,
+		})
+		q.push(e)
+	}
+	for login, u := range q.users {
+		s := saved[login]
+		u.pass, u.dispatched = s.pass, s.dispatched
+	}
+	q.vtime = vtime
+
+	return stats
+}
+
+func matches(capabilities models.Capabilities, key queueKey) bool {
+	if len(capabilities.Operations) > 0 && !slices.Contains(capabilities.Operations, key.operation) {
+		return false
+	}
+	return len(capabilities.Modes) == 0 || slices.Contains(capabilities.Modes, key.mode)
+}
+
+// before — порядок задач внутри пользователя: выше приоритет, затем раньше в очереди.
+func before(a, b *queuedTask) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
+
+type taskHeap []*queuedTask
+
+func (h taskHeap) Len() int           { return len(h) }
+func (h taskHeap) Less(i, j int) bool { return before(h[i], h[j]) }
+func (h taskHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x any) { *h = append(*h, x.(*queuedTask)) }
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
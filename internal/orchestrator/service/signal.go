@@ -0,0 +1,27 @@
+package service
+
+import "sync"
+
+// taskSignal будит всех ожидающих, когда появляются задачи для выдачи.
+// Канал из Wait закрывается при следующем Broadcast, после чего заменяется новым.
+type taskSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newTaskSignal() *taskSignal {
+	return &taskSignal{ch: make(chan struct{})}
+}
+
+func (s *taskSignal) Wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+func (s *taskSignal) Broadcast() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.ch)
+	s.ch = make(chan struct{})
+}
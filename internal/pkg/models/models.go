@@ -2,24 +2,227 @@ package models
 
 import "time"
 
+// Режимы вычисления выражения.
+const (
+	ModeReal     = "real"
+	ModeInteger  = "integer"
+	ModeComplex  = "complex"
+	ModeInterval = "interval"
+)
+
+var Modes = []string{ModeReal, ModeInteger, ModeComplex, ModeInterval}
+
 type Expression struct {
-	ID     string   `json:"id"`
-	Status string   `json:"status"`
-	Result *float64 `json:"result"`
-	Owner  string   `json:"owner"`
+	ID          string   `json:"id"`
+	Status      string   `json:"status"`
+	Result      *float64 `json:"result"`
+	ResultImag  *float64 `json:"result_imag,omitempty"`
+	ResultLower *float64 `json:"result_lower,omitempty"`
+	ResultUpper *float64 `json:"result_upper,omitempty"`
+	ResultText  string   `json:"result_text,omitempty"`
+	ResultType  string   `json:"result_type,omitempty"`
+	Formatted   string   `json:"formatted,omitempty"`
+	Owner       string   `json:"owner"`
+	Mode        string   `json:"mode"`
+	// Priority упорядочивает выражения одного пользователя: больше — раньше
+	Priority int `json:"priority"`
+	// Verify — каждая задача выражения выполняется двумя разными агентами
+	Verify bool `json:"verify,omitempty"`
 }
 
 type Task struct {
-	ID            string    `json:"id"`
-	Arg1          float64   `json:"arg1"`
-	Arg2          float64   `json:"arg2"`
-	Operation     string    `json:"operation"`
-	OperationTime int       `json:"operation_time"`
-	Result        *float64  `json:"result"`
-	DependsOn     []string  `json:"depends_on"`
-	UserLogin     string    `json:"user_login"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	Status        string    `json:"status"`
+	ID            string       `json:"id"`
+	Arg1          float64      `json:"arg1"`
+	Arg2          float64      `json:"arg2"`
+	Arg1Text      string       `json:"arg1_text,omitempty"`
+	Arg2Text      string       `json:"arg2_text,omitempty"`
+	Arg1Imag      float64      `json:"arg1_imag,omitempty"`
+	Arg2Imag      float64      `json:"arg2_imag,omitempty"`
+	Arg1Upper     float64      `json:"arg1_upper,omitempty"`
+	Arg2Upper     float64      `json:"arg2_upper,omitempty"`
+	Arg1Type      string       `json:"arg1_type,omitempty"`
+	Arg2Type      string       `json:"arg2_type,omitempty"`
+	Operation     string       `json:"operation"`
+	Mode          string       `json:"mode"`
+	OperationTime int          `json:"operation_time"`
+	Result        *float64     `json:"result"`
+	ResultImag    float64      `json:"result_imag,omitempty"`
+	ResultUpper   *float64     `json:"result_upper,omitempty"`
+	ResultType    string       `json:"result_type,omitempty"`
+	ResultText    string       `json:"result_text,omitempty"`
+	Dependencies  []Dependency `json:"dependencies,omitempty"`
+	UserLogin     string       `json:"user_login"`
+	Priority      int          `json:"priority"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+	Status        string       `json:"status"`
+	// LeaseID выдаётся при захвате задачи; результат принимается только с ним
+	// и только пока аренда не истекла и задача не передана другому агенту.
+	LeaseID        string    `json:"lease_id,omitempty"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at,omitempty"`
+	// Attempts — сколько раз задачу выдавали агентам; RetryAt — раньше этого
+	// времени задачу после временной ошибки снова не выдают.
+	Attempts int       `json:"attempts,omitempty"`
+	RetryAt  time.Time `json:"retry_at,omitempty"`
+	// Verify — результат принимается, только когда его подтвердили два агента;
+	// Voters — агенты, чьи результаты уже получены: им задачу повторно не выдают.
+	Verify bool     `json:"verify,omitempty"`
+	Voters []string `json:"-"`
+}
+
+// Позиции аргумента задачи, который вычисляется другой задачей.
+const (
+	ArgLeft  = "left"
+	ArgRight = "right"
+)
+
+// Dependency — ребро графа задач: аргумент Position берётся из результата задачи TaskID.
+type Dependency struct {
+	TaskID   string `json:"task_id"`
+	Position string `json:"position"`
+}
+
+// SetArg подставляет результат зависимости в аргумент position.
+func (t *Task) SetArg(position string, r *TaskResult) {
+	var upper float64
+	if r.Upper != nil {
+		upper = *r.Upper
+	}
+	if position == ArgLeft {
+		t.Arg1, t.Arg1Text, t.Arg1Imag, t.Arg1Upper, t.Arg1Type = r.Value, r.Text, r.Imag, upper, r.Type
+		return
+	}
+	t.Arg2, t.Arg2Text, t.Arg2Imag, t.Arg2Upper, t.Arg2Type = r.Value, r.Text, r.Imag, upper, r.Type
+}
+
+// Agent — зарегистрированный агент. InFlight и Completed — число задач агента
+// в обработке и выполненных, Alive — приходили ли heartbeat в последнее время.
+type Agent struct {
+	ID             string `json:"id"`
+	Hostname       string `json:"hostname"`
+	Version        string `json:"version"`
+	ComputingPower int    `json:"computing_power"`
+	// Labels — метки из конфигурации агента
+	Labels       map[string]string `json:"labels,omitempty"`
+	RegisteredAt time.Time         `json:"registered_at"`
+	LastSeen     time.Time         `json:"last_seen"`
+	InFlight     int               `json:"in_flight"`
+	Completed    int               `json:"completed"`
+	Alive        bool              `json:"alive"`
+}
+
+// AgentHealth — надёжность агента по завершённым попыткам за последнее окно:
+// Score — доля попыток без сбоя (internal_error, истёкшая аренда, расхождение
+// при верификации), 1 — если попыток не было.
+type AgentHealth struct {
+	AgentID          string     `json:"agent_id"`
+	Attempts         int        `json:"attempts"`
+	InternalErrors   int        `json:"internal_errors"`
+	LeaseExpired     int        `json:"lease_expired"`
+	Mismatches       int        `json:"mismatches"`
+	Score            float64    `json:"score"`
+	Quarantined      bool       `json:"quarantined"`
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+}
+
+// JoinToken — одноразовый токен подключения агента. Token показывается только
+// при выпуске: в БД хранится его хэш.
+type JoinToken struct {
+	Token     string    `json:"token"`
+	CreatedBy string    `json:"created_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// EnrolledAgent — агент, подключённый по токену. Ключ отозванного агента
+// (RevokedAt задан) больше не принимается.
+type EnrolledAgent struct {
+	ID         string     `json:"id"`
+	EnrolledBy string     `json:"enrolled_by"`
+	EnrolledAt time.Time  `json:"enrolled_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// TaskAttempt — одна выдача задачи агенту. Outcome — completed, код ошибки
+// или lease_expired; пустой, пока попытка не завершена.
+// StartedAt — время захвата задачи агентом, AgentVersion — версия агента на тот
+// момент, Worker — номер воркера агента (с 1), приславшего результат.
+type TaskAttempt struct {
+	Attempt      int        `json:"attempt"`
+	AgentID      string     `json:"agent_id"`
+	AgentVersion string     `json:"agent_version,omitempty"`
+	Worker       int        `json:"worker,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Outcome      string     `json:"outcome,omitempty"`
+}
+
+// ExpressionTask — подзадача выражения с результатом и историей выдач агентам:
+// по ней неверный результат прослеживается до машины и воркера, которые его посчитали.
+type ExpressionTask struct {
+	ID          string        `json:"id"`
+	Operation   string        `json:"operation"`
+	Mode        string        `json:"mode"`
+	Status      string        `json:"status"`
+	Result      *float64      `json:"result,omitempty"`
+	ResultImag  float64       `json:"result_imag,omitempty"`
+	ResultUpper *float64      `json:"result_upper,omitempty"`
+	ResultType  string        `json:"result_type,omitempty"`
+	ResultText  string        `json:"result_text,omitempty"`
+	Attempts    []TaskAttempt `json:"attempts"`
+}
+
+// DeadTask — задача, исчерпавшая попытки, с историей попыток.
+type DeadTask struct {
+	ID        string        `json:"id"`
+	Operation string        `json:"operation"`
+	Mode      string        `json:"mode"`
+	UserLogin string        `json:"user_login"`
+	Attempts  []TaskAttempt `json:"attempts"`
+}
+
+// QueueStats — состояние очереди готовых задач для администратора: доли
+// пользователей и ближайшие задачи в порядке выдачи.
+type QueueStats struct {
+	Users []UserQueueStats `json:"users"`
+	Next  []QueuedTask     `json:"next"`
+}
+
+// UserQueueStats — доля пользователя в справедливой очереди. VirtualTime растёт
+// на 1/Weight с каждой выданной задачей; следующей выдаётся задача пользователя
+// с наименьшим VirtualTime.
+type UserQueueStats struct {
+	Login       string  `json:"login"`
+	Weight      int     `json:"weight"`
+	Queued      int     `json:"queued"`
+	Dispatched  int64   `json:"dispatched"`
+	VirtualTime float64 `json:"virtual_time"`
+}
+
+type QueuedTask struct {
+	TaskID    string `json:"task_id"`
+	UserLogin string `json:"user_login"`
+	Priority  int    `json:"priority"`
+	Operation string `json:"operation"`
+	Mode      string `json:"mode"`
+}
+
+// Capabilities — операции и режимы вычисления, которые поддерживает агент.
+// Пустой список означает отсутствие ограничений.
+type Capabilities struct {
+	Operations []string
+	Modes      []string
+}
+
+// TaskResult — результат вычисления задачи. Целые числа, не помещающиеся
+// в float64 без потери точности (больше 2^53), передаются строкой в Text.
+// В комплексном режиме Imag содержит мнимую часть, в интервальном Value —
+// нижняя граница, Upper — верхняя. Type — тип значения (дата, длительность или число).
+type TaskResult struct {
+	Value float64
+	Imag  float64
+	Upper *float64
+	Text  string
+	Type  string
 }
 
 type User struct {
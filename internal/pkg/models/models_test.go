@@ -16,3 +16,67 @@ func TestNewTaskErrorAndErrorString(t *testing.T) {
 	expected := "division_by_zero: cannot divide by zero"
 	assert.Equal(t, expected, te.Error())
 }
+
+func TestTransient(t *testing.T) {
+	assert.True(t, models.ErrInternalError.Transient())
+	for _, code := range []models.TaskErrorCode{models.ErrTimeout, models.ErrCancelled, models.ErrDivisionByZero} {
+		assert.False(t, code.Transient(), code)
+	}
+}
+
+func TestResultType(t *testing.T) {
+	tests := []struct {
+		operation, left, right string
+		expected               string
+		wantErr                bool
+	}{
+		{"+", models.TypeDate, models.TypeDuration, models.TypeDate, false},
+		{"-", models.TypeDate, models.TypeDate, models.TypeDuration, false},
+		{"*", models.TypeDuration, models.TypeNumber, models.TypeDuration, false},
+		{"/", models.TypeDuration, models.TypeDuration, models.TypeNumber, false},
+		{"+", models.TypeNumber, models.TypeNumber, models.TypeNumber, false},
+		{"+", models.TypeDate, models.TypeDate, "", true},
+		{"*", models.TypeDate, models.TypeNumber, "", true},
+		{"-", models.TypeDuration, models.TypeDate, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := models.ResultType(tt.operation, tt.left, tt.right)
+		if tt.wantErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, got)
+	}
+}
+
+func TestParseAndFormatDates(t *testing.T) {
+	seconds, err := models.ParseDate("2026-10-18")
+	assert.NoError(t, err)
+	assert.Equal(t, "2027-01-16", models.FormatDate(seconds+90*24*3600))
+	assert.Equal(t, "2026-10-18T03:15:00Z", models.FormatDate(seconds+3*3600+15*60))
+
+	_, err = models.ParseDate("2026-02-30")
+	assert.Error(t, err)
+
+	duration, err := models.ParseDuration("3h15m")
+	assert.NoError(t, err)
+	assert.Equal(t, 11700.0, duration)
+	assert.Equal(t, "13h", models.FormatDuration(duration*4))
+	assert.Equal(t, "1d2h30s", models.FormatDuration(26*3600+30))
+	assert.Equal(t, "-14d", models.FormatDuration(-14*24*3600))
+	assert.Equal(t, "0s", models.FormatDuration(0))
+}
+
+func TestTaskSetArg(t *testing.T) {
+	task := &models.Task{Arg1: 9, Arg2: 9}
+	upper := 4.0
+	task.SetArg(models.ArgRight, &models.TaskResult{Value: 2, Upper: &upper})
+	task.SetArg(models.ArgLeft, &models.TaskResult{Text: "123456789012345678901"})
+
+	assert.Equal(t, 0.0, task.Arg1)
+	assert.Equal(t, "123456789012345678901", task.Arg1Text)
+	assert.Equal(t, 2.0, task.Arg2)
+	assert.Equal(t, 4.0, task.Arg2Upper)
+}
@@ -8,8 +8,27 @@ const (
 	ErrDivisionByZero   TaskErrorCode = "division_by_zero"
 	ErrUnknownOperation TaskErrorCode = "unknown_operation"
 	ErrInternalError    TaskErrorCode = "internal_error"
+	ErrNonInteger       TaskErrorCode = "non_integer_argument"
+	ErrNegativeArgument TaskErrorCode = "negative_argument"
+	ErrArgumentTooLarge TaskErrorCode = "argument_too_large"
+	ErrTypeMismatch     TaskErrorCode = "type_mismatch"
+	// ErrVerificationFailed ставит оркестратор, если агенты так и не сошлись
+	// в результате задачи с верификацией
+	ErrVerificationFailed TaskErrorCode = "verification_failed"
+	// ErrTimeout — агент прервал задачу, не уложившуюся в отведённое время
+	ErrTimeout TaskErrorCode = "timeout"
+	// ErrCancelled — выполнение прервано: пользователь отменил выражение
+	ErrCancelled TaskErrorCode = "cancelled"
 )
 
+// Transient сообщает, что ошибка может не повториться при следующей попытке
+// (сбой агента, а не свойство аргументов), и задачу стоит выполнить заново.
+// timeout не повторяется: задача, не уложившаяся в срок, скорее всего не уложится
+// и снова, а cancelled — решение пользователя.
+func (c TaskErrorCode) Transient() bool {
+	return c == ErrInternalError
+}
+
 type TaskError struct {
 	Code    TaskErrorCode
 	Message string
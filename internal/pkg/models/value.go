@@ -0,0 +1,126 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Типы значений выражения. Даты и длительности передаются числом секунд
+// (дата — Unix-время в UTC), пустой тип означает обычное число.
+const (
+	TypeNumber   = ""
+	TypeDate     = "date"
+	TypeDuration = "duration"
+)
+
+const dateLayout = "2006-01-02"
+
+var (
+	dateLiteral     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	durationLiteral = regexp.MustCompile(`^(\d+(\.\d+)?[wdhms])+$`)
+	durationPart    = regexp.MustCompile(`(\d+(?:\.\d+)?)([wdhms])`)
+)
+
+var durationUnits = map[string]float64{
+	"w": 7 * 24 * 3600,
+	"d": 24 * 3600,
+	"h": 3600,
+	"m": 60,
+	"s": 1,
+}
+
+// ResultType возвращает тип результата операции над аргументами типов left и right
+// или ошибку, если операция для них не определена. У унарных операций right — число.
+func ResultType(operation, left, right string) (string, error) {
+	if left == TypeNumber && right == TypeNumber {
+		return TypeNumber, nil
+	}
+
+	switch {
+	case operation == "+" && left == TypeDate && right == TypeDuration,
+		operation == "+" && left == TypeDuration && right == TypeDate,
+		operation == "-" && left == TypeDate && right == TypeDuration:
+		return TypeDate, nil
+	case operation == "-" && left == TypeDate && right == TypeDate,
+		(operation == "+" || operation == "-") && left == TypeDuration && right == TypeDuration,
+		operation == "*" && left == TypeDuration && right == TypeNumber,
+		operation == "*" && left == TypeNumber && right == TypeDuration,
+		operation == "/" && left == TypeDuration && right == TypeNumber,
+		operation == "abs" && left == TypeDuration:
+		return TypeDuration, nil
+	case operation == "/" && left == TypeDuration && right == TypeDuration:
+		return TypeNumber, nil
+	}
+
+	return "", fmt.Errorf("operation %s is not defined for %s and %s", operation, typeName(left), typeName(right))
+}
+
+func typeName(t string) string {
+	if t == TypeNumber {
+		return "number"
+	}
+	return t
+}
+
+func IsDate(token string) bool {
+	return dateLiteral.MatchString(token)
+}
+
+func IsDuration(token string) bool {
+	return durationLiteral.MatchString(token)
+}
+
+// ParseDate переводит дату вида 2026-10-18 в Unix-время полуночи UTC.
+func ParseDate(token string) (float64, error) {
+	t, err := time.Parse(dateLayout, token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid date: %s", token)
+	}
+	return float64(t.Unix()), nil
+}
+
+// ParseDuration переводит длительность вида 3h15m или 90d в секунды.
+// Единицы: w — недели, d — дни, h — часы, m — минуты, s — секунды.
+func ParseDuration(token string) (float64, error) {
+	if !IsDuration(token) {
+		return 0, fmt.Errorf("invalid duration: %s", token)
+	}
+	var seconds float64
+	for _, part := range durationPart.FindAllStringSubmatch(token, -1) {
+		n, _ := strconv.ParseFloat(part[1], 64)
+		seconds += n * durationUnits[part[2]]
+	}
+	return seconds, nil
+}
+
+// FormatDate записывает дату как 2026-10-18, а если есть время суток — в RFC 3339.
+func FormatDate(seconds float64) string {
+	t := time.Unix(int64(math.Floor(seconds)), 0).UTC()
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format(dateLayout)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// FormatDuration записывает длительность в днях, часах, минутах и секундах: 3h15m, 90d.
+func FormatDuration(seconds float64) string {
+	var b strings.Builder
+	if seconds < 0 {
+		b.WriteByte('-')
+		seconds = -seconds
+	}
+	for _, unit := range []string{"d", "h", "m"} {
+		if n := math.Floor(seconds / durationUnits[unit]); n > 0 {
+			fmt.Fprintf(&b, "%.0f%s", n, unit)
+			seconds -= n * durationUnits[unit]
+		}
+	}
+	if seconds > 0 || b.Len() == 0 || b.String() == "-" {
+		b.WriteString(strconv.FormatFloat(seconds, 'f', -1, 64) + "s")
+	}
+	return b.String()
+}
@@ -0,0 +1,194 @@
+package operations
+
+import (
+	"calculator_app/internal/pkg/models"
+	"context"
+	"math"
+	"time"
+)
+
+// builtin — встроенная операция. Версии для комплексного и интервального режимов
+// необязательны: без них операция допускает только действительные аргументы
+// и вырожденные интервалы соответственно. Операции над большими целыми задают
+// exact вместо scalar: их вычисление прерывается по ctx.
+type builtin struct {
+	name       string
+	arity      int
+	precedence int
+	duration   time.Duration
+	scalar     func(task *models.Task) (*models.TaskResult, error)
+	exact      func(ctx context.Context, task *models.Task) (*models.TaskResult, error)
+	complex    complexFunc
+	interval   intervalFunc
+}
+
+func (op *builtin) Name() string                   { return op.name }
+func (op *builtin) Arity() int                     { return op.arity }
+func (op *builtin) Precedence() int                { return op.precedence }
+func (op *builtin) DefaultDuration() time.Duration { return op.duration }
+
+func (op *builtin) Validate(task *models.Task) error {
+	if task.Arg1Type != models.TypeNumber || task.Arg2Type != models.TypeNumber {
+		if _, err := models.ResultType(op.name, task.Arg1Type, task.Arg2Type); err != nil {
+			return models.NewTaskError(models.ErrTypeMismatch, err.Error())
+		}
+	}
+
+	switch task.Mode {
+	case models.ModeComplex:
+		if op.complex == nil && (task.Arg1Imag != 0 || task.Arg2Imag != 0) {
+			return models.NewTaskError(models.ErrUnknownOperation, "operation is not defined for complex arguments")
+		}
+	case models.ModeInterval:
+		if op.interval == nil && !isDegenerate(task) {
+			return models.NewTaskError(models.ErrUnknownOperation, "operation is not defined for intervals")
+		}
+	case models.ModeInteger:
+		if task.Arg1Text == "" {
+			if _, err := integerArg(task.Arg1, ""); err != nil {
+				return err
+			}
+		}
+		if task.Arg2Text == "" {
+			if _, err := integerArg(task.Arg2, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (op *builtin) Execute(task *models.Task) (*models.TaskResult, error) {
+	return op.ExecuteContext(context.Background(), task)
+}
+
+func (op *builtin) ExecuteContext(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	if err := op.Validate(task); err != nil {
+		return nil, err
+	}
+
+	// даты и длительности передаются секундами, поэтому считаются обычной арифметикой
+	if task.Arg1Type != models.TypeNumber || task.Arg2Type != models.TypeNumber {
+		resultType, _ := models.ResultType(op.name, task.Arg1Type, task.Arg2Type)
+		result, err := op.run(ctx, task)
+		if err != nil {
+			return nil, err
+		}
+		result.Type = resultType
+		return result, nil
+	}
+
+	switch task.Mode {
+	case models.ModeComplex:
+		if op.complex != nil {
+			return executeComplex(op.complex, task)
+		}
+	case models.ModeInterval:
+		if op.interval != nil {
+			lower, upper, err := op.interval(intervalArgs(task))
+			if err != nil {
+				return nil, err
+			}
+			return &models.TaskResult{Value: lower, Upper: &upper}, nil
+		}
+		result, err := op.run(ctx, task)
+		if err != nil {
+			return nil, err
+		}
+		if result.Text == "" {
+			result.Upper = &result.Value
+		}
+		return result, nil
+	}
+
+	return op.run(ctx, task)
+}
+
+func (op *builtin) run(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	if op.exact != nil {
+		return op.exact(ctx, task)
+	}
+	return op.scalar(task)
+}
+
+func init() {
+	for _, op := range []*builtin{
+		{name: "+", arity: 2, precedence: 5, duration: 100 * time.Millisecond,
+			scalar:  arithmetic(func(x, y float64) float64 { return x + y }),
+			complex: complexBinary(func(x, y complex128) complex128 { return x + y }), interval: intervalAdd},
+		{name: "-", arity: 2, precedence: 5, duration: 100 * time.Millisecond,
+			scalar:  arithmetic(func(x, y float64) float64 { return x - y }),
+			complex: complexBinary(func(x, y complex128) complex128 { return x - y }), interval: intervalSubtract},
+		{name: "*", arity: 2, precedence: 6, duration: 200 * time.Millisecond,
+			scalar:  arithmetic(func(x, y float64) float64 { return x * y }),
+			complex: complexBinary(func(x, y complex128) complex128 { return x * y }), interval: intervalMultiply},
+		{name: "/", arity: 2, precedence: 6, duration: 200 * time.Millisecond,
+			scalar: divide, complex: complexDivide, interval: intervalDivide},
+
+		{name: "|", arity: 2, precedence: 1, exact: executeBitwise},
+		{name: "xor", arity: 2, precedence: 2, exact: executeBitwise},
+		{name: "&", arity: 2, precedence: 3, exact: executeBitwise},
+		{name: "<<", arity: 2, precedence: 4, exact: executeBitwise},
+		{name: ">>", arity: 2, precedence: 4, exact: executeBitwise},
+		{name: "~", arity: 1, precedence: 7, exact: executeBitwise},
+
+		{name: "fact", arity: 1, exact: executeIntegerFunction},
+		{name: "isprime", arity: 1, exact: executeIntegerFunction},
+		{name: "fib", arity: 1, exact: executeIntegerFunction},
+		{name: "gcd", arity: 2, exact: executeIntegerFunction},
+		{name: "lcm", arity: 2, exact: executeIntegerFunction},
+		{name: "choose", arity: 2, exact: executeIntegerFunction},
+
+		{name: "sqrt", arity: 1, scalar: realSqrt, complex: complexSqrt, interval: intervalSqrt},
+		{name: "abs", arity: 1, scalar: realFunc(math.Abs), complex: complexAbs, interval: intervalAbs},
+		{name: "re", arity: 1, scalar: realFunc(func(x float64) float64 { return x }), complex: complexRe},
+		{name: "im", arity: 1, scalar: realFunc(func(float64) float64 { return 0 }), complex: complexIm},
+		{name: "arg", arity: 1, scalar: realFunc(realArg), complex: complexArg},
+		{name: "conj", arity: 1, scalar: realFunc(func(x float64) float64 { return x }), complex: complexConj},
+	} {
+		Register(op)
+	}
+}
+
+// arithmetic считает + - * точно над целыми в целочисленном режиме и для аргументов,
+// переданных строкой (больше 2^53), иначе — в float64.
+func arithmetic(f func(x, y float64) float64) func(task *models.Task) (*models.TaskResult, error) {
+	return func(task *models.Task) (*models.TaskResult, error) {
+		if task.Mode == models.ModeInteger || task.Arg1Text != "" || task.Arg2Text != "" {
+			return executeExactArithmetic(task)
+		}
+		return &models.TaskResult{Value: f(task.Arg1, task.Arg2)}, nil
+	}
+}
+
+func divide(task *models.Task) (*models.TaskResult, error) {
+	if task.Mode == models.ModeInteger {
+		return executeExactArithmetic(task)
+	}
+	x, y := floatArg(task.Arg1, task.Arg1Text), floatArg(task.Arg2, task.Arg2Text)
+	if y == 0 {
+		return nil, models.NewTaskError(models.ErrDivisionByZero, "division by zero")
+	}
+	return &models.TaskResult{Value: x / y}, nil
+}
+
+func realFunc(f func(x float64) float64) func(task *models.Task) (*models.TaskResult, error) {
+	return func(task *models.Task) (*models.TaskResult, error) {
+		return &models.TaskResult{Value: f(floatArg(task.Arg1, task.Arg1Text))}, nil
+	}
+}
+
+func realSqrt(task *models.Task) (*models.TaskResult, error) {
+	x := floatArg(task.Arg1, task.Arg1Text)
+	if x < 0 {
+		return nil, models.NewTaskError(models.ErrNegativeArgument, "square root of a negative number")
+	}
+	return &models.TaskResult{Value: math.Sqrt(x)}, nil
+}
+
+func realArg(x float64) float64 {
+	if x < 0 {
+		return math.Pi
+	}
+	return 0
+}
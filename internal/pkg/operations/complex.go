@@ -0,0 +1,45 @@
+package operations
+
+import (
+	"calculator_app/internal/pkg/models"
+	"math/cmplx"
+)
+
+// complexFunc — версия операции для комплексного режима.
+type complexFunc func(x, y complex128) (complex128, error)
+
+func complexDivide(x, y complex128) (complex128, error) {
+	if y == 0 {
+		return 0, models.NewTaskError(models.ErrDivisionByZero, "division by zero")
+	}
+	return x / y, nil
+}
+
+func complexUnary(f func(complex128) complex128) complexFunc {
+	return func(x, _ complex128) (complex128, error) {
+		return f(x), nil
+	}
+}
+
+func complexBinary(f func(x, y complex128) complex128) complexFunc {
+	return func(x, y complex128) (complex128, error) {
+		return f(x, y), nil
+	}
+}
+
+func executeComplex(f complexFunc, task *models.Task) (*models.TaskResult, error) {
+	r, err := f(complex(task.Arg1, task.Arg1Imag), complex(task.Arg2, task.Arg2Imag))
+	if err != nil {
+		return nil, err
+	}
+	return &models.TaskResult{Value: real(r), Imag: imag(r)}, nil
+}
+
+var (
+	complexRe   = complexUnary(func(x complex128) complex128 { return complex(real(x), 0) })
+	complexIm   = complexUnary(func(x complex128) complex128 { return complex(imag(x), 0) })
+	complexAbs  = complexUnary(func(x complex128) complex128 { return complex(cmplx.Abs(x), 0) })
+	complexArg  = complexUnary(func(x complex128) complex128 { return complex(cmplx.Phase(x), 0) })
+	complexConj = complexUnary(cmplx.Conj)
+	complexSqrt = complexUnary(cmplx.Sqrt)
+)
@@ -0,0 +1,261 @@
+package operations
+
+import (
+	"calculator_app/internal/pkg/models"
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Ограничения на аргументы, чтобы одна задача не занимала воркер часами.
+const (
+	maxFactorialArg = 10000
+	maxFibonacciArg = 100000
+	maxBinomialArg  = 100000
+	maxShiftArg     = 65536
+)
+
+var maxExactInteger = new(big.Int).Lsh(big.NewInt(1), 53)
+
+// mulRangeChunk — сколько множителей mulRange перемножает между проверками ctx.
+const mulRangeChunk = 256
+
+func executeIntegerFunction(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	n, err := integerArg(task.Arg1, task.Arg1Text)
+	if err != nil {
+		return nil, err
+	}
+
+	switch task.Operation {
+	case "fact":
+		if err := checkRange(n, maxFactorialArg); err != nil {
+			return nil, err
+		}
+		product, err := mulRange(ctx, 1, n.Int64())
+		if err != nil {
+			return nil, err
+		}
+		return integerResult(product), nil
+	case "fib":
+		if err := checkRange(n, maxFibonacciArg); err != nil {
+			return nil, err
+		}
+		f, err := fibonacci(ctx, n.Int64())
+		if err != nil {
+			return nil, err
+		}
+		return integerResult(f), nil
+	case "isprime":
+		if n.Sign() > 0 && n.ProbablyPrime(20) {
+			return &models.TaskResult{Value: 1}, nil
+		}
+		return &models.TaskResult{Value: 0}, nil
+	}
+
+	k, err := integerArg(task.Arg2, task.Arg2Text)
+	if err != nil {
+		return nil, err
+	}
+
+	switch task.Operation {
+	case "gcd":
+		return integerResult(new(big.Int).GCD(nil, nil, new(big.Int).Abs(n), new(big.Int).Abs(k))), nil
+	case "lcm":
+		if n.Sign() == 0 || k.Sign() == 0 {
+			return &models.TaskResult{Value: 0}, nil
+		}
+		gcd := new(big.Int).GCD(nil, nil, new(big.Int).Abs(n), new(big.Int).Abs(k))
+		lcm := new(big.Int).Mul(n, k)
+		lcm.Abs(lcm).Quo(lcm, gcd)
+		return integerResult(lcm), nil
+	case "choose":
+		if err := checkRange(n, maxBinomialArg); err != nil {
+			return nil, err
+		}
+		if k.Sign() < 0 {
+			return nil, models.NewTaskError(models.ErrNegativeArgument, "negative argument")
+		}
+		if k.Cmp(n) > 0 {
+			return &models.TaskResult{Value: 0}, nil
+		}
+		c, err := binomial(ctx, n.Int64(), k.Int64())
+		if err != nil {
+			return nil, err
+		}
+		return integerResult(c), nil
+	}
+
+	return nil, models.NewTaskError(models.ErrUnknownOperation, "unknown operation")
+}
+
+func executeBitwise(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	x, err := integerArg(task.Arg1, task.Arg1Text)
+	if err != nil {
+		return nil, err
+	}
+	if task.Operation == "~" {
+		return integerResult(x.Not(x)), nil
+	}
+
+	y, err := integerArg(task.Arg2, task.Arg2Text)
+	if err != nil {
+		return nil, err
+	}
+
+	switch task.Operation {
+	case "&":
+		return integerResult(x.And(x, y)), nil
+	case "|":
+		return integerResult(x.Or(x, y)), nil
+	case "xor":
+		return integerResult(x.Xor(x, y)), nil
+	case "<<", ">>":
+		if err := checkRange(y, maxShiftArg); err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if task.Operation == "<<" {
+			return integerResult(x.Lsh(x, uint(y.Int64()))), nil
+		}
+		return integerResult(x.Rsh(x, uint(y.Int64()))), nil
+	}
+
+	return nil, models.NewTaskError(models.ErrUnknownOperation, "unknown operation")
+}
+
+// executeExactArithmetic выполняет арифметику над целыми аргументами без потери
+// точности: в целочисленном режиме или когда аргумент передан строкой.
+// Деление в целочисленном режиме отбрасывает дробную часть.
+func executeExactArithmetic(task *models.Task) (*models.TaskResult, error) {
+	x, errX := integerArg(task.Arg1, task.Arg1Text)
+	y, errY := integerArg(task.Arg2, task.Arg2Text)
+	if errX != nil || errY != nil {
+		if task.Mode == models.ModeInteger {
+			if errX != nil {
+				return nil, errX
+			}
+			return nil, errY
+		}
+		arg1, arg2 := floatArg(task.Arg1, task.Arg1Text), floatArg(task.Arg2, task.Arg2Text)
+		switch task.Operation {
+		case "+":
+			return &models.TaskResult{Value: arg1 + arg2}, nil
+		case "-":
+			return &models.TaskResult{Value: arg1 - arg2}, nil
+		default:
+			return &models.TaskResult{Value: arg1 * arg2}, nil
+		}
+	}
+
+	switch task.Operation {
+	case "+":
+		return integerResult(x.Add(x, y)), nil
+	case "-":
+		return integerResult(x.Sub(x, y)), nil
+	case "/":
+		if y.Sign() == 0 {
+			return nil, models.NewTaskError(models.ErrDivisionByZero, "division by zero")
+		}
+		return integerResult(x.Quo(x, y)), nil
+	default:
+		return integerResult(x.Mul(x, y)), nil
+	}
+}
+
+func integerArg(value float64, text string) (*big.Int, error) {
+	if text != "" {
+		n, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			return nil, models.NewTaskError(models.ErrNonInteger, fmt.Sprintf("%s is not an integer", text))
+		}
+		return n, nil
+	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) || value != math.Trunc(value) {
+		return nil, models.NewTaskError(models.ErrNonInteger, fmt.Sprintf("%g is not an integer", value))
+	}
+
+	n, _ := big.NewFloat(value).Int(nil)
+	return n, nil
+}
+
+func floatArg(value float64, text string) float64 {
+	if text == "" {
+		return value
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return value
+	}
+	return f
+}
+
+func checkRange(n *big.Int, limit int64) error {
+	if n.Sign() < 0 {
+		return models.NewTaskError(models.ErrNegativeArgument, "negative argument")
+	}
+	if n.Cmp(big.NewInt(limit)) > 0 {
+		return models.NewTaskError(models.ErrArgumentTooLarge, fmt.Sprintf("argument exceeds %d", limit))
+	}
+	return nil
+}
+
+// integerResult возвращает значение числом, пока оно точно представимо в float64,
+// и строкой — если по модулю больше 2^53.
+func integerResult(n *big.Int) *models.TaskResult {
+	if n.CmpAbs(maxExactInteger) > 0 {
+		return &models.TaskResult{Text: n.String()}
+	}
+	return &models.TaskResult{Value: float64(n.Int64())}
+}
+
+// mulRange — произведение целых от a до b; в отличие от big.Int.MulRange
+// прерывается, когда ctx завершён.
+func mulRange(ctx context.Context, a, b int64) (*big.Int, error) {
+	product := big.NewInt(1)
+	for lo := a; lo <= b; lo += mulRangeChunk {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		product.Mul(product, new(big.Int).MulRange(lo, min(lo+mulRangeChunk-1, b)))
+	}
+	return product, nil
+}
+
+// binomial — C(n, k) для 0 <= k <= n, прерывается по ctx.
+func binomial(ctx context.Context, n, k int64) (*big.Int, error) {
+	k = min(k, n-k)
+	numerator, err := mulRange(ctx, n-k+1, n)
+	if err != nil {
+		return nil, err
+	}
+	denominator, err := mulRange(ctx, 1, k)
+	if err != nil {
+		return nil, err
+	}
+	return numerator.Quo(numerator, denominator), nil
+}
+
+// fibonacci считает F(n) методом удвоения: F(2k) = F(k)(2F(k+1) - F(k)),
+// F(2k+1) = F(k)^2 + F(k+1)^2.
+func fibonacci(ctx context.Context, n int64) (*big.Int, error) {
+	a, b := big.NewInt(0), big.NewInt(1)
+	for i := 62; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		t := new(big.Int).Lsh(b, 1)
+		t.Sub(t, a).Mul(t, a)
+		u := new(big.Int).Mul(a, a)
+		u.Add(u, new(big.Int).Mul(b, b))
+		a, b = t, u
+		if (n>>uint(i))&1 == 1 {
+			a, b = b, new(big.Int).Add(a, b)
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,76 @@
+package operations
+
+import (
+	"calculator_app/internal/pkg/models"
+	"math"
+)
+
+// intervalFunc — версия операции над интервалами [a, b] и [c, d].
+type intervalFunc func(a, b, c, d float64) (float64, float64, error)
+
+func intervalAdd(a, b, c, d float64) (float64, float64, error) {
+	return a + c, b + d, nil
+}
+
+func intervalSubtract(a, b, c, d float64) (float64, float64, error) {
+	return a - d, b - c, nil
+}
+
+func intervalMultiply(a, b, c, d float64) (float64, float64, error) {
+	lower, upper := minMax(a*c, a*d, b*c, b*d)
+	return lower, upper, nil
+}
+
+func intervalDivide(a, b, c, d float64) (float64, float64, error) {
+	if c <= 0 && d >= 0 {
+		return 0, 0, models.NewTaskError(models.ErrDivisionByZero, "divisor interval contains zero")
+	}
+	lower, upper := minMax(a/c, a/d, b/c, b/d)
+	return lower, upper, nil
+}
+
+func intervalSqrt(a, b, _, _ float64) (float64, float64, error) {
+	if a < 0 {
+		return 0, 0, models.NewTaskError(models.ErrNegativeArgument, "square root of a negative number")
+	}
+	return math.Sqrt(a), math.Sqrt(b), nil
+}
+
+func intervalAbs(a, b, _, _ float64) (float64, float64, error) {
+	switch {
+	case a >= 0:
+		return a, b, nil
+	case b <= 0:
+		return -b, -a, nil
+	default:
+		return 0, math.Max(-a, b), nil
+	}
+}
+
+func intervalArgs(task *models.Task) (a, b, c, d float64) {
+	a, b = intervalArg(task.Arg1, task.Arg1Upper, task.Arg1Text)
+	c, d = intervalArg(task.Arg2, task.Arg2Upper, task.Arg2Text)
+	return a, b, c, d
+}
+
+func intervalArg(lower, upper float64, text string) (float64, float64) {
+	if text != "" {
+		v := floatArg(lower, text)
+		return v, v
+	}
+	return lower, upper
+}
+
+func isDegenerate(task *models.Task) bool {
+	a, b, c, d := intervalArgs(task)
+	return a == b && c == d
+}
+
+func minMax(values ...float64) (float64, float64) {
+	lower, upper := values[0], values[0]
+	for _, v := range values[1:] {
+		lower = math.Min(lower, v)
+		upper = math.Max(upper, v)
+	}
+	return lower, upper
+}
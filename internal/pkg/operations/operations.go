@@ -0,0 +1,87 @@
+package operations
+
+import (
+	"calculator_app/internal/pkg/models"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operation — операция выражения. Оркестратор берёт из реестра синтаксис и время
+// выполнения, агент — проверку аргументов и само вычисление.
+type Operation interface {
+	Name() string
+	Arity() int
+	// Validate проверяет, что операция определена для режима и типов аргументов задачи.
+	Validate(task *models.Task) error
+	Execute(task *models.Task) (*models.TaskResult, error)
+	DefaultDuration() time.Duration
+}
+
+// Infix реализуют операции, которые записываются знаком между аргументами
+// (унарные — перед аргументом). Остальные операции записываются как функции: name(a, b).
+type Infix interface {
+	Precedence() int
+}
+
+// Interruptible реализуют операции, которые могут считать долго: ExecuteContext
+// прекращает вычисление и возвращает ошибку ctx, когда ctx завершён.
+type Interruptible interface {
+	ExecuteContext(ctx context.Context, task *models.Task) (*models.TaskResult, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Operation)
+)
+
+// Register добавляет операцию в реестр. Повторная регистрация имени — ошибка программы.
+func Register(op Operation) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if op == nil || op.Name() == "" {
+		panic("operations: Register of unnamed operation")
+	}
+	if _, dup := registry[op.Name()]; dup {
+		panic(fmt.Sprintf("operations: Register called twice for %s", op.Name()))
+	}
+	registry[op.Name()] = op
+}
+
+func Lookup(name string) (Operation, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	op, ok := registry[name]
+	return op, ok
+}
+
+// Names возвращает имена всех зарегистрированных операций в алфавитном порядке.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Precedence возвращает приоритет инфиксной операции; ok = false для функций
+// и незарегистрированных имён.
+func Precedence(name string) (int, bool) {
+	op, ok := Lookup(name)
+	if !ok {
+		return 0, false
+	}
+	infix, ok := op.(Infix)
+	if !ok || infix.Precedence() <= 0 {
+		return 0, false
+	}
+	return infix.Precedence(), true
+}
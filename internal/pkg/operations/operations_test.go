@@ -0,0 +1,84 @@
+package operations_test
+
+import (
+	"calculator_app/internal/pkg/models"
+	"calculator_app/internal/pkg/operations"
+	"context"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type hypot struct{}
+
+func (hypot) Name() string                   { return "hypot" }
+func (hypot) Arity() int                     { return 2 }
+func (hypot) Validate(*models.Task) error    { return nil }
+func (hypot) DefaultDuration() time.Duration { return 50 * time.Millisecond }
+func (hypot) Execute(task *models.Task) (*models.TaskResult, error) {
+	return &models.TaskResult{Value: math.Hypot(task.Arg1, task.Arg2)}, nil
+}
+
+func TestRegister(t *testing.T) {
+	operations.Register(hypot{})
+
+	op, ok := operations.Lookup("hypot")
+	assert.True(t, ok)
+	result, err := op.Execute(&models.Task{Arg1: 3, Arg2: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, result.Value)
+	assert.Contains(t, operations.Names(), "hypot")
+
+	_, infix := operations.Precedence("hypot")
+	assert.False(t, infix)
+
+	assert.Panics(t, func() { operations.Register(hypot{}) })
+}
+
+func TestBuiltins(t *testing.T) {
+	for _, name := range []string{"+", "-", "*", "/", "~", "xor", "fact", "sqrt", "conj"} {
+		_, ok := operations.Lookup(name)
+		assert.True(t, ok, name)
+	}
+
+	plus, _ := operations.Precedence("+")
+	mul, _ := operations.Precedence("*")
+	assert.Less(t, plus, mul)
+
+	op, _ := operations.Lookup("fact")
+	err := op.Validate(&models.Task{Arg1: 1.5, Mode: models.ModeInteger})
+	var taskErr *models.TaskError
+	if assert.ErrorAs(t, err, &taskErr) {
+		assert.Equal(t, models.ErrNonInteger, taskErr.Code)
+	}
+
+	op, _ = operations.Lookup("/")
+	err = op.Validate(&models.Task{Arg1Type: models.TypeDate, Arg2Type: models.TypeDate})
+	if assert.ErrorAs(t, err, &taskErr) {
+		assert.Equal(t, models.ErrTypeMismatch, taskErr.Code)
+	}
+}
+
+func TestInterruptible(t *testing.T) {
+	op, _ := operations.Lookup("choose")
+	interruptible, ok := op.(operations.Interruptible)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	result, err := interruptible.ExecuteContext(context.Background(), &models.Task{Arg1: 1000, Arg2: 600, Operation: "choose"})
+	assert.NoError(t, err)
+	assert.Equal(t, new(big.Int).Binomial(1000, 600).String(), result.Text)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = interruptible.ExecuteContext(ctx, &models.Task{Arg1: 100000, Arg2: 50000, Operation: "choose"})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	op, _ = operations.Lookup("fib")
+	_, err = op.(operations.Interruptible).ExecuteContext(ctx, &models.Task{Arg1: 100000, Operation: "fib"})
+	assert.ErrorIs(t, err, context.Canceled)
+}
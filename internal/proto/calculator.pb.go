@@ -24,6 +24,9 @@ const (
 
 type GetTaskRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	Operations    []string               `protobuf:"bytes,1,rep,name=operations,proto3" json:"operations,omitempty"`
+	Modes         []string               `protobuf:"bytes,2,rep,name=modes,proto3" json:"modes,omitempty"`
+	AgentId       string                 `protobuf:"bytes,3,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -58,6 +61,207 @@ func (*GetTaskRequest) Descriptor() ([]byte, []int) {
 	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{0}
 }
 
+func (x *GetTaskRequest) GetOperations() []string {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+func (x *GetTaskRequest) GetModes() []string {
+	if x != nil {
+		return x.Modes
+	}
+	return nil
+}
+
+func (x *GetTaskRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+type TaskStreamRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Operations    []string               `protobuf:"bytes,2,rep,name=operations,proto3" json:"operations,omitempty"`
+	Modes         []string               `protobuf:"bytes,3,rep,name=modes,proto3" json:"modes,omitempty"`
+	FreeSlots     int32                  `protobuf:"varint,4,opt,name=free_slots,json=freeSlots,proto3" json:"free_slots,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskStreamRequest) Reset() {
+	*x = TaskStreamRequest{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskStreamRequest) ProtoMessage() {}
+
+func (x *TaskStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskStreamRequest.ProtoReflect.Descriptor instead.
+func (*TaskStreamRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TaskStreamRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *TaskStreamRequest) GetOperations() []string {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+func (x *TaskStreamRequest) GetModes() []string {
+	if x != nil {
+		return x.Modes
+	}
+	return nil
+}
+
+func (x *TaskStreamRequest) GetFreeSlots() int32 {
+	if x != nil {
+		return x.FreeSlots
+	}
+	return 0
+}
+
+type GetTasksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Operations    []string               `protobuf:"bytes,1,rep,name=operations,proto3" json:"operations,omitempty"`
+	Modes         []string               `protobuf:"bytes,2,rep,name=modes,proto3" json:"modes,omitempty"`
+	AgentId       string                 `protobuf:"bytes,3,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	MaxN          int32                  `protobuf:"varint,4,opt,name=max_n,json=maxN,proto3" json:"max_n,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTasksRequest) Reset() {
+	*x = GetTasksRequest{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTasksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTasksRequest) ProtoMessage() {}
+
+func (x *GetTasksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTasksRequest.ProtoReflect.Descriptor instead.
+func (*GetTasksRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetTasksRequest) GetOperations() []string {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+func (x *GetTasksRequest) GetModes() []string {
+	if x != nil {
+		return x.Modes
+	}
+	return nil
+}
+
+func (x *GetTasksRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *GetTasksRequest) GetMaxN() int32 {
+	if x != nil {
+		return x.MaxN
+	}
+	return 0
+}
+
+type GetTasksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tasks         []*GetTaskResponse     `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTasksResponse) Reset() {
+	*x = GetTasksResponse{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTasksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTasksResponse) ProtoMessage() {}
+
+func (x *GetTasksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTasksResponse.ProtoReflect.Descriptor instead.
+func (*GetTasksResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetTasksResponse) GetTasks() []*GetTaskResponse {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
 type GetTaskResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
@@ -65,15 +269,26 @@ type GetTaskResponse struct {
 	Arg1          float64                `protobuf:"fixed64,3,opt,name=arg1,proto3" json:"arg1,omitempty"`
 	Arg2          float64                `protobuf:"fixed64,4,opt,name=arg2,proto3" json:"arg2,omitempty"`
 	OperationTime int32                  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`
-	DependsOn     []string               `protobuf:"bytes,6,rep,name=depends_on,json=dependsOn,proto3" json:"depends_on,omitempty"`
 	UserLogin     string                 `protobuf:"bytes,7,opt,name=user_login,json=userLogin,proto3" json:"user_login,omitempty"`
+	Arg1Text      string                 `protobuf:"bytes,8,opt,name=arg1_text,json=arg1Text,proto3" json:"arg1_text,omitempty"`
+	Arg2Text      string                 `protobuf:"bytes,9,opt,name=arg2_text,json=arg2Text,proto3" json:"arg2_text,omitempty"`
+	Mode          string                 `protobuf:"bytes,10,opt,name=mode,proto3" json:"mode,omitempty"`
+	Arg1Imag      float64                `protobuf:"fixed64,11,opt,name=arg1_imag,json=arg1Imag,proto3" json:"arg1_imag,omitempty"`
+	Arg2Imag      float64                `protobuf:"fixed64,12,opt,name=arg2_imag,json=arg2Imag,proto3" json:"arg2_imag,omitempty"`
+	Arg1Upper     float64                `protobuf:"fixed64,13,opt,name=arg1_upper,json=arg1Upper,proto3" json:"arg1_upper,omitempty"`
+	Arg2Upper     float64                `protobuf:"fixed64,14,opt,name=arg2_upper,json=arg2Upper,proto3" json:"arg2_upper,omitempty"`
+	Arg1Type      string                 `protobuf:"bytes,15,opt,name=arg1_type,json=arg1Type,proto3" json:"arg1_type,omitempty"`
+	Arg2Type      string                 `protobuf:"bytes,16,opt,name=arg2_type,json=arg2Type,proto3" json:"arg2_type,omitempty"`
+	LeaseId       string                 `protobuf:"bytes,17,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	QueueDepth    int32                  `protobuf:"varint,18,opt,name=queue_depth,json=queueDepth,proto3" json:"queue_depth,omitempty"`
+	CancelTaskIds []string               `protobuf:"bytes,19,rep,name=cancel_task_ids,json=cancelTaskIds,proto3" json:"cancel_task_ids,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetTaskResponse) Reset() {
 	*x = GetTaskResponse{}
-	mi := &file_internal_proto_calculator_proto_msgTypes[1]
+	mi := &file_internal_proto_calculator_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -85,7 +300,7 @@ func (x *GetTaskResponse) String() string {
 func (*GetTaskResponse) ProtoMessage() {}
 
 func (x *GetTaskResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_proto_calculator_proto_msgTypes[1]
+	mi := &file_internal_proto_calculator_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -98,7 +313,7 @@ func (x *GetTaskResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetTaskResponse.ProtoReflect.Descriptor instead.
 func (*GetTaskResponse) Descriptor() ([]byte, []int) {
-	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{1}
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *GetTaskResponse) GetTaskId() string {
@@ -136,20 +351,97 @@ func (x *GetTaskResponse) GetOperationTime() int32 {
 	return 0
 }
 
-func (x *GetTaskResponse) GetDependsOn() []string {
+func (x *GetTaskResponse) GetUserLogin() string {
 	if x != nil {
-		return x.DependsOn
+		return x.UserLogin
 	}
-	return nil
+	return ""
 }
 
-func (x *GetTaskResponse) GetUserLogin() string {
+func (x *GetTaskResponse) GetArg1Text() string {
 	if x != nil {
-		return x.UserLogin
+		return x.Arg1Text
+	}
+	return ""
+}
+
+func (x *GetTaskResponse) GetArg2Text() string {
+	if x != nil {
+		return x.Arg2Text
+	}
+	return ""
+}
+
+func (x *GetTaskResponse) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *GetTaskResponse) GetArg1Imag() float64 {
+	if x != nil {
+		return x.Arg1Imag
+	}
+	return 0
+}
+
+func (x *GetTaskResponse) GetArg2Imag() float64 {
+	if x != nil {
+		return x.Arg2Imag
+	}
+	return 0
+}
+
+func (x *GetTaskResponse) GetArg1Upper() float64 {
+	if x != nil {
+		return x.Arg1Upper
+	}
+	return 0
+}
+
+func (x *GetTaskResponse) GetArg2Upper() float64 {
+	if x != nil {
+		return x.Arg2Upper
+	}
+	return 0
+}
+
+func (x *GetTaskResponse) GetArg1Type() string {
+	if x != nil {
+		return x.Arg1Type
+	}
+	return ""
+}
+
+func (x *GetTaskResponse) GetArg2Type() string {
+	if x != nil {
+		return x.Arg2Type
+	}
+	return ""
+}
+
+func (x *GetTaskResponse) GetLeaseId() string {
+	if x != nil {
+		return x.LeaseId
 	}
 	return ""
 }
 
+func (x *GetTaskResponse) GetQueueDepth() int32 {
+	if x != nil {
+		return x.QueueDepth
+	}
+	return 0
+}
+
+func (x *GetTaskResponse) GetCancelTaskIds() []string {
+	if x != nil {
+		return x.CancelTaskIds
+	}
+	return nil
+}
+
 type SubmitResultRequest struct {
 	state  protoimpl.MessageState `protogen:"open.v1"`
 	TaskId string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
@@ -157,14 +449,20 @@ type SubmitResultRequest struct {
 	//
 	//	*SubmitResultRequest_Result
 	//	*SubmitResultRequest_Error
+	//	*SubmitResultRequest_ResultText
 	Outcome       isSubmitResultRequest_Outcome `protobuf_oneof:"outcome"`
+	ResultImag    float64                       `protobuf:"fixed64,5,opt,name=result_imag,json=resultImag,proto3" json:"result_imag,omitempty"`
+	ResultUpper   *float64                      `protobuf:"fixed64,6,opt,name=result_upper,json=resultUpper,proto3,oneof" json:"result_upper,omitempty"`
+	ResultType    string                        `protobuf:"bytes,7,opt,name=result_type,json=resultType,proto3" json:"result_type,omitempty"`
+	LeaseId       string                        `protobuf:"bytes,8,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	Worker        int32                         `protobuf:"varint,9,opt,name=worker,proto3" json:"worker,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *SubmitResultRequest) Reset() {
 	*x = SubmitResultRequest{}
-	mi := &file_internal_proto_calculator_proto_msgTypes[2]
+	mi := &file_internal_proto_calculator_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -176,7 +474,7 @@ func (x *SubmitResultRequest) String() string {
 func (*SubmitResultRequest) ProtoMessage() {}
 
 func (x *SubmitResultRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_proto_calculator_proto_msgTypes[2]
+	mi := &file_internal_proto_calculator_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -189,7 +487,7 @@ func (x *SubmitResultRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SubmitResultRequest.ProtoReflect.Descriptor instead.
 func (*SubmitResultRequest) Descriptor() ([]byte, []int) {
-	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{2}
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *SubmitResultRequest) GetTaskId() string {
@@ -224,6 +522,50 @@ func (x *SubmitResultRequest) GetError() string {
 	return ""
 }
 
+func (x *SubmitResultRequest) GetResultText() string {
+	if x != nil {
+		if x, ok := x.Outcome.(*SubmitResultRequest_ResultText); ok {
+			return x.ResultText
+		}
+	}
+	return ""
+}
+
+func (x *SubmitResultRequest) GetResultImag() float64 {
+	if x != nil {
+		return x.ResultImag
+	}
+	return 0
+}
+
+func (x *SubmitResultRequest) GetResultUpper() float64 {
+	if x != nil && x.ResultUpper != nil {
+		return *x.ResultUpper
+	}
+	return 0
+}
+
+func (x *SubmitResultRequest) GetResultType() string {
+	if x != nil {
+		return x.ResultType
+	}
+	return ""
+}
+
+func (x *SubmitResultRequest) GetLeaseId() string {
+	if x != nil {
+		return x.LeaseId
+	}
+	return ""
+}
+
+func (x *SubmitResultRequest) GetWorker() int32 {
+	if x != nil {
+		return x.Worker
+	}
+	return 0
+}
+
 type isSubmitResultRequest_Outcome interface {
 	isSubmitResultRequest_Outcome()
 }
@@ -236,10 +578,16 @@ type SubmitResultRequest_Error struct {
 	Error string `protobuf:"bytes,3,opt,name=error,proto3,oneof"`
 }
 
+type SubmitResultRequest_ResultText struct {
+	ResultText string `protobuf:"bytes,4,opt,name=result_text,json=resultText,proto3,oneof"`
+}
+
 func (*SubmitResultRequest_Result) isSubmitResultRequest_Outcome() {}
 
 func (*SubmitResultRequest_Error) isSubmitResultRequest_Outcome() {}
 
+func (*SubmitResultRequest_ResultText) isSubmitResultRequest_Outcome() {}
+
 type SubmitResultResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -249,7 +597,7 @@ type SubmitResultResponse struct {
 
 func (x *SubmitResultResponse) Reset() {
 	*x = SubmitResultResponse{}
-	mi := &file_internal_proto_calculator_proto_msgTypes[3]
+	mi := &file_internal_proto_calculator_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -261,7 +609,7 @@ func (x *SubmitResultResponse) String() string {
 func (*SubmitResultResponse) ProtoMessage() {}
 
 func (x *SubmitResultResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_proto_calculator_proto_msgTypes[3]
+	mi := &file_internal_proto_calculator_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -274,7 +622,7 @@ func (x *SubmitResultResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SubmitResultResponse.ProtoReflect.Descriptor instead.
 func (*SubmitResultResponse) Descriptor() ([]byte, []int) {
-	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{3}
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *SubmitResultResponse) GetSuccess() bool {
@@ -284,16 +632,364 @@ func (x *SubmitResultResponse) GetSuccess() bool {
 	return false
 }
 
-type GetTaskResultRequest struct {
+type SubmitResultsRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	Results       []*SubmitResultRequest `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GetTaskResultRequest) Reset() {
+func (x *SubmitResultsRequest) Reset() {
+	*x = SubmitResultsRequest{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitResultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitResultsRequest) ProtoMessage() {}
+
+func (x *SubmitResultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitResultsRequest.ProtoReflect.Descriptor instead.
+func (*SubmitResultsRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SubmitResultsRequest) GetResults() []*SubmitResultRequest {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type SubmitResultsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       []bool                 `protobuf:"varint,1,rep,packed,name=success,proto3" json:"success,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitResultsResponse) Reset() {
+	*x = SubmitResultsResponse{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitResultsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitResultsResponse) ProtoMessage() {}
+
+func (x *SubmitResultsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitResultsResponse.ProtoReflect.Descriptor instead.
+func (*SubmitResultsResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SubmitResultsResponse) GetSuccess() []bool {
+	if x != nil {
+		return x.Success
+	}
+	return nil
+}
+
+type TaskLease struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	LeaseId       string                 `protobuf:"bytes,2,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskLease) Reset() {
+	*x = TaskLease{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskLease) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskLease) ProtoMessage() {}
+
+func (x *TaskLease) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskLease.ProtoReflect.Descriptor instead.
+func (*TaskLease) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TaskLease) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *TaskLease) GetLeaseId() string {
+	if x != nil {
+		return x.LeaseId
+	}
+	return ""
+}
+
+type ReleaseTasksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Tasks         []*TaskLease           `protobuf:"bytes,2,rep,name=tasks,proto3" json:"tasks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseTasksRequest) Reset() {
+	*x = ReleaseTasksRequest{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseTasksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseTasksRequest) ProtoMessage() {}
+
+func (x *ReleaseTasksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseTasksRequest.ProtoReflect.Descriptor instead.
+func (*ReleaseTasksRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ReleaseTasksRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *ReleaseTasksRequest) GetTasks() []*TaskLease {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+type ReleaseTasksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       []bool                 `protobuf:"varint,1,rep,packed,name=success,proto3" json:"success,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseTasksResponse) Reset() {
+	*x = ReleaseTasksResponse{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseTasksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseTasksResponse) ProtoMessage() {}
+
+func (x *ReleaseTasksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseTasksResponse.ProtoReflect.Descriptor instead.
+func (*ReleaseTasksResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ReleaseTasksResponse) GetSuccess() []bool {
+	if x != nil {
+		return x.Success
+	}
+	return nil
+}
+
+type EnrollRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JoinToken     string                 `protobuf:"bytes,1,opt,name=join_token,json=joinToken,proto3" json:"join_token,omitempty"`
+	AgentId       string                 `protobuf:"bytes,2,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Hostname      string                 `protobuf:"bytes,3,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrollRequest) Reset() {
+	*x = EnrollRequest{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrollRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollRequest) ProtoMessage() {}
+
+func (x *EnrollRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollRequest.ProtoReflect.Descriptor instead.
+func (*EnrollRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *EnrollRequest) GetJoinToken() string {
+	if x != nil {
+		return x.JoinToken
+	}
+	return ""
+}
+
+func (x *EnrollRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *EnrollRequest) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+type EnrollResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Credential    string                 `protobuf:"bytes,2,opt,name=credential,proto3" json:"credential,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrollResponse) Reset() {
+	*x = EnrollResponse{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrollResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollResponse) ProtoMessage() {}
+
+func (x *EnrollResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollResponse.ProtoReflect.Descriptor instead.
+func (*EnrollResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *EnrollResponse) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *EnrollResponse) GetCredential() string {
+	if x != nil {
+		return x.Credential
+	}
+	return ""
+}
+
+type GetTaskResultRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTaskResultRequest) Reset() {
 	*x = GetTaskResultRequest{}
-	mi := &file_internal_proto_calculator_proto_msgTypes[4]
+	mi := &file_internal_proto_calculator_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -305,7 +1001,7 @@ func (x *GetTaskResultRequest) String() string {
 func (*GetTaskResultRequest) ProtoMessage() {}
 
 func (x *GetTaskResultRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_proto_calculator_proto_msgTypes[4]
+	mi := &file_internal_proto_calculator_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -318,7 +1014,7 @@ func (x *GetTaskResultRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetTaskResultRequest.ProtoReflect.Descriptor instead.
 func (*GetTaskResultRequest) Descriptor() ([]byte, []int) {
-	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{4}
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *GetTaskResultRequest) GetTaskId() string {
@@ -332,13 +1028,17 @@ type GetTaskResultResponse struct {
 	state         protoimpl.MessageState  `protogen:"open.v1"`
 	Result        *wrapperspb.DoubleValue `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
 	TaskExists    bool                    `protobuf:"varint,2,opt,name=task_exists,json=taskExists,proto3" json:"task_exists,omitempty"`
+	ResultText    string                  `protobuf:"bytes,3,opt,name=result_text,json=resultText,proto3" json:"result_text,omitempty"`
+	ResultImag    float64                 `protobuf:"fixed64,4,opt,name=result_imag,json=resultImag,proto3" json:"result_imag,omitempty"`
+	ResultUpper   *float64                `protobuf:"fixed64,5,opt,name=result_upper,json=resultUpper,proto3,oneof" json:"result_upper,omitempty"`
+	ResultType    string                  `protobuf:"bytes,6,opt,name=result_type,json=resultType,proto3" json:"result_type,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetTaskResultResponse) Reset() {
 	*x = GetTaskResultResponse{}
-	mi := &file_internal_proto_calculator_proto_msgTypes[5]
+	mi := &file_internal_proto_calculator_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -350,7 +1050,7 @@ func (x *GetTaskResultResponse) String() string {
 func (*GetTaskResultResponse) ProtoMessage() {}
 
 func (x *GetTaskResultResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_proto_calculator_proto_msgTypes[5]
+	mi := &file_internal_proto_calculator_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -363,7 +1063,7 @@ func (x *GetTaskResultResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetTaskResultResponse.ProtoReflect.Descriptor instead.
 func (*GetTaskResultResponse) Descriptor() ([]byte, []int) {
-	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{5}
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *GetTaskResultResponse) GetResult() *wrapperspb.DoubleValue {
@@ -380,13 +1080,279 @@ func (x *GetTaskResultResponse) GetTaskExists() bool {
 	return false
 }
 
+func (x *GetTaskResultResponse) GetResultText() string {
+	if x != nil {
+		return x.ResultText
+	}
+	return ""
+}
+
+func (x *GetTaskResultResponse) GetResultImag() float64 {
+	if x != nil {
+		return x.ResultImag
+	}
+	return 0
+}
+
+func (x *GetTaskResultResponse) GetResultUpper() float64 {
+	if x != nil && x.ResultUpper != nil {
+		return *x.ResultUpper
+	}
+	return 0
+}
+
+func (x *GetTaskResultResponse) GetResultType() string {
+	if x != nil {
+		return x.ResultType
+	}
+	return ""
+}
+
+type RegisterAgentRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	AgentId        string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Hostname       string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Version        string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	ComputingPower int32                  `protobuf:"varint,4,opt,name=computing_power,json=computingPower,proto3" json:"computing_power,omitempty"`
+	Labels         map[string]string      `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *RegisterAgentRequest) Reset() {
+	*x = RegisterAgentRequest{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterAgentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterAgentRequest) ProtoMessage() {}
+
+func (x *RegisterAgentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterAgentRequest.ProtoReflect.Descriptor instead.
+func (*RegisterAgentRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *RegisterAgentRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *RegisterAgentRequest) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *RegisterAgentRequest) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *RegisterAgentRequest) GetComputingPower() int32 {
+	if x != nil {
+		return x.ComputingPower
+	}
+	return 0
+}
+
+func (x *RegisterAgentRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type RegisterAgentResponse struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	HeartbeatIntervalMs int32                  `protobuf:"varint,1,opt,name=heartbeat_interval_ms,json=heartbeatIntervalMs,proto3" json:"heartbeat_interval_ms,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *RegisterAgentResponse) Reset() {
+	*x = RegisterAgentResponse{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterAgentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterAgentResponse) ProtoMessage() {}
+
+func (x *RegisterAgentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterAgentResponse.ProtoReflect.Descriptor instead.
+func (*RegisterAgentResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *RegisterAgentResponse) GetHeartbeatIntervalMs() int32 {
+	if x != nil {
+		return x.HeartbeatIntervalMs
+	}
+	return 0
+}
+
+type HeartbeatRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Workers       int32                  `protobuf:"varint,2,opt,name=workers,proto3" json:"workers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatRequest) Reset() {
+	*x = HeartbeatRequest{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatRequest) ProtoMessage() {}
+
+func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatRequest.ProtoReflect.Descriptor instead.
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *HeartbeatRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *HeartbeatRequest) GetWorkers() int32 {
+	if x != nil {
+		return x.Workers
+	}
+	return 0
+}
+
+type HeartbeatResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Registered    bool                   `protobuf:"varint,1,opt,name=registered,proto3" json:"registered,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatResponse) Reset() {
+	*x = HeartbeatResponse{}
+	mi := &file_internal_proto_calculator_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatResponse) ProtoMessage() {}
+
+func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_calculator_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatResponse.ProtoReflect.Descriptor instead.
+func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_calculator_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *HeartbeatResponse) GetRegistered() bool {
+	if x != nil {
+		return x.Registered
+	}
+	return false
+}
+
 var File_internal_proto_calculator_proto protoreflect.FileDescriptor
 
 const file_internal_proto_calculator_proto_rawDesc = "" +
 	"\n" +
 	"\x1finternal/proto/calculator.proto\x12\n" +
-	"calculator\x1a\x1egoogle/protobuf/wrappers.proto\"\x10\n" +
-	"\x0eGetTaskRequest\"\xd5\x01\n" +
+	"calculator\x1a\x1egoogle/protobuf/wrappers.proto\"a\n" +
+	"\x0eGetTaskRequest\x12\x1e\n" +
+	"\n" +
+	"operations\x18\x01 \x03(\tR\n" +
+	"operations\x12\x14\n" +
+	"\x05modes\x18\x02 \x03(\tR\x05modes\x12\x19\n" +
+	"\bagent_id\x18\x03 \x01(\tR\aagentId\"\x83\x01\n" +
+	"\x11TaskStreamRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1e\n" +
+	"\n" +
+	"operations\x18\x02 \x03(\tR\n" +
+	"operations\x12\x14\n" +
+	"\x05modes\x18\x03 \x03(\tR\x05modes\x12\x1d\n" +
+	"\n" +
+	"free_slots\x18\x04 \x01(\x05R\tfreeSlots\"w\n" +
+	"\x0fGetTasksRequest\x12\x1e\n" +
+	"\n" +
+	"operations\x18\x01 \x03(\tR\n" +
+	"operations\x12\x14\n" +
+	"\x05modes\x18\x02 \x03(\tR\x05modes\x12\x19\n" +
+	"\bagent_id\x18\x03 \x01(\tR\aagentId\x12\x13\n" +
+	"\x05max_n\x18\x04 \x01(\x05R\x04maxN\"E\n" +
+	"\x10GetTasksResponse\x121\n" +
+	"\x05tasks\x18\x01 \x03(\v2\x1b.calculator.GetTaskResponseR\x05tasks\"\xac\x04\n" +
 	"\x0fGetTaskResponse\x12\x17\n" +
 	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x1c\n" +
 	"\toperation\x18\x02 \x01(\tR\toperation\x12\x12\n" +
@@ -394,26 +1360,106 @@ const file_internal_proto_calculator_proto_rawDesc = "" +
 	"\x04arg2\x18\x04 \x01(\x01R\x04arg2\x12%\n" +
 	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\x12\x1d\n" +
 	"\n" +
-	"depends_on\x18\x06 \x03(\tR\tdependsOn\x12\x1d\n" +
+	"user_login\x18\a \x01(\tR\tuserLogin\x12\x1b\n" +
+	"\targ1_text\x18\b \x01(\tR\barg1Text\x12\x1b\n" +
+	"\targ2_text\x18\t \x01(\tR\barg2Text\x12\x12\n" +
+	"\x04mode\x18\n" +
+	" \x01(\tR\x04mode\x12\x1b\n" +
+	"\targ1_imag\x18\v \x01(\x01R\barg1Imag\x12\x1b\n" +
+	"\targ2_imag\x18\f \x01(\x01R\barg2Imag\x12\x1d\n" +
+	"\n" +
+	"arg1_upper\x18\r \x01(\x01R\targ1Upper\x12\x1d\n" +
 	"\n" +
-	"user_login\x18\a \x01(\tR\tuserLogin\"k\n" +
+	"arg2_upper\x18\x0e \x01(\x01R\targ2Upper\x12\x1b\n" +
+	"\targ1_type\x18\x0f \x01(\tR\barg1Type\x12\x1b\n" +
+	"\targ2_type\x18\x10 \x01(\tR\barg2Type\x12\x19\n" +
+	"\blease_id\x18\x11 \x01(\tR\aleaseId\x12\x1f\n" +
+	"\vqueue_depth\x18\x12 \x01(\x05R\n" +
+	"queueDepth\x12&\n" +
+	"\x0fcancel_task_ids\x18\x13 \x03(\tR\rcancelTaskIdsJ\x04\b\x06\x10\aR\n" +
+	"depends_on\"\xbc\x02\n" +
 	"\x13SubmitResultRequest\x12\x17\n" +
 	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x18\n" +
 	"\x06result\x18\x02 \x01(\x01H\x00R\x06result\x12\x16\n" +
-	"\x05error\x18\x03 \x01(\tH\x00R\x05errorB\t\n" +
-	"\aoutcome\"0\n" +
+	"\x05error\x18\x03 \x01(\tH\x00R\x05error\x12!\n" +
+	"\vresult_text\x18\x04 \x01(\tH\x00R\n" +
+	"resultText\x12\x1f\n" +
+	"\vresult_imag\x18\x05 \x01(\x01R\n" +
+	"resultImag\x12&\n" +
+	"\fresult_upper\x18\x06 \x01(\x01H\x01R\vresultUpper\x88\x01\x01\x12\x1f\n" +
+	"\vresult_type\x18\a \x01(\tR\n" +
+	"resultType\x12\x19\n" +
+	"\blease_id\x18\b \x01(\tR\aleaseId\x12\x16\n" +
+	"\x06worker\x18\t \x01(\x05R\x06workerB\t\n" +
+	"\aoutcomeB\x0f\n" +
+	"\r_result_upper\"0\n" +
 	"\x14SubmitResultResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\"/\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"Q\n" +
+	"\x14SubmitResultsRequest\x129\n" +
+	"\aresults\x18\x01 \x03(\v2\x1f.calculator.SubmitResultRequestR\aresults\"1\n" +
+	"\x15SubmitResultsResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x03(\bR\asuccess\"?\n" +
+	"\tTaskLease\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x19\n" +
+	"\blease_id\x18\x02 \x01(\tR\aleaseId\"]\n" +
+	"\x13ReleaseTasksRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12+\n" +
+	"\x05tasks\x18\x02 \x03(\v2\x15.calculator.TaskLeaseR\x05tasks\"0\n" +
+	"\x14ReleaseTasksResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x03(\bR\asuccess\"e\n" +
+	"\rEnrollRequest\x12\x1d\n" +
+	"\n" +
+	"join_token\x18\x01 \x01(\tR\tjoinToken\x12\x19\n" +
+	"\bagent_id\x18\x02 \x01(\tR\aagentId\x12\x1a\n" +
+	"\bhostname\x18\x03 \x01(\tR\bhostname\"K\n" +
+	"\x0eEnrollResponse\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1e\n" +
+	"\n" +
+	"credential\x18\x02 \x01(\tR\n" +
+	"credential\"/\n" +
 	"\x14GetTaskResultRequest\x12\x17\n" +
-	"\atask_id\x18\x01 \x01(\tR\x06taskId\"n\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\"\x8a\x02\n" +
 	"\x15GetTaskResultResponse\x124\n" +
 	"\x06result\x18\x01 \x01(\v2\x1c.google.protobuf.DoubleValueR\x06result\x12\x1f\n" +
 	"\vtask_exists\x18\x02 \x01(\bR\n" +
-	"taskExists2\x82\x02\n" +
+	"taskExists\x12\x1f\n" +
+	"\vresult_text\x18\x03 \x01(\tR\n" +
+	"resultText\x12\x1f\n" +
+	"\vresult_imag\x18\x04 \x01(\x01R\n" +
+	"resultImag\x12&\n" +
+	"\fresult_upper\x18\x05 \x01(\x01H\x00R\vresultUpper\x88\x01\x01\x12\x1f\n" +
+	"\vresult_type\x18\x06 \x01(\tR\n" +
+	"resultTypeB\x0f\n" +
+	"\r_result_upper\"\x91\x02\n" +
+	"\x14RegisterAgentRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12'\n" +
+	"\x0fcomputing_power\x18\x04 \x01(\x05R\x0ecomputingPower\x12D\n" +
+	"\x06labels\x18\x05 \x03(\v2,.calculator.RegisterAgentRequest.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"K\n" +
+	"\x15RegisterAgentResponse\x122\n" +
+	"\x15heartbeat_interval_ms\x18\x01 \x01(\x05R\x13heartbeatIntervalMs\"G\n" +
+	"\x10HeartbeatRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x18\n" +
+	"\aworkers\x18\x02 \x01(\x05R\aworkers\"3\n" +
+	"\x11HeartbeatResponse\x12\x1e\n" +
+	"\n" +
+	"registered\x18\x01 \x01(\bR\n" +
+	"registered2\xa2\x06\n" +
 	"\x13OrchestratorService\x12B\n" +
 	"\aGetTask\x12\x1a.calculator.GetTaskRequest\x1a\x1b.calculator.GetTaskResponse\x12Q\n" +
 	"\fSubmitResult\x12\x1f.calculator.SubmitResultRequest\x1a .calculator.SubmitResultResponse\x12T\n" +
-	"\rGetTaskResult\x12 .calculator.GetTaskResultRequest\x1a!.calculator.GetTaskResultResponseB\x16Z\x14internal/proto;protob\x06proto3"
+	"\rGetTaskResult\x12 .calculator.GetTaskResultRequest\x1a!.calculator.GetTaskResultResponse\x12T\n" +
+	"\rRegisterAgent\x12 .calculator.RegisterAgentRequest\x1a!.calculator.RegisterAgentResponse\x12H\n" +
+	"\tHeartbeat\x12\x1c.calculator.HeartbeatRequest\x1a\x1d.calculator.HeartbeatResponse\x12M\n" +
+	"\vStreamTasks\x12\x1d.calculator.TaskStreamRequest\x1a\x1b.calculator.GetTaskResponse(\x010\x01\x12E\n" +
+	"\bGetTasks\x12\x1b.calculator.GetTasksRequest\x1a\x1c.calculator.GetTasksResponse\x12T\n" +
+	"\rSubmitResults\x12 .calculator.SubmitResultsRequest\x1a!.calculator.SubmitResultsResponse\x12Q\n" +
+	"\fReleaseTasks\x12\x1f.calculator.ReleaseTasksRequest\x1a .calculator.ReleaseTasksResponse\x12?\n" +
+	"\x06Enroll\x12\x19.calculator.EnrollRequest\x1a\x1a.calculator.EnrollResponseB\x16Z\x14internal/proto;protob\x06proto3"
 
 var (
 	file_internal_proto_calculator_proto_rawDescOnce sync.Once
@@ -427,29 +1473,62 @@ func file_internal_proto_calculator_proto_rawDescGZIP() []byte {
 	return file_internal_proto_calculator_proto_rawDescData
 }
 
-var file_internal_proto_calculator_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_internal_proto_calculator_proto_msgTypes = make([]protoimpl.MessageInfo, 21)
 var file_internal_proto_calculator_proto_goTypes = []any{
 	(*GetTaskRequest)(nil),         // 0: calculator.GetTaskRequest
-	(*GetTaskResponse)(nil),        // 1: calculator.GetTaskResponse
-	(*SubmitResultRequest)(nil),    // 2: calculator.SubmitResultRequest
-	(*SubmitResultResponse)(nil),   // 3: calculator.SubmitResultResponse
-	(*GetTaskResultRequest)(nil),   // 4: calculator.GetTaskResultRequest
-	(*GetTaskResultResponse)(nil),  // 5: calculator.GetTaskResultResponse
-	(*wrapperspb.DoubleValue)(nil), // 6: google.protobuf.DoubleValue
+	(*TaskStreamRequest)(nil),      // 1: calculator.TaskStreamRequest
+	(*GetTasksRequest)(nil),        // 2: calculator.GetTasksRequest
+	(*GetTasksResponse)(nil),       // 3: calculator.GetTasksResponse
+	(*GetTaskResponse)(nil),        // 4: calculator.GetTaskResponse
+	(*SubmitResultRequest)(nil),    // 5: calculator.SubmitResultRequest
+	(*SubmitResultResponse)(nil),   // 6: calculator.SubmitResultResponse
+	(*SubmitResultsRequest)(nil),   // 7: calculator.SubmitResultsRequest
+	(*SubmitResultsResponse)(nil),  // 8: calculator.SubmitResultsResponse
+	(*TaskLease)(nil),              // 9: calculator.TaskLease
+	(*ReleaseTasksRequest)(nil),    // 10: calculator.ReleaseTasksRequest
+	(*ReleaseTasksResponse)(nil),   // 11: calculator.ReleaseTasksResponse
+	(*EnrollRequest)(nil),          // 12: calculator.EnrollRequest
+	(*EnrollResponse)(nil),         // 13: calculator.EnrollResponse
+	(*GetTaskResultRequest)(nil),   // 14: calculator.GetTaskResultRequest
+	(*GetTaskResultResponse)(nil),  // 15: calculator.GetTaskResultResponse
+	(*RegisterAgentRequest)(nil),   // 16: calculator.RegisterAgentRequest
+	(*RegisterAgentResponse)(nil),  // 17: calculator.RegisterAgentResponse
+	(*HeartbeatRequest)(nil),       // 18: calculator.HeartbeatRequest
+	(*HeartbeatResponse)(nil),      // 19: calculator.HeartbeatResponse
+	nil,                            // 20: calculator.RegisterAgentRequest.LabelsEntry
+	(*wrapperspb.DoubleValue)(nil), // 21: google.protobuf.DoubleValue
 }
 var file_internal_proto_calculator_proto_depIdxs = []int32{
-	6, // 0: calculator.GetTaskResultResponse.result:type_name -> google.protobuf.DoubleValue
-	0, // 1: calculator.OrchestratorService.GetTask:input_type -> calculator.GetTaskRequest
-	2, // 2: calculator.OrchestratorService.SubmitResult:input_type -> calculator.SubmitResultRequest
-	4, // 3: calculator.OrchestratorService.GetTaskResult:input_type -> calculator.GetTaskResultRequest
-	1, // 4: calculator.OrchestratorService.GetTask:output_type -> calculator.GetTaskResponse
-	3, // 5: calculator.OrchestratorService.SubmitResult:output_type -> calculator.SubmitResultResponse
-	5, // 6: calculator.OrchestratorService.GetTaskResult:output_type -> calculator.GetTaskResultResponse
-	4, // [4:7] is the sub-list for method output_type
-	1, // [1:4] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	4,  // 0: calculator.GetTasksResponse.tasks:type_name -> calculator.GetTaskResponse
+	5,  // 1: calculator.SubmitResultsRequest.results:type_name -> calculator.SubmitResultRequest
+	9,  // 2: calculator.ReleaseTasksRequest.tasks:type_name -> calculator.TaskLease
+	21, // 3: calculator.GetTaskResultResponse.result:type_name -> google.protobuf.DoubleValue
+	20, // 4: calculator.RegisterAgentRequest.labels:type_name -> calculator.RegisterAgentRequest.LabelsEntry
+	0,  // 5: calculator.OrchestratorService.GetTask:input_type -> calculator.GetTaskRequest
+	5,  // 6: calculator.OrchestratorService.SubmitResult:input_type -> calculator.SubmitResultRequest
+	14, // 7: calculator.OrchestratorService.GetTaskResult:input_type -> calculator.GetTaskResultRequest
+	16, // 8: calculator.OrchestratorService.RegisterAgent:input_type -> calculator.RegisterAgentRequest
+	18, // 9: calculator.OrchestratorService.Heartbeat:input_type -> calculator.HeartbeatRequest
+	1,  // 10: calculator.OrchestratorService.StreamTasks:input_type -> calculator.TaskStreamRequest
+	2,  // 11: calculator.OrchestratorService.GetTasks:input_type -> calculator.GetTasksRequest
+	7,  // 12: calculator.OrchestratorService.SubmitResults:input_type -> calculator.SubmitResultsRequest
+	10, // 13: calculator.OrchestratorService.ReleaseTasks:input_type -> calculator.ReleaseTasksRequest
+	12, // 14: calculator.OrchestratorService.Enroll:input_type -> calculator.EnrollRequest
+	4,  // 15: calculator.OrchestratorService.GetTask:output_type -> calculator.GetTaskResponse
+	6,  // 16: calculator.OrchestratorService.SubmitResult:output_type -> calculator.SubmitResultResponse
+	15, // 17: calculator.OrchestratorService.GetTaskResult:output_type -> calculator.GetTaskResultResponse
+	17, // 18: calculator.OrchestratorService.RegisterAgent:output_type -> calculator.RegisterAgentResponse
+	19, // 19: calculator.OrchestratorService.Heartbeat:output_type -> calculator.HeartbeatResponse
+	4,  // 20: calculator.OrchestratorService.StreamTasks:output_type -> calculator.GetTaskResponse
+	3,  // 21: calculator.OrchestratorService.GetTasks:output_type -> calculator.GetTasksResponse
+	8,  // 22: calculator.OrchestratorService.SubmitResults:output_type -> calculator.SubmitResultsResponse
+	11, // 23: calculator.OrchestratorService.ReleaseTasks:output_type -> calculator.ReleaseTasksResponse
+	13, // 24: calculator.OrchestratorService.Enroll:output_type -> calculator.EnrollResponse
+	15, // [15:25] is the sub-list for method output_type
+	5,  // [5:15] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_internal_proto_calculator_proto_init() }
@@ -457,17 +1536,19 @@ func file_internal_proto_calculator_proto_init() {
 	if File_internal_proto_calculator_proto != nil {
 		return
 	}
-	file_internal_proto_calculator_proto_msgTypes[2].OneofWrappers = []any{
+	file_internal_proto_calculator_proto_msgTypes[5].OneofWrappers = []any{
 		(*SubmitResultRequest_Result)(nil),
 		(*SubmitResultRequest_Error)(nil),
+		(*SubmitResultRequest_ResultText)(nil),
 	}
+	file_internal_proto_calculator_proto_msgTypes[15].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_proto_calculator_proto_rawDesc), len(file_internal_proto_calculator_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   21,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -22,6 +22,13 @@ const (
 	OrchestratorService_GetTask_FullMethodName       = "/calculator.OrchestratorService/GetTask"
 	OrchestratorService_SubmitResult_FullMethodName  = "/calculator.OrchestratorService/SubmitResult"
 	OrchestratorService_GetTaskResult_FullMethodName = "/calculator.OrchestratorService/GetTaskResult"
+	OrchestratorService_RegisterAgent_FullMethodName = "/calculator.OrchestratorService/RegisterAgent"
+	OrchestratorService_Heartbeat_FullMethodName     = "/calculator.OrchestratorService/Heartbeat"
+	OrchestratorService_StreamTasks_FullMethodName   = "/calculator.OrchestratorService/StreamTasks"
+	OrchestratorService_GetTasks_FullMethodName      = "/calculator.OrchestratorService/GetTasks"
+	OrchestratorService_SubmitResults_FullMethodName = "/calculator.OrchestratorService/SubmitResults"
+	OrchestratorService_ReleaseTasks_FullMethodName  = "/calculator.OrchestratorService/ReleaseTasks"
+	OrchestratorService_Enroll_FullMethodName        = "/calculator.OrchestratorService/Enroll"
 )
 
 // OrchestratorServiceClient is the client API for OrchestratorService service.
@@ -31,6 +38,13 @@ type OrchestratorServiceClient interface {
 	GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*GetTaskResponse, error)
 	SubmitResult(ctx context.Context, in *SubmitResultRequest, opts ...grpc.CallOption) (*SubmitResultResponse, error)
 	GetTaskResult(ctx context.Context, in *GetTaskResultRequest, opts ...grpc.CallOption) (*GetTaskResultResponse, error)
+	RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error)
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	StreamTasks(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TaskStreamRequest, GetTaskResponse], error)
+	GetTasks(ctx context.Context, in *GetTasksRequest, opts ...grpc.CallOption) (*GetTasksResponse, error)
+	SubmitResults(ctx context.Context, in *SubmitResultsRequest, opts ...grpc.CallOption) (*SubmitResultsResponse, error)
+	ReleaseTasks(ctx context.Context, in *ReleaseTasksRequest, opts ...grpc.CallOption) (*ReleaseTasksResponse, error)
+	Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error)
 }
 
 type orchestratorServiceClient struct {
@@ -71,6 +85,79 @@ func (c *orchestratorServiceClient) GetTaskResult(ctx context.Context, in *GetTa
 	return out, nil
 }
 
+func (c *orchestratorServiceClient) RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RegisterAgentResponse)
+	err := c.cc.Invoke(ctx, OrchestratorService_RegisterAgent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, OrchestratorService_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorServiceClient) StreamTasks(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TaskStreamRequest, GetTaskResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &OrchestratorService_ServiceDesc.Streams[0], OrchestratorService_StreamTasks_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TaskStreamRequest, GetTaskResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrchestratorService_StreamTasksClient = grpc.BidiStreamingClient[TaskStreamRequest, GetTaskResponse]
+
+func (c *orchestratorServiceClient) GetTasks(ctx context.Context, in *GetTasksRequest, opts ...grpc.CallOption) (*GetTasksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTasksResponse)
+	err := c.cc.Invoke(ctx, OrchestratorService_GetTasks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorServiceClient) SubmitResults(ctx context.Context, in *SubmitResultsRequest, opts ...grpc.CallOption) (*SubmitResultsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitResultsResponse)
+	err := c.cc.Invoke(ctx, OrchestratorService_SubmitResults_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorServiceClient) ReleaseTasks(ctx context.Context, in *ReleaseTasksRequest, opts ...grpc.CallOption) (*ReleaseTasksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReleaseTasksResponse)
+	err := c.cc.Invoke(ctx, OrchestratorService_ReleaseTasks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorServiceClient) Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnrollResponse)
+	err := c.cc.Invoke(ctx, OrchestratorService_Enroll_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // OrchestratorServiceServer is the server API for OrchestratorService service.
 // All implementations must embed UnimplementedOrchestratorServiceServer
 // for forward compatibility.
@@ -78,6 +165,13 @@ type OrchestratorServiceServer interface {
 	GetTask(context.Context, *GetTaskRequest) (*GetTaskResponse, error)
 	SubmitResult(context.Context, *SubmitResultRequest) (*SubmitResultResponse, error)
 	GetTaskResult(context.Context, *GetTaskResultRequest) (*GetTaskResultResponse, error)
+	RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error)
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	StreamTasks(grpc.BidiStreamingServer[TaskStreamRequest, GetTaskResponse]) error
+	GetTasks(context.Context, *GetTasksRequest) (*GetTasksResponse, error)
+	SubmitResults(context.Context, *SubmitResultsRequest) (*SubmitResultsResponse, error)
+	ReleaseTasks(context.Context, *ReleaseTasksRequest) (*ReleaseTasksResponse, error)
+	Enroll(context.Context, *EnrollRequest) (*EnrollResponse, error)
 	mustEmbedUnimplementedOrchestratorServiceServer()
 }
 
@@ -97,6 +191,27 @@ func (UnimplementedOrchestratorServiceServer) SubmitResult(context.Context, *Sub
 func (UnimplementedOrchestratorServiceServer) GetTaskResult(context.Context, *GetTaskResultRequest) (*GetTaskResultResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetTaskResult not implemented")
 }
+func (UnimplementedOrchestratorServiceServer) RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterAgent not implemented")
+}
+func (UnimplementedOrchestratorServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedOrchestratorServiceServer) StreamTasks(grpc.BidiStreamingServer[TaskStreamRequest, GetTaskResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTasks not implemented")
+}
+func (UnimplementedOrchestratorServiceServer) GetTasks(context.Context, *GetTasksRequest) (*GetTasksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTasks not implemented")
+}
+func (UnimplementedOrchestratorServiceServer) SubmitResults(context.Context, *SubmitResultsRequest) (*SubmitResultsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitResults not implemented")
+}
+func (UnimplementedOrchestratorServiceServer) ReleaseTasks(context.Context, *ReleaseTasksRequest) (*ReleaseTasksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseTasks not implemented")
+}
+func (UnimplementedOrchestratorServiceServer) Enroll(context.Context, *EnrollRequest) (*EnrollResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Enroll not implemented")
+}
 func (UnimplementedOrchestratorServiceServer) mustEmbedUnimplementedOrchestratorServiceServer() {}
 func (UnimplementedOrchestratorServiceServer) testEmbeddedByValue()                             {}
 
@@ -172,6 +287,121 @@ func _OrchestratorService_GetTaskResult_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _OrchestratorService_RegisterAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServiceServer).RegisterAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrchestratorService_RegisterAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServiceServer).RegisterAgent(ctx, req.(*RegisterAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrchestratorService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrchestratorService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrchestratorService_StreamTasks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(OrchestratorServiceServer).StreamTasks(&grpc.GenericServerStream[TaskStreamRequest, GetTaskResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrchestratorService_StreamTasksServer = grpc.BidiStreamingServer[TaskStreamRequest, GetTaskResponse]
+
+func _OrchestratorService_GetTasks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTasksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServiceServer).GetTasks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrchestratorService_GetTasks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServiceServer).GetTasks(ctx, req.(*GetTasksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrchestratorService_SubmitResults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitResultsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServiceServer).SubmitResults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrchestratorService_SubmitResults_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServiceServer).SubmitResults(ctx, req.(*SubmitResultsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrchestratorService_ReleaseTasks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseTasksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServiceServer).ReleaseTasks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrchestratorService_ReleaseTasks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServiceServer).ReleaseTasks(ctx, req.(*ReleaseTasksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrchestratorService_Enroll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServiceServer).Enroll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrchestratorService_Enroll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServiceServer).Enroll(ctx, req.(*EnrollRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // OrchestratorService_ServiceDesc is the grpc.ServiceDesc for OrchestratorService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -191,7 +421,38 @@ var OrchestratorService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetTaskResult",
 			Handler:    _OrchestratorService_GetTaskResult_Handler,
 		},
+		{
+			MethodName: "RegisterAgent",
+			Handler:    _OrchestratorService_RegisterAgent_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _OrchestratorService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "GetTasks",
+			Handler:    _OrchestratorService_GetTasks_Handler,
+		},
+		{
+			MethodName: "SubmitResults",
+			Handler:    _OrchestratorService_SubmitResults_Handler,
+		},
+		{
+			MethodName: "ReleaseTasks",
+			Handler:    _OrchestratorService_ReleaseTasks_Handler,
+		},
+		{
+			MethodName: "Enroll",
+			Handler:    _OrchestratorService_Enroll_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamTasks",
+			Handler:       _OrchestratorService_StreamTasks_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/proto/calculator.proto",
 }